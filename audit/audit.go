@@ -0,0 +1,210 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"velocitylimits/dedup"
+	"velocitylimits/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrShortLog is returned by Truncate for a log that ends before the offset
+// it is to be cut back to.
+var ErrShortLog = errors.New("audit log ends before the offset")
+
+// ErrTampered is returned when a record of the log does not match its hash
+// or does not follow the record before it.
+var ErrTampered = errors.New("audit log tampered")
+
+// Record is one decision in the audit log: the request, the decision, the
+// account before and after it and the transactions written with it. Each
+// record holds the hash of the one before, so changing, removing or
+// reordering records breaks the chain.
+type Record struct {
+	Seq           int64           `json:"seq"`
+	Request       *models.Request `json:"request"`
+	Decision      models.Decision `json:"decision"`
+	Before        *models.Account `json:"before"`
+	After         *models.Account `json:"after"`
+	Transactions  []dedup.Entry   `json:"transactions,omitempty"`
+	ConfigVersion string          `json:"config_version"`
+	Prev          string          `json:"prev"`
+	Hash          string          `json:"hash"`
+}
+
+// Log appends records to an audit log file. It is safe for concurrent use.
+type Log struct {
+	mu            sync.Mutex
+	file          *os.File
+	configVersion string
+	seq           int64
+	prev          string
+	offset        int64
+	syncWrites    bool
+}
+
+// Open verifies the audit log at path, creating it if it does not exist, and
+// returns a log appending to it. Records are stamped with configVersion and,
+// with syncWrites, synced one by one. A torn record at the end, expected after
+// a crash, is dropped.
+func Open(path, configVersion string, syncWrites bool) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{file: file, configVersion: configVersion, syncWrites: syncWrites}
+	offset, err := Read(file, func(r Record) error {
+		l.seq, l.prev = r.Seq, r.Hash
+		return nil
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	l.offset = offset
+	return l, nil
+}
+
+// Truncate cuts the audit log at path back to offset, dropping the records
+// after it, e.g. those of a run resumed from a checkpoint taken at offset.
+func Truncate(path string, offset int64) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if info.Size() < offset {
+		file.Close()
+		return fmt.Errorf("%w: %d bytes, expected at least %d", ErrShortLog, info.Size(), offset)
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Offset returns the size of the log after the last record appended.
+func (l *Log) Offset() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.offset
+}
+
+// Record chains the record to the log and appends it. A decision must not be
+// acknowledged if it could not be audited, so write failures are fatal.
+func (l *Log) Record(r Record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.Seq = l.seq + 1
+	r.ConfigVersion = l.configVersion
+	r.Prev = l.prev
+	r.Hash = ""
+	hash, err := hashRecord(r)
+	if err != nil {
+		logrus.Panicf("Unable to encode audit record: %v", err)
+	}
+	r.Hash = hash
+	line, err := json.Marshal(r)
+	if err != nil {
+		logrus.Panicf("Unable to encode audit record: %v", err)
+	}
+	n, err := l.file.Write(append(line, '\n'))
+	if err != nil {
+		logrus.Panicf("Unable to write audit log: %v", err)
+	}
+	if l.syncWrites {
+		if err := l.file.Sync(); err != nil {
+			logrus.Panicf("Unable to sync audit log: %v", err)
+		}
+	}
+	l.seq, l.prev = r.Seq, r.Hash
+	l.offset += int64(n)
+}
+
+// Close syncs and closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// Read verifies the chain of the log in r and calls fn with every record in
+// order. It returns the offset after the last complete record; a torn record
+// after it is skipped with a warning.
+func Read(r io.Reader, fn func(Record) error) (int64, error) {
+	reader := bufio.NewReader(r)
+	var offset, seq int64
+	prev := ""
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				logrus.Warnf("Dropping torn audit record at offset %d", offset)
+			}
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			return offset, fmt.Errorf("%w: record at offset %d: %v", ErrTampered, offset, err)
+		}
+		hash, err := hashRecord(withoutHash(record))
+		if err != nil {
+			return offset, err
+		}
+		switch {
+		case record.Hash != hash:
+			return offset, fmt.Errorf("%w: record %d does not match its hash", ErrTampered, record.Seq)
+		case record.Seq != seq+1 || record.Prev != prev:
+			return offset, fmt.Errorf("%w: record %d does not follow record %d", ErrTampered, record.Seq, seq)
+		}
+		if err := fn(record); err != nil {
+			return offset, err
+		}
+		seq, prev = record.Seq, record.Hash
+		offset += int64(len(line))
+	}
+}
+
+func withoutHash(r Record) Record {
+	r.Hash = ""
+	return r
+}
+
+// hashRecord returns the hex SHA-256 of the record encoded without its hash.
+func hashRecord(r Record) (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
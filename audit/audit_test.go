@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"velocitylimits/dedup"
+	"velocitylimits/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func newTestRecord(t *testing.T, id string, amount models.Money) Record {
+	at := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	request, err := models.ParseRequest(id, "528", amount.String(), at.Format(time.RFC3339))
+	require.NoError(t, err)
+	account := models.NewAccount("528")
+	account.DailyLimit = models.NewDailyLimit(at, models.Dollars(5000), 3, models.DefaultBoundaries)
+	account.WeeklyLimit = models.NewWeeklyLimit(at, models.Dollars(20000), models.DefaultBoundaries)
+	decision := account.LoadFunds(request, nil, models.DefaultBoundaries)
+	return Record{
+		Request:      request,
+		Decision:     decision,
+		After:        account,
+		Transactions: []dedup.Entry{{Key: dedup.Key{ID: id, CustomerID: "528"}, Time: at, Amount: amount, Decision: &decision}},
+	}
+}
+
+// writeTestLog writes n records to a new log and returns its lines.
+func writeTestLog(t *testing.T, path string, n int) []string {
+	log, err := Open(path, "v1", false)
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		log.Record(newTestRecord(t, string(rune('a'+i)), models.Dollars(int64(i+1))))
+	}
+	require.NoError(t, log.Close())
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(contents), "\n")
+	return lines[:len(lines)-1]
+}
+
+func readAll(t *testing.T, contents string) ([]Record, error) {
+	var records []Record
+	_, err := Read(strings.NewReader(contents), func(r Record) error {
+		records = append(records, r)
+		return nil
+	})
+	return records, err
+}
+
+func TestLog(t *testing.T) {
+	t.Run("chains the records it appends", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "audit.log")
+		lines := writeTestLog(t, path, 3)
+		records, err := readAll(t, strings.Join(lines, ""))
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		assert.Equal(t, "", records[0].Prev)
+		for i, record := range records {
+			assert.Equal(t, int64(i+1), record.Seq)
+			assert.Equal(t, "v1", record.ConfigVersion)
+			assert.Len(t, record.Hash, 64)
+			if i > 0 {
+				assert.Equal(t, records[i-1].Hash, record.Prev)
+			}
+		}
+		assert.Equal(t, "b", records[1].Request.ID)
+		assert.Equal(t, models.Dollars(2), records[1].After.Balance)
+	})
+	t.Run("continues the chain when reopened", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "audit.log")
+		writeTestLog(t, path, 2)
+		log, err := Open(path, "v2", false)
+		require.NoError(t, err)
+		log.Record(newTestRecord(t, "z", models.Dollars(1)))
+		require.NoError(t, log.Close())
+		contents, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		records, err := readAll(t, string(contents))
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		assert.Equal(t, int64(3), records[2].Seq)
+		assert.Equal(t, "v2", records[2].ConfigVersion)
+	})
+	t.Run("writes every record through with sync writes", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "audit.log")
+		log, err := Open(path, "v1", true)
+		require.NoError(t, err)
+		defer log.Close()
+		log.Record(newTestRecord(t, "a", models.Dollars(1)))
+		contents, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		records, err := readAll(t, string(contents))
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, "a", records[0].Request.ID)
+	})
+	t.Run("drops a torn record at the end when reopened", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "audit.log")
+		lines := writeTestLog(t, path, 2)
+		require.NoError(t, ioutil.WriteFile(path, []byte(lines[0]+lines[1][:20]), 0644))
+		log, err := Open(path, "v1", false)
+		require.NoError(t, err)
+		log.Record(newTestRecord(t, "z", models.Dollars(1)))
+		require.NoError(t, log.Close())
+		contents, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		records, err := readAll(t, string(contents))
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, "z", records[1].Request.ID)
+	})
+}
+
+func TestTruncate(t *testing.T) {
+	t.Run("cuts the log back to the offset of a record", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "audit.log")
+		log, err := Open(path, "v1", false)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), log.Offset())
+		log.Record(newTestRecord(t, "a", models.Dollars(1)))
+		offset := log.Offset()
+		log.Record(newTestRecord(t, "b", models.Dollars(2)))
+		require.NoError(t, log.Close())
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, info.Size(), log.Offset())
+
+		require.NoError(t, Truncate(path, offset))
+		contents, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		records, err := readAll(t, string(contents))
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, "a", records[0].Request.ID)
+		// the chain continues from the record kept
+		log, err = Open(path, "v1", false)
+		require.NoError(t, err)
+		assert.Equal(t, offset, log.Offset())
+		require.NoError(t, log.Close())
+	})
+	t.Run("returns error for an offset past the end of the log", func(t *testing.T) {
+		path := filepath.Join(tempDir(t), "audit.log")
+		lines := writeTestLog(t, path, 1)
+		err := Truncate(path, int64(len(lines[0])+1))
+		assert.True(t, errors.Is(err, ErrShortLog))
+	})
+}
+
+func TestRead(t *testing.T) {
+	path := filepath.Join(tempDir(t), "audit.log")
+	lines := writeTestLog(t, path, 3)
+
+	t.Run("detects a changed record", func(t *testing.T) {
+		changed := strings.Replace(lines[1], `"load_amount":"$2.00"`, `"load_amount":"$20.00"`, 1)
+		require.NotEqual(t, lines[1], changed)
+		_, err := readAll(t, lines[0]+changed+lines[2])
+		assert.True(t, errors.Is(err, ErrTampered))
+	})
+	t.Run("detects a removed record", func(t *testing.T) {
+		_, err := readAll(t, lines[0]+lines[2])
+		assert.True(t, errors.Is(err, ErrTampered))
+	})
+	t.Run("detects reordered records", func(t *testing.T) {
+		_, err := readAll(t, lines[1]+lines[0]+lines[2])
+		assert.True(t, errors.Is(err, ErrTampered))
+	})
+	t.Run("returns the offset after the last complete record", func(t *testing.T) {
+		contents := lines[0] + lines[1] + `{"seq":3`
+		offset, err := Read(bytes.NewReader([]byte(contents)), func(Record) error { return nil })
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(lines[0]+lines[1])), offset)
+	})
+}
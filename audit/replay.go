@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"velocitylimits/config"
+	"velocitylimits/dedup"
+	"velocitylimits/models"
+)
+
+// State is the cache state rebuilt from an audit log.
+type State struct {
+	Records      int
+	Accounts     map[string]*models.Account
+	Transactions *dedup.Set
+}
+
+// Replay verifies the audit log in r and rebuilds the accounts and
+// transactions its decisions left behind. Transactions are kept as the store
+// keeps them, within the retention and maximum of dedupConfig.
+func Replay(r io.Reader, dedupConfig config.Dedup) (*State, error) {
+	state := &State{
+		Accounts:     make(map[string]*models.Account),
+		Transactions: dedup.NewSet(dedupConfig),
+	}
+	_, err := Read(r, func(record Record) error {
+		state.Records++
+		for _, entry := range record.Transactions {
+			state.Transactions.Add(entry)
+		}
+		if record.After != nil {
+			state.Accounts[record.After.CustomerID] = record.After
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Diff compares the rebuilt state with the accounts and transactions of a
+// store and describes every difference, sorted. It is empty when they match.
+func (s *State) Diff(accounts []*models.Account, transactions []dedup.Entry) []string {
+	var diffs []string
+	stored := make(map[string]bool, len(accounts))
+	for _, account := range accounts {
+		stored[account.CustomerID] = true
+		replayed, ok := s.Accounts[account.CustomerID]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("account %s is stored but not in the audit log", account.CustomerID))
+		case !reflect.DeepEqual(normalize(replayed), normalize(account)):
+			diffs = append(diffs, fmt.Sprintf("account %s differs from the audit log", account.CustomerID))
+		}
+	}
+	for customerID := range s.Accounts {
+		if !stored[customerID] {
+			diffs = append(diffs, fmt.Sprintf("account %s is in the audit log but not stored", customerID))
+		}
+	}
+	storedTransactions := make(map[dedup.Key]bool, len(transactions))
+	for _, entry := range transactions {
+		storedTransactions[entry.Key] = true
+		replayed, ok := s.Transactions.Get(entry.Key)
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("transaction %s of customer %s is stored but not in the audit log", entry.ID, entry.CustomerID))
+		case !reflect.DeepEqual(normalizeEntry(replayed), normalizeEntry(entry)):
+			diffs = append(diffs, fmt.Sprintf("transaction %s of customer %s differs from the audit log", entry.ID, entry.CustomerID))
+		}
+	}
+	for _, entry := range s.Transactions.Entries() {
+		if !storedTransactions[entry.Key] {
+			diffs = append(diffs, fmt.Sprintf("transaction %s of customer %s is in the audit log but not stored", entry.ID, entry.CustomerID))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// normalize puts the times of an account in UTC, so accounts read back from
+// JSON compare equal to the ones they were written from.
+func normalize(account *models.Account) *models.Account {
+	account = account.Clone()
+	if account.DailyLimit != nil {
+		account.DailyLimit.Date = account.DailyLimit.Date.UTC()
+	}
+	if account.WeeklyLimit != nil {
+		account.WeeklyLimit.Date = account.WeeklyLimit.Date.UTC()
+	}
+	for i := range account.Loads {
+		account.Loads[i].Time = account.Loads[i].Time.UTC()
+	}
+	return account
+}
+
+func normalizeEntry(entry dedup.Entry) dedup.Entry {
+	entry.Time = entry.Time.UTC()
+	return entry
+}
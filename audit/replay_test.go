@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"velocitylimits/config"
+	"velocitylimits/dedup"
+	"velocitylimits/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(tempDir(t), "audit.log")
+	writeTestLog(t, path, 3)
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	t.Run("rebuilds the accounts and transactions", func(t *testing.T) {
+		state, err := Replay(strings.NewReader(string(contents)), config.Dedup{})
+		require.NoError(t, err)
+		assert.Equal(t, 3, state.Records)
+		require.Contains(t, state.Accounts, "528")
+		assert.Equal(t, models.Dollars(3), state.Accounts["528"].Balance)
+		assert.Equal(t, 3, state.Transactions.Len())
+	})
+	t.Run("matches the state it was written from", func(t *testing.T) {
+		state, err := Replay(strings.NewReader(string(contents)), config.Dedup{})
+		require.NoError(t, err)
+		last := newTestRecord(t, "c", models.Dollars(3))
+		var transactions []dedup.Entry
+		for i := 0; i < 3; i++ {
+			transactions = append(transactions, newTestRecord(t, string(rune('a'+i)), models.Dollars(int64(i+1))).Transactions...)
+		}
+		assert.Empty(t, state.Diff([]*models.Account{last.After}, transactions))
+	})
+	t.Run("describes every difference", func(t *testing.T) {
+		state, err := Replay(strings.NewReader(string(contents)), config.Dedup{})
+		require.NoError(t, err)
+		changed := newTestRecord(t, "c", models.Dollars(4))
+		other := models.NewAccount("529")
+		transactions := append(changed.Transactions, dedup.Entry{Key: dedup.Key{ID: "x", CustomerID: "529"}})
+		assert.Equal(t, []string{
+			"account 528 differs from the audit log",
+			"account 529 is stored but not in the audit log",
+			"transaction a of customer 528 is in the audit log but not stored",
+			"transaction b of customer 528 is in the audit log but not stored",
+			"transaction c of customer 528 differs from the audit log",
+			"transaction x of customer 529 is stored but not in the audit log",
+		}, state.Diff([]*models.Account{changed.After, other}, transactions))
+	})
+	t.Run("returns error for a tampered log", func(t *testing.T) {
+		_, err := Replay(strings.NewReader(strings.Replace(string(contents), `"seq":2`, `"seq":4`, 1)), config.Dedup{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,248 @@
+package backtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"velocitylimits/audit"
+	"velocitylimits/config"
+	"velocitylimits/engine"
+	"velocitylimits/models"
+	"velocitylimits/pipeline"
+	"velocitylimits/service"
+)
+
+// Policy is a configuration to backtest. Store, which holds the customer
+// limits and any accounts to start from, must not be shared with another
+// policy; without it the accounts are kept in memory.
+type Policy struct {
+	Name   string
+	Config *config.Configurations
+	Store  service.Cache
+}
+
+// Outcome is how a policy decided the loads of the input.
+type Outcome struct {
+	Name    string           `json:"name"`
+	Summary pipeline.Summary `json:"summary"`
+	// Loads counts the loads decided by the limits, withdrawals, refunds and
+	// reversals are left out, as are loads declined for arriving late.
+	Loads    int                   `json:"loads"`
+	Accepted int                   `json:"accepted"`
+	Declined map[models.Reason]int `json:"declined"`
+	// AcceptanceRate is the percentage of Loads accepted.
+	AcceptanceRate float64 `json:"acceptance_rate"`
+	// AcceptedVolume is the amount loaded, retried loads count once.
+	AcceptedVolume models.Money `json:"accepted_volume"`
+}
+
+// Difference is a load the policies did not all decide the same.
+type Difference struct {
+	ID         string       `json:"id"`
+	CustomerID string       `json:"customer_id"`
+	Amount     models.Money `json:"load_amount"`
+	Time       time.Time    `json:"time"`
+	// Reasons holds the reason of the decision of each policy in the order
+	// of the policies, ReasonAccepted for an accepted load. A policy that did
+	// not decide the load has an empty reason.
+	Reasons []models.Reason `json:"reasons"`
+}
+
+// Report compares the outcomes of the policies on the same input.
+type Report struct {
+	Outcomes    []Outcome    `json:"outcomes"`
+	Differences []Difference `json:"differences"`
+}
+
+// requestID identifies the requests of a customer with the same ID.
+type requestID struct {
+	customerID string
+	id         string
+}
+
+// key tells the decisions of a load apart from those of its retries: the nth
+// request with an ID is matched to the nth one of every other policy.
+type key struct {
+	requestID
+	n int
+}
+
+// decision is the decision a policy made on a load.
+type decision struct {
+	request *models.Request
+	reason  models.Reason
+}
+
+// recorder collects the decisions on loads. The workers of a run share it.
+type recorder struct {
+	mu        sync.Mutex
+	outcome   *Outcome
+	seen      map[requestID]int
+	decisions map[key]decision
+}
+
+func newRecorder(outcome *Outcome) *recorder {
+	return &recorder{outcome: outcome, seen: make(map[requestID]int), decisions: make(map[key]decision)}
+}
+
+// Record ...
+func (r *recorder) Record(record audit.Record) {
+	if !record.Request.Type.IsLoad() {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := requestID{customerID: record.Request.CustomerID, id: record.Request.ID}
+	// the requests of a customer are decided in input order
+	k := key{requestID: id, n: r.seen[id]}
+	r.seen[id]++
+	r.decisions[k] = decision{request: record.Request, reason: record.Decision.Reason}
+
+	r.outcome.Loads++
+	if !record.Decision.Accepted {
+		r.outcome.Declined[record.Decision.Reason]++
+		return
+	}
+	r.outcome.Accepted++
+	// a retry answered with its original response has no transactions
+	if len(record.Transactions) > 0 {
+		r.outcome.AcceptedVolume += record.Request.ParsedAmount
+	}
+}
+
+// Run runs the input through the pipeline once for each policy and compares
+// the decisions on its loads. Responses, malformed lines and late lines are
+// discarded; checkpoints are not taken.
+func Run(ctx context.Context, input []byte, policies []Policy) (*Report, error) {
+	report := &Report{Outcomes: make([]Outcome, len(policies)), Differences: []Difference{}}
+	recorders := make([]*recorder, len(policies))
+	for i, policy := range policies {
+		report.Outcomes[i] = Outcome{Name: policy.Name, Declined: make(map[models.Reason]int)}
+		recorders[i] = newRecorder(&report.Outcomes[i])
+		config := *policy.Config
+		config.Checkpoint.File = ""
+		options := []engine.Option{engine.WithConfig(&config), engine.WithAuditor(recorders[i])}
+		if policy.Store != nil {
+			options = append(options, engine.WithStore(policy.Store))
+		}
+		e := engine.New(options...)
+		summary, err := pipeline.Run(ctx, &config, bytes.NewReader(input), pipeline.Sinks{Output: ioutil.Discard}, e, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", policy.Name, err)
+		}
+		report.Outcomes[i].Summary = summary
+		if loads := report.Outcomes[i].Loads; loads > 0 {
+			report.Outcomes[i].AcceptanceRate = 100 * float64(report.Outcomes[i].Accepted) / float64(loads)
+		}
+	}
+
+	keys := make(map[key]*models.Request)
+	for _, recorder := range recorders {
+		for k, d := range recorder.decisions {
+			keys[k] = d.request
+		}
+	}
+	for k, request := range keys {
+		reasons := make([]models.Reason, len(recorders))
+		differs := false
+		for i, recorder := range recorders {
+			reasons[i] = recorder.decisions[k].reason
+			differs = differs || reasons[i] != reasons[0]
+		}
+		if differs {
+			report.Differences = append(report.Differences, Difference{
+				ID:         request.ID,
+				CustomerID: request.CustomerID,
+				Amount:     request.ParsedAmount,
+				Time:       request.ParsedTime,
+				Reasons:    reasons,
+			})
+		}
+	}
+	sort.Slice(report.Differences, func(i, j int) bool {
+		a, b := report.Differences[i], report.Differences[j]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		if a.CustomerID != b.CustomerID {
+			return a.CustomerID < b.CustomerID
+		}
+		return a.ID < b.ID
+	})
+	return report, nil
+}
+
+// WriteTable writes the report as a table of the outcomes followed by one of
+// the differences.
+func (r *Report) WriteTable(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "POLICY\tLOADS\tACCEPTED\tRATE\tVOLUME\tDECLINED")
+	for _, outcome := range r.Outcomes {
+		fmt.Fprintf(table, "%s\t%d\t%d\t%.2f%%\t%s\t%s\n", outcome.Name, outcome.Loads, outcome.Accepted,
+			outcome.AcceptanceRate, outcome.AcceptedVolume, formatDeclined(outcome.Declined))
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	if len(r.Differences) == 0 {
+		_, err := fmt.Fprintln(w, "\nNo load is decided differently.")
+		return err
+	}
+	fmt.Fprintf(w, "\n%d loads are decided differently:\n", len(r.Differences))
+	fmt.Fprint(table, "ID\tCUSTOMER\tAMOUNT\tTIME")
+	for _, outcome := range r.Outcomes {
+		fmt.Fprintf(table, "\t%s", outcome.Name)
+	}
+	fmt.Fprintln(table)
+	for _, difference := range r.Differences {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s", difference.ID, difference.CustomerID, difference.Amount, difference.Time.Format(time.RFC3339))
+		for _, reason := range difference.Reasons {
+			if reason == "" {
+				reason = "-"
+			}
+			fmt.Fprintf(table, "\t%s", reason)
+		}
+		fmt.Fprintln(table)
+	}
+	return table.Flush()
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// formatDeclined lists the declines by reason, most frequent first.
+func formatDeclined(declined map[models.Reason]int) string {
+	reasons := make([]models.Reason, 0, len(declined))
+	for reason := range declined {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if declined[reasons[i]] != declined[reasons[j]] {
+			return declined[reasons[i]] > declined[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	var buf bytes.Buffer
+	for i, reason := range reasons {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(&buf, "%s %d", reason, declined[reason])
+	}
+	if buf.Len() == 0 {
+		return "-"
+	}
+	return buf.String()
+}
@@ -0,0 +1,148 @@
+package backtest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"velocitylimits/backtest"
+	"velocitylimits/cache"
+	"velocitylimits/config"
+	"velocitylimits/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const input = `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-03T00:00:00Z"}
+{"id":"2","customer_id":"528","load_amount":"$2500","time":"2000-01-03T01:00:00Z"}
+{"id":"3","customer_id":"529","load_amount":"$100","time":"2000-01-03T02:00:00Z"}
+{"id":"2","customer_id":"528","load_amount":"$2500","time":"2000-01-03T01:00:00Z"}
+{"id":"4","customer_id":"528","type":"withdrawal","load_amount":"$50","time":"2000-01-03T03:00:00Z"}
+not json
+{"id":"5","customer_id":"529","load_amount":"$200","time":"2000-01-03T04:00:00Z"}
+`
+
+func newConfig(maxDailyLoad int64, maxDailyTransactions int) *config.Configurations {
+	return &config.Configurations{
+		VelocityLimit: config.VelocityLimit{
+			MaxDailyLoadLimit:    models.Dollars(maxDailyLoad),
+			MaxDailyTransactions: maxDailyTransactions,
+			MaxWeeklyLoadLimit:   models.Dollars(20000),
+		},
+		Pipeline: config.Pipeline{Workers: 4},
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	t.Run("compares the decisions of the policies", func(t *testing.T) {
+		report, err := backtest.Run(ctx, []byte(input), []backtest.Policy{
+			{Name: "current", Config: newConfig(5000, 3)},
+			{Name: "raised", Config: newConfig(6000, 3)},
+			{Name: "single", Config: newConfig(6000, 1)},
+		})
+		require.NoError(t, err)
+		require.Len(t, report.Outcomes, 3)
+
+		current := report.Outcomes[0]
+		assert.Equal(t, "current", current.Name)
+		assert.Equal(t, 7, current.Summary.Lines)
+		assert.Equal(t, 1, current.Summary.Malformed)
+		assert.Equal(t, 5, current.Loads)
+		assert.Equal(t, 3, current.Accepted)
+		assert.Equal(t, map[models.Reason]int{models.ReasonDailyAmountExceeded: 1, models.ReasonDuplicate: 1}, current.Declined)
+		assert.Equal(t, 60.0, current.AcceptanceRate)
+		assert.Equal(t, models.Dollars(3300), current.AcceptedVolume)
+
+		raised := report.Outcomes[1]
+		assert.Equal(t, 4, raised.Accepted)
+		assert.Equal(t, models.Dollars(5800), raised.AcceptedVolume)
+		single := report.Outcomes[2]
+		assert.Equal(t, 2, single.Accepted)
+
+		assert.Equal(t, []backtest.Difference{
+			{ID: "2", CustomerID: "528", Amount: models.Dollars(2500), Time: parseTime(t, "2000-01-03T01:00:00Z"),
+				Reasons: []models.Reason{models.ReasonDailyAmountExceeded, models.ReasonAccepted, models.ReasonDailyCountExceeded}},
+			{ID: "5", CustomerID: "529", Amount: models.Dollars(200), Time: parseTime(t, "2000-01-03T04:00:00Z"),
+				Reasons: []models.Reason{models.ReasonAccepted, models.ReasonAccepted, models.ReasonDailyCountExceeded}},
+		}, report.Differences)
+	})
+	t.Run("counts the volume of retried loads once", func(t *testing.T) {
+		config := newConfig(6000, 3)
+		config.Store.Dedup.Idempotent = true
+		report, err := backtest.Run(ctx, []byte(input), []backtest.Policy{{Name: "idempotent", Config: config}, {Name: "raised", Config: newConfig(6000, 3)}})
+		require.NoError(t, err)
+		assert.Equal(t, 5, report.Outcomes[0].Accepted)
+		assert.Equal(t, models.Dollars(5800), report.Outcomes[0].AcceptedVolume)
+		require.Len(t, report.Differences, 1)
+		assert.Equal(t, []models.Reason{models.ReasonAccepted, models.ReasonDuplicate}, report.Differences[0].Reasons)
+	})
+	t.Run("applies the customer limits of the store", func(t *testing.T) {
+		one := 1
+		store := cache.NewCache()
+		store.AddCustomerLimits(&models.CustomerLimits{CustomerID: "529", Overrides: []models.Override{{MaxDailyTransactions: &one}}})
+		report, err := backtest.Run(ctx, []byte(input), []backtest.Policy{
+			{Name: "current", Config: newConfig(5000, 3)},
+			{Name: "override", Config: newConfig(5000, 3), Store: store},
+		})
+		require.NoError(t, err)
+		require.Len(t, report.Differences, 1)
+		assert.Equal(t, "5", report.Differences[0].ID)
+	})
+	t.Run("returns the error of a failed run", func(t *testing.T) {
+		config := newConfig(5000, 3)
+		config.Pipeline.MaxErrorRate = 1
+		_, err := backtest.Run(ctx, []byte("not json\n"), []backtest.Policy{{Name: "strict", Config: config}})
+		assert.EqualError(t, err, "strict: malformed line rate exceeded: 1 of 1 lines")
+	})
+}
+
+func TestReport(t *testing.T) {
+	report, err := backtest.Run(context.Background(), []byte(input), []backtest.Policy{
+		{Name: "current", Config: newConfig(5000, 3)},
+		{Name: "raised", Config: newConfig(6000, 3)},
+	})
+	require.NoError(t, err)
+	t.Run("writes a table", func(t *testing.T) {
+		var table bytes.Buffer
+		require.NoError(t, report.WriteTable(&table))
+		assert.Equal(t, `POLICY   LOADS  ACCEPTED  RATE    VOLUME     DECLINED
+current  5      3         60.00%  $3,300.00  daily_amount_exceeded 1, duplicate 1
+raised   5      4         80.00%  $5,800.00  duplicate 1
+
+1 loads are decided differently:
+ID  CUSTOMER  AMOUNT     TIME                  current                raised
+2   528       $2,500.00  2000-01-03T01:00:00Z  daily_amount_exceeded  accepted
+`, table.String())
+	})
+	t.Run("writes JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, report.WriteJSON(&buf))
+		var decoded struct {
+			Outcomes []struct {
+				Name           string  `json:"name"`
+				AcceptanceRate float64 `json:"acceptance_rate"`
+				AcceptedVolume string  `json:"accepted_volume"`
+			} `json:"outcomes"`
+			Differences []struct {
+				ID      string   `json:"id"`
+				Reasons []string `json:"reasons"`
+			} `json:"differences"`
+		}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+		require.Len(t, decoded.Outcomes, 2)
+		assert.Equal(t, 80.0, decoded.Outcomes[1].AcceptanceRate)
+		assert.Equal(t, "$5,800.00", decoded.Outcomes[1].AcceptedVolume)
+		require.Len(t, decoded.Differences, 1)
+		assert.Equal(t, []string{"daily_amount_exceeded", "accepted"}, decoded.Differences[0].Reasons)
+	})
+}
+
+func parseTime(t *testing.T, s string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, s)
+	require.NoError(t, err)
+	return parsed
+}
@@ -1,25 +1,50 @@
 package cache
 
 import (
+	"sync"
+
+	"velocitylimits/config"
+	"velocitylimits/dedup"
 	"velocitylimits/models"
 )
 
-// Cache ...
+// Cache is safe for concurrent use. It does not guard the accounts it hands
+// out, callers must not work on the same customer from several goroutines.
 type Cache struct {
+	mu           sync.RWMutex
+	dedupConfig  config.Dedup
 	accounts     map[string]*models.Account
-	transactions map[string]struct{}
+	transactions *dedup.Set
+	customers    map[string]*models.CustomerLimits
+}
+
+// State is a copy of everything a cache holds, saved in checkpoints.
+type State struct {
+	Accounts     []*models.Account        `json:"accounts"`
+	Transactions []dedup.Entry            `json:"transactions"`
+	Customers    []*models.CustomerLimits `json:"customers,omitempty"`
 }
 
-// NewCache ...
+// NewCache returns a cache that keeps every transaction ID.
 func NewCache() *Cache {
+	return NewBoundedCache(config.Dedup{})
+}
+
+// NewBoundedCache returns a cache that keeps transaction IDs within the
+// retention and maximum of the dedup config.
+func NewBoundedCache(dedupConfig config.Dedup) *Cache {
 	return &Cache{
+		dedupConfig:  dedupConfig,
 		accounts:     make(map[string]*models.Account),
-		transactions: make(map[string]struct{}),
+		transactions: dedup.NewSet(dedupConfig),
+		customers:    make(map[string]*models.CustomerLimits),
 	}
 }
 
 // GetAccount ...
 func (s *Cache) GetAccount(customerID string) *models.Account {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	if acc, ok := s.accounts[customerID]; ok {
 		return acc
 	}
@@ -28,19 +53,90 @@ func (s *Cache) GetAccount(customerID string) *models.Account {
 
 // AddAccountToStore ...
 func (s *Cache) AddAccount(account *models.Account) *models.Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.accounts[account.CustomerID] = account
 	return account
 }
 
 // AddTransaction ...
-func (s *Cache) AddTransaction(id, customerID string) {
-	s.transactions[id+customerID] = struct{}{}
+func (s *Cache) AddTransaction(entry dedup.Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transactions.Add(entry)
+}
+
+// GetTransaction ...
+func (s *Cache) GetTransaction(id, customerID string) (dedup.Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.transactions.Get(dedup.Key{ID: id, CustomerID: customerID})
 }
 
 // IsDuplicateTransaction ...
 func (s *Cache) IsDuplicateTransaction(id, customerID string) bool {
-	if _, ok := s.transactions[id+customerID]; ok {
-		return true
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.transactions.Contains(dedup.Key{ID: id, CustomerID: customerID})
+}
+
+// AccountCount ...
+func (s *Cache) AccountCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.accounts)
+}
+
+// TransactionCount ...
+func (s *Cache) TransactionCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.transactions.Len()
+}
+
+// GetCustomerLimits ...
+func (s *Cache) GetCustomerLimits(customerID string) *models.CustomerLimits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.customers[customerID]
+}
+
+// AddCustomerLimits ...
+func (s *Cache) AddCustomerLimits(limits *models.CustomerLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.customers[limits.CustomerID] = limits
+}
+
+// State returns a copy of the accounts, transactions and customer limits.
+func (s *Cache) State() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state := State{Transactions: s.transactions.Entries()}
+	for _, account := range s.accounts {
+		state.Accounts = append(state.Accounts, account.Clone())
+	}
+	for _, limits := range s.customers {
+		state.Customers = append(state.Customers, limits.Clone())
 	}
-	return false
+	return state
+}
+
+// Restore replaces everything the cache holds with the state.
+func (s *Cache) Restore(state State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts = make(map[string]*models.Account, len(state.Accounts))
+	for _, account := range state.Accounts {
+		s.accounts[account.CustomerID] = account.Clone()
+	}
+	s.transactions = dedup.NewSet(s.dedupConfig)
+	for _, entry := range state.Transactions {
+		s.transactions.Add(entry)
+	}
+	s.customers = make(map[string]*models.CustomerLimits, len(state.Customers))
+	for _, limits := range state.Customers {
+		s.customers[limits.CustomerID] = limits.Clone()
+	}
+	return nil
 }
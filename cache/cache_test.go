@@ -2,7 +2,10 @@ package cache
 
 import (
 	"testing"
+	"time"
 
+	"velocitylimits/config"
+	"velocitylimits/dedup"
 	"velocitylimits/models"
 
 	"github.com/stretchr/testify/assert"
@@ -12,7 +15,8 @@ func TestNewCache(t *testing.T) {
 	t.Run("returns expected cache", func(t *testing.T) {
 		expectedCache := &Cache{
 			accounts:     make(map[string]*models.Account),
-			transactions: make(map[string]struct{}),
+			transactions: dedup.NewSet(config.Dedup{}),
+			customers:    make(map[string]*models.CustomerLimits),
 		}
 		actualCache := NewCache()
 		assert.Equal(t, expectedCache, actualCache)
@@ -46,27 +50,87 @@ func TestAddAccount(t *testing.T) {
 	})
 }
 
+func transaction(id, customerID string, t time.Time) dedup.Entry {
+	return dedup.Entry{Key: dedup.Key{ID: id, CustomerID: customerID}, Time: t}
+}
+
 func TestAddTransaction(t *testing.T) {
 	t.Run("adds transaction", func(t *testing.T) {
 		cache := NewCache()
-		cache.AddTransaction("11", "2")
-		duplicate := cache.transactions["11"+"2"]
-		assert.NotNil(t, duplicate)
+		cache.AddTransaction(transaction("11", "2", time.Time{}))
+		assert.True(t, cache.transactions.Contains(dedup.Key{ID: "11", CustomerID: "2"}))
+	})
+	t.Run("keeps transactions within the retention", func(t *testing.T) {
+		cache := NewBoundedCache(config.Dedup{Retention: 24 * time.Hour, MaxEntries: 2})
+		start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		cache.AddTransaction(transaction("1", "1", start))
+		cache.AddTransaction(transaction("2", "1", start.Add(25*time.Hour)))
+		assert.False(t, cache.IsDuplicateTransaction("1", "1"))
+		cache.AddTransaction(transaction("3", "1", start.Add(26*time.Hour)))
+		cache.AddTransaction(transaction("4", "1", start.Add(27*time.Hour)))
+		assert.False(t, cache.IsDuplicateTransaction("2", "1"))
+		assert.True(t, cache.IsDuplicateTransaction("4", "1"))
 	})
 }
 
 func TestCacheIsDuplicateTransaction(t *testing.T) {
 	t.Run("returns true when there is a duplicate transaction", func(t *testing.T) {
 		cache := NewCache()
-		cache.AddTransaction("11", "2")
+		cache.AddTransaction(transaction("11", "2", time.Time{}))
 		duplicate := cache.IsDuplicateTransaction("11", "2")
 		assert.True(t, duplicate)
 
 	})
+	t.Run("does not collide on concatenated keys", func(t *testing.T) {
+		cache := NewCache()
+		cache.AddTransaction(transaction("1", "23", time.Time{}))
+		assert.False(t, cache.IsDuplicateTransaction("12", "3"))
+	})
 	t.Run("returns false when there is no transaction", func(t *testing.T) {
 		cache := NewCache()
-		cache.AddTransaction("11", "2")
+		cache.AddTransaction(transaction("11", "2", time.Time{}))
 		duplicate := cache.IsDuplicateTransaction("111", "21")
 		assert.False(t, duplicate)
 	})
 }
+
+func TestCounts(t *testing.T) {
+	t.Run("counts the accounts and transactions", func(t *testing.T) {
+		cache := NewCache()
+		cache.AddAccount(&models.Account{CustomerID: "1"})
+		cache.AddTransaction(transaction("11", "1", time.Time{}))
+		cache.AddTransaction(transaction("12", "1", time.Time{}))
+		assert.Equal(t, 1, cache.AccountCount())
+		assert.Equal(t, 2, cache.TransactionCount())
+	})
+}
+
+func TestState(t *testing.T) {
+	t.Run("restores a copy of the state", func(t *testing.T) {
+		cache := NewCache()
+		cache.AddAccount(&models.Account{CustomerID: "1", Balance: models.Dollars(1)})
+		cache.AddTransaction(transaction("11", "1", time.Time{}))
+		cache.AddCustomerLimits(&models.CustomerLimits{CustomerID: "1", Tier: "premium"})
+		state := cache.State()
+		cache.GetAccount("1").Balance = models.Dollars(2)
+		cache.AddAccount(&models.Account{CustomerID: "2"})
+		cache.AddTransaction(transaction("12", "2", time.Time{}))
+
+		assert.NoError(t, cache.Restore(state))
+		assert.Equal(t, models.Dollars(1), cache.GetAccount("1").Balance)
+		assert.Nil(t, cache.GetAccount("2"))
+		assert.True(t, cache.IsDuplicateTransaction("11", "1"))
+		assert.False(t, cache.IsDuplicateTransaction("12", "2"))
+		assert.Equal(t, "premium", cache.GetCustomerLimits("1").Tier)
+	})
+}
+
+func TestCustomerLimits(t *testing.T) {
+	t.Run("returns the added customer limits", func(t *testing.T) {
+		cache := NewCache()
+		assert.Nil(t, cache.GetCustomerLimits("1"))
+		limits := &models.CustomerLimits{CustomerID: "1", Tier: "premium"}
+		cache.AddCustomerLimits(limits)
+		assert.Equal(t, limits, cache.GetCustomerLimits("1"))
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"velocitylimits/backtest"
+	"velocitylimits/cache"
+	"velocitylimits/config"
+)
+
+func runBacktest(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags, configPath := newFlagSet("backtest", "Runs the input through the config and every candidate config, each starting\nwith no accounts, and reports the acceptance rate and accepted volume of\neach and the loads they decide differently. Nothing is written to the store.", stderr)
+	in := flags.String("in", "", "input file, - for stdin (default velocitylimit.inputfile of the config)")
+	var candidates stringsFlag
+	flags.Var(&candidates, "candidate", "config file to compare with the config, may be repeated")
+	format := flags.String("format", formatTable, "report format, table or json")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		return &usageError{err: errors.New("--candidate is required")}
+	}
+	if *format != formatTable && *format != formatJSON {
+		return &usageError{err: fmt.Errorf("unknown format %q", *format)}
+	}
+
+	baseline, err := config.ParseConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	name := *configPath
+	if name == "" {
+		name = "config/config.yaml"
+	}
+	policies := []backtest.Policy{{Name: name, Config: baseline}}
+	for _, path := range candidates {
+		candidate, err := config.ParseConfig(path)
+		if err != nil {
+			return err
+		}
+		policies = append(policies, backtest.Policy{Name: path, Config: candidate})
+	}
+	for i := range policies {
+		store := cache.NewBoundedCache(policies[i].Config.Store.Dedup)
+		if err := importCustomers(policies[i].Config, store); err != nil {
+			return err
+		}
+		policies[i].Store = store
+	}
+
+	inputFile := baseline.VelocityLimit.InputFile
+	if *in != "" {
+		inputFile = *in
+	}
+	var input []byte
+	if inputFile == stdio {
+		input, err = ioutil.ReadAll(stdin)
+	} else {
+		input, err = ioutil.ReadFile(inputFile)
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	report, err := backtest.Run(ctx, input, policies)
+	if err != nil {
+		return err
+	}
+	if *format == formatJSON {
+		return report.WriteJSON(stdout)
+	}
+	return report.WriteTable(stdout)
+}
+
+// stringsFlag collects the values of a flag given more than once.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"time"
+
+	"velocitylimits/config"
+	"velocitylimits/engine"
+	"velocitylimits/models"
+	"velocitylimits/service"
+)
+
+func runCheck(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags, configPath := newFlagSet("check", "Reports the decision a load by a customer would get at a given time and\nwhat the customer could load before and after it, as JSON. Nothing is\nloaded, the store is left as it was.", stderr)
+	customerID := flags.String("customer", "", "customer to check")
+	var amount models.Money
+	flags.Var(moneyFlag{&amount}, "amount", "amount of the load, e.g. 100 or $1,000.00")
+	at := flags.String("at", "", "RFC 3339 time of the load (default now)")
+	customers := flags.String("customers", "", "JSON lines file of customer tiers and overrides (default tiers.customersfile)")
+	storeType := flags.String("store", "", "store type, memory or file (default store.type)")
+	storePath := flags.String("store-path", "", "directory of the file store (default store.path)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *customerID == "" {
+		return &usageError{err: errors.New("--customer is required")}
+	}
+	if amount <= 0 {
+		return &usageError{err: errors.New("--amount must be positive")}
+	}
+	var clock service.Clock = service.SystemClock{}
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return &usageError{err: err}
+		}
+		clock = service.FixedClock(t)
+	}
+
+	config, err := config.ParseConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "customers":
+			config.Tiers.CustomersFile = *customers
+		case "store":
+			config.Store.Type = *storeType
+		case "store-path":
+			config.Store.Path = *storePath
+		}
+	})
+	return check(config, *customerID, amount, clock, stdout)
+}
+
+// check prints the what-if of the load at the clock's time.
+func check(config *config.Configurations, customerID string, amount models.Money, clock service.Clock, stdout io.Writer) error {
+	store, closeStore, err := openStoreReadOnly(config.Store)
+	if err != nil {
+		return err
+	}
+	// the customers file is loaded beside the store rather than into it
+	cache := &customerOverlay{Cache: store, customers: make(map[string]*models.CustomerLimits)}
+	if err := importCustomers(config, cache); err != nil {
+		closeStore()
+		return err
+	}
+	whatIf := engine.New(engine.WithConfig(config), engine.WithStore(cache), engine.WithClock(clock)).WhatIf(customerID, amount, time.Time{})
+	if err := closeStore(); err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(whatIf)
+}
+
+// customerOverlay keeps the customer limits added to it apart from the store
+// underneath.
+type customerOverlay struct {
+	service.Cache
+	customers map[string]*models.CustomerLimits
+}
+
+func (c *customerOverlay) GetCustomerLimits(customerID string) *models.CustomerLimits {
+	if limits, ok := c.customers[customerID]; ok {
+		return limits
+	}
+	return c.Cache.GetCustomerLimits(customerID)
+}
+
+func (c *customerOverlay) AddCustomerLimits(limits *models.CustomerLimits) {
+	c.customers[limits.CustomerID] = limits
+}
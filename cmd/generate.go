@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"time"
+
+	"velocitylimits/config"
+	"velocitylimits/generator"
+)
+
+func runGenerate(args []string, stdin io.Reader, stdout, stderr io.Writer) (err error) {
+	defaults := generator.DefaultOptions()
+	flags, configPath := newFlagSet("generate", "Writes synthetic load requests, one JSON object per line, for testing and\nbenchmarking. The same flags and seed always write the same lines. Rates\nare percentages of the lines.", stderr)
+	out := flags.String("out", stdio, "output file, - for stdout")
+	lines := flags.Int("lines", defaults.Lines, "number of lines")
+	customers := flags.Int("customers", defaults.Customers, "number of customers")
+	skew := flags.Float64("skew", defaults.Skew, "Zipf exponent of the activity of the customers, over 1, or 0 for even activity")
+	amounts := flags.String("amounts", defaults.Amounts, "distribution of the amounts: uniform, exponential or lognormal")
+	minAmount, maxAmount, meanAmount := defaults.MinAmount, defaults.MaxAmount, defaults.MeanAmount
+	flags.Var(moneyFlag{&minAmount}, "min-amount", "smallest amount")
+	flags.Var(moneyFlag{&maxAmount}, "max-amount", "largest amount")
+	flags.Var(moneyFlag{&meanAmount}, "mean-amount", "mean amount of the exponential and lognormal distributions")
+	duplicateRate := flags.Float64("duplicate-rate", 0, "rate of lines repeating a recent load")
+	malformedRate := flags.Float64("malformed-rate", 0, "rate of lines that cannot be parsed")
+	outOfOrderRate := flags.Float64("out-of-order-rate", 0, "rate of loads dated before the loads around them")
+	maxDelay := flags.Duration("max-delay", 0, "how far before the loads around them out of order loads are dated (default reorder.lateness of --config, or 1h)")
+	start := flags.String("start", defaults.Start.Format(time.RFC3339), "RFC 3339 time of the first load")
+	span := flags.Duration("span", defaults.Span, "time between the first and the last load")
+	seed := flags.Int64("seed", defaults.Seed, "seed of the random numbers")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	options := generator.Options{
+		Lines:          *lines,
+		Customers:      *customers,
+		Skew:           *skew,
+		Amounts:        *amounts,
+		MinAmount:      minAmount,
+		MaxAmount:      maxAmount,
+		MeanAmount:     meanAmount,
+		DuplicateRate:  *duplicateRate,
+		MalformedRate:  *malformedRate,
+		OutOfOrderRate: *outOfOrderRate,
+		MaxDelay:       defaults.MaxDelay,
+		Span:           *span,
+		Seed:           *seed,
+	}
+	if options.Start, err = time.Parse(time.RFC3339, *start); err != nil {
+		return &usageError{err: err}
+	}
+	maxDelaySet := false
+	flags.Visit(func(f *flag.Flag) {
+		maxDelaySet = maxDelaySet || f.Name == "max-delay"
+	})
+	if maxDelaySet {
+		options.MaxDelay = *maxDelay
+	} else if *configPath != "" {
+		// out of order loads stay within reach of the reorder buffer
+		config, err := config.ParseConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		if config.Reorder.Lateness > 0 {
+			options.MaxDelay = config.Reorder.Lateness
+		}
+	}
+	if err := options.Validate(); err != nil {
+		return &usageError{err: err}
+	}
+
+	output := stdout
+	if *out != stdio {
+		outputFile, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := outputFile.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		output = outputFile
+	}
+	return generator.Generate(output, options)
+}
@@ -1,128 +1,254 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
-	"velocitylimits/models"
+	"os/signal"
+	"syscall"
 
+	"velocitylimits/audit"
 	"velocitylimits/cache"
-	"velocitylimits/service"
-
 	"velocitylimits/config"
+	"velocitylimits/models"
+	"velocitylimits/service"
+	"velocitylimits/store"
 
 	"github.com/sirupsen/logrus"
-	"golang.org/x/sync/errgroup"
 )
 
+// Exit codes of the binary.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// Formats of the backtest and verify reports.
+const (
+	formatTable = "table"
+	formatText  = "text"
+	formatJSON  = "json"
+)
+
+// command is a subcommand of the binary.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+// usageError marks errors caused by bad command line arguments.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func commands() []command {
+	return []command{
+		{name: "process", summary: "run a file or stdin of load requests through the velocity limits", run: runProcess},
+		{name: "serve", summary: "serve the HTTP and gRPC APIs", run: runServe},
+		{name: "replay", summary: "verify the audit log and rebuild the store state from it", run: runReplay},
+		{name: "check", summary: "report whether a load would be accepted, without making it", run: runCheck},
+		{name: "backtest", summary: "compare the decisions of configs on the same input", run: runBacktest},
+		{name: "verify", summary: "compare the responses to an input with the expected output", run: runVerify},
+		{name: "generate", summary: "write synthetic load requests for testing and benchmarking", run: runGenerate},
+	}
+}
+
 func main() {
-	config := config.ParseConfig()
-	cache := cache.NewCache()
-	errGroup := errgroup.Group{}
-
-	// go routine to read the file
-	requestC, getRequest := GetRequest(config)
-	errGroup.Go(getRequest)
-	// go routine to attempt load and validate
-	responseC, attemptLoadF := AttemptLoad(config, requestC, cache)
-	go attemptLoadF()
-	// go routine to write the response back to file
-	responderF := Responder(config, responseC)
-	errGroup.Go(responderF)
-
-	if err := errGroup.Wait(); err != nil {
-		logrus.Panicf("error. closing wait group: %v", err)
-	}
-}
-
-// GetRequest reads the file and converts to request
-func GetRequest(config *config.Configurations) (<-chan *models.Request, func() error) {
-	requestC := make(chan *models.Request)
-	parser := func() error {
-		inputFile, err := OpenFile(config)
-		if err != nil {
-			return err
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the subcommand named by the first argument and returns the
+// process exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		printUsage(stderr)
+		return exitUsage
+	}
+	if args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		printUsage(stdout)
+		return exitOK
+	}
+	for _, cmd := range commands() {
+		if cmd.name != args[0] {
+			continue
 		}
-		defer inputFile.Close()
-		scanner := bufio.NewScanner(inputFile)
-		for scanner.Scan() {
-			request, err := models.NewRequest(scanner.Text())
-			if err != nil {
-				logrus.Error(err)
-				return err
-			}
-			// add the request to the request channel
-			requestC <- request
-			// error reading file
-			if err := scanner.Err(); err != nil {
-				return err
-			}
+		err := cmd.run(args[1:], stdin, stdout, stderr)
+		var usageErr *usageError
+		switch {
+		case err == nil:
+			return exitOK
+		case errors.Is(err, flag.ErrHelp):
+			return exitOK
+		case errors.As(err, &usageErr):
+			fmt.Fprintf(stderr, "velocitylimits %s: %v\n", cmd.name, err)
+			return exitUsage
 		}
-		// close the channel
-		close(requestC)
-		return nil
+		logrus.Errorf("velocitylimits %s: %v", cmd.name, err)
+		return exitFailure
 	}
-	return requestC, parser
+	fmt.Fprintf(stderr, "velocitylimits: unknown command %q\n\n", args[0])
+	printUsage(stderr)
+	return exitUsage
 }
 
-// AttemptLoad reads the request, validates, attempts to load and writes the response back
-func AttemptLoad(config *config.Configurations, requestC <-chan *models.Request, cache *cache.Cache) (<-chan *models.Response, func()) {
-	responseC := make(chan *models.Response)
-	attemptLoader := func() {
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: velocitylimits <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'velocitylimits <command> --help' for the flags of a command.")
+	fmt.Fprintln(w, "Exit codes: 0 success, 1 failure, 2 invalid arguments.")
+}
 
-		for request := range requestC {
-			// attempt to load
-			response := service.AttemptLoad(request, config, cache)
-			// adds the response to the response channel
-			responseC <- response
+// signalContext returns a context that is canceled once SIGINT or SIGTERM is
+// received, and a function that stops listening for them.
+func signalContext(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	signalC := make(chan os.Signal, 1)
+	signal.Notify(signalC, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signalC:
+			logrus.Infof("Received %s", sig)
+			cancel()
+		case <-ctx.Done():
 		}
-		// close the response channel
-		close(responseC)
-	}
-	return responseC, attemptLoader
-}
-
-// Responder writes the response back to the file
-func Responder(config *config.Configurations, responseC <-chan *models.Response) func() error {
-	responder := func() error {
-		outputFile := CreateFile(config)
-		defer outputFile.Close()
-		writer := bufio.NewWriter(outputFile)
-
-		for response := range responseC {
-			resBytes, err := json.Marshal(response)
-			if err != nil {
-				logrus.Errorf("Error marshalling json:%v", err)
-				return err
-			}
-			// write to file
-			if _, err = writer.WriteString(string(resBytes) + "\n"); err != nil {
-				logrus.Errorf("Error writing to file file:%v", err)
-				return err
-			}
+	}()
+	return ctx, func() {
+		signal.Stop(signalC)
+		cancel()
+	}
+}
 
+// newFlagSet returns a flag set for a subcommand with the shared --config
+// flag registered.
+func newFlagSet(name, synopsis string, stderr io.Writer) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "path to the config file (default config/config.yaml)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: velocitylimits %s [flags]\n\n%s\n\nFlags:\n", name, synopsis)
+		flags.PrintDefaults()
+	}
+	return flags, configPath
+}
+
+// parseFlags parses args and rejects positional arguments.
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
 		}
-		writer.Flush()
+		return &usageError{err: err}
+	}
+	if flags.NArg() > 0 {
+		return &usageError{err: fmt.Errorf("unexpected arguments %v", flags.Args())}
+	}
+	return nil
+}
+
+// OpenCache returns the cache selected in the store config, loaded with the
+// customers file, and a function that closes it once processing is done.
+func OpenCache(config *config.Configurations) (service.Cache, func() error, error) {
+	cache, closeCache, err := openStore(config.Store)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := importCustomers(config, cache); err != nil {
+		closeCache()
+		return nil, nil, err
+	}
+	return cache, closeCache, nil
+}
+
+// importCustomers adds the customers file named in the config, if any, to the
+// cache.
+func importCustomers(config *config.Configurations, cache service.Cache) error {
+	if config.Tiers.CustomersFile == "" {
 		return nil
 	}
+	customers, err := os.Open(config.Tiers.CustomersFile)
+	if err != nil {
+		return err
+	}
+	defer customers.Close()
+	count, err := service.ImportCustomerLimits(customers, cache)
+	if err != nil {
+		return fmt.Errorf("%s: %w", config.Tiers.CustomersFile, err)
+	}
+	logrus.Infof("Loaded the limits of %d customers from %s", count, config.Tiers.CustomersFile)
+	return nil
+}
 
-	return responder
+// OpenAudit opens the audit log named in the config, synced like the store,
+// or returns a nil auditor if none is, and a function that closes it.
+func OpenAudit(config *config.Configurations) (service.Auditor, func() error, error) {
+	if config.Audit.File == "" {
+		return nil, func() error { return nil }, nil
+	}
+	log, err := audit.Open(config.Audit.File, config.Version(), config.Store.SyncWrites)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", config.Audit.File, err)
+	}
+	return log, log.Close, nil
 }
 
-func OpenFile(config *config.Configurations) (*os.File, error) {
-	// TODO: Path for the file needs to be handled better
-	input, err := os.Open("../" + config.VelocityLimit.InputFile)
+func openStore(storeConfig config.Store) (service.Cache, func() error, error) {
+	switch storeConfig.Type {
+	case "", config.StoreTypeMemory:
+		return cache.NewBoundedCache(storeConfig.Dedup), func() error { return nil }, nil
+	case config.StoreTypeFile:
+		fileStore, err := store.Open(storeConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		return fileStore, fileStore.Close, nil
+	}
+	return nil, nil, fmt.Errorf("unknown store type %q", storeConfig.Type)
+}
+
+// openStoreReadOnly opens the store like openStore, leaving a file store as
+// it is on disk. A missing file store is an error rather than created.
+func openStoreReadOnly(storeConfig config.Store) (service.Cache, func() error, error) {
+	if storeConfig.Type != config.StoreTypeFile {
+		return openStore(storeConfig)
+	}
+	fileStore, err := store.OpenReadOnly(storeConfig)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	return fileStore, fileStore.Close, nil
+}
+
+// moneyFlag is a flag.Value for amounts such as "5000" or "$5,000.00".
+type moneyFlag struct {
+	value *models.Money
+}
+
+func (f moneyFlag) String() string {
+	if f.value == nil {
+		return ""
 	}
-	return input, nil
+	return f.value.String()
 }
 
-func CreateFile(config *config.Configurations) *os.File {
-	// TODO: Path for the file needs to be handled better
-	output, err := os.Create("../" + config.VelocityLimit.OutputFile)
+func (f moneyFlag) Set(s string) error {
+	m, err := models.ParseMoney(s)
 	if err != nil {
-		logrus.Panicf("Unable to open file: %s", err)
+		return err
 	}
-	return output
+	*f.value = m
+	return nil
 }
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"velocitylimits/audit"
+	"velocitylimits/backtest"
+	"velocitylimits/config"
+	"velocitylimits/conformance"
+	"velocitylimits/engine"
+	"velocitylimits/metrics"
+	"velocitylimits/models"
+	"velocitylimits/pipeline"
+	"velocitylimits/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = "../config/config.yaml"
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cmd")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func runCommand(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	t.Run("prints usage for --help", func(t *testing.T) {
+		code, stdout, _ := runCommand("", "--help")
+		assert.Equal(t, exitOK, code)
+		assert.Contains(t, stdout, "process")
+		assert.Contains(t, stdout, "serve")
+	})
+	t.Run("exits with usage code without a command", func(t *testing.T) {
+		code, _, stderr := runCommand("")
+		assert.Equal(t, exitUsage, code)
+		assert.Contains(t, stderr, "Usage")
+	})
+	t.Run("exits with usage code for an unknown command", func(t *testing.T) {
+		code, _, stderr := runCommand("", "frobnicate")
+		assert.Equal(t, exitUsage, code)
+		assert.Contains(t, stderr, "unknown command")
+	})
+}
+
+func TestRunProcess(t *testing.T) {
+	input := `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-01T00:00:00Z"}
+{"id":"2","customer_id":"528","load_amount":"$100","time":"2000-01-01T01:00:00Z"}
+{"id":"3","customer_id":"528","load_amount":"$3000","time":"2000-01-01T02:00:00Z"}
+`
+	t.Run("reads stdin and writes stdout", func(t *testing.T) {
+		code, stdout, _ := runCommand(input, "process", "--config", testConfig, "--in", "-", "--out", "-", "--dead-letter", "")
+		assert.Equal(t, exitOK, code)
+		assert.Equal(t, `{"id":"1","customer_id":"528","accepted":true}
+{"id":"2","customer_id":"528","accepted":true}
+{"id":"3","customer_id":"528","accepted":false}
+`, stdout)
+	})
+	t.Run("flags override the config", func(t *testing.T) {
+		code, stdout, _ := runCommand(input, "process", "--config", testConfig, "--in", "-", "--out", "-", "--dead-letter", "",
+			"--max-daily-transactions", "1", "--max-daily-load", "$10,000", "--include-reason")
+		assert.Equal(t, exitOK, code)
+		assert.Equal(t, `{"id":"1","customer_id":"528","accepted":true,"reason":"accepted"}
+{"id":"2","customer_id":"528","accepted":false,"reason":"daily_count_exceeded","limit":"daily_count","remaining":"0","limit_value":"1"}
+{"id":"3","customer_id":"528","accepted":false,"reason":"daily_count_exceeded","limit":"daily_count","remaining":"0","limit_value":"1"}
+`, stdout)
+	})
+	t.Run("dead letters malformed lines and prints a summary", func(t *testing.T) {
+		deadLetter := filepath.Join(tempDir(t), "deadletter.txt")
+		code, stdout, stderr := runCommand("not json\n"+input, "process", "--config", testConfig, "--in", "-", "--out", "-", "--dead-letter", deadLetter)
+		assert.Equal(t, exitOK, code)
+		assert.Len(t, strings.Split(strings.TrimSpace(stdout), "\n"), 3)
+		assert.Contains(t, stderr, "4 lines read: 2 accepted, 1 declined, 1 malformed")
+		contents, err := ioutil.ReadFile(deadLetter)
+		require.NoError(t, err)
+		assert.Contains(t, string(contents), `"line":1`)
+		assert.Contains(t, string(contents), `"input":"not json"`)
+	})
+	t.Run("declines lines breaking the validation rules", func(t *testing.T) {
+		invalid := `{"id":"4","customer_id":"528","load_amount":"1","time":"2000-01-01T03:00:00Z"}` + "\n"
+		code, stdout, stderr := runCommand(input+invalid, "process", "--config", testConfig, "--in", "-", "--out", "-", "--dead-letter", "", "--include-reason")
+		assert.Equal(t, exitOK, code)
+		assert.True(t, strings.HasSuffix(stdout, `{"id":"4","customer_id":"528","accepted":false,"reason":"invalid"}`+"\n"), stdout)
+		assert.Contains(t, stderr, "4 lines read: 2 accepted, 2 declined, 0 malformed, 0 late, 1 invalid")
+	})
+	t.Run("exits with failure code over the error rate", func(t *testing.T) {
+		code, _, _ := runCommand(strings.Repeat("not json\n", 200), "process", "--config", testConfig, "--in", "-", "--out", "-", "--dead-letter", "", "--max-error-rate", "1")
+		assert.Equal(t, exitFailure, code)
+	})
+	t.Run("applies the tiers of the customers file", func(t *testing.T) {
+		customers := filepath.Join(tempDir(t), "customers.json")
+		require.NoError(t, ioutil.WriteFile(customers, []byte(`{"customer_id":"528","tier":"premium"}`+"\n"), 0644))
+		code, stdout, _ := runCommand(input, "process", "--config", testConfig, "--in", "-", "--out", "-", "--dead-letter", "", "--customers", customers)
+		assert.Equal(t, exitOK, code)
+		assert.NotContains(t, stdout, `"accepted":false`)
+	})
+	t.Run("replays retried lines with --idempotent", func(t *testing.T) {
+		retried := input + strings.SplitAfter(input, "\n")[0]
+		code, stdout, _ := runCommand(retried, "process", "--config", testConfig, "--in", "-", "--out", "-", "--dead-letter", "", "--idempotent")
+		assert.Equal(t, exitOK, code)
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		require.Len(t, lines, 4)
+		assert.Equal(t, lines[0], lines[3])
+	})
+	t.Run("routes late lines to the late file", func(t *testing.T) {
+		late := filepath.Join(tempDir(t), "late.txt")
+		lateInput := input + `{"id":"4","customer_id":"528","load_amount":"$1","time":"2000-01-01T00:30:00Z"}` + "\n"
+		code, stdout, stderr := runCommand(lateInput, "process", "--config", testConfig, "--in", "-", "--out", "-", "--dead-letter", "",
+			"--lateness", "1h", "--late-policy", "file", "--late-file", late)
+		assert.Equal(t, exitOK, code)
+		assert.Len(t, strings.Split(strings.TrimSpace(stdout), "\n"), 3)
+		assert.Contains(t, stderr, "1 late")
+		contents, err := ioutil.ReadFile(late)
+		require.NoError(t, err)
+		assert.Contains(t, string(contents), `"line":4`)
+	})
+	t.Run("serves metrics while processing", func(t *testing.T) {
+		code, stdout, _ := runCommand(input, "process", "--config", testConfig, "--in", "-", "--out", "-", "--dead-letter", "", "--metrics-addr", "127.0.0.1:0")
+		assert.Equal(t, exitOK, code)
+		assert.Len(t, strings.Split(strings.TrimSpace(stdout), "\n"), 3)
+	})
+	t.Run("exits with failure code when metrics cannot be served", func(t *testing.T) {
+		code, _, _ := runCommand(input, "process", "--config", testConfig, "--in", "-", "--out", "-", "--dead-letter", "", "--metrics-addr", "not an address")
+		assert.Equal(t, exitFailure, code)
+	})
+	t.Run("prints the flags for --help", func(t *testing.T) {
+		code, _, stderr := runCommand("", "process", "--help")
+		assert.Equal(t, exitOK, code)
+		assert.Contains(t, stderr, "-in")
+		assert.Contains(t, stderr, "-max-weekly-load")
+	})
+	t.Run("exits with usage code for a bad flag", func(t *testing.T) {
+		code, _, _ := runCommand("", "process", "--max-daily-load", "lots")
+		assert.Equal(t, exitUsage, code)
+		code, _, _ = runCommand("", "process", "extra")
+		assert.Equal(t, exitUsage, code)
+	})
+	t.Run("exits with failure code for a missing input file", func(t *testing.T) {
+		code, _, _ := runCommand("", "process", "--config", testConfig, "--in", "does-not-exist.txt", "--out", "-", "--dead-letter", "")
+		assert.Equal(t, exitFailure, code)
+	})
+	t.Run("exits with failure code for a missing config file", func(t *testing.T) {
+		code, _, _ := runCommand("", "process", "--config", "does-not-exist.yaml")
+		assert.Equal(t, exitFailure, code)
+	})
+}
+
+// killedReader fails once n bytes have been read, like a process killed part
+// way through its input.
+type killedReader struct {
+	r io.Reader
+	n int
+}
+
+func (k *killedReader) Read(p []byte) (int, error) {
+	if k.n <= 0 {
+		return 0, errors.New("killed")
+	}
+	if len(p) > k.n {
+		p = p[:k.n]
+	}
+	n, err := k.r.Read(p)
+	k.n -= n
+	return n, err
+}
+
+func TestRunProcessResume(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&input, `{"id":"%d","customer_id":"%d","load_amount":"$%d","time":"2000-01-01T%02d:00:00Z"}`+"\n", i, i%3, 1000+i*100, i/2)
+		if i%7 == 0 {
+			input.WriteString("not json\n")
+		}
+	}
+	dir := tempDir(t)
+	inputFile := filepath.Join(dir, "input.txt")
+	require.NoError(t, ioutil.WriteFile(inputFile, []byte(input.String()), 0644))
+	code, _, _ := runCommand("", "process", "--config", testConfig, "--in", inputFile, "--out", filepath.Join(dir, "expected.txt"),
+		"--dead-letter", filepath.Join(dir, "expected-deadletter.txt"))
+	require.Equal(t, exitOK, code)
+
+	t.Run("continues a killed run from its checkpoint", func(t *testing.T) {
+		output, deadLetter, checkpoint := filepath.Join(dir, "output.txt"), filepath.Join(dir, "deadletter.txt"), filepath.Join(dir, "checkpoint.json")
+		args := []string{"process", "--config", testConfig, "--in", inputFile, "--out", output, "--dead-letter", deadLetter,
+			"--checkpoint-file", checkpoint, "--checkpoint-every", "7"}
+
+		// what the killed process left behind
+		killedConfig, err := config.ParseConfig(testConfig)
+		require.NoError(t, err)
+		killedConfig.Checkpoint = config.Checkpoint{File: checkpoint, Every: 7}
+		outputFile, err := os.Create(output)
+		require.NoError(t, err)
+		deadLetterFile, err := os.Create(deadLetter)
+		require.NoError(t, err)
+		killed := &killedReader{r: strings.NewReader(input.String()), n: input.Len() / 2}
+		_, err = pipeline.Run(context.Background(), killedConfig, killed, pipeline.Sinks{Output: outputFile, DeadLetter: deadLetterFile}, engine.New(engine.WithConfig(killedConfig)), nil)
+		require.Error(t, err)
+		require.NoError(t, outputFile.Close())
+		require.NoError(t, deadLetterFile.Close())
+		require.FileExists(t, checkpoint)
+
+		code, _, stderr := runCommand("", append(args, "--resume")...)
+		assert.Equal(t, exitOK, code)
+		assert.Contains(t, stderr, "46 lines read")
+		for actual, expected := range map[string]string{output: "expected.txt", deadLetter: "expected-deadletter.txt"} {
+			actualContents, err := ioutil.ReadFile(actual)
+			require.NoError(t, err)
+			expectedContents, err := ioutil.ReadFile(filepath.Join(dir, expected))
+			require.NoError(t, err)
+			assert.Equal(t, string(expectedContents), string(actualContents))
+		}
+		_, err = os.Stat(checkpoint)
+		assert.True(t, os.IsNotExist(err), "the checkpoint is removed once the run succeeds")
+	})
+	t.Run("cuts the audit log back to the checkpoint", func(t *testing.T) {
+		auditDir := tempDir(t)
+		output, checkpoint, auditFile := filepath.Join(auditDir, "output.txt"), filepath.Join(auditDir, "checkpoint.json"), filepath.Join(auditDir, "audit.log")
+		killedConfig, err := config.ParseConfig(testConfig)
+		require.NoError(t, err)
+		killedConfig.Checkpoint = config.Checkpoint{File: checkpoint, Every: 7}
+		auditLog, err := audit.Open(auditFile, killedConfig.Version(), false)
+		require.NoError(t, err)
+		killed := &killedReader{r: strings.NewReader(input.String()), n: input.Len() / 2}
+		_, err = pipeline.Run(context.Background(), killedConfig, killed, pipeline.Sinks{Output: ioutil.Discard},
+			engine.New(engine.WithConfig(killedConfig), engine.WithAuditor(auditLog)), nil)
+		require.Error(t, err)
+		from, err := pipeline.ReadCheckpoint(checkpoint)
+		require.NoError(t, err)
+		require.NotNil(t, from.AuditOffset)
+		// a decision made after the checkpoint, before the process was killed
+		next, err := models.ParseRequest("20", "2", "$3000", "2000-01-01T10:00:00Z")
+		require.NoError(t, err)
+		auditLog.Record(audit.Record{Request: next, Decision: models.Decision{Accepted: true}})
+		require.NoError(t, auditLog.Close())
+		require.True(t, auditLog.Offset() > *from.AuditOffset)
+
+		code, _, _ := runCommand("", "process", "--config", testConfig, "--in", inputFile, "--out", output, "--dead-letter", "",
+			"--checkpoint-file", checkpoint, "--checkpoint-every", "7", "--audit-file", auditFile, "--resume")
+		assert.Equal(t, exitOK, code)
+		file, err := os.Open(auditFile)
+		require.NoError(t, err)
+		defer file.Close()
+		audited := make(map[string]int)
+		_, err = audit.Read(file, func(record audit.Record) error {
+			audited[record.Request.ID]++
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Len(t, audited, 40)
+		for id, n := range audited {
+			assert.Equal(t, 1, n, "load %s is audited once", id)
+		}
+	})
+	t.Run("keeps the checkpoint of an interrupted run", func(t *testing.T) {
+		interruptedDir := tempDir(t)
+		interruptedConfig, err := config.ParseConfig(testConfig)
+		require.NoError(t, err)
+		interruptedConfig.VelocityLimit.InputFile = inputFile
+		interruptedConfig.VelocityLimit.OutputFile = filepath.Join(interruptedDir, "output.txt")
+		interruptedConfig.VelocityLimit.DeadLetterFile = ""
+		interruptedConfig.Checkpoint = config.Checkpoint{File: filepath.Join(interruptedDir, "checkpoint.json"), Every: 7}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err = process(ctx, interruptedConfig, false, strings.NewReader(""), ioutil.Discard, ioutil.Discard)
+		assert.EqualError(t, err, "interrupted after 0 lines")
+		require.FileExists(t, interruptedConfig.Checkpoint.File)
+
+		code, _, _ := runCommand("", "process", "--config", testConfig, "--in", inputFile, "--out", interruptedConfig.VelocityLimit.OutputFile,
+			"--dead-letter", "", "--checkpoint-file", interruptedConfig.Checkpoint.File, "--resume")
+		assert.Equal(t, exitOK, code)
+		actualContents, err := ioutil.ReadFile(interruptedConfig.VelocityLimit.OutputFile)
+		require.NoError(t, err)
+		expectedContents, err := ioutil.ReadFile(filepath.Join(dir, "expected.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, string(expectedContents), string(actualContents))
+	})
+	t.Run("starts from the beginning without a checkpoint", func(t *testing.T) {
+		output := filepath.Join(tempDir(t), "output.txt")
+		code, _, _ := runCommand("", "process", "--config", testConfig, "--in", inputFile, "--out", output, "--dead-letter", "",
+			"--checkpoint-file", filepath.Join(tempDir(t), "checkpoint.json"), "--resume")
+		assert.Equal(t, exitOK, code)
+		actualContents, err := ioutil.ReadFile(output)
+		require.NoError(t, err)
+		expectedContents, err := ioutil.ReadFile(filepath.Join(dir, "expected.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, string(expectedContents), string(actualContents))
+	})
+	t.Run("exits with usage code resuming without a checkpoint file", func(t *testing.T) {
+		code, _, _ := runCommand("", "process", "--config", testConfig, "--in", inputFile, "--resume")
+		assert.Equal(t, exitUsage, code)
+	})
+}
+
+func TestRunServe(t *testing.T) {
+	t.Run("prints the flags for --help", func(t *testing.T) {
+		code, _, stderr := runCommand("", "serve", "--help")
+		assert.Equal(t, exitOK, code)
+		assert.Contains(t, stderr, "-http-addr")
+	})
+	t.Run("exits with usage code without any address", func(t *testing.T) {
+		code, _, _ := runCommand("", "serve", "--config", testConfig, "--http-addr", "", "--grpc-addr", "")
+		assert.Equal(t, exitUsage, code)
+	})
+	t.Run("exits with failure code when metrics cannot be served", func(t *testing.T) {
+		code, _, _ := runCommand("", "serve", "--config", testConfig, "--http-addr", "127.0.0.1:0", "--grpc-addr", "", "--metrics-addr", "not an address")
+		assert.Equal(t, exitFailure, code)
+	})
+	t.Run("serves the metrics of the decisions beside the APIs", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		address := listener.Addr().String()
+		require.NoError(t, listener.Close())
+		config := &config.Configurations{
+			VelocityLimit: config.VelocityLimit{MaxDailyLoadLimit: models.Dollars(5000), MaxDailyTransactions: 3, MaxWeeklyLoadLimit: models.Dollars(20000)},
+			Server:        config.Server{Address: "127.0.0.1:0"},
+			Metrics:       config.Metrics{Address: address, Path: "/metrics"},
+		}
+		stats := metrics.New()
+		engine := engine.New(engine.WithConfig(config), engine.WithMetrics(stats))
+		ctx, cancel := context.WithCancel(context.Background())
+		errC := make(chan error, 1)
+		go func() { errC <- serve(ctx, config, engine, stats) }()
+		defer func() {
+			cancel()
+			assert.NoError(t, <-errC)
+		}()
+		request, err := models.ParseRequest("1", "528", "$1", "2000-01-01T00:00:00Z")
+		require.NoError(t, err)
+		_, err = engine.Evaluate(ctx, request)
+		require.NoError(t, err)
+
+		var body []byte
+		require.Eventually(t, func() bool {
+			response, err := http.Get("http://" + address + "/metrics")
+			if err != nil {
+				return false
+			}
+			defer response.Body.Close()
+			body, err = ioutil.ReadAll(response.Body)
+			return err == nil && response.StatusCode == http.StatusOK
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Contains(t, string(body), "velocitylimits_accepted_total 1\n")
+		assert.Contains(t, string(body), `velocitylimits_cache_size{kind="accounts"} 1`+"\n")
+	})
+}
+
+func TestRunReplay(t *testing.T) {
+	input := `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-01T00:00:00Z"}
+{"id":"2","customer_id":"528","load_amount":"$100","time":"2000-01-01T01:00:00Z"}
+{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-01T00:00:00Z"}
+{"id":"3","customer_id":"529","load_amount":"$3000","time":"2000-01-01T02:00:00Z"}
+`
+	process := func(t *testing.T, dir string) {
+		code, _, _ := runCommand(input, "process", "--config", testConfig, "--in", "-", "--out", filepath.Join(dir, "output.txt"), "--dead-letter", "",
+			"--store", "file", "--store-path", filepath.Join(dir, "data"), "--audit-file", filepath.Join(dir, "audit.log"))
+		require.Equal(t, exitOK, code)
+	}
+	t.Run("verifies a store that matches the audit log", func(t *testing.T) {
+		dir := tempDir(t)
+		process(t, dir)
+		code, stdout, _ := runCommand("", "replay", "--config", testConfig, "--audit-file", filepath.Join(dir, "audit.log"), "--store-path", filepath.Join(dir, "data"))
+		assert.Equal(t, exitOK, code)
+		assert.Contains(t, stdout, "4 audit records verified: 2 accounts and 3 transactions match the store")
+	})
+	t.Run("exits with failure code for a store that differs", func(t *testing.T) {
+		dir := tempDir(t)
+		process(t, dir)
+		other := tempDir(t)
+		code, _, _ := runCommand(input, "process", "--config", testConfig, "--in", "-", "--out", filepath.Join(other, "output.txt"), "--dead-letter", "",
+			"--store", "file", "--store-path", filepath.Join(dir, "data"))
+		require.Equal(t, exitOK, code)
+		code, stdout, _ := runCommand(`{"id":"4","customer_id":"528","load_amount":"$1","time":"2000-01-01T03:00:00Z"}`+"\n", "process", "--config", testConfig,
+			"--in", "-", "--out", filepath.Join(other, "output.txt"), "--dead-letter", "", "--store", "file", "--store-path", filepath.Join(dir, "data"))
+		require.Equal(t, exitOK, code, stdout)
+		code, stdout, _ = runCommand("", "replay", "--config", testConfig, "--audit-file", filepath.Join(dir, "audit.log"), "--store-path", filepath.Join(dir, "data"))
+		assert.Equal(t, exitFailure, code)
+		assert.Contains(t, stdout, "account 528 differs from the audit log")
+		assert.Contains(t, stdout, "transaction 4 of customer 528 is stored but not in the audit log")
+	})
+	t.Run("exits with failure code for a tampered audit log", func(t *testing.T) {
+		dir := tempDir(t)
+		process(t, dir)
+		path := filepath.Join(dir, "audit.log")
+		contents, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(path, []byte(strings.Replace(string(contents), `"$100.00"`, `"$1.00"`, 1)), 0644))
+		code, stdout, _ := runCommand("", "replay", "--config", testConfig, "--audit-file", path, "--store-path", filepath.Join(dir, "data"))
+		assert.Equal(t, exitFailure, code)
+		assert.Empty(t, stdout)
+	})
+	t.Run("exits with usage code without an audit log", func(t *testing.T) {
+		code, _, _ := runCommand("", "replay", "--config", testConfig)
+		assert.Equal(t, exitUsage, code)
+	})
+}
+
+func TestRunCheck(t *testing.T) {
+	input := `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-01T00:00:00Z"}
+{"id":"2","customer_id":"528","load_amount":"$100","time":"2000-01-01T01:00:00Z"}
+`
+	t.Run("reports the limits of an unknown customer", func(t *testing.T) {
+		code, stdout, _ := runCommand("", "check", "--config", testConfig, "--store", "memory", "--customers", "",
+			"--customer", "528", "--amount", "$4,000", "--at", "2000-01-01T10:00:00Z")
+		assert.Equal(t, exitOK, code)
+		var whatIf models.WhatIf
+		require.NoError(t, json.Unmarshal([]byte(stdout), &whatIf))
+		assert.True(t, whatIf.Accepted)
+		assert.Equal(t, models.Dollars(5000), whatIf.Headroom.Daily.RemainingAmount)
+		assert.Equal(t, models.Dollars(1000), whatIf.After.Daily.RemainingAmount)
+	})
+	t.Run("checks against the file store without loading", func(t *testing.T) {
+		dir := tempDir(t)
+		code, _, _ := runCommand(input, "process", "--config", testConfig, "--in", "-", "--out", filepath.Join(dir, "output.txt"), "--dead-letter", "",
+			"--store", "file", "--store-path", filepath.Join(dir, "data"))
+		require.Equal(t, exitOK, code)
+		check := func(amount string) models.WhatIf {
+			code, stdout, _ := runCommand("", "check", "--config", testConfig, "--store", "file", "--store-path", filepath.Join(dir, "data"), "--customers", "",
+				"--customer", "528", "--amount", amount, "--at", "2000-01-01T10:00:00Z")
+			require.Equal(t, exitOK, code)
+			var whatIf models.WhatIf
+			require.NoError(t, json.Unmarshal([]byte(stdout), &whatIf))
+			return whatIf
+		}
+		// a torn record is left for the next writer to drop
+		journal := []byte(`{"account":{"CustomerID":"529"`)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "data", "journal.log"), journal, 0644))
+		snapshot, err := ioutil.ReadFile(filepath.Join(dir, "data", "snapshot.json"))
+		require.NoError(t, err)
+		whatIf := check("1900")
+		assert.True(t, whatIf.Accepted)
+		assert.Equal(t, models.Dollars(0), whatIf.After.Daily.RemainingAmount)
+		whatIf = check("1900.01")
+		assert.False(t, whatIf.Accepted)
+		assert.Equal(t, models.ReasonDailyAmountExceeded, whatIf.Reason)
+		assert.Equal(t, models.Dollars(1900), whatIf.Headroom.Daily.RemainingAmount)
+		// the store is not snapshotted or otherwise rewritten
+		after, err := ioutil.ReadFile(filepath.Join(dir, "data", "journal.log"))
+		require.NoError(t, err)
+		assert.Equal(t, journal, after)
+		after, err = ioutil.ReadFile(filepath.Join(dir, "data", "snapshot.json"))
+		require.NoError(t, err)
+		assert.Equal(t, snapshot, after)
+	})
+	t.Run("exits with failure code while another process has the store open", func(t *testing.T) {
+		dir := filepath.Join(tempDir(t), "data")
+		open, err := store.Open(config.Store{Type: config.StoreTypeFile, Path: dir})
+		require.NoError(t, err)
+		defer open.Close()
+		code, _, _ := runCommand("", "check", "--config", testConfig, "--store", "file", "--store-path", dir, "--customers", "",
+			"--customer", "528", "--amount", "1")
+		assert.Equal(t, exitFailure, code)
+	})
+	t.Run("exits with failure code for a missing file store", func(t *testing.T) {
+		code, _, _ := runCommand("", "check", "--config", testConfig, "--store", "file", "--store-path", filepath.Join(tempDir(t), "data"),
+			"--customer", "528", "--amount", "1")
+		assert.Equal(t, exitFailure, code)
+	})
+	t.Run("exits with usage code without a customer or amount", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"--amount", "1"},
+			{"--customer", "528"},
+			{"--customer", "528", "--amount", "-1"},
+			{"--customer", "528", "--amount", "1", "--at", "today"},
+		} {
+			code, _, _ := runCommand("", append([]string{"check", "--config", testConfig}, args...)...)
+			assert.Equal(t, exitUsage, code, args)
+		}
+	})
+}
+
+func TestRunBacktest(t *testing.T) {
+	input := `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-01T00:00:00Z"}
+{"id":"2","customer_id":"528","load_amount":"$2500","time":"2000-01-01T01:00:00Z"}
+`
+	dir := tempDir(t)
+	defaults, err := ioutil.ReadFile(testConfig)
+	require.NoError(t, err)
+	candidate := filepath.Join(dir, "candidate.yaml")
+	require.NoError(t, ioutil.WriteFile(candidate, bytes.Replace(defaults, []byte("maxdailyloadlimit: 5000"), []byte("maxdailyloadlimit: 6000"), 1), 0644))
+	t.Run("prints a table of the outcomes and differences", func(t *testing.T) {
+		code, stdout, _ := runCommand(input, "backtest", "--config", testConfig, "--candidate", candidate, "--in", "-")
+		assert.Equal(t, exitOK, code)
+		assert.Regexp(t, `\.\./config/config\.yaml +2 +1 +50\.00% +\$3,000\.00 +daily_amount_exceeded 1\n`, stdout)
+		assert.Regexp(t, `candidate\.yaml +2 +2 +100\.00% +\$5,500\.00 +-\n`, stdout)
+		assert.Contains(t, stdout, "1 loads are decided differently:")
+		assert.Regexp(t, `2 +528 +\$2,500\.00 +2000-01-01T01:00:00Z +daily_amount_exceeded +accepted\n`, stdout)
+	})
+	t.Run("prints JSON", func(t *testing.T) {
+		path := filepath.Join(dir, "input.txt")
+		require.NoError(t, ioutil.WriteFile(path, []byte(input), 0644))
+		code, stdout, _ := runCommand("", "backtest", "--config", testConfig, "--candidate", candidate, "--candidate", testConfig, "--in", path, "--format", "json")
+		assert.Equal(t, exitOK, code)
+		var report backtest.Report
+		require.NoError(t, json.Unmarshal([]byte(stdout), &report))
+		require.Len(t, report.Outcomes, 3)
+		assert.Equal(t, candidate, report.Outcomes[1].Name)
+		assert.Equal(t, models.Dollars(5500), report.Outcomes[1].AcceptedVolume)
+		require.Len(t, report.Differences, 1)
+		assert.Equal(t, []models.Reason{models.ReasonDailyAmountExceeded, models.ReasonAccepted, models.ReasonDailyAmountExceeded}, report.Differences[0].Reasons)
+	})
+	t.Run("exits with usage code without a candidate or for an unknown format", func(t *testing.T) {
+		code, _, _ := runCommand(input, "backtest", "--config", testConfig, "--in", "-")
+		assert.Equal(t, exitUsage, code)
+		code, _, _ = runCommand(input, "backtest", "--config", testConfig, "--candidate", candidate, "--in", "-", "--format", "csv")
+		assert.Equal(t, exitUsage, code)
+	})
+	t.Run("exits with failure code for a missing candidate", func(t *testing.T) {
+		code, _, _ := runCommand(input, "backtest", "--config", testConfig, "--candidate", filepath.Join(dir, "missing.yaml"), "--in", "-")
+		assert.Equal(t, exitFailure, code)
+	})
+}
+
+func TestRunVerify(t *testing.T) {
+	t.Run("gives the expected output for the input", func(t *testing.T) {
+		code, stdout, _ := runCommand("", "verify", "--config", testConfig, "--in", "../input.txt", "--customers", "", "--expected", "testdata/output.txt")
+		assert.Equal(t, exitOK, code, stdout)
+		assert.Equal(t, "1000 responses match the expected output\n", stdout)
+	})
+	t.Run("exits with failure code for a mismatch", func(t *testing.T) {
+		golden, err := ioutil.ReadFile("testdata/output.txt")
+		require.NoError(t, err)
+		expected := filepath.Join(tempDir(t), "output.txt")
+		require.NoError(t, ioutil.WriteFile(expected, bytes.Replace(golden, []byte(`{"id":"15887","customer_id":"528","accepted":true}`), []byte(`{"id":"15887","customer_id":"528","accepted":false}`), 1), 0644))
+		code, stdout, _ := runCommand("", "verify", "--config", testConfig, "--in", "../input.txt", "--customers", "", "--expected", expected)
+		assert.Equal(t, exitFailure, code)
+		assert.Equal(t, `line 1: 15887 of customer 528: expected declined, got accepted
+  request: $3,318.47 at 2000-01-01T00:00:00Z
+  before:  no account
+  after:   $1,681.53 of $5,000.00 and 2 of 3 loads left on the day of 2000-01-01, $16,681.53 of $20,000.00 left in the week of 1999-12-27
+`, stdout)
+
+		code, stdout, _ = runCommand("", "verify", "--config", testConfig, "--in", "../input.txt", "--customers", "", "--expected", expected, "--format", "json")
+		assert.Equal(t, exitFailure, code)
+		var result conformance.Result
+		require.NoError(t, json.Unmarshal([]byte(stdout), &result))
+		require.Len(t, result.Mismatches, 1)
+		assert.Equal(t, models.Dollars(5000), result.Mismatches[0].After.Daily.LimitAmount)
+	})
+	t.Run("exits with usage code without an expected output", func(t *testing.T) {
+		code, _, _ := runCommand("", "verify", "--config", testConfig)
+		assert.Equal(t, exitUsage, code)
+	})
+}
+
+func TestRunGenerate(t *testing.T) {
+	args := []string{"generate", "--lines", "500", "--customers", "20", "--duplicate-rate", "5", "--malformed-rate", "2", "--out-of-order-rate", "5", "--seed", "7"}
+	t.Run("writes the same input for the same seed", func(t *testing.T) {
+		code, stdout, _ := runCommand("", args...)
+		assert.Equal(t, exitOK, code)
+		assert.Equal(t, 500, strings.Count(stdout, "\n"))
+		path := filepath.Join(tempDir(t), "input.txt")
+		code, _, _ = runCommand("", append(args, "--out", path)...)
+		assert.Equal(t, exitOK, code)
+		contents, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, stdout, string(contents))
+
+		code, _, stderr := runCommand(stdout, "process", "--config", testConfig, "--in", "-", "--out", "-", "--dead-letter", "", "--lateness", "1h")
+		assert.Equal(t, exitOK, code)
+		assert.Contains(t, stderr, "500 lines read")
+	})
+	t.Run("dates out of order loads back by the lateness of the config", func(t *testing.T) {
+		config := filepath.Join(tempDir(t), "config.yaml")
+		require.NoError(t, ioutil.WriteFile(config, []byte("reorder:\n  lateness: 10m\n"), 0644))
+		// the loads are on the hour before they are dated back
+		_, stdout, _ := runCommand("", "generate", "--config", config, "--lines", "100", "--span", "100h", "--out-of-order-rate", "100")
+		assert.NotRegexp(t, `T\d\d:[0-4]\d:`, stdout)
+		_, stdout, _ = runCommand("", "generate", "--lines", "100", "--span", "100h", "--out-of-order-rate", "100")
+		assert.Regexp(t, `T\d\d:[0-4]\d:`, stdout)
+	})
+	t.Run("exits with usage code for invalid options", func(t *testing.T) {
+		for _, flags := range [][]string{
+			{"--customers", "0"},
+			{"--skew", "0.5"},
+			{"--amounts", "normal"},
+			{"--min-amount", "100", "--max-amount", "10"},
+			{"--malformed-rate", "101"},
+			{"--start", "today"},
+		} {
+			code, _, _ := runCommand("", append([]string{"generate"}, flags...)...)
+			assert.Equal(t, exitUsage, code, flags)
+		}
+	})
+}
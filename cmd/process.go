@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"velocitylimits/audit"
+	"velocitylimits/config"
+	"velocitylimits/engine"
+	"velocitylimits/metrics"
+	"velocitylimits/models"
+	"velocitylimits/pipeline"
+
+	"github.com/sirupsen/logrus"
+)
+
+// stdio is the --in and --out value that selects stdin and stdout.
+const stdio = "-"
+
+func runProcess(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags, configPath := newFlagSet("process", "Runs load requests, one JSON object per line, through the velocity limits\nand writes one response line per request. Flags override the config file.", stderr)
+	in := flags.String("in", "", "input file, - for stdin (default velocitylimit.inputfile)")
+	out := flags.String("out", "", "output file, - for stdout (default velocitylimit.outputfile)")
+	deadLetter := flags.String("dead-letter", "", "file for malformed lines, empty to only log them (default velocitylimit.deadletterfile)")
+	maxErrorRate := flags.Float64("max-error-rate", 0, "abort when more than this percentage of lines is malformed, 0 never aborts (default pipeline.maxerrorrate)")
+	workers := flags.Int("workers", 0, "number of workers attempting loads (default pipeline.workers)")
+	preserveOrder := flags.Bool("preserve-order", false, "write responses in input order (default pipeline.preserveorder)")
+	includeReason := flags.Bool("include-reason", false, "add decline reasons to the responses (default output.includereason)")
+	lateness := flags.Duration("lateness", 0, "put requests up to this far behind the newest one back in time order (default reorder.lateness)")
+	latePolicy := flags.String("late-policy", "", "reprocess, reject or file requests behind the watermark (default reorder.latepolicy)")
+	lateFile := flags.String("late-file", "", "file for late requests under the file policy (default reorder.latefile)")
+	auditFile := flags.String("audit-file", "", "audit log of every decision, empty to not audit (default audit.file)")
+	checkpointFile := flags.String("checkpoint-file", "", "file to checkpoint the run in, empty to not checkpoint (default checkpoint.file)")
+	checkpointEvery := flags.Int("checkpoint-every", 0, "input lines between checkpoints (default checkpoint.every)")
+	resume := flags.Bool("resume", false, "continue the run recorded in the checkpoint file, if there is one")
+	metricsAddress := flags.String("metrics-addr", "", "serve Prometheus metrics on this address while processing, empty to not serve them (default metrics.address)")
+	customers := flags.String("customers", "", "JSON lines file of customer tiers and overrides (default tiers.customersfile)")
+	storeType := flags.String("store", "", "store type, memory or file (default store.type)")
+	storePath := flags.String("store-path", "", "directory of the file store (default store.path)")
+	idempotent := flags.Bool("idempotent", false, "answer retried loads with their original response (default store.dedup.idempotent)")
+	var maxDailyLoad, maxWeeklyLoad models.Money
+	flags.Var(moneyFlag{&maxDailyLoad}, "max-daily-load", "maximum amount loaded per day (default velocitylimit.maxdailyloadlimit)")
+	maxDailyTransactions := flags.Int("max-daily-transactions", 0, "maximum loads per day (default velocitylimit.maxdailytransactions)")
+	flags.Var(moneyFlag{&maxWeeklyLoad}, "max-weekly-load", "maximum amount loaded per week (default velocitylimit.maxweeklyloadlimit)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	config, err := config.ParseConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	// only flags given on the command line override the config
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "in":
+			config.VelocityLimit.InputFile = *in
+		case "out":
+			config.VelocityLimit.OutputFile = *out
+		case "dead-letter":
+			config.VelocityLimit.DeadLetterFile = *deadLetter
+		case "max-error-rate":
+			config.Pipeline.MaxErrorRate = *maxErrorRate
+		case "workers":
+			config.Pipeline.Workers = *workers
+		case "preserve-order":
+			config.Pipeline.PreserveOrder = *preserveOrder
+		case "include-reason":
+			config.Output.IncludeReason = *includeReason
+		case "lateness":
+			config.Reorder.Lateness = *lateness
+		case "late-policy":
+			config.Reorder.LatePolicy = *latePolicy
+		case "late-file":
+			config.Reorder.LateFile = *lateFile
+		case "audit-file":
+			config.Audit.File = *auditFile
+		case "checkpoint-file":
+			config.Checkpoint.File = *checkpointFile
+		case "checkpoint-every":
+			config.Checkpoint.Every = *checkpointEvery
+		case "metrics-addr":
+			config.Metrics.Address = *metricsAddress
+		case "customers":
+			config.Tiers.CustomersFile = *customers
+		case "store":
+			config.Store.Type = *storeType
+		case "store-path":
+			config.Store.Path = *storePath
+		case "idempotent":
+			config.Store.Dedup.Idempotent = *idempotent
+		case "max-daily-load":
+			config.VelocityLimit.MaxDailyLoadLimit = maxDailyLoad
+		case "max-daily-transactions":
+			config.VelocityLimit.MaxDailyTransactions = *maxDailyTransactions
+		case "max-weekly-load":
+			config.VelocityLimit.MaxWeeklyLoadLimit = maxWeeklyLoad
+		}
+	})
+	if *resume && config.Checkpoint.File == "" {
+		return &usageError{err: errors.New("--resume needs a checkpoint file")}
+	}
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	return process(ctx, config, *resume, stdin, stdout, stderr)
+}
+
+// process runs the input named in the config through the pipeline and prints
+// a summary of the run to stderr. With resume set a run is continued from
+// its checkpoint, if there is one. The checkpoint is removed once the run
+// succeeds. Once ctx is done the requests read so far are answered and the
+// run fails, keeping the checkpoint to resume from.
+func process(ctx context.Context, config *config.Configurations, resume bool, stdin io.Reader, stdout, stderr io.Writer) (err error) {
+	var from *pipeline.Checkpoint
+	if resume {
+		if from, err = pipeline.ReadCheckpoint(config.Checkpoint.File); err != nil {
+			return fmt.Errorf("%s: %w", config.Checkpoint.File, err)
+		}
+		if from == nil {
+			logrus.Infof("No checkpoint in %s, starting from the beginning", config.Checkpoint.File)
+		} else if config.VelocityLimit.InputFile == stdio || config.VelocityLimit.OutputFile == stdio {
+			return &usageError{err: errors.New("cannot resume reading stdin or writing stdout")}
+		}
+	}
+
+	// the offsets the files are cut back to when resuming
+	var offsets pipeline.Checkpoint
+	if from != nil {
+		offsets = *from
+	}
+
+	cache, closeCache, err := OpenCache(config)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeCache(); err == nil {
+			err = closeErr
+		}
+	}()
+	if from != nil && from.AuditOffset != nil && config.Audit.File != "" {
+		// decisions after the checkpoint are made and audited again
+		if err := audit.Truncate(config.Audit.File, *from.AuditOffset); err != nil {
+			return fmt.Errorf("%s: %w", config.Audit.File, err)
+		}
+	}
+	auditor, closeAudit, err := OpenAudit(config)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeAudit(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	input := stdin
+	if config.VelocityLimit.InputFile != stdio {
+		inputFile, err := os.Open(config.VelocityLimit.InputFile)
+		if err != nil {
+			return err
+		}
+		defer inputFile.Close()
+		if from != nil {
+			if _, err := inputFile.Seek(from.InputOffset, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		input = inputFile
+	}
+	output := stdout
+	if config.VelocityLimit.OutputFile != stdio {
+		outputFile, err := createOutput(config.VelocityLimit.OutputFile, from != nil, offsets.OutputOffset)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := outputFile.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		output = outputFile
+	}
+
+	var deadLetter io.Writer
+	if config.VelocityLimit.DeadLetterFile != "" {
+		deadLetterFile, err := createOutput(config.VelocityLimit.DeadLetterFile, from != nil, offsets.DeadLetterOffset)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := deadLetterFile.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		deadLetter = deadLetterFile
+	}
+
+	var late io.Writer
+	lateFile, err := createLateFile(config.Reorder, from != nil, offsets.LateOffset)
+	if err != nil {
+		return err
+	}
+	if lateFile != nil {
+		defer func() {
+			if closeErr := lateFile.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		late = lateFile
+	}
+
+	var stats *metrics.Metrics
+	if config.Metrics.Address != "" {
+		stats = metrics.New()
+		stopMetrics, err := serveMetrics(config.Metrics, stats)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if stopErr := stopMetrics(); err == nil {
+				err = stopErr
+			}
+		}()
+	}
+
+	sinks := pipeline.Sinks{Output: output, DeadLetter: deadLetter, Late: late, Metrics: stats}
+	engine := engine.New(engine.WithConfig(config), engine.WithStore(cache), engine.WithAuditor(auditor))
+	summary, err := pipeline.Run(ctx, config, input, sinks, engine, from)
+	fmt.Fprintln(stderr, summary)
+	if errors.Is(err, context.Canceled) {
+		return fmt.Errorf("interrupted after %d lines", summary.Lines)
+	}
+	if err != nil {
+		return err
+	}
+	if config.Checkpoint.File != "" {
+		if err := os.Remove(config.Checkpoint.File); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// createOutput creates the file at path. When resuming the file is opened
+// instead and cut back to the offset the checkpoint recorded for it, dropping
+// whatever was written after the checkpoint.
+func createOutput(path string, resume bool, offset int64) (*os.File, error) {
+	if !resume {
+		return os.Create(path)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// createLateFile creates the file late requests are routed to, or returns nil
+// if they are not routed to a file.
+func createLateFile(reorder config.Reorder, resume bool, offset int64) (*os.File, error) {
+	if reorder.LatePolicy != config.LatePolicyFile || reorder.LateFile == "" {
+		return nil, nil
+	}
+	return createOutput(reorder.LateFile, resume, offset)
+}
+
+// serveMetrics serves stats on the configured address while the input is
+// processed and returns a function that stops serving them.
+func serveMetrics(metricsConfig config.Metrics, stats *metrics.Metrics) (func() error, error) {
+	listener, err := net.Listen("tcp", metricsConfig.Address)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+	go func() {
+		errC <- metrics.Serve(ctx, listener, metricsConfig.Path, stats.Registry())
+	}()
+	return func() error {
+		cancel()
+		return <-errC
+	}, nil
+}
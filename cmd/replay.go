@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"velocitylimits/audit"
+	"velocitylimits/config"
+	"velocitylimits/store"
+)
+
+// errStateMismatch is returned by replay when the store does not hold the
+// state the audit log rebuilds.
+var errStateMismatch = errors.New("store does not match the audit log")
+
+func runReplay(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags, configPath := newFlagSet("replay", "Verifies the hash chain of the audit log, rebuilds the accounts and\ntransactions from its decisions and compares them with the file store.\nRun it while nothing is writing to the store.", stderr)
+	auditFile := flags.String("audit-file", "", "audit log to replay (default audit.file)")
+	storePath := flags.String("store-path", "", "directory of the file store to verify (default store.path)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	config, err := config.ParseConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "audit-file":
+			config.Audit.File = *auditFile
+		case "store-path":
+			config.Store.Path = *storePath
+		}
+	})
+	if config.Audit.File == "" {
+		return &usageError{err: errors.New("no audit log is configured")}
+	}
+	return replay(config, stdout)
+}
+
+// replay rebuilds the state from the audit log, prints how it differs from
+// the file store and fails if it does.
+func replay(config *config.Configurations, stdout io.Writer) error {
+	auditFile, err := os.Open(config.Audit.File)
+	if err != nil {
+		return err
+	}
+	defer auditFile.Close()
+	state, err := audit.Replay(auditFile, config.Store.Dedup)
+	if err != nil {
+		return fmt.Errorf("%s: %w", config.Audit.File, err)
+	}
+
+	fileStore, err := store.OpenReadOnly(config.Store)
+	if err != nil {
+		return err
+	}
+	accounts, transactions := fileStore.Accounts(), fileStore.Transactions()
+	if err := fileStore.Close(); err != nil {
+		return err
+	}
+
+	diffs := state.Diff(accounts, transactions)
+	for _, diff := range diffs {
+		fmt.Fprintln(stdout, diff)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%w: %d differences", errStateMismatch, len(diffs))
+	}
+	fmt.Fprintf(stdout, "%d audit records verified: %d accounts and %d transactions match the store\n", state.Records, len(accounts), len(transactions))
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"net"
+
+	"velocitylimits/config"
+	"velocitylimits/engine"
+	"velocitylimits/grpcserver"
+	"velocitylimits/metrics"
+	"velocitylimits/server"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func runServe(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags, configPath := newFlagSet("serve", "Serves the HTTP and gRPC APIs that have an address configured until SIGINT\nor SIGTERM is received. Flags override the config file.", stderr)
+	httpAddress := flags.String("http-addr", "", "HTTP listen address, empty to disable (default server.address)")
+	grpcAddress := flags.String("grpc-addr", "", "gRPC listen address, empty to disable (default grpc.address)")
+	auditFile := flags.String("audit-file", "", "audit log of every decision, empty to not audit (default audit.file)")
+	idempotent := flags.Bool("idempotent", false, "answer retried loads with their original response (default store.dedup.idempotent)")
+	metricsAddress := flags.String("metrics-addr", "", "serve Prometheus metrics on this address, empty to not serve them (default metrics.address)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	config, err := config.ParseConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "http-addr":
+			config.Server.Address = *httpAddress
+		case "grpc-addr":
+			config.GRPC.Address = *grpcAddress
+		case "audit-file":
+			config.Audit.File = *auditFile
+		case "idempotent":
+			config.Store.Dedup.Idempotent = *idempotent
+		case "metrics-addr":
+			config.Metrics.Address = *metricsAddress
+		}
+	})
+	if config.Server.Address == "" && config.GRPC.Address == "" {
+		return &usageError{err: errors.New("neither an HTTP nor a gRPC address is configured")}
+	}
+
+	cache, closeCache, err := OpenCache(config)
+	if err != nil {
+		return err
+	}
+	auditor, closeAudit, err := OpenAudit(config)
+	if err != nil {
+		closeCache()
+		return err
+	}
+	var stats *metrics.Metrics
+	if config.Metrics.Address != "" {
+		stats = metrics.New()
+	}
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	engine := engine.New(engine.WithConfig(config), engine.WithStore(cache), engine.WithAuditor(auditor), engine.WithMetrics(stats))
+	if err := serve(ctx, config, engine, stats); err != nil {
+		closeAudit()
+		closeCache()
+		return err
+	}
+	if err := closeAudit(); err != nil {
+		closeCache()
+		return err
+	}
+	return closeCache()
+}
+
+// serve runs the configured APIs, and the metrics endpoint if stats are
+// given, until ctx is done or one of them fails.
+func serve(ctx context.Context, config *config.Configurations, engine *engine.Engine, stats *metrics.Metrics) error {
+	errGroup, ctx := errgroup.WithContext(ctx)
+
+	// both servers share the engine, so they never work on a customer at
+	// the same time
+	if config.Server.Address != "" {
+		errGroup.Go(func() error {
+			return server.NewServer(engine).ListenAndServe(ctx)
+		})
+	}
+	if config.GRPC.Address != "" {
+		errGroup.Go(func() error {
+			return grpcserver.NewServer(engine).ListenAndServe(ctx)
+		})
+	}
+	if stats != nil {
+		errGroup.Go(func() error {
+			listener, err := net.Listen("tcp", config.Metrics.Address)
+			if err != nil {
+				return err
+			}
+			return metrics.Serve(ctx, listener, config.Metrics.Path, stats.Registry())
+		})
+	}
+	return errGroup.Wait()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"velocitylimits/cache"
+	"velocitylimits/config"
+	"velocitylimits/conformance"
+)
+
+// errOutputMismatch is returned by verify when the responses differ from the
+// expected output.
+var errOutputMismatch = errors.New("output does not match the expected output")
+
+func runVerify(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags, configPath := newFlagSet("verify", "Runs the input through the velocity limits, starting with no accounts, and\ncompares the responses with the expected output by ID. Every mismatch is\nprinted with the customer's limits before and after the decision.", stderr)
+	in := flags.String("in", "", "input file, - for stdin (default velocitylimit.inputfile)")
+	expected := flags.String("expected", "", "file of the expected responses, one per line")
+	customers := flags.String("customers", "", "JSON lines file of customer tiers and overrides (default tiers.customersfile)")
+	format := flags.String("format", formatText, "report format, text or json")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *expected == "" {
+		return &usageError{err: errors.New("--expected is required")}
+	}
+	if *format != formatText && *format != formatJSON {
+		return &usageError{err: fmt.Errorf("unknown format %q", *format)}
+	}
+
+	config, err := config.ParseConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "in":
+			config.VelocityLimit.InputFile = *in
+		case "customers":
+			config.Tiers.CustomersFile = *customers
+		}
+	})
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	return verify(ctx, config, *expected, *format, stdin, stdout)
+}
+
+// verify prints the mismatches of the run with the expected output and fails
+// if there are any.
+func verify(ctx context.Context, config *config.Configurations, expectedPath, format string, stdin io.Reader, stdout io.Writer) error {
+	input := stdin
+	if config.VelocityLimit.InputFile != stdio {
+		inputFile, err := os.Open(config.VelocityLimit.InputFile)
+		if err != nil {
+			return err
+		}
+		defer inputFile.Close()
+		input = inputFile
+	}
+	expected, err := os.Open(expectedPath)
+	if err != nil {
+		return err
+	}
+	defer expected.Close()
+
+	store := cache.NewBoundedCache(config.Store.Dedup)
+	if err := importCustomers(config, store); err != nil {
+		return err
+	}
+	result, err := conformance.Verify(ctx, config, input, expected, store)
+	if err != nil {
+		return err
+	}
+	if format == formatJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+	} else {
+		for _, mismatch := range result.Mismatches {
+			fmt.Fprintln(stdout, mismatch)
+		}
+	}
+	if !result.OK() {
+		return fmt.Errorf("%w: %d mismatches", errOutputMismatch, len(result.Mismatches))
+	}
+	if format == formatText {
+		fmt.Fprintf(stdout, "%d responses match the expected output\n", result.Checked)
+	}
+	return nil
+}
@@ -1,37 +1,376 @@
 package config
 
 import (
-	"github.com/sirupsen/logrus"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"velocitylimits/models"
+
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
 type Configurations struct {
 	VelocityLimit VelocityLimit
+	Tiers         Tiers
+	Validation    models.ValidationRules
+	Output        Output
+	Store         Store
+	Pipeline      Pipeline
+	Reorder       Reorder
+	Checkpoint    Checkpoint
+	Returns       Returns
+	Audit         Audit
+	Metrics       Metrics
+	Server        Server
+	GRPC          GRPC
 }
 
 type VelocityLimit struct {
-	MaxDailyLoadLimit    float64
+	MaxDailyLoadLimit    models.Money
 	MaxDailyTransactions int
-	MaxWeeklyLoadLimit   float64
-	InputFile            string
-	OutputFile           string
+	MaxWeeklyLoadLimit   models.Money
+	// TimeZone is the IANA time zone days start at midnight in, UTC when
+	// empty. Tiers and customers may set their own.
+	TimeZone string
+	// WeekStart is the day weeks start on, Monday when empty.
+	WeekStart  string
+	InputFile  string
+	OutputFile string
+	// Rules are limits every load must pass besides the default rules, the
+	// daily and weekly limits above, whose names they must not take, e.g.
+	//
+	//   rules:
+	//     - name: monthly_amount
+	//       window: calendar   # calendar (period) or sliding (duration)
+	//       period: month      # day, week or month
+	//       metric: sum        # sum of amounts or count of loads
+	//       threshold: 40000
+	Rules []models.Rule
+	// DeadLetterFile receives the input lines that could not be parsed, with
+	// their line number and error. They are only logged when it is empty.
+	DeadLetterFile string
+}
+
+// Limits returns the limits of customers without a tier.
+func (v VelocityLimit) Limits() models.Limits {
+	return models.Limits{
+		MaxDailyLoadLimit:    v.MaxDailyLoadLimit,
+		MaxDailyTransactions: v.MaxDailyTransactions,
+		MaxWeeklyLoadLimit:   v.MaxWeeklyLoadLimit,
+		TimeZone:             v.TimeZone,
+		WeekStart:            v.WeekStart,
+	}
+}
+
+// Tiers is the catalogue of customer tiers and their limits.
+type Tiers struct {
+	// Default is the tier of customers that are not assigned one. Without
+	// it they get the velocitylimit values.
+	Default string
+	// Catalogue maps tier names to their limits.
+	Catalogue map[string]models.Limits
+	// CustomersFile is a JSON lines file of models.CustomerLimits assigning
+	// customers to tiers and overriding their limits. It is loaded into the
+	// store on start up.
+	CustomersFile string
+}
+
+// Limits returns the limits of the named tier. Tier names are not case
+// sensitive.
+func (t Tiers) Limits(name string) (models.Limits, bool) {
+	limits, ok := t.Catalogue[strings.ToLower(name)]
+	return limits, ok
+}
+
+// Output controls how responses are written.
+type Output struct {
+	// IncludeReason adds the decline reason and limit headroom to each
+	// response line instead of the default three field format.
+	IncludeReason bool
+}
+
+// Store selects where accounts and transactions are kept between runs.
+type Store struct {
+	// Type is "memory" (the default) or "file".
+	Type string
+	// Path is the directory the file store keeps its snapshot and journal in.
+	Path string
+	// SyncWrites fsyncs the journal, and the audit log, after every decision.
+	SyncWrites bool
+	// SnapshotEvery folds the journal into a new snapshot after this many
+	// records. Zero only snapshots on close.
+	SnapshotEvery int
+	Dedup         Dedup
+}
+
+// Dedup bounds the transaction IDs kept to decline duplicate loads.
+type Dedup struct {
+	// Retention is how long, in event time, an ID is kept after its load.
+	// Zero keeps IDs forever.
+	Retention time.Duration
+	// MaxEntries bounds the IDs kept, the oldest are evicted first. Zero does
+	// not bound them.
+	MaxEntries int
+	// Idempotent answers a retried load with its original response instead
+	// of declining it as a duplicate. A load reusing an ID with a different
+	// amount or time is declined as a conflict.
+	Idempotent bool
 }
 
-// TODO: test me please!!!
-//ParseConfig ...
-func ParseConfig() *Configurations {
+const (
+	StoreTypeMemory = "memory"
+	StoreTypeFile   = "file"
+)
+
+// Pipeline tunes how requests are processed.
+type Pipeline struct {
+	// Workers is the number of goroutines attempting loads in parallel.
+	Workers int
+	// QueueSize is the number of requests buffered for each worker.
+	QueueSize int
+	// PreserveOrder writes responses in input order instead of as they
+	// complete.
+	PreserveOrder bool
+	// MaxErrorRate aborts the run once more than this percentage of the lines
+	// read are malformed. Zero never aborts.
+	MaxErrorRate float64
+	// ErrorRateMinLines is the number of lines read before MaxErrorRate is
+	// checked, so a bad line early on does not abort the run.
+	ErrorRateMinLines int
+}
+
+// Checkpoint configures the checkpoints a run of the process command can be
+// resumed from.
+type Checkpoint struct {
+	// File is where the checkpoint is written. Empty does not checkpoint.
+	File string
+	// Every is the number of input lines between checkpoints.
+	Every int
+}
+
+// Reorder puts requests that arrive out of time order back in order before
+// they are processed.
+type Reorder struct {
+	// Lateness is how far behind the newest request seen a request may arrive
+	// and still be processed in time order. Requests are held back until the
+	// watermark, the newest time minus Lateness, passes them. Zero processes
+	// requests as they arrive.
+	Lateness time.Duration
+	// BufferSize bounds the number of requests held back. A full buffer
+	// releases its oldest request early. Zero does not bound it.
+	BufferSize int
+	// LatePolicy is what happens to requests behind the watermark: "reprocess"
+	// (the default) attempts them against the limits of their own day and
+	// week, "reject" declines them as late and "file" writes them to LateFile
+	// unanswered.
+	LatePolicy string
+	LateFile   string
+}
+
+const (
+	LatePolicyReprocess = "reprocess"
+	LatePolicyReject    = "reject"
+	LatePolicyFile      = "file"
+)
+
+// Returns decides what reversals and refunds give back besides the funds.
+type Returns struct {
+	// RestoreHeadroom gives the amount back to the daily and weekly limits and
+	// the rules the load counted against, if they are still current.
+	RestoreHeadroom bool
+	// RestoreCount gives a reversed load back to the daily count.
+	RestoreCount bool
+}
+
+// Audit configures the audit log of every decision.
+type Audit struct {
+	// File is the hash-chained log decisions are appended to. Empty does not
+	// audit.
+	File string
+}
+
+// Metrics configures the endpoint the statistics of process and serve are
+// scraped from.
+type Metrics struct {
+	// Address is the host:port to listen on. Metrics are only served when it
+	// is set.
+	Address string
+	Path    string
+}
+
+// Server configures the HTTP API.
+type Server struct {
+	// Address is the host:port to listen on. The HTTP API is only served
+	// when it is set.
+	Address      string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// ShutdownTimeout bounds how long in-flight requests may take to finish
+	// once the server is asked to stop.
+	ShutdownTimeout time.Duration
+}
+
+// GRPC configures the gRPC API.
+type GRPC struct {
+	// Address is the host:port to listen on. The gRPC API is only served
+	// when it is set.
+	Address string
+}
+
+// Version identifies the effective configuration, flag overrides included,
+// by a hash of its settings.
+func (c *Configurations) Version() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ParseConfig reads the config file at path. With an empty path config.yaml
+// is looked up in the config directory under the working directory.
+func ParseConfig(path string) (*Configurations, error) {
 	var config Configurations
-	viper.SetConfigName("config")
-	viper.AddConfigPath("../config")
-	viper.SetConfigType("yml")
-	// TODO: Path for the file needs to be handled better
-	if err := viper.ReadInConfig(); err != nil {
-		logrus.Panicf("Error reading config file, %s", err)
-	}
-	err := viper.Unmarshal(&config)
+	v := viper.New()
+	if path != "" {
+		v.SetConfigFile(path)
+	} else {
+		v.SetConfigName("config")
+		v.AddConfigPath("config")
+	}
+	v.SetConfigType("yml")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	err := v.Unmarshal(&config, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToTimeHookFunc(time.RFC3339),
+		mapstructure.StringToSliceHookFunc(","),
+		moneyHookFunc,
+		ruleHookFunc,
+	)))
 	if err != nil {
-		logrus.Panicf("Unable to decode into struct, %v", err)
+		return nil, fmt.Errorf("unable to decode config: %w", err)
+	}
+	if _, err := config.VelocityLimit.Limits().Boundaries(); err != nil {
+		return nil, err
+	}
+	for name, limits := range config.Tiers.Catalogue {
+		if _, err := limits.Boundaries(); err != nil {
+			return nil, fmt.Errorf("tier %q: %w", name, err)
+		}
+	}
+	if config.Validation.IDPattern != "" {
+		if _, err := models.CompileIDPattern(config.Validation.IDPattern); err != nil {
+			return nil, fmt.Errorf("validation id pattern: %w", err)
+		}
+	}
+	switch config.Reorder.LatePolicy {
+	case "", LatePolicyReprocess, LatePolicyReject, LatePolicyFile:
+	default:
+		return nil, fmt.Errorf("unknown late policy %q", config.Reorder.LatePolicy)
 	}
+	if config.Tiers.Default != "" {
+		if _, ok := config.Tiers.Limits(config.Tiers.Default); !ok {
+			return nil, fmt.Errorf("default tier %q is not in the catalogue", config.Tiers.Default)
+		}
+	}
+	names := make(map[string]bool)
+	for _, rule := range config.VelocityLimit.Rules {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+		if models.ReservedRuleName(rule.Name) {
+			return nil, fmt.Errorf("%w %q: name is reserved for a default rule", models.ErrInvalidRule, rule.Name)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("%w %q: duplicate name", models.ErrInvalidRule, rule.Name)
+		}
+		names[rule.Name] = true
+	}
+
+	return &config, nil
+}
+
+// moneyHookFunc decodes plain numbers (5000, 5000.5) and strings ("$5,000")
+// from the config file into models.Money.
+func moneyHookFunc(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(models.Money(0)) {
+		return data, nil
+	}
+	switch v := data.(type) {
+	case int:
+		return models.Dollars(int64(v)), nil
+	case int64:
+		return models.Dollars(v), nil
+	case float64:
+		return models.ParseMoney(strconv.FormatFloat(v, 'f', -1, 64))
+	case string:
+		return models.ParseMoney(v)
+	}
+	return data, nil
+}
 
-	return &config
+// ruleHookFunc decodes a rule from the config file. The threshold is an
+// amount for sum rules and a number of loads for count rules.
+func ruleHookFunc(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(models.Rule{}) {
+		return data, nil
+	}
+	fields := make(map[string]interface{})
+	switch v := data.(type) {
+	case map[string]interface{}:
+		fields = v
+	case map[interface{}]interface{}:
+		for key, value := range v {
+			fields[strings.ToLower(fmt.Sprint(key))] = value
+		}
+	default:
+		return data, nil
+	}
+	field := func(name string) string {
+		if value, ok := fields[name]; ok && value != nil {
+			return fmt.Sprint(value)
+		}
+		return ""
+	}
+	rule := models.Rule{
+		Name:   field("name"),
+		Window: models.Window(field("window")),
+		Period: models.Period(field("period")),
+		Metric: models.Metric(field("metric")),
+	}
+	if duration := field("duration"); duration != "" {
+		var err error
+		if rule.Duration, err = time.ParseDuration(duration); err != nil {
+			return nil, fmt.Errorf("%w %q: %v", models.ErrInvalidRule, rule.Name, err)
+		}
+	}
+	threshold := field("threshold")
+	switch rule.Metric {
+	case models.MetricSum:
+		amount, err := moneyHookFunc(reflect.TypeOf(fields["threshold"]), reflect.TypeOf(models.Money(0)), fields["threshold"])
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: threshold: %v", models.ErrInvalidRule, rule.Name, err)
+		}
+		var ok bool
+		if rule.MaxAmount, ok = amount.(models.Money); !ok {
+			return nil, fmt.Errorf("%w %q: threshold %q is not an amount", models.ErrInvalidRule, rule.Name, threshold)
+		}
+	case models.MetricCount:
+		count, err := strconv.Atoi(threshold)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: threshold %q is not a number of loads", models.ErrInvalidRule, rule.Name, threshold)
+		}
+		rule.MaxCount = count
+	}
+	return rule, nil
 }
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"velocitylimits/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func TestParseConfig(t *testing.T) {
+	t.Run("parses the repository config", func(t *testing.T) {
+		config, err := ParseConfig("config.yaml")
+		require.NoError(t, err)
+		assert.Equal(t, models.Dollars(5000), config.VelocityLimit.MaxDailyLoadLimit)
+		assert.Equal(t, 3, config.VelocityLimit.MaxDailyTransactions)
+		assert.Equal(t, models.Dollars(20000), config.VelocityLimit.MaxWeeklyLoadLimit)
+		assert.Equal(t, "input.txt", config.VelocityLimit.InputFile)
+		assert.Equal(t, 15*time.Second, config.Server.ShutdownTimeout)
+		assert.Empty(t, config.VelocityLimit.Rules)
+		assert.Equal(t, LatePolicyReprocess, config.Reorder.LatePolicy)
+		assert.Equal(t, Returns{RestoreHeadroom: true, RestoreCount: true}, config.Returns)
+		assert.Equal(t, Metrics{Path: "/metrics"}, config.Metrics)
+		assert.Equal(t, Checkpoint{Every: 10000}, config.Checkpoint)
+		assert.True(t, config.Validation.Strict)
+		assert.Equal(t, time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC), config.Validation.LatestTime)
+		premium, ok := config.Tiers.Limits("Premium")
+		require.True(t, ok)
+		assert.Equal(t, models.Limits{MaxDailyLoadLimit: models.Dollars(25000), MaxDailyTransactions: 10, MaxWeeklyLoadLimit: models.Dollars(100000)}, premium)
+	})
+	t.Run("decodes money from numbers and strings", func(t *testing.T) {
+		path := writeConfig(t, `
+velocitylimit:
+  maxdailyloadlimit: 5000.25
+  maxweeklyloadlimit: "$20,000.10"
+`)
+		config, err := ParseConfig(path)
+		require.NoError(t, err)
+		assert.Equal(t, models.Cents(500025), config.VelocityLimit.MaxDailyLoadLimit)
+		assert.Equal(t, models.Cents(2000010), config.VelocityLimit.MaxWeeklyLoadLimit)
+	})
+	t.Run("returns error for an invalid amount", func(t *testing.T) {
+		path := writeConfig(t, `
+velocitylimit:
+  maxdailyloadlimit: "lots"
+`)
+		_, err := ParseConfig(path)
+		assert.Error(t, err)
+	})
+	t.Run("decodes rules", func(t *testing.T) {
+		path := writeConfig(t, `
+velocitylimit:
+  rules:
+    - name: monthly_amount
+      window: calendar
+      period: month
+      metric: sum
+      threshold: "$40,000"
+    - name: hourly_count
+      window: sliding
+      duration: 1h
+      metric: count
+      threshold: 2
+`)
+		config, err := ParseConfig(path)
+		require.NoError(t, err)
+		assert.Equal(t, []models.Rule{
+			{Name: "monthly_amount", Window: models.WindowCalendar, Period: models.PeriodMonth, Metric: models.MetricSum, MaxAmount: models.Dollars(40000)},
+			{Name: "hourly_count", Window: models.WindowSliding, Duration: time.Hour, Metric: models.MetricCount, MaxCount: 2},
+		}, config.VelocityLimit.Rules)
+	})
+	t.Run("returns error for an invalid rule", func(t *testing.T) {
+		for _, rules := range []string{
+			"[{name: r, window: calendar, period: year, metric: sum, threshold: 1}]",
+			"[{name: r, window: sliding, duration: soon, metric: sum, threshold: 1}]",
+			"[{name: r, window: sliding, duration: 1h, metric: count, threshold: 1.5}]",
+			"[{name: r, window: sliding, duration: 1h, metric: sum}]",
+			"[{name: r, window: sliding, duration: 1h, metric: sum, threshold: 1}, {name: r, window: calendar, period: day, metric: sum, threshold: 1}]",
+			"[{name: daily_amount, window: calendar, period: day, metric: sum, threshold: 1}]",
+			"[{name: weekly_amount, window: sliding, duration: 168h, metric: sum, threshold: 1}]",
+		} {
+			_, err := ParseConfig(writeConfig(t, "velocitylimit:\n  rules: "+rules+"\n"))
+			require.Error(t, err, rules)
+			assert.Contains(t, err.Error(), models.ErrInvalidRule.Error(), rules)
+		}
+	})
+	t.Run("returns error for invalid boundaries", func(t *testing.T) {
+		_, err := ParseConfig(writeConfig(t, "velocitylimit:\n  timezone: Mars/Olympus_Mons\n"))
+		assert.True(t, errors.Is(err, models.ErrInvalidBoundaries))
+		_, err = ParseConfig(writeConfig(t, "tiers:\n  catalogue:\n    basic:\n      weekstart: someday\n"))
+		assert.True(t, errors.Is(err, models.ErrInvalidBoundaries))
+	})
+	t.Run("returns error for an unknown default tier", func(t *testing.T) {
+		_, err := ParseConfig(writeConfig(t, `
+tiers:
+  default: gold
+  catalogue:
+    basic:
+      maxdailyloadlimit: 1
+`))
+		assert.Error(t, err)
+	})
+	t.Run("returns error for an unknown late policy", func(t *testing.T) {
+		_, err := ParseConfig(writeConfig(t, "reorder:\n  latepolicy: drop\n"))
+		assert.Error(t, err)
+	})
+	t.Run("decodes the output settings", func(t *testing.T) {
+		config, err := ParseConfig(writeConfig(t, "output:\n  includereason: true\n"))
+		require.NoError(t, err)
+		assert.True(t, config.Output.IncludeReason)
+	})
+	t.Run("returns error for an invalid id pattern", func(t *testing.T) {
+		_, err := ParseConfig(writeConfig(t, "validation:\n  idpattern: \"[a-z\"\n"))
+		assert.Error(t, err)
+	})
+	t.Run("returns error for a missing file", func(t *testing.T) {
+		_, err := ParseConfig("does-not-exist.yaml")
+		assert.Error(t, err)
+	})
+}
+
+func TestVersion(t *testing.T) {
+	t.Run("changes with the config", func(t *testing.T) {
+		config, err := ParseConfig("config.yaml")
+		require.NoError(t, err)
+		version := config.Version()
+		assert.Len(t, version, 16)
+		assert.Equal(t, version, config.Version())
+		config.VelocityLimit.MaxDailyTransactions++
+		assert.NotEqual(t, version, config.Version())
+	})
+}
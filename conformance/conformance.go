@@ -0,0 +1,239 @@
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"velocitylimits/audit"
+	"velocitylimits/config"
+	"velocitylimits/engine"
+	"velocitylimits/models"
+	"velocitylimits/pipeline"
+	"velocitylimits/service"
+)
+
+// Line is the part of a response line that is compared.
+type Line struct {
+	ID         string        `json:"id"`
+	CustomerID string        `json:"customer_id"`
+	Accepted   bool          `json:"accepted"`
+	Reason     models.Reason `json:"reason,omitempty"`
+}
+
+// String ...
+func (l *Line) String() string {
+	if l == nil {
+		return "no response"
+	}
+	if l.Accepted {
+		return "accepted"
+	}
+	if l.Reason == "" {
+		return "declined"
+	}
+	return fmt.Sprintf("declined (%s)", l.Reason)
+}
+
+// Mismatch is an expected response the run did not give, or a response of
+// the run that was not expected.
+type Mismatch struct {
+	// Line is the line of the expected output, 0 for a response that was
+	// not expected.
+	Line     int   `json:"line"`
+	Expected *Line `json:"expected"`
+	Actual   *Line `json:"actual"`
+	// Request is the request decided, Before and After what the customer's
+	// account held before and after the decision. They are nil for requests
+	// that were not decided against an account, Before also for a customer
+	// without one.
+	Request *models.Request  `json:"request,omitempty"`
+	Before  *models.Headroom `json:"before,omitempty"`
+	After   *models.Headroom `json:"after,omitempty"`
+}
+
+// String ...
+func (m Mismatch) String() string {
+	line := m.Expected
+	if line == nil {
+		line = m.Actual
+	}
+	var s strings.Builder
+	if m.Line > 0 {
+		fmt.Fprintf(&s, "line %d: ", m.Line)
+	}
+	fmt.Fprintf(&s, "%s of customer %s: expected %s, got %s", line.ID, line.CustomerID, m.Expected, m.Actual)
+	if m.Request != nil {
+		fmt.Fprintf(&s, "\n  request: %s at %s", m.Request.ParsedAmount, m.Request.Time)
+		fmt.Fprintf(&s, "\n  before:  %s", formatHeadroom(m.Before))
+		fmt.Fprintf(&s, "\n  after:   %s", formatHeadroom(m.After))
+	}
+	return s.String()
+}
+
+// formatHeadroom describes what an account had left of its limits.
+func formatHeadroom(headroom *models.Headroom) string {
+	if headroom == nil {
+		return "no account"
+	}
+	return fmt.Sprintf("%s of %s and %d of %d loads left on the day of %s, %s of %s left in the week of %s",
+		headroom.Daily.RemainingAmount, headroom.Daily.LimitAmount, headroom.Daily.RemainingLoads, headroom.Daily.LimitLoads,
+		headroom.Daily.Start.Format("2006-01-02"),
+		headroom.Weekly.RemainingAmount, headroom.Weekly.LimitAmount, headroom.Weekly.Start.Format("2006-01-02"))
+}
+
+// Result is the outcome of comparing a run with the expected output.
+type Result struct {
+	// Checked counts the expected responses.
+	Checked    int        `json:"checked"`
+	Mismatches []Mismatch `json:"mismatches"`
+}
+
+// OK reports whether the run gave exactly the expected responses.
+func (r *Result) OK() bool {
+	return len(r.Mismatches) == 0
+}
+
+// key matches the responses of a run to the expected ones: the nth response
+// to an ID of a customer is compared with the nth expected one.
+type key struct {
+	customerID string
+	id         string
+	n          int
+}
+
+// counter numbers the responses to each ID of a customer.
+type counter map[key]int
+
+func (c counter) next(customerID, id string) key {
+	k := key{customerID: customerID, id: id}
+	k.n = c[k]
+	c[k]++
+	return k
+}
+
+// recorder keeps the audit records of a run by the key of their response.
+// The workers of a run share it.
+type recorder struct {
+	mu      sync.Mutex
+	counter counter
+	records map[key]audit.Record
+}
+
+// Record ...
+func (r *recorder) Record(record audit.Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records[r.counter.next(record.Request.CustomerID, record.Request.ID)] = record
+}
+
+// Verify runs the input through the pipeline with config and compares the
+// responses with the expected output, one response per line, by the ID and
+// customer of each. Acceptance is always compared, the reason only if the
+// expected line has one. Store, which may be nil, holds the customer limits
+// and accounts to start from; the accounts are kept in memory without it.
+func Verify(ctx context.Context, config *config.Configurations, input, expected io.Reader, store service.Cache) (*Result, error) {
+	expectedLines, lineNumbers, err := readLines(expected)
+	if err != nil {
+		return nil, fmt.Errorf("expected output: %w", err)
+	}
+
+	runConfig := *config
+	runConfig.Checkpoint.File = ""
+	runConfig.Output.IncludeReason = true
+	recorder := &recorder{counter: make(counter), records: make(map[key]audit.Record)}
+	options := []engine.Option{engine.WithConfig(&runConfig), engine.WithAuditor(recorder)}
+	if store != nil {
+		options = append(options, engine.WithStore(store))
+	}
+	var output bytes.Buffer
+	if _, err := pipeline.Run(ctx, &runConfig, input, pipeline.Sinks{Output: &output}, engine.New(options...), nil); err != nil {
+		return nil, err
+	}
+	actualLines, _, err := readLines(&output)
+	if err != nil {
+		return nil, err
+	}
+
+	// requests declined for arriving late are not decided against an
+	// account, so they are not audited
+	actual := make(map[key]*Line, len(actualLines))
+	records := make(map[key]audit.Record, len(actualLines))
+	var order []key
+	responses, decisions := make(counter), make(counter)
+	for i := range actualLines {
+		line := &actualLines[i]
+		k := responses.next(line.CustomerID, line.ID)
+		actual[k] = line
+		order = append(order, k)
+		if line.Reason != models.ReasonLate {
+			records[k] = recorder.records[decisions.next(line.CustomerID, line.ID)]
+		}
+	}
+
+	result := &Result{Checked: len(expectedLines), Mismatches: []Mismatch{}}
+	expectedKeys := make(counter)
+	for i := range expectedLines {
+		want := &expectedLines[i]
+		k := expectedKeys.next(want.CustomerID, want.ID)
+		got := actual[k]
+		delete(actual, k)
+		if got != nil && got.Accepted == want.Accepted && (want.Reason == "" || got.Reason == want.Reason) {
+			continue
+		}
+		mismatch := Mismatch{Line: lineNumbers[i], Expected: want, Actual: got}
+		addState(&mismatch, records[k])
+		result.Mismatches = append(result.Mismatches, mismatch)
+	}
+	for _, k := range order {
+		if got, ok := actual[k]; ok {
+			mismatch := Mismatch{Actual: got}
+			addState(&mismatch, records[k])
+			result.Mismatches = append(result.Mismatches, mismatch)
+		}
+	}
+	return result, nil
+}
+
+// addState adds the request and account of the record, if there is one, to
+// the mismatch.
+func addState(mismatch *Mismatch, record audit.Record) {
+	if record.Request == nil {
+		return
+	}
+	mismatch.Request = record.Request
+	if record.Before != nil {
+		mismatch.Before = models.NewHeadroom(record.Before)
+	}
+	if record.After != nil {
+		mismatch.After = models.NewHeadroom(record.After)
+	}
+}
+
+// readLines decodes one response per line and returns them with their line
+// numbers. Blank lines are skipped.
+func readLines(r io.Reader) ([]Line, []int, error) {
+	var lines []Line
+	var numbers []int
+	scanner := bufio.NewScanner(r)
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var line Line
+		if err := json.Unmarshal([]byte(text), &line); err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", number, err)
+		}
+		lines = append(lines, line)
+		numbers = append(numbers, number)
+	}
+	return lines, numbers, scanner.Err()
+}
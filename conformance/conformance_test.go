@@ -0,0 +1,91 @@
+package conformance_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"velocitylimits/config"
+	"velocitylimits/conformance"
+	"velocitylimits/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const input = `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-03T00:00:00Z"}
+{"id":"2","customer_id":"528","load_amount":"$2500","time":"2000-01-03T01:00:00Z"}
+{"id":"3","customer_id":"529","load_amount":"$100","time":"2000-01-03T02:00:00Z"}
+{"id":"2","customer_id":"528","load_amount":"$2500","time":"2000-01-03T01:00:00Z"}
+`
+
+func newConfig() *config.Configurations {
+	return &config.Configurations{
+		VelocityLimit: config.VelocityLimit{
+			MaxDailyLoadLimit:    models.Dollars(5000),
+			MaxDailyTransactions: 3,
+			MaxWeeklyLoadLimit:   models.Dollars(20000),
+		},
+		Pipeline: config.Pipeline{Workers: 4, PreserveOrder: true},
+	}
+}
+
+func verify(t *testing.T, expected string) *conformance.Result {
+	result, err := conformance.Verify(context.Background(), newConfig(), strings.NewReader(input), strings.NewReader(expected), nil)
+	require.NoError(t, err)
+	return result
+}
+
+func TestVerify(t *testing.T) {
+	t.Run("matches the responses by ID in any order", func(t *testing.T) {
+		result := verify(t, `{"id":"3","customer_id":"529","accepted":true}
+{"id":"1","customer_id":"528","accepted":true}
+
+{"id":"2","customer_id":"528","accepted":false,"reason":"daily_amount_exceeded"}
+{"id":"2","customer_id":"528","accepted":false}
+`)
+		assert.True(t, result.OK(), "%v", result.Mismatches)
+		assert.Equal(t, 4, result.Checked)
+	})
+	t.Run("reports a different decision with the customer's limits", func(t *testing.T) {
+		result := verify(t, `{"id":"1","customer_id":"528","accepted":true}
+{"id":"2","customer_id":"528","accepted":true}
+{"id":"2","customer_id":"528","accepted":false,"reason":"conflict"}
+{"id":"3","customer_id":"529","accepted":true}
+`)
+		require.Len(t, result.Mismatches, 2)
+		mismatch := result.Mismatches[0]
+		assert.Equal(t, 2, mismatch.Line)
+		assert.Equal(t, &conformance.Line{ID: "2", CustomerID: "528", Accepted: false, Reason: models.ReasonDailyAmountExceeded}, mismatch.Actual)
+		assert.Equal(t, models.Dollars(2500), mismatch.Request.ParsedAmount)
+		assert.Equal(t, models.Dollars(2000), mismatch.Before.Daily.RemainingAmount)
+		assert.Equal(t, 2, mismatch.Before.Daily.RemainingLoads)
+		assert.Equal(t, mismatch.Before, mismatch.After)
+		assert.Equal(t, `line 2: 2 of customer 528: expected accepted, got declined (daily_amount_exceeded)
+  request: $2,500.00 at 2000-01-03T01:00:00Z
+  before:  $2,000.00 of $5,000.00 and 2 of 3 loads left on the day of 2000-01-03, $17,000.00 of $20,000.00 left in the week of 2000-01-03
+  after:   $2,000.00 of $5,000.00 and 2 of 3 loads left on the day of 2000-01-03, $17,000.00 of $20,000.00 left in the week of 2000-01-03`, mismatch.String())
+		assert.Equal(t, models.ReasonDuplicate, result.Mismatches[1].Actual.Reason)
+	})
+	t.Run("reports missing and unexpected responses", func(t *testing.T) {
+		result := verify(t, `{"id":"1","customer_id":"528","accepted":true}
+{"id":"2","customer_id":"528","accepted":false}
+{"id":"3","customer_id":"530","accepted":true}
+`)
+		require.Len(t, result.Mismatches, 3)
+		assert.Equal(t, 3, result.Mismatches[0].Line)
+		assert.Nil(t, result.Mismatches[0].Actual)
+		assert.Nil(t, result.Mismatches[0].Request)
+		assert.Equal(t, "line 3: 3 of customer 530: expected accepted, got no response", result.Mismatches[0].String())
+		assert.Equal(t, 0, result.Mismatches[1].Line)
+		assert.Nil(t, result.Mismatches[1].Expected)
+		assert.Nil(t, result.Mismatches[1].Before)
+		assert.Equal(t, time.Date(2000, 1, 3, 2, 0, 0, 0, time.UTC), result.Mismatches[1].Request.ParsedTime)
+		assert.Equal(t, "2", result.Mismatches[2].Actual.ID)
+	})
+	t.Run("returns error for a malformed expected line", func(t *testing.T) {
+		_, err := conformance.Verify(context.Background(), newConfig(), strings.NewReader(input), strings.NewReader("{}\nnot json\n"), nil)
+		assert.Contains(t, err.Error(), "expected output: line 2:")
+	})
+}
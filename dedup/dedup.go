@@ -0,0 +1,146 @@
+package dedup
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+
+	"velocitylimits/config"
+	"velocitylimits/models"
+)
+
+// Key identifies a transaction. IDs are only unique per customer, so the key
+// keeps both apart rather than concatenating them, which would make id "1" of
+// customer "23" collide with id "12" of customer "3".
+type Key struct {
+	ID         string `json:"id"`
+	CustomerID string `json:"customer_id"`
+}
+
+// Entry is a transaction seen at the event time of its load, with the amount
+// and the decision it was answered with so a retry can be told apart from a
+// conflicting load reusing the ID.
+type Entry struct {
+	Key
+	Time     time.Time          `json:"time,omitempty"`
+	Type     models.RequestType `json:"type,omitempty"`
+	Amount   models.Money       `json:"amount,omitempty"`
+	Decision *models.Decision   `json:"decision,omitempty"`
+	// Returned is how much of a load reversals and refunds gave back.
+	Returned models.Money `json:"returned,omitempty"`
+}
+
+// Set remembers the transactions seen so duplicate loads can be declined. It
+// forgets transactions older than the retention, measured in event time from
+// the newest load added, and evicts the oldest ones once it holds MaxEntries.
+// A Set is not safe for concurrent use.
+type Set struct {
+	retention  time.Duration
+	maxEntries int
+	entries    map[Key]*item
+	byTime     itemHeap
+	newest     time.Time
+	added      int
+}
+
+// NewSet ...
+func NewSet(cfg config.Dedup) *Set {
+	return &Set{
+		retention:  cfg.Retention,
+		maxEntries: cfg.MaxEntries,
+		entries:    make(map[Key]*item),
+	}
+}
+
+// Add remembers the transaction and forgets the transactions that fall out
+// of the retention or over the maximum. The entry of a transaction already
+// in the set is replaced, keeping the time it was first added with.
+func (s *Set) Add(entry Entry) {
+	if it, ok := s.entries[entry.Key]; ok {
+		entry.Time = it.Time
+		it.Entry = entry
+		return
+	}
+	it := &item{Entry: entry, seq: s.added}
+	s.added++
+	heap.Push(&s.byTime, it)
+	s.entries[entry.Key] = it
+	if entry.Time.After(s.newest) {
+		s.newest = entry.Time
+	}
+	if s.retention > 0 {
+		cutoff := s.newest.Add(-s.retention)
+		for s.byTime.Len() > 0 && s.byTime[0].Time.Before(cutoff) {
+			s.remove()
+		}
+	}
+	for s.maxEntries > 0 && s.byTime.Len() > s.maxEntries {
+		s.remove()
+	}
+}
+
+// Get returns the remembered entry of the transaction.
+func (s *Set) Get(key Key) (Entry, bool) {
+	if it, ok := s.entries[key]; ok {
+		return it.Entry, true
+	}
+	return Entry{}, false
+}
+
+// Contains reports whether the transaction is remembered.
+func (s *Set) Contains(key Key) bool {
+	_, ok := s.entries[key]
+	return ok
+}
+
+// Len returns the number of transactions remembered.
+func (s *Set) Len() int {
+	return len(s.entries)
+}
+
+// Entries returns the transactions remembered, oldest first. Adding them to
+// an empty set with the same limits restores this one.
+func (s *Set) Entries() []Entry {
+	items := append(itemHeap(nil), s.byTime...)
+	sort.Sort(items)
+	entries := make([]Entry, 0, len(items))
+	for _, it := range items {
+		entries = append(entries, it.Entry)
+	}
+	return entries
+}
+
+// remove forgets the oldest transaction.
+func (s *Set) remove() {
+	it := heap.Pop(&s.byTime).(*item)
+	delete(s.entries, it.Key)
+}
+
+type item struct {
+	Entry
+	// seq breaks ties between equal times in the order they were added.
+	seq int
+}
+
+// itemHeap orders transactions by time and then by the order they were added.
+type itemHeap []*item
+
+func (h itemHeap) Len() int { return len(h) }
+
+func (h itemHeap) Less(i, j int) bool {
+	if h[i].Time.Equal(h[j].Time) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].Time.Before(h[j].Time)
+}
+
+func (h itemHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *itemHeap) Push(x interface{}) { *h = append(*h, x.(*item)) }
+
+func (h *itemHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,63 @@
+package dedup
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"velocitylimits/config"
+	"velocitylimits/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSet(t *testing.T) {
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := func(id, customerID string) Key { return Key{ID: id, CustomerID: customerID} }
+
+	t.Run("keys on ID and customer apart", func(t *testing.T) {
+		set := NewSet(config.Dedup{})
+		set.Add(Entry{Key: key("1", "23"), Time: start})
+		assert.True(t, set.Contains(key("1", "23")))
+		assert.False(t, set.Contains(key("12", "3")))
+		assert.False(t, set.Contains(key("23", "1")))
+	})
+	t.Run("keeps everything without limits", func(t *testing.T) {
+		set := NewSet(config.Dedup{})
+		for i := 0; i < 100; i++ {
+			set.Add(Entry{Key: key(fmt.Sprint(i), "1"), Time: start.AddDate(i, 0, 0)})
+		}
+		assert.Equal(t, 100, set.Len())
+	})
+	t.Run("forgets transactions as event time passes the retention", func(t *testing.T) {
+		set := NewSet(config.Dedup{Retention: 30 * 24 * time.Hour})
+		set.Add(Entry{Key: key("1", "1"), Time: start})
+		set.Add(Entry{Key: key("2", "1"), Time: start.AddDate(0, 0, 20)})
+		set.Add(Entry{Key: key("3", "1"), Time: start.AddDate(0, 0, 30)})
+		assert.True(t, set.Contains(key("1", "1")), "exactly at the retention")
+		set.Add(Entry{Key: key("4", "1"), Time: start.AddDate(0, 0, 31)})
+		assert.False(t, set.Contains(key("1", "1")))
+		assert.True(t, set.Contains(key("2", "1")))
+		// a load older than the retention is forgotten at once
+		set.Add(Entry{Key: key("5", "1"), Time: start})
+		assert.False(t, set.Contains(key("5", "1")))
+		assert.Equal(t, 3, set.Len())
+	})
+	t.Run("evicts the oldest over the maximum", func(t *testing.T) {
+		set := NewSet(config.Dedup{MaxEntries: 2})
+		set.Add(Entry{Key: key("1", "1"), Time: start.Add(time.Hour)})
+		set.Add(Entry{Key: key("2", "1"), Time: start})
+		set.Add(Entry{Key: key("3", "1"), Time: start.Add(2 * time.Hour)})
+		assert.False(t, set.Contains(key("2", "1")))
+		assert.Equal(t, []Entry{{Key: key("1", "1"), Time: start.Add(time.Hour)}, {Key: key("3", "1"), Time: start.Add(2 * time.Hour)}}, set.Entries())
+	})
+	t.Run("replaces a transaction added again but keeps its time", func(t *testing.T) {
+		set := NewSet(config.Dedup{})
+		set.Add(Entry{Key: key("1", "1"), Time: start, Amount: models.Dollars(1)})
+		set.Add(Entry{Key: key("1", "1"), Time: start.Add(time.Hour), Amount: models.Dollars(1), Returned: models.Dollars(1)})
+		entry, ok := set.Get(key("1", "1"))
+		assert.True(t, ok)
+		assert.Equal(t, Entry{Key: key("1", "1"), Time: start, Amount: models.Dollars(1), Returned: models.Dollars(1)}, entry)
+		assert.Equal(t, 1, set.Len())
+	})
+}
@@ -0,0 +1,291 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"velocitylimits/cache"
+	"velocitylimits/config"
+	"velocitylimits/metrics"
+	"velocitylimits/models"
+	"velocitylimits/service"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNoRequest is returned for a nil request.
+var ErrNoRequest = errors.New("no request")
+
+// Engine decides loads against the velocity limits. It is safe for
+// concurrent use: work on a customer is serialized, different customers are
+// decided in parallel. Everything sharing a store must go through the same
+// Engine.
+type Engine struct {
+	config  *config.Configurations
+	limits  *models.Limits
+	cache   service.Cache
+	locks   *service.CustomerLocks
+	auditor service.Auditor
+	clock   service.Clock
+	logger  *logrus.Logger
+	stats   *metrics.Metrics
+}
+
+// Option configures an Engine.
+type Option func(*Engine)
+
+// WithConfig decides loads by the limits, tiers and rules of config. Without
+// it the default limits of $5,000 and 3 loads a day and $20,000 a week apply.
+func WithConfig(config *config.Configurations) Option {
+	return func(e *Engine) {
+		e.config = config
+	}
+}
+
+// WithLimits replaces the daily and weekly limits of the config, and its
+// time zone and week start if they are set.
+func WithLimits(limits models.Limits) Option {
+	return func(e *Engine) {
+		e.limits = &limits
+	}
+}
+
+// WithStore keeps the accounts and transactions in store. Without it they
+// are kept in memory.
+func WithStore(store service.Cache) Option {
+	return func(e *Engine) {
+		e.cache = store
+	}
+}
+
+// WithAuditor records every decision that is applied.
+func WithAuditor(auditor service.Auditor) Option {
+	return func(e *Engine) {
+		e.auditor = auditor
+	}
+}
+
+// WithClock tells the time of requests and headroom queries that do not
+// carry one. Without it the wall clock is used.
+func WithClock(clock service.Clock) Option {
+	return func(e *Engine) {
+		e.clock = clock
+	}
+}
+
+// WithLogger logs the decisions at debug level, and what pipelines run with
+// the engine log, to logger instead of the standard logger. The service and
+// models packages still log to the standard logger.
+func WithLogger(logger *logrus.Logger) Option {
+	return func(e *Engine) {
+		e.logger = logger
+	}
+}
+
+// WithMetrics counts the decisions of Evaluate and Decline in stats, and
+// reports the size of the store if it can tell it. Pipelines count the
+// decisions they write in their own metrics, an engine a pipeline runs with
+// needs no metrics of its own.
+func WithMetrics(stats *metrics.Metrics) Option {
+	return func(e *Engine) {
+		e.stats = stats
+	}
+}
+
+// New returns an engine configured by the options.
+func New(options ...Option) *Engine {
+	e := &Engine{
+		locks:  &service.CustomerLocks{},
+		clock:  service.SystemClock{},
+		logger: logrus.StandardLogger(),
+	}
+	for _, option := range options {
+		option(e)
+	}
+	if e.config == nil {
+		e.config = defaultConfig()
+	}
+	if e.limits != nil {
+		// the caller's config is left as it is
+		config := *e.config
+		config.VelocityLimit.MaxDailyLoadLimit = e.limits.MaxDailyLoadLimit
+		config.VelocityLimit.MaxDailyTransactions = e.limits.MaxDailyTransactions
+		config.VelocityLimit.MaxWeeklyLoadLimit = e.limits.MaxWeeklyLoadLimit
+		if e.limits.TimeZone != "" {
+			config.VelocityLimit.TimeZone = e.limits.TimeZone
+		}
+		if e.limits.WeekStart != "" {
+			config.VelocityLimit.WeekStart = e.limits.WeekStart
+		}
+		e.config = &config
+	}
+	if e.cache == nil {
+		e.cache = cache.NewBoundedCache(e.config.Store.Dedup)
+	}
+	if sizer, ok := e.cache.(metrics.CacheSizer); ok {
+		e.stats.WatchCache(sizer)
+	}
+	return e
+}
+
+// defaultConfig holds the limits of the problem statement.
+func defaultConfig() *config.Configurations {
+	return &config.Configurations{
+		VelocityLimit: config.VelocityLimit{
+			MaxDailyLoadLimit:    models.Dollars(5000),
+			MaxDailyTransactions: 3,
+			MaxWeeklyLoadLimit:   models.Dollars(20000),
+		},
+		Returns: config.Returns{RestoreHeadroom: true, RestoreCount: true},
+	}
+}
+
+// Config returns the configuration loads are decided by.
+func (e *Engine) Config() *config.Configurations {
+	return e.config
+}
+
+// Store returns the store of the accounts and transactions.
+func (e *Engine) Store() service.Cache {
+	return e.cache
+}
+
+// Auditor returns the auditor decisions are recorded by, nil if there is
+// none.
+func (e *Engine) Auditor() service.Auditor {
+	return e.auditor
+}
+
+// Logger returns the logger the engine and its pipelines log to.
+func (e *Engine) Logger() *logrus.Logger {
+	return e.logger
+}
+
+// Evaluate decides the request and applies the decision to the store. A
+// request without a time is stamped with the clock's.
+func (e *Engine) Evaluate(ctx context.Context, request *models.Request) (*models.Response, error) {
+	request, err := e.prepare(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	lock := e.locks.For(request.CustomerID)
+	lock.Lock()
+	response := service.AttemptLoad(request, e.config, e.cache, e.auditor)
+	lock.Unlock()
+	if response.Decision != nil {
+		e.stats.Decision(*response.Decision)
+	}
+	e.log("Evaluated", response)
+	return response, nil
+}
+
+// Decline answers the request with decision instead of deciding it, e.g.
+// for a request that breaks a validation rule. The store is left as it is,
+// the decline is audited like any other decision.
+func (e *Engine) Decline(ctx context.Context, request *models.Request, decision models.Decision) (*models.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if request == nil {
+		return nil, ErrNoRequest
+	}
+	lock := e.locks.For(request.CustomerID)
+	lock.Lock()
+	response := service.Decline(request, decision, e.cache, e.auditor)
+	lock.Unlock()
+	e.stats.Decision(decision)
+	e.log("Declined", response)
+	return response, nil
+}
+
+// Peek decides the request like Evaluate without applying the decision or
+// recording it.
+func (e *Engine) Peek(ctx context.Context, request *models.Request) (*models.Response, error) {
+	request, err := e.prepare(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	lock := e.locks.For(request.CustomerID)
+	lock.Lock()
+	response := service.PeekLoad(request, e.config, e.cache)
+	lock.Unlock()
+	e.log("Peeked", response)
+	return response, nil
+}
+
+// Headroom returns what the customer can still load at the given time, the
+// clock's if it is zero, or nil for an unknown customer.
+func (e *Engine) Headroom(customerID string, at time.Time) *models.Headroom {
+	if at.IsZero() {
+		at = e.clock.Now()
+	}
+	lock := e.locks.For(customerID)
+	lock.Lock()
+	defer lock.Unlock()
+	return service.GetHeadroom(customerID, at, e.cache, e.config)
+}
+
+// WhatIf reports the decision a load of amount by the customer would get at
+// the given time, the clock's if it is zero, and what the customer could
+// load before and after it. Nothing is changed.
+func (e *Engine) WhatIf(customerID string, amount models.Money, at time.Time) *models.WhatIf {
+	clock := e.clock
+	if !at.IsZero() {
+		clock = service.FixedClock(at)
+	}
+	lock := e.locks.For(customerID)
+	lock.Lock()
+	defer lock.Unlock()
+	return service.WhatIf(customerID, amount, clock, e.cache, e.config)
+}
+
+// prepare checks the request can be decided, parses its amount if the caller
+// has not and stamps it with the clock's time if it has none. Requests built
+// by hand rather than by models.NewRequest get the same checks: an ID and
+// customer ID, and a positive amount for loads and withdrawals.
+func (e *Engine) prepare(ctx context.Context, request *models.Request) (*models.Request, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if request == nil {
+		return nil, ErrNoRequest
+	}
+	if request.ID == "" {
+		return nil, &models.FieldError{Field: "id", Err: models.ErrMissingField}
+	}
+	if request.CustomerID == "" {
+		return nil, &models.FieldError{Field: "customer_id", Err: models.ErrMissingField}
+	}
+	prepared := *request
+	if prepared.ParsedAmount == 0 && prepared.Amount != "" {
+		amount, err := models.ParseMoney(prepared.Amount)
+		if err != nil {
+			return nil, &models.FieldError{Field: "load_amount", Err: err}
+		}
+		prepared.ParsedAmount = amount
+	}
+	if (prepared.Type.IsLoad() || prepared.Type == models.RequestWithdrawal) && prepared.ParsedAmount <= 0 {
+		return nil, &models.FieldError{Field: "load_amount", Err: models.ErrNotPositive}
+	}
+	if prepared.ParsedTime.IsZero() {
+		prepared.ParsedTime = e.clock.Now()
+		prepared.Time = prepared.ParsedTime.Format(time.RFC3339)
+	}
+	return &prepared, nil
+}
+
+func (e *Engine) log(action string, response *models.Response) {
+	if !e.logger.IsLevelEnabled(logrus.DebugLevel) {
+		return
+	}
+	entry := e.logger.WithFields(logrus.Fields{
+		"id":          response.ID,
+		"customer_id": response.CustomerID,
+		"accepted":    response.Accepted,
+	})
+	if response.Decision != nil && response.Decision.Reason != "" {
+		entry = entry.WithField("reason", response.Decision.Reason)
+	}
+	entry.Debugln(action + " load")
+}
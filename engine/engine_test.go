@@ -0,0 +1,261 @@
+package engine_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"velocitylimits/audit"
+	"velocitylimits/cache"
+	"velocitylimits/config"
+	"velocitylimits/engine"
+	"velocitylimits/metrics"
+	"velocitylimits/models"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+type recordingAuditor struct {
+	records []audit.Record
+}
+
+func (a *recordingAuditor) Record(record audit.Record) {
+	a.records = append(a.records, record)
+}
+
+func newRequest(t *testing.T, id, customerID, amount, at string) *models.Request {
+	request, err := models.ParseRequest(id, customerID, amount, at)
+	require.NoError(t, err)
+	return request
+}
+
+func TestEvaluate(t *testing.T) {
+	ctx := context.Background()
+	t.Run("applies the default limits", func(t *testing.T) {
+		e := engine.New()
+		response, err := e.Evaluate(ctx, newRequest(t, "1", "528", "$3000", "2000-01-01T00:00:00Z"))
+		require.NoError(t, err)
+		assert.True(t, response.Accepted)
+		response, err = e.Evaluate(ctx, newRequest(t, "2", "528", "$3000", "2000-01-01T01:00:00Z"))
+		require.NoError(t, err)
+		assert.Equal(t, models.ReasonDailyAmountExceeded, response.Decision.Reason)
+	})
+	t.Run("applies the limits given over those of the config", func(t *testing.T) {
+		config := &config.Configurations{VelocityLimit: config.VelocityLimit{
+			MaxDailyLoadLimit:    models.Dollars(100),
+			MaxDailyTransactions: 3,
+			MaxWeeklyLoadLimit:   models.Dollars(100),
+		}}
+		e := engine.New(engine.WithLimits(models.Limits{
+			MaxDailyLoadLimit:    models.Dollars(1000),
+			MaxDailyTransactions: 1,
+			MaxWeeklyLoadLimit:   models.Dollars(1000),
+		}), engine.WithConfig(config))
+		response, err := e.Evaluate(ctx, newRequest(t, "1", "528", "$500", "2000-01-01T00:00:00Z"))
+		require.NoError(t, err)
+		assert.True(t, response.Accepted)
+		response, err = e.Evaluate(ctx, newRequest(t, "2", "528", "$1", "2000-01-01T01:00:00Z"))
+		require.NoError(t, err)
+		assert.Equal(t, models.ReasonDailyCountExceeded, response.Decision.Reason)
+		assert.Equal(t, models.Dollars(100), config.VelocityLimit.MaxDailyLoadLimit)
+	})
+	t.Run("keeps the accounts in the store and records the decisions", func(t *testing.T) {
+		store := cache.NewCache()
+		auditor := &recordingAuditor{}
+		e := engine.New(engine.WithStore(store), engine.WithAuditor(auditor))
+		_, err := e.Evaluate(ctx, newRequest(t, "1", "528", "$30", "2000-01-01T00:00:00Z"))
+		require.NoError(t, err)
+		assert.Equal(t, models.Dollars(30), store.GetAccount("528").Balance)
+		assert.Same(t, store, e.Store())
+		require.Len(t, auditor.records, 1)
+		assert.True(t, auditor.records[0].Decision.Accepted)
+	})
+	t.Run("stamps requests without a time with the clock's", func(t *testing.T) {
+		now := time.Date(2000, 1, 3, 12, 0, 0, 0, time.UTC)
+		auditor := &recordingAuditor{}
+		e := engine.New(engine.WithClock(fixedClock(now)), engine.WithAuditor(auditor))
+		request := &models.Request{ID: "1", CustomerID: "528", ParsedAmount: models.Dollars(10)}
+		response, err := e.Evaluate(ctx, request)
+		require.NoError(t, err)
+		assert.True(t, response.Accepted)
+		assert.True(t, request.ParsedTime.IsZero(), "the caller's request is left as it is")
+		require.Len(t, auditor.records, 1)
+		assert.Equal(t, "2000-01-03T12:00:00Z", auditor.records[0].Request.Time)
+		// the headroom of the clock's day, not of today
+		assert.Equal(t, models.Dollars(4990), e.Headroom("528", time.Time{}).Daily.RemainingAmount)
+	})
+	t.Run("logs the decisions at debug level", func(t *testing.T) {
+		var logs bytes.Buffer
+		logger := logrus.New()
+		logger.SetOutput(&logs)
+		logger.SetLevel(logrus.DebugLevel)
+		e := engine.New(engine.WithLogger(logger))
+		_, err := e.Evaluate(ctx, newRequest(t, "1", "528", "$6000", "2000-01-01T00:00:00Z"))
+		require.NoError(t, err)
+		assert.Contains(t, logs.String(), `msg="Evaluated load" accepted=false customer_id=528 id=1 reason=daily_amount_exceeded`)
+	})
+	t.Run("counts the decisions in the metrics", func(t *testing.T) {
+		stats := metrics.New()
+		e := engine.New(engine.WithMetrics(stats))
+		_, err := e.Evaluate(ctx, newRequest(t, "1", "528", "$1", "2000-01-01T00:00:00Z"))
+		require.NoError(t, err)
+		_, err = e.Evaluate(ctx, newRequest(t, "2", "528", "$6000", "2000-01-01T01:00:00Z"))
+		require.NoError(t, err)
+		_, err = e.Decline(ctx, newRequest(t, "3", "528", "$1", "2000-01-01T02:00:00Z"), models.NewInvalidDecision())
+		require.NoError(t, err)
+		var output bytes.Buffer
+		_, err = stats.Registry().WriteTo(&output)
+		require.NoError(t, err)
+		for _, sample := range []string{
+			"velocitylimits_accepted_total 1\n",
+			`velocitylimits_declined_total{reason="daily_amount_exceeded"} 1` + "\n",
+			`velocitylimits_declined_total{reason="invalid"} 1` + "\n",
+			`velocitylimits_cache_size{kind="accounts"} 1` + "\n",
+		} {
+			assert.Contains(t, output.String(), sample)
+		}
+	})
+	t.Run("returns error for a done context or no request", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := engine.New().Evaluate(canceled, newRequest(t, "1", "528", "$1", "2000-01-01T00:00:00Z"))
+		assert.Equal(t, context.Canceled, err)
+		_, err = engine.New().Evaluate(ctx, nil)
+		assert.True(t, errors.Is(err, engine.ErrNoRequest))
+	})
+	t.Run("parses the amount of a request built without it", func(t *testing.T) {
+		e := engine.New()
+		request := &models.Request{ID: "1", CustomerID: "528", Amount: "$100", Time: "2000-01-01T00:00:00Z"}
+		request.ParsedTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		response, err := e.Evaluate(ctx, request)
+		require.NoError(t, err)
+		assert.True(t, response.Accepted)
+		assert.Equal(t, models.Dollars(4900), e.Headroom("528", request.ParsedTime).Daily.RemainingAmount)
+		assert.Equal(t, models.Money(0), request.ParsedAmount, "the caller's request is left as it is")
+	})
+	t.Run("returns error for a request that cannot be decided", func(t *testing.T) {
+		at := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		for name, request := range map[string]*models.Request{
+			"no amount":             {ID: "1", CustomerID: "528", ParsedTime: at},
+			"negative amount":       {ID: "1", CustomerID: "528", ParsedAmount: models.Dollars(-10000), ParsedTime: at},
+			"negative withdrawal":   {ID: "1", CustomerID: "528", Type: models.RequestWithdrawal, ParsedAmount: models.Dollars(-1), ParsedTime: at},
+			"invalid amount":        {ID: "1", CustomerID: "528", Amount: "lots", ParsedTime: at},
+			"no id":                 {CustomerID: "528", ParsedAmount: models.Dollars(1), ParsedTime: at},
+			"no customer":           {ID: "1", ParsedAmount: models.Dollars(1), ParsedTime: at},
+			"negative amount given": {ID: "1", CustomerID: "528", Amount: "-$10000", ParsedTime: at},
+		} {
+			e := engine.New()
+			_, err := e.Evaluate(ctx, request)
+			var fieldErr *models.FieldError
+			assert.True(t, errors.As(err, &fieldErr), name)
+			_, err = e.Peek(ctx, request)
+			assert.True(t, errors.As(err, &fieldErr), name)
+			assert.Nil(t, e.Headroom("528", at), name)
+		}
+	})
+	t.Run("decides concurrent loads of a customer one at a time", func(t *testing.T) {
+		e := engine.New()
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		accepted := 0
+		for i := 0; i < 50; i++ {
+			request := newRequest(t, fmt.Sprint(i), fmt.Sprint(i%2), "$1", "2000-01-01T00:00:00Z")
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				response, err := e.Evaluate(ctx, request)
+				if assert.NoError(t, err) && response.Accepted {
+					mu.Lock()
+					accepted++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 6, accepted)
+		assert.Equal(t, 0, e.Headroom("0", time.Date(2000, 1, 1, 1, 0, 0, 0, time.UTC)).Daily.RemainingLoads)
+	})
+}
+
+func TestDecline(t *testing.T) {
+	ctx := context.Background()
+	t.Run("audits the decline without loading", func(t *testing.T) {
+		auditor := &recordingAuditor{}
+		e := engine.New(engine.WithAuditor(auditor))
+		request := newRequest(t, "1", "528", "$3000", "2000-01-01T00:00:00Z")
+		response, err := e.Decline(ctx, request, models.NewInvalidDecision())
+		require.NoError(t, err)
+		assert.Equal(t, models.NewDecisionResponse("1", "528", models.NewInvalidDecision()), response)
+		assert.Nil(t, e.Headroom("528", request.ParsedTime))
+		require.Len(t, auditor.records, 1)
+		assert.Equal(t, models.ReasonInvalid, auditor.records[0].Decision.Reason)
+	})
+	t.Run("returns error for a nil request or a done context", func(t *testing.T) {
+		_, err := engine.New().Decline(ctx, nil, models.NewInvalidDecision())
+		assert.True(t, errors.Is(err, engine.ErrNoRequest))
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err = engine.New().Decline(canceled, newRequest(t, "1", "528", "$1", "2000-01-01T00:00:00Z"), models.NewInvalidDecision())
+		assert.True(t, errors.Is(err, context.Canceled))
+	})
+}
+
+func TestPeek(t *testing.T) {
+	ctx := context.Background()
+	t.Run("decides without applying the decision", func(t *testing.T) {
+		auditor := &recordingAuditor{}
+		e := engine.New(engine.WithAuditor(auditor))
+		request := newRequest(t, "1", "528", "$3000", "2000-01-01T00:00:00Z")
+		response, err := e.Peek(ctx, request)
+		require.NoError(t, err)
+		assert.True(t, response.Accepted)
+		assert.Nil(t, e.Headroom("528", request.ParsedTime))
+		assert.Empty(t, auditor.records)
+
+		_, err = e.Evaluate(ctx, request)
+		require.NoError(t, err)
+		response, err = e.Peek(ctx, newRequest(t, "2", "528", "$2000", "2000-01-01T01:00:00Z"))
+		require.NoError(t, err)
+		assert.True(t, response.Accepted)
+		assert.Equal(t, models.Dollars(2000), e.Headroom("528", request.ParsedTime).Daily.RemainingAmount)
+	})
+}
+
+func TestHeadroom(t *testing.T) {
+	t.Run("returns nil for an unknown customer", func(t *testing.T) {
+		assert.Nil(t, engine.New().Headroom("528", time.Time{}))
+	})
+}
+
+func TestWhatIf(t *testing.T) {
+	t.Run("checks at the clock's time without changing the store", func(t *testing.T) {
+		now := time.Date(2000, 1, 3, 12, 0, 0, 0, time.UTC)
+		auditor := &recordingAuditor{}
+		e := engine.New(engine.WithClock(fixedClock(now)), engine.WithAuditor(auditor))
+		_, err := e.Evaluate(context.Background(), newRequest(t, "1", "528", "$4000", "2000-01-03T01:00:00Z"))
+		require.NoError(t, err)
+
+		whatIf := e.WhatIf("528", models.Dollars(2000), time.Time{})
+		assert.False(t, whatIf.Accepted)
+		assert.Equal(t, now, whatIf.Time)
+		assert.Equal(t, models.Dollars(1000), whatIf.Headroom.Daily.RemainingAmount)
+		// the next day it would be accepted
+		whatIf = e.WhatIf("528", models.Dollars(2000), now.AddDate(0, 0, 1))
+		assert.True(t, whatIf.Accepted)
+		assert.Equal(t, models.Dollars(3000), whatIf.After.Daily.RemainingAmount)
+		assert.Equal(t, models.Dollars(4000), e.Store().GetAccount("528").Balance)
+		assert.Len(t, auditor.records, 1)
+	})
+}
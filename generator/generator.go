@@ -0,0 +1,232 @@
+package generator
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"velocitylimits/models"
+)
+
+// Distributions of the load amounts.
+const (
+	// AmountUniform draws amounts evenly between MinAmount and MaxAmount.
+	AmountUniform = "uniform"
+	// AmountExponential draws amounts averaging MeanAmount, most of them
+	// small.
+	AmountExponential = "exponential"
+	// AmountLogNormal draws amounts around MeanAmount with a long tail of
+	// large ones.
+	AmountLogNormal = "lognormal"
+)
+
+// logNormalSigma is the spread of log-normal amounts: about one in six is
+// over 2.7 times the median.
+const logNormalSigma = 1.0
+
+// recentRequests is how many of the last requests a duplicate is drawn from.
+const recentRequests = 1000
+
+// ErrInvalidOptions is wrapped in the errors of options that cannot be
+// generated from.
+var ErrInvalidOptions = errors.New("invalid generator options")
+
+// Options describe the input to generate. Rates are percentages of the
+// lines.
+type Options struct {
+	// Lines is the number of lines generated.
+	Lines int
+	// Customers is the number of customers loading.
+	Customers int
+	// Skew is the exponent of the Zipf distribution of loads over customers,
+	// over 1; the higher it is the more the loads of the most active
+	// customers outnumber the rest. Zero spreads loads evenly.
+	Skew float64
+	// Amounts is the distribution of the load amounts, which are kept
+	// between MinAmount and MaxAmount.
+	Amounts    string
+	MinAmount  models.Money
+	MaxAmount  models.Money
+	MeanAmount models.Money
+	// DuplicateRate is the rate of lines repeating one of the recent loads.
+	DuplicateRate float64
+	// MalformedRate is the rate of lines that cannot be parsed into a
+	// request.
+	MalformedRate float64
+	// OutOfOrderRate is the rate of loads dated up to MaxDelay before the
+	// loads around them.
+	OutOfOrderRate float64
+	MaxDelay       time.Duration
+	// Start and Span are when the loads are made, spread evenly.
+	Start time.Time
+	Span  time.Duration
+	// Seed makes the input reproducible: the same options and seed always
+	// generate the same input.
+	Seed int64
+}
+
+// DefaultOptions returns options for a thousand loads of a hundred customers
+// over a week from 2000-01-01.
+func DefaultOptions() Options {
+	return Options{
+		Lines:      1000,
+		Customers:  100,
+		Skew:       1.1,
+		Amounts:    AmountLogNormal,
+		MinAmount:  models.Cents(1),
+		MaxAmount:  models.Dollars(6000),
+		MeanAmount: models.Dollars(1000),
+		MaxDelay:   time.Hour,
+		Start:      time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		Span:       7 * 24 * time.Hour,
+		Seed:       1,
+	}
+}
+
+// Validate checks the options can be generated from.
+func (o Options) Validate() error {
+	switch {
+	case o.Lines < 0:
+		return fmt.Errorf("%w: negative number of lines", ErrInvalidOptions)
+	case o.Customers < 1:
+		return fmt.Errorf("%w: no customers", ErrInvalidOptions)
+	case o.Skew != 0 && o.Skew <= 1:
+		return fmt.Errorf("%w: skew %g is not over 1", ErrInvalidOptions, o.Skew)
+	case o.Amounts != AmountUniform && o.Amounts != AmountExponential && o.Amounts != AmountLogNormal:
+		return fmt.Errorf("%w: unknown amount distribution %q", ErrInvalidOptions, o.Amounts)
+	case o.MinAmount <= 0 || o.MaxAmount < o.MinAmount:
+		return fmt.Errorf("%w: amounts must be positive and the minimum at most the maximum", ErrInvalidOptions)
+	case o.Amounts != AmountUniform && o.MeanAmount <= 0:
+		return fmt.Errorf("%w: mean amount must be positive", ErrInvalidOptions)
+	case o.DuplicateRate+o.MalformedRate > 100 || o.DuplicateRate < 0 || o.MalformedRate < 0 || o.OutOfOrderRate < 0 || o.OutOfOrderRate > 100:
+		return fmt.Errorf("%w: rates must be between 0 and 100", ErrInvalidOptions)
+	case o.MaxDelay < 0 || o.Span < 0:
+		return fmt.Errorf("%w: negative duration", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// generator holds the state of one run.
+type generator struct {
+	options   Options
+	random    *rand.Rand
+	zipf      *rand.Zipf
+	customers []string
+	recent    []models.Request
+	nextID    int
+}
+
+// Generate writes options.Lines lines of load requests to w.
+func Generate(w io.Writer, options Options) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	g := &generator{options: options, random: rand.New(rand.NewSource(options.Seed))}
+	if options.Skew != 0 {
+		g.zipf = rand.NewZipf(g.random, options.Skew, 1, uint64(options.Customers-1))
+	}
+	// the most active customers are not the lowest IDs
+	g.customers = make([]string, options.Customers)
+	for i, n := range g.random.Perm(options.Customers) {
+		g.customers[i] = strconv.Itoa(n + 1)
+	}
+
+	writer := bufio.NewWriter(w)
+	for i := 0; i < options.Lines; i++ {
+		line, err := g.line(i)
+		if err != nil {
+			return err
+		}
+		writer.Write(line)
+		writer.WriteByte('\n')
+	}
+	return writer.Flush()
+}
+
+// line returns the ith line: a duplicate, a malformed line or a new load.
+func (g *generator) line(i int) ([]byte, error) {
+	roll := g.random.Float64() * 100
+	switch {
+	case roll < g.options.DuplicateRate:
+		// before any load there is nothing to duplicate, a new one is sent
+		if len(g.recent) > 0 {
+			return json.Marshal(g.recent[g.random.Intn(len(g.recent))])
+		}
+	case roll < g.options.DuplicateRate+g.options.MalformedRate:
+		return g.malformed(g.load(i)), nil
+	}
+	request := g.load(i)
+	if len(g.recent) < recentRequests {
+		g.recent = append(g.recent, request)
+	} else {
+		g.recent[g.random.Intn(recentRequests)] = request
+	}
+	return json.Marshal(request)
+}
+
+// load returns a new load of a customer drawn by activity, at the ith
+// line's share of the span or up to MaxDelay before it.
+func (g *generator) load(i int) models.Request {
+	g.nextID++
+	customer := g.random.Intn(g.options.Customers)
+	if g.zipf != nil {
+		customer = int(g.zipf.Uint64())
+	}
+	at := g.options.Start
+	if g.options.Lines > 0 {
+		at = at.Add(time.Duration(float64(g.options.Span) * float64(i) / float64(g.options.Lines)))
+	}
+	if g.random.Float64()*100 < g.options.OutOfOrderRate && g.options.MaxDelay > 0 {
+		at = at.Add(-time.Duration(g.random.Int63n(int64(g.options.MaxDelay)) + 1))
+	}
+	return models.Request{
+		ID:         strconv.Itoa(g.nextID),
+		CustomerID: g.customers[customer],
+		Amount:     g.amount().String(),
+		Time:       at.Truncate(time.Second).Format(time.RFC3339),
+	}
+}
+
+// amount draws an amount from the distribution, rounded to cents.
+func (g *generator) amount() models.Money {
+	min, max := float64(g.options.MinAmount), float64(g.options.MaxAmount)
+	mean := float64(g.options.MeanAmount)
+	var cents float64
+	switch g.options.Amounts {
+	case AmountUniform:
+		cents = min + g.random.Float64()*(max-min)
+	case AmountExponential:
+		cents = g.random.ExpFloat64() * mean
+	case AmountLogNormal:
+		// the mean of exp(mu + sigma*N) is exp(mu + sigma^2/2)
+		mu := math.Log(mean) - logNormalSigma*logNormalSigma/2
+		cents = math.Exp(mu + logNormalSigma*g.random.NormFloat64())
+	}
+	return models.Cents(int64(math.Round(math.Max(min, math.Min(max, cents)))))
+}
+
+// malformed breaks the request in one of the ways that keep it from being
+// parsed.
+func (g *generator) malformed(request models.Request) []byte {
+	switch g.random.Intn(5) {
+	case 0:
+		line, _ := json.Marshal(request)
+		return line[:g.random.Intn(len(line)-1)+1]
+	case 1:
+		request.Amount = "$" + strconv.Itoa(g.random.Intn(1000)) + ".999"
+	case 2:
+		request.Amount = "lots"
+	case 3:
+		request.Time = request.Time[:10]
+	case 4:
+		request.ID = ""
+	}
+	line, _ := json.Marshal(request)
+	return line
+}
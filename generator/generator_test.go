@@ -0,0 +1,173 @@
+package generator_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"velocitylimits/generator"
+	"velocitylimits/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generate(t *testing.T, options generator.Options) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, generator.Generate(&buf, options))
+	return buf.Bytes()
+}
+
+// parse returns the requests of the lines that can be parsed and counts
+// those that cannot.
+func parse(t *testing.T, input []byte) ([]*models.Request, int) {
+	var requests []*models.Request
+	malformed := 0
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		var request models.Request
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			malformed++
+			continue
+		}
+		parsed, err := models.ParseRequest(request.ID, request.CustomerID, request.Amount, request.Time)
+		if err != nil {
+			malformed++
+			continue
+		}
+		requests = append(requests, parsed)
+	}
+	require.NoError(t, scanner.Err())
+	return requests, malformed
+}
+
+func TestGenerate(t *testing.T) {
+	t.Run("is deterministic under a seed", func(t *testing.T) {
+		options := generator.DefaultOptions()
+		options.DuplicateRate, options.MalformedRate, options.OutOfOrderRate = 5, 5, 5
+		first := generate(t, options)
+		assert.Equal(t, first, generate(t, options))
+		options.Seed = 2
+		assert.NotEqual(t, first, generate(t, options))
+	})
+	t.Run("writes the lines asked for over the span", func(t *testing.T) {
+		options := generator.DefaultOptions()
+		requests, malformed := parse(t, generate(t, options))
+		require.Len(t, requests, 1000)
+		assert.Zero(t, malformed)
+		assert.Equal(t, options.Start, requests[0].ParsedTime)
+		ids := make(map[string]bool)
+		for i, request := range requests {
+			assert.False(t, ids[request.ID], "IDs are unique")
+			ids[request.ID] = true
+			assert.True(t, request.ParsedTime.Before(options.Start.Add(options.Span)))
+			if i > 0 {
+				assert.False(t, request.ParsedTime.Before(requests[i-1].ParsedTime), "in time order")
+			}
+			assert.True(t, request.ParsedAmount >= options.MinAmount && request.ParsedAmount <= options.MaxAmount)
+		}
+	})
+	t.Run("writes duplicate, malformed and out of order lines at their rates", func(t *testing.T) {
+		options := generator.DefaultOptions()
+		options.Lines = 20000
+		options.DuplicateRate, options.MalformedRate, options.OutOfOrderRate = 10, 5, 20
+		options.MaxDelay = time.Hour
+		requests, malformed := parse(t, generate(t, options))
+		assert.InDelta(t, 1000, malformed, 150)
+		seen := make(map[string]bool)
+		duplicates, outOfOrder := 0, 0
+		newest := time.Time{}
+		for _, request := range requests {
+			if seen[request.ID] {
+				duplicates++
+				continue
+			}
+			seen[request.ID] = true
+			if request.ParsedTime.Before(newest) {
+				outOfOrder++
+				assert.True(t, newest.Sub(request.ParsedTime) <= time.Hour)
+			} else {
+				newest = request.ParsedTime
+			}
+		}
+		assert.InDelta(t, 2000, duplicates, 200)
+		// a few are dated back less than the gap to the load before
+		assert.InDelta(t, 0.2*float64(len(requests)-duplicates), outOfOrder, 300)
+	})
+	t.Run("writes no malformed lines at a malformed rate of zero", func(t *testing.T) {
+		options := generator.DefaultOptions()
+		options.DuplicateRate, options.MalformedRate = 100, 0
+		requests, malformed := parse(t, generate(t, options))
+		assert.Zero(t, malformed)
+		require.Len(t, requests, options.Lines)
+		// the first line has nothing to duplicate, so it is a new load
+		assert.Equal(t, "1", requests[0].ID)
+	})
+	t.Run("concentrates the loads on few customers by skew", func(t *testing.T) {
+		options := generator.DefaultOptions()
+		options.Lines = 10000
+		top := func(skew float64) int {
+			options.Skew = skew
+			requests, _ := parse(t, generate(t, options))
+			counts := make(map[string]int)
+			most := 0
+			for _, request := range requests {
+				counts[request.CustomerID]++
+				if counts[request.CustomerID] > most {
+					most = counts[request.CustomerID]
+				}
+			}
+			assert.Len(t, counts, options.Customers)
+			return most
+		}
+		assert.True(t, top(0) < 200)
+		assert.True(t, top(1.5) > 2000)
+	})
+	t.Run("draws amounts from the distribution", func(t *testing.T) {
+		for _, amounts := range []string{generator.AmountUniform, generator.AmountExponential, generator.AmountLogNormal} {
+			options := generator.DefaultOptions()
+			options.Lines = 20000
+			options.Amounts = amounts
+			options.MinAmount, options.MaxAmount, options.MeanAmount = models.Dollars(10), models.Dollars(1000000), models.Dollars(1000)
+			if amounts == generator.AmountUniform {
+				options.MaxAmount = models.Dollars(1990)
+			}
+			requests, _ := parse(t, generate(t, options))
+			var total models.Money
+			for _, request := range requests {
+				assert.True(t, request.ParsedAmount >= options.MinAmount && request.ParsedAmount <= options.MaxAmount)
+				total += request.ParsedAmount
+			}
+			assert.InDelta(t, 100000, total.Cents()/int64(len(requests)), 5000, amounts)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	for name, change := range map[string]func(*generator.Options){
+		"no customers":         func(o *generator.Options) { o.Customers = 0 },
+		"skew of 1":            func(o *generator.Options) { o.Skew = 1 },
+		"unknown distribution": func(o *generator.Options) { o.Amounts = "normal" },
+		"minimum over maximum": func(o *generator.Options) { o.MinAmount = o.MaxAmount + 1 },
+		"rates over 100":       func(o *generator.Options) { o.DuplicateRate, o.MalformedRate = 60, 50 },
+		"negative rate":        func(o *generator.Options) { o.OutOfOrderRate = -1 },
+		"negative span":        func(o *generator.Options) { o.Span = -time.Hour },
+	} {
+		t.Run("returns error for "+name, func(t *testing.T) {
+			options := generator.DefaultOptions()
+			change(&options)
+			err := generator.Generate(&bytes.Buffer{}, options)
+			assert.True(t, errors.Is(err, generator.ErrInvalidOptions))
+		})
+	}
+	t.Run("accepts the defaults and a single customer", func(t *testing.T) {
+		options := generator.DefaultOptions()
+		assert.NoError(t, options.Validate())
+		options.Customers = 1
+		requests, _ := parse(t, generate(t, options))
+		assert.Equal(t, "1", requests[0].CustomerID)
+	})
+}
@@ -3,8 +3,11 @@ module velocitylimits
 go 1.14
 
 require (
+	github.com/mitchellh/mapstructure v1.1.2
 	github.com/sirupsen/logrus v1.7.0
 	github.com/spf13/viper v1.7.1
 	github.com/stretchr/testify v1.6.1
 	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a
+	google.golang.org/grpc v1.38.0
+	google.golang.org/protobuf v1.26.0
 )
@@ -0,0 +1,169 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"velocitylimits/config"
+	"velocitylimits/engine"
+	"velocitylimits/models"
+	"velocitylimits/pb"
+	"velocitylimits/service"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements pb.VelocityLimitsServer on top of an engine.Engine.
+type Server struct {
+	pb.UnimplementedVelocityLimitsServer
+	config    *config.Configurations
+	engine    *engine.Engine
+	validator *models.Validator
+}
+
+// NewServer returns a server over the engine, configured by its config.
+func NewServer(engine *engine.Engine) *Server {
+	return &Server{
+		config:    engine.Config(),
+		engine:    engine,
+		validator: models.NewValidator(engine.Config().Validation),
+	}
+}
+
+// ListenAndServe serves on the configured address until ctx is done.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.config.GRPC.Address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves on listener until ctx is done and then stops gracefully,
+// letting in-flight calls finish.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	grpcServer := grpc.NewServer()
+	pb.RegisterVelocityLimitsServer(grpcServer, s)
+	errC := make(chan error, 1)
+	go func() {
+		logrus.Infof("Listening for gRPC on %s", listener.Addr())
+		errC <- grpcServer.Serve(listener)
+	}()
+	select {
+	case err := <-errC:
+		return err
+	case <-ctx.Done():
+	}
+	logrus.Infoln("Shutting down gRPC server")
+	grpcServer.GracefulStop()
+	return <-errC
+}
+
+// AttemptLoad ...
+func (s *Server) AttemptLoad(ctx context.Context, req *pb.LoadRequest) (*pb.LoadResponse, error) {
+	return s.attemptLoad(ctx, req)
+}
+
+// AttemptLoadStream answers every request on the stream in order.
+func (s *Server) AttemptLoadStream(stream pb.VelocityLimits_AttemptLoadStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		response, err := s.attemptLoad(stream.Context(), req)
+		if err != nil {
+			response = &pb.LoadResponse{Id: req.Id, CustomerId: req.CustomerId, Error: status.Convert(err).Message()}
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
+
+// GetAccountLimits ...
+func (s *Server) GetAccountLimits(ctx context.Context, req *pb.GetAccountLimitsRequest) (*pb.AccountLimits, error) {
+	if req.CustomerId == "" {
+		return nil, status.Error(codes.InvalidArgument, "customer_id is required")
+	}
+	var at time.Time
+	if req.At != nil {
+		if err := req.At.CheckValid(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		at = req.At.AsTime()
+	}
+
+	headroom := s.engine.Headroom(req.CustomerId, at)
+	if headroom == nil {
+		return nil, status.Error(codes.NotFound, "customer not found")
+	}
+	return &pb.AccountLimits{
+		CustomerId: headroom.CustomerID,
+		Daily: &pb.DailyLimit{
+			Start:           timestamppb.New(headroom.Daily.Start),
+			RemainingAmount: headroom.Daily.RemainingAmount.String(),
+			LimitAmount:     headroom.Daily.LimitAmount.String(),
+			RemainingLoads:  int32(headroom.Daily.RemainingLoads),
+			LimitLoads:      int32(headroom.Daily.LimitLoads),
+		},
+		Weekly: &pb.WeeklyLimit{
+			Start:           timestamppb.New(headroom.Weekly.Start),
+			RemainingAmount: headroom.Weekly.RemainingAmount.String(),
+			LimitAmount:     headroom.Weekly.LimitAmount.String(),
+		},
+	}, nil
+}
+
+// attemptLoad parses the request and evaluates it. Errors are gRPC status
+// errors.
+func (s *Server) attemptLoad(ctx context.Context, req *pb.LoadRequest) (*pb.LoadResponse, error) {
+	request, err := s.validator.ParseFields(models.Request{
+		ID:         req.Id,
+		CustomerID: req.CustomerId,
+		Type:       models.RequestType(req.Type),
+		LoadID:     req.LoadId,
+		Amount:     req.LoadAmount,
+		Time:       req.Time,
+	})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	response, err := s.engine.Evaluate(ctx, request)
+	if err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	if response.Conflicting() {
+		return nil, status.Error(codes.AlreadyExists, service.ErrConflictingRetry.Error())
+	}
+
+	return toLoadResponse(response), nil
+}
+
+func toLoadResponse(response *models.Response) *pb.LoadResponse {
+	loadResponse := &pb.LoadResponse{
+		Id:         response.ID,
+		CustomerId: response.CustomerID,
+		Accepted:   response.Accepted,
+	}
+	if response.Decision != nil {
+		loadResponse.Decision = &pb.Decision{
+			Reason:     string(response.Decision.Reason),
+			Limit:      string(response.Decision.Limit),
+			Remaining:  response.Decision.FormatRemaining(),
+			LimitValue: response.Decision.FormatLimitValue(),
+		}
+	}
+	return loadResponse
+}
@@ -0,0 +1,200 @@
+package grpcserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"velocitylimits/config"
+	"velocitylimits/engine"
+	"velocitylimits/models"
+	"velocitylimits/pb"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newTestClient serves a fresh server on an in-process bufconn listener and
+// returns a client connected to it. configure may change the server config.
+func newTestClient(t *testing.T, configure ...func(*config.Configurations)) pb.VelocityLimitsClient {
+	config := &config.Configurations{VelocityLimit: config.VelocityLimit{
+		MaxDailyLoadLimit:    models.Dollars(5000),
+		MaxDailyTransactions: 3,
+		MaxWeeklyLoadLimit:   models.Dollars(20000),
+	}}
+	for _, fn := range configure {
+		fn(config)
+	}
+	listener := bufconn.Listen(1 << 20)
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+	go func() {
+		errC <- NewServer(engine.New(engine.WithConfig(config))).Serve(ctx, listener)
+	}()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		assert.NoError(t, <-errC)
+	})
+	return pb.NewVelocityLimitsClient(conn)
+}
+
+func loadRequest(id, amount, t string) *pb.LoadRequest {
+	return &pb.LoadRequest{Id: id, CustomerId: "528", LoadAmount: amount, Time: t}
+}
+
+func TestAttemptLoad(t *testing.T) {
+	t.Run("accepts and then declines over the daily limit", func(t *testing.T) {
+		client := newTestClient(t)
+		response, err := client.AttemptLoad(context.Background(), loadRequest("1", "$3000", "2000-01-01T00:00:00Z"))
+		require.NoError(t, err)
+		assert.True(t, response.Accepted)
+		assert.Equal(t, "accepted", response.Decision.Reason)
+
+		response, err = client.AttemptLoad(context.Background(), loadRequest("2", "$3000", "2000-01-01T01:00:00Z"))
+		require.NoError(t, err)
+		assert.False(t, response.Accepted)
+		assert.Equal(t, &pb.Decision{Reason: "daily_amount_exceeded", Limit: "daily_amount", Remaining: "$2,000.00", LimitValue: "$5,000.00"}, response.Decision)
+	})
+	t.Run("returns invalid argument for an invalid request", func(t *testing.T) {
+		client := newTestClient(t)
+		_, err := client.AttemptLoad(context.Background(), loadRequest("1", "$abc", "2000-01-01T00:00:00Z"))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = client.AttemptLoad(context.Background(), &pb.LoadRequest{LoadAmount: "$1", Time: "2000-01-01T00:00:00Z"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+	t.Run("returns invalid argument for a request breaking a validation rule", func(t *testing.T) {
+		client := newTestClient(t, func(config *config.Configurations) {
+			config.Validation = models.ValidationRules{Strict: true, MaxIDLength: 3}
+		})
+		_, err := client.AttemptLoad(context.Background(), loadRequest("1234", "$1", "2000-01-01T00:00:00Z"))
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, err.Error(), "id: too long")
+	})
+	t.Run("replays retries and returns already exists for conflicts", func(t *testing.T) {
+		client := newTestClient(t, func(config *config.Configurations) { config.Store.Dedup.Idempotent = true })
+		first, err := client.AttemptLoad(context.Background(), loadRequest("1", "$3000", "2000-01-01T00:00:00Z"))
+		require.NoError(t, err)
+		retry, err := client.AttemptLoad(context.Background(), loadRequest("1", "$3000", "2000-01-01T00:00:00Z"))
+		require.NoError(t, err)
+		assert.True(t, retry.Accepted)
+		assert.Equal(t, first.Decision.Reason, retry.Decision.Reason)
+		_, err = client.AttemptLoad(context.Background(), loadRequest("1", "$3001", "2000-01-01T00:00:00Z"))
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	})
+	t.Run("withdraws what the balance covers", func(t *testing.T) {
+		client := newTestClient(t)
+		_, err := client.AttemptLoad(context.Background(), loadRequest("1", "$3000", "2000-01-01T00:00:00Z"))
+		require.NoError(t, err)
+		withdrawal := loadRequest("2", "$1000", "2000-01-01T01:00:00Z")
+		withdrawal.Type = "withdrawal"
+		response, err := client.AttemptLoad(context.Background(), withdrawal)
+		require.NoError(t, err)
+		assert.True(t, response.Accepted)
+
+		withdrawal = loadRequest("3", "$2000.01", "2000-01-01T02:00:00Z")
+		withdrawal.Type = "withdrawal"
+		response, err = client.AttemptLoad(context.Background(), withdrawal)
+		require.NoError(t, err)
+		assert.Equal(t, &pb.Decision{Reason: "insufficient_balance"}, response.Decision)
+	})
+	t.Run("reverses a load and gives its headroom back", func(t *testing.T) {
+		client := newTestClient(t, func(config *config.Configurations) {
+			config.Returns.RestoreHeadroom, config.Returns.RestoreCount = true, true
+		})
+		_, err := client.AttemptLoad(context.Background(), loadRequest("1", "$3000", "2000-01-01T00:00:00Z"))
+		require.NoError(t, err)
+		reversal := &pb.LoadRequest{Id: "2", CustomerId: "528", Type: "reversal", LoadId: "1", Time: "2000-01-01T01:00:00Z"}
+		response, err := client.AttemptLoad(context.Background(), reversal)
+		require.NoError(t, err)
+		assert.True(t, response.Accepted)
+
+		limits, err := client.GetAccountLimits(context.Background(), &pb.GetAccountLimitsRequest{CustomerId: "528", At: timestamppb.New(time.Date(2000, 1, 1, 2, 0, 0, 0, time.UTC))})
+		require.NoError(t, err)
+		assert.Equal(t, "$5,000.00", limits.Daily.RemainingAmount)
+		assert.Equal(t, int32(3), limits.Daily.RemainingLoads)
+
+		reversal.Id, reversal.LoadId = "3", "4"
+		response, err = client.AttemptLoad(context.Background(), reversal)
+		require.NoError(t, err)
+		assert.Equal(t, "unknown_load", response.Decision.Reason)
+	})
+	t.Run("returns invalid argument for a reversal without a load", func(t *testing.T) {
+		client := newTestClient(t)
+		_, err := client.AttemptLoad(context.Background(), &pb.LoadRequest{Id: "1", CustomerId: "528", Type: "reversal", Time: "2000-01-01T00:00:00Z"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, err.Error(), "load_id")
+	})
+}
+
+func TestAttemptLoadStream(t *testing.T) {
+	t.Run("answers every request in order", func(t *testing.T) {
+		client := newTestClient(t)
+		stream, err := client.AttemptLoadStream(context.Background())
+		require.NoError(t, err)
+		for i := 0; i < 5; i++ {
+			require.NoError(t, stream.Send(loadRequest(fmt.Sprint(i), "$100", "2000-01-01T00:00:00Z")))
+		}
+		require.NoError(t, stream.Send(loadRequest("bad", "$abc", "2000-01-01T00:00:00Z")))
+		require.NoError(t, stream.CloseSend())
+
+		var responses []*pb.LoadResponse
+		for {
+			response, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			responses = append(responses, response)
+		}
+		require.Len(t, responses, 6)
+		for i, response := range responses[:5] {
+			assert.Equal(t, fmt.Sprint(i), response.Id)
+			assert.Equal(t, i < 3, response.Accepted)
+		}
+		assert.Equal(t, "bad", responses[5].Id)
+		assert.Contains(t, responses[5].Error, "load_amount")
+	})
+}
+
+func TestGetAccountLimits(t *testing.T) {
+	t.Run("returns remaining headroom", func(t *testing.T) {
+		client := newTestClient(t)
+		_, err := client.AttemptLoad(context.Background(), loadRequest("1", "$3000", "2000-01-04T10:00:00Z"))
+		require.NoError(t, err)
+
+		at := timestamppb.New(time.Date(2000, 1, 4, 12, 0, 0, 0, time.UTC))
+		limits, err := client.GetAccountLimits(context.Background(), &pb.GetAccountLimitsRequest{CustomerId: "528", At: at})
+		require.NoError(t, err)
+		assert.Equal(t, "$2,000.00", limits.Daily.RemainingAmount)
+		assert.Equal(t, "$5,000.00", limits.Daily.LimitAmount)
+		assert.Equal(t, int32(2), limits.Daily.RemainingLoads)
+		assert.Equal(t, int32(3), limits.Daily.LimitLoads)
+		assert.Equal(t, time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC), limits.Daily.Start.AsTime())
+		assert.Equal(t, "$17,000.00", limits.Weekly.RemainingAmount)
+		assert.Equal(t, time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC), limits.Weekly.Start.AsTime())
+	})
+	t.Run("returns not found for an unknown customer", func(t *testing.T) {
+		client := newTestClient(t)
+		_, err := client.GetAccountLimits(context.Background(), &pb.GetAccountLimitsRequest{CustomerId: "1"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+	t.Run("returns invalid argument without a customer", func(t *testing.T) {
+		client := newTestClient(t)
+		_, err := client.GetAccountLimits(context.Background(), &pb.GetAccountLimitsRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"velocitylimits/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Stages of the pipeline whose latency is measured.
+const (
+	StageGetRequest  = "get_request"
+	StageAttemptLoad = "attempt_load"
+	StageResponder   = "responder"
+)
+
+// latencyBuckets are the upper bounds in seconds of the stage latencies, from
+// a microsecond to a second.
+var latencyBuckets = []float64{
+	0.000001, 0.0000025, 0.000005, 0.00001, 0.000025, 0.00005, 0.0001, 0.00025,
+	0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+}
+
+// CacheSizer is implemented by caches that can report their size.
+type CacheSizer interface {
+	AccountCount() int
+	TransactionCount() int
+}
+
+// Metrics are the statistics of the pipeline and its decisions. The methods
+// of a nil *Metrics do nothing, so stages can be run without them.
+type Metrics struct {
+	registry     *Registry
+	requestsRead *Counter
+	parseErrors  *Counter
+	late         *Counter
+	accepted     *Counter
+	declined     *CounterVec
+	duplicates   *Counter
+	stageLatency *HistogramVec
+	backlog      *GaugeFuncVec
+	cacheSize    *GaugeFuncVec
+}
+
+// New registers the metrics with a new registry.
+func New() *Metrics {
+	r := NewRegistry()
+	return &Metrics{
+		registry:     r,
+		requestsRead: r.NewCounter("velocitylimits_requests_read_total", "Input lines read."),
+		parseErrors:  r.NewCounter("velocitylimits_parse_errors_total", "Input lines that could not be parsed into a request."),
+		late:         r.NewCounter("velocitylimits_late_requests_total", "Requests that arrived behind the watermark."),
+		accepted:     r.NewCounter("velocitylimits_accepted_total", "Requests accepted."),
+		declined:     r.NewCounterVec("velocitylimits_declined_total", "Requests declined, by reason.", "reason"),
+		duplicates:   r.NewCounter("velocitylimits_duplicates_total", "Requests whose ID was seen before."),
+		stageLatency: r.NewHistogramVec("velocitylimits_stage_duration_seconds", "Time a pipeline stage spends on one request.", "stage", latencyBuckets),
+		backlog:      r.NewGaugeFuncVec("velocitylimits_channel_backlog", "Requests queued between pipeline stages.", "channel"),
+		cacheSize:    r.NewGaugeFuncVec("velocitylimits_cache_size", "Entries held by the cache.", "kind"),
+	}
+}
+
+// Registry returns the registry the metrics are written from.
+func (m *Metrics) Registry() *Registry {
+	if m == nil {
+		return nil
+	}
+	return m.registry
+}
+
+// RequestRead counts an input line.
+func (m *Metrics) RequestRead() {
+	if m == nil {
+		return
+	}
+	m.requestsRead.Inc()
+}
+
+// ParseError counts an input line that could not be parsed.
+func (m *Metrics) ParseError() {
+	if m == nil {
+		return
+	}
+	m.parseErrors.Inc()
+}
+
+// Late counts a request behind the watermark.
+func (m *Metrics) Late() {
+	if m == nil {
+		return
+	}
+	m.late.Inc()
+}
+
+// Decision counts a decision by its outcome and reason.
+func (m *Metrics) Decision(decision models.Decision) {
+	if m == nil {
+		return
+	}
+	if decision.Accepted {
+		m.accepted.Inc()
+		return
+	}
+	m.declined.Inc(string(decision.Reason))
+	if decision.Reason == models.ReasonDuplicate {
+		m.duplicates.Inc()
+	}
+}
+
+// ObserveStage records the time the stage took since start.
+func (m *Metrics) ObserveStage(stage string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.stageLatency.Observe(stage, time.Since(start).Seconds())
+}
+
+// WatchBacklog reports the length of the channel from fn when scraped.
+func (m *Metrics) WatchBacklog(channel string, fn func() int) {
+	if m == nil {
+		return
+	}
+	m.backlog.Set(channel, func() float64 { return float64(fn()) })
+}
+
+// WatchCache reports the size of the cache when scraped.
+func (m *Metrics) WatchCache(cache CacheSizer) {
+	if m == nil {
+		return
+	}
+	m.cacheSize.Set("accounts", func() float64 { return float64(cache.AccountCount()) })
+	m.cacheSize.Set("transactions", func() float64 { return float64(cache.TransactionCount()) })
+}
+
+// Serve serves the metrics of registry at path on listener until ctx is done.
+func Serve(ctx context.Context, listener net.Listener, path string, registry *Registry) error {
+	if path == "" {
+		path = "/metrics"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, registry)
+	httpServer := &http.Server{Handler: mux}
+	errC := make(chan error, 1)
+	go func() {
+		logrus.Infof("Serving metrics on %s%s", listener.Addr(), path)
+		errC <- httpServer.Serve(listener)
+	}()
+	select {
+	case err := <-errC:
+		return err
+	case <-ctx.Done():
+	}
+	if err := httpServer.Close(); err != nil {
+		return err
+	}
+	if err := <-errC; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
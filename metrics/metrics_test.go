@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"velocitylimits/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCache struct {
+	accounts, transactions int
+}
+
+func (c fakeCache) AccountCount() int     { return c.accounts }
+func (c fakeCache) TransactionCount() int { return c.transactions }
+
+// scrape serves registry on a free port and returns what a GET of path
+// returns.
+func scrape(t *testing.T, registry *Registry, path string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	errC := make(chan error, 1)
+	go func() { errC <- Serve(ctx, listener, path, registry) }()
+	defer func() {
+		cancel()
+		require.NoError(t, <-errC)
+	}()
+	response, err := http.Get("http://" + listener.Addr().String() + path)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("scrapes the counted statistics", func(t *testing.T) {
+		stats := New()
+		stats.RequestRead()
+		stats.RequestRead()
+		stats.RequestRead()
+		stats.ParseError()
+		stats.Decision(models.NewAcceptedDecision())
+		stats.Decision(models.NewDuplicateDecision())
+		stats.Decision(models.NewLateDecision())
+		stats.ObserveStage(StageAttemptLoad, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+		stats.WatchBacklog("jobs", func() int { return 4 })
+		stats.WatchCache(fakeCache{accounts: 2, transactions: 5})
+
+		body := scrape(t, stats.Registry(), "/metrics")
+		for _, sample := range []string{
+			"velocitylimits_requests_read_total 3\n",
+			"velocitylimits_parse_errors_total 1\n",
+			"velocitylimits_accepted_total 1\n",
+			`velocitylimits_declined_total{reason="duplicate"} 1` + "\n",
+			`velocitylimits_declined_total{reason="late"} 1` + "\n",
+			"velocitylimits_duplicates_total 1\n",
+			`velocitylimits_stage_duration_seconds_count{stage="attempt_load"} 1` + "\n",
+			`velocitylimits_channel_backlog{channel="jobs"} 4` + "\n",
+			`velocitylimits_cache_size{kind="accounts"} 2` + "\n",
+			`velocitylimits_cache_size{kind="transactions"} 5` + "\n",
+		} {
+			assert.Contains(t, body, sample)
+		}
+	})
+	t.Run("serves on the configured path", func(t *testing.T) {
+		stats := New()
+		stats.RequestRead()
+		assert.Contains(t, scrape(t, stats.Registry(), "/stats"), "velocitylimits_requests_read_total 1\n")
+	})
+	t.Run("a nil Metrics counts nothing", func(t *testing.T) {
+		var stats *Metrics
+		assert.NotPanics(t, func() {
+			stats.RequestRead()
+			stats.Decision(models.NewAcceptedDecision())
+			stats.ObserveStage(StageResponder, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+			stats.WatchCache(fakeCache{})
+		})
+	})
+}
@@ -0,0 +1,304 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry holds metrics and writes them in the Prometheus text exposition
+// format. It is safe for concurrent use, as are the metrics it holds.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+// family is a metric with its samples.
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry ...
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+}
+
+// WriteTo writes every metric in the order they were registered.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+	counter := &countingWriter{w: w}
+	writer := bufio.NewWriter(counter)
+	for _, f := range families {
+		f.write(writer)
+	}
+	err := writer.Flush()
+	return counter.n, err
+}
+
+// ServeHTTP serves the metrics for scraping.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	name, help string
+	mu         sync.Mutex
+	value      float64
+}
+
+// NewCounter registers a counter.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	r.register(c)
+	return c
+}
+
+// Inc adds one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value += delta
+}
+
+// Value ...
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	writeSample(w, c.name, "", "", c.Value())
+}
+
+// CounterVec is a counter per value of a label.
+type CounterVec struct {
+	name, help, label string
+	mu                sync.Mutex
+	values            map[string]float64
+}
+
+// NewCounterVec registers a counter partitioned by label.
+func (r *Registry) NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{name: name, help: help, label: label, values: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter of the label value.
+func (c *CounterVec) Inc(value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[value]++
+}
+
+// Value ...
+func (c *CounterVec) Value(value string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[value]
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, value := range sortedKeys(c.values) {
+		writeSample(w, c.name, c.label, value, c.values[value])
+	}
+}
+
+// GaugeFuncVec is a gauge per value of a label, read from a function when
+// scraped.
+type GaugeFuncVec struct {
+	name, help, label string
+	mu                sync.Mutex
+	funcs             map[string]func() float64
+}
+
+// NewGaugeFuncVec registers a gauge partitioned by label.
+func (r *Registry) NewGaugeFuncVec(name, help, label string) *GaugeFuncVec {
+	g := &GaugeFuncVec{name: name, help: help, label: label, funcs: make(map[string]func() float64)}
+	r.register(g)
+	return g
+}
+
+// Set makes fn the gauge of the label value. It must be safe to call from any
+// goroutine.
+func (g *GaugeFuncVec) Set(value string, fn func() float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.funcs[value] = fn
+}
+
+func (g *GaugeFuncVec) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeHeader(w, g.name, g.help, "gauge")
+	for _, value := range sortedKeys(g.funcs) {
+		writeSample(w, g.name, g.label, value, g.funcs[value]())
+	}
+}
+
+// HistogramVec is a histogram per value of a label.
+type HistogramVec struct {
+	name, help, label string
+	buckets           []float64
+	mu                sync.Mutex
+	histograms        map[string]*histogram
+}
+
+type histogram struct {
+	// counts holds the observations per bucket, not cumulated, and the
+	// observations over the last bucket at the end
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec registers a histogram partitioned by label with the given
+// upper bounds, in increasing order.
+func (r *Registry) NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, label: label, buckets: buckets, histograms: make(map[string]*histogram)}
+	r.register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram of the label value.
+func (h *HistogramVec) Observe(value string, observation float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.histograms[value]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets)+1)}
+		h.histograms[value] = hist
+	}
+	hist.counts[sort.SearchFloat64s(h.buckets, observation)]++
+	hist.sum += observation
+	hist.count++
+}
+
+// Count returns the number of observations of the label value.
+func (h *HistogramVec) Count(value string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hist, ok := h.histograms[value]; ok {
+		return hist.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, value := range sortedKeys(h.histograms) {
+		hist := h.histograms[value]
+		labels := labelPair(h.label, value)
+		if labels != "" {
+			labels += ","
+		}
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hist.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", h.name, labels, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", h.name, labels, hist.count)
+		writeSample(w, h.name+"_sum", h.label, value, hist.sum)
+		writeSample(w, h.name+"_count", h.label, value, float64(hist.count))
+	}
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func writeSample(w *bufio.Writer, name, label, value string, sample float64) {
+	if labels := labelPair(label, value); labels != "" {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(sample))
+		return
+	}
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(sample))
+}
+
+func labelPair(label, value string) string {
+	if label == "" {
+		return ""
+	}
+	return fmt.Sprintf(`%s="%s"`, label, strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value))
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]float64:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]func() float64:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*histogram:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countingWriter counts the bytes written through it for WriteTo.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
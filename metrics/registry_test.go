@@ -0,0 +1,381 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"velocitylimits/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Run("writes the text exposition format", func(t *testing.T) {
+		registry := NewRegistry()
+		counter := registry.NewCounter("lines_total", "Lines read.")
+		counterVec := registry.NewCounterVec("declined_total", "Declines by reason.", "reason")
+		gauge := registry.NewGaugeFuncVec("backlog", "Queued jobs.", "channel")
+		histogram := registry.NewHistogramVec("duration_seconds", "Time taken.", "stage", []float64{0.1, 1})
+		counter.Add(3)
+		counterVec.Inc("late")
+		counterVec.Inc("duplicate")
+		counterVec.Inc("duplicate")
+		gauge.Set("jobs", func() float64 { return 7 })
+		histogram.Observe("read", 0.05)
+		histogram.Observe("read", 0.5)
+		histogram.Observe("read", 2)
+
+		var output bytes.Buffer
+		n, err := registry.WriteTo(&output)
+		require.NoError(t, err)
+		assert.Equal(t, int64(output.Len()), n)
+		assert.Equal(t, `# HELP lines_total Lines read.
+# TYPE lines_total counter
+lines_total 3
+# HELP declined_total Declines by reason.
+# TYPE declined_total counter
+declined_total{reason="duplicate"} 2
+declined_total{reason="late"} 1
+# HELP backlog Queued jobs.
+# TYPE backlog gauge
+backlog{channel="jobs"} 7
+# HELP duration_seconds Time taken.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{stage="read",le="0.1"} 1
+duration_seconds_bucket{stage="read",le="1"} 2
+duration_seconds_bucket{stage="read",le="+Inf"} 3
+duration_seconds_sum{stage="read"} 2.55
+duration_seconds_count{stage="read"} 3
+`, output.String())
+	})
+	t.Run("escapes label values and help", func(t *testing.T) {
+		registry := NewRegistry()
+		registry.NewCounterVec("odd_total", "A \\ and\na newline.", "value").Inc("a \"quoted\"\nvalue")
+		var output bytes.Buffer
+		_, err := registry.WriteTo(&output)
+		require.NoError(t, err)
+		assert.Contains(t, output.String(), `# HELP odd_total A \\ and\na newline.`)
+		assert.Contains(t, output.String(), `odd_total{value="a \"quoted\"\nvalue"} 1`)
+	})
+	t.Run("writes what a strict exposition parser accepts", func(t *testing.T) {
+		stats := New()
+		stats.RequestRead()
+		stats.ParseError()
+		stats.Late()
+		stats.Decision(models.NewAcceptedDecision())
+		stats.Decision(models.NewDuplicateDecision())
+		stats.Decision(models.Decision{Reason: "a \\ \"quoted\"\nreason"})
+		// a start long gone falls in the +Inf bucket only
+		stats.ObserveStage(StageResponder, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+		stats.WatchBacklog("jobs", func() int { return 4 })
+		stats.WatchCache(fakeCache{accounts: 2, transactions: 5})
+		stats.Registry().NewCounter("no_help_total", "")
+		stats.Registry().NewGaugeFuncVec("big", "Unbounded values.", "value").Set("inf", func() float64 { return math.Inf(1) })
+
+		var output bytes.Buffer
+		_, err := stats.Registry().WriteTo(&output)
+		require.NoError(t, err)
+		samples, err := parseExposition(output.String())
+		require.NoError(t, err, output.String())
+		values := make(map[string]float64)
+		for _, sample := range samples {
+			values[sample.name+fmt.Sprint(sample.labels)] = sample.value
+		}
+		assert.Equal(t, float64(1), values["velocitylimits_declined_total"+fmt.Sprint(map[string]string{"reason": "a \\ \"quoted\"\nreason"})])
+		assert.Equal(t, float64(1), values["velocitylimits_stage_duration_seconds_bucket"+fmt.Sprint(map[string]string{"stage": "responder", "le": "+Inf"})])
+		assert.Equal(t, float64(0), values["velocitylimits_stage_duration_seconds_bucket"+fmt.Sprint(map[string]string{"stage": "responder", "le": "1"})])
+		assert.True(t, math.IsInf(values["big"+fmt.Sprint(map[string]string{"value": "inf"})], 1))
+	})
+	t.Run("checks against a parser that rejects invalid expositions", func(t *testing.T) {
+		for name, text := range map[string]string{
+			"no trailing newline":         "# TYPE a counter\na 1",
+			"a sample without its TYPE":   "a 1\n",
+			"a split family":              "# TYPE a counter\na 1\n# TYPE b counter\nb 1\n# HELP a A.\n",
+			"a sample of another family":  "# TYPE a counter\nb 1\n",
+			"an unknown type":             "# TYPE a meter\na 1\n",
+			"an unquoted label value":     "# TYPE a counter\na{x=1} 1\n",
+			"an unknown escape":           "# TYPE a counter\na{x=\"\\t\"} 1\n",
+			"a repeated series":           "# TYPE a counter\na 1\na 2\n",
+			"a value that is no number":   "# TYPE a counter\na one\n",
+			"two spaces before a value":   "# TYPE a counter\na  1\n",
+			"buckets out of order":        "# TYPE h histogram\nh_bucket{le=\"1\"} 2\nh_bucket{le=\"+Inf\"} 1\nh_sum 1\nh_count 1\n",
+			"no +Inf bucket":              "# TYPE h histogram\nh_bucket{le=\"1\"} 1\nh_sum 1\nh_count 1\n",
+			"a count off the +Inf bucket": "# TYPE h histogram\nh_bucket{le=\"+Inf\"} 1\nh_sum 1\nh_count 2\n",
+		} {
+			_, err := parseExposition(text)
+			assert.Error(t, err, name)
+		}
+		_, err := parseExposition("# HELP a An \\\\ and\\n.\n# TYPE a counter\na{x=\"\\\"\"} 1 1000\n")
+		assert.NoError(t, err)
+	})
+	t.Run("serves the metrics over HTTP", func(t *testing.T) {
+		registry := NewRegistry()
+		registry.NewCounter("lines_total", "Lines read.").Inc()
+		recorder := httptest.NewRecorder()
+		registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, ContentType, recorder.Header().Get("Content-Type"))
+		assert.Contains(t, recorder.Body.String(), "lines_total 1\n")
+
+		recorder = httptest.NewRecorder()
+		registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+}
+
+// series is a sample parsed from the text exposition format.
+type series struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+var (
+	metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*`)
+	labelName  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*`)
+)
+
+// invalidExposition is the panic parseExposition recovers its error from.
+type invalidExposition struct{ error }
+
+// parseExposition parses text strictly by the Prometheus text exposition
+// format 0.0.4 and returns its samples in order. It rejects what a scraper
+// would: malformed lines, bad escapes, samples before or outside their
+// family's TYPE, families that are split or declared twice, repeated series
+// and histograms whose buckets are not cumulative up to a +Inf bucket equal
+// to their count.
+func parseExposition(text string) (samples []series, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			invalid, ok := recovered.(invalidExposition)
+			if !ok {
+				panic(recovered)
+			}
+			err = invalid.error
+		}
+	}()
+	if !strings.HasSuffix(text, "\n") {
+		return nil, errors.New("the last line does not end in a newline")
+	}
+	types := make(map[string]string)
+	helps := make(map[string]bool)
+	seen := make(map[string]bool)
+	family := ""
+	for i, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		fail := func(format string, args ...interface{}) {
+			panic(invalidExposition{fmt.Errorf("line %d %q: %s", i+1, line, fmt.Sprintf(format, args...))})
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) < 3 || fields[0] != "#" || metricName.FindString(fields[2]) != fields[2] {
+				fail("not a HELP or TYPE line")
+			}
+			name := fields[2]
+			if name != family && (types[name] != "" || helps[name]) {
+				fail("family %s is split", name)
+			}
+			family = name
+			switch fields[1] {
+			case "HELP":
+				if helps[name] {
+					fail("second HELP")
+				}
+				helps[name] = true
+				if len(fields) == 4 {
+					unescape(fail, fields[3], false)
+				}
+			case "TYPE":
+				if types[name] != "" {
+					fail("second TYPE")
+				}
+				if len(fields) != 4 {
+					fail("TYPE without a type")
+				}
+				switch fields[3] {
+				case "counter", "gauge", "histogram", "summary", "untyped":
+				default:
+					fail("unknown type %q", fields[3])
+				}
+				types[name] = fields[3]
+			default:
+				fail("not a HELP or TYPE line")
+			}
+			continue
+		}
+
+		sample := series{name: metricName.FindString(line), labels: make(map[string]string)}
+		if sample.name == "" {
+			fail("no metric name")
+		}
+		rest := line[len(sample.name):]
+		if strings.HasPrefix(rest, "{") {
+			rest = rest[1:]
+			for !strings.HasPrefix(rest, "}") {
+				label := labelName.FindString(rest)
+				if label == "" || !strings.HasPrefix(rest[len(label):], `="`) {
+					fail("malformed label")
+				}
+				if _, ok := sample.labels[label]; ok {
+					fail("label %s repeated", label)
+				}
+				rest = rest[len(label)+2:]
+				end := 0
+				for end < len(rest) && rest[end] != '"' {
+					if rest[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(rest) {
+					fail("unterminated label value")
+				}
+				sample.labels[label] = unescape(fail, rest[:end], true)
+				rest = rest[end+1:]
+				if strings.HasPrefix(rest, ",") {
+					rest = rest[1:]
+				} else if !strings.HasPrefix(rest, "}") {
+					fail("labels not separated by a comma")
+				}
+			}
+			rest = rest[1:]
+		}
+		fields := strings.Split(rest, " ")
+		if fields[0] != "" || len(fields) < 2 || len(fields) > 3 {
+			fail("not a value and optional timestamp after one space")
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil && fields[1] != "+Inf" && fields[1] != "-Inf" && fields[1] != "NaN" {
+			fail("value: %v", err)
+		}
+		sample.value = value
+		if len(fields) == 3 {
+			if _, err := strconv.ParseInt(fields[2], 10, 64); err != nil {
+				fail("timestamp: %v", err)
+			}
+		}
+
+		kind := types[family]
+		if kind == "" {
+			fail("sample before the TYPE of its family")
+		}
+		suffixes := []string{""}
+		if kind == "histogram" {
+			suffixes = []string{"_bucket", "_sum", "_count"}
+		}
+		ofFamily := false
+		for _, suffix := range suffixes {
+			ofFamily = ofFamily || sample.name == family+suffix
+		}
+		if !ofFamily {
+			fail("sample outside of family %s", family)
+		}
+		if _, ok := sample.labels["le"]; ok != (sample.name == family+"_bucket") {
+			fail("le label on a sample that is not a bucket, or a bucket without it")
+		}
+		key := sample.name + fmt.Sprint(sample.labels)
+		if seen[key] {
+			fail("series repeated")
+		}
+		seen[key] = true
+		samples = append(samples, sample)
+	}
+	checkHistograms(types, samples)
+	return samples, nil
+}
+
+// unescape returns s with its escapes replaced. Help text may escape a
+// backslash and a newline, label values a double quote too.
+func unescape(fail func(string, ...interface{}), s string, labelValue bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			if s[i] == '\n' || labelValue && s[i] == '"' {
+				fail("unescaped %q", s[i])
+			}
+			b.WriteByte(s[i])
+			continue
+		}
+		if i++; i == len(s) {
+			fail("trailing backslash")
+		}
+		switch {
+		case s[i] == '\\':
+			b.WriteByte('\\')
+		case s[i] == 'n':
+			b.WriteByte('\n')
+		case s[i] == '"' && labelValue:
+			b.WriteByte('"')
+		default:
+			fail("unknown escape \\%c", s[i])
+		}
+	}
+	return b.String()
+}
+
+// checkHistograms panics unless the buckets of every histogram series go up by
+// le and count, and end in a +Inf bucket equal to its count, beside its sum.
+func checkHistograms(types map[string]string, samples []series) {
+	fail := func(format string, args ...interface{}) {
+		panic(invalidExposition{fmt.Errorf(format, args...)})
+	}
+	type histogram struct {
+		bounds, counts []float64
+		sum, count     bool
+		total          float64
+	}
+	histograms := make(map[string]*histogram)
+	get := func(name string, labels map[string]string) *histogram {
+		rest := make(map[string]string)
+		for label, value := range labels {
+			if label != "le" {
+				rest[label] = value
+			}
+		}
+		key := name + fmt.Sprint(rest)
+		if histograms[key] == nil {
+			histograms[key] = &histogram{}
+		}
+		return histograms[key]
+	}
+	for _, sample := range samples {
+		switch {
+		case strings.HasSuffix(sample.name, "_bucket") && types[strings.TrimSuffix(sample.name, "_bucket")] == "histogram":
+			h := get(strings.TrimSuffix(sample.name, "_bucket"), sample.labels)
+			le := sample.labels["le"]
+			bound, err := strconv.ParseFloat(le, 64)
+			if err != nil {
+				fail("le %q of %s: %v", le, sample.name, err)
+			}
+			if n := len(h.bounds); n > 0 && (bound <= h.bounds[n-1] || sample.value < h.counts[n-1]) {
+				fail("buckets of %s are not in order and cumulative at le %q", sample.name, le)
+			}
+			h.bounds = append(h.bounds, bound)
+			h.counts = append(h.counts, sample.value)
+		case strings.HasSuffix(sample.name, "_sum") && types[strings.TrimSuffix(sample.name, "_sum")] == "histogram":
+			get(strings.TrimSuffix(sample.name, "_sum"), sample.labels).sum = true
+		case strings.HasSuffix(sample.name, "_count") && types[strings.TrimSuffix(sample.name, "_count")] == "histogram":
+			h := get(strings.TrimSuffix(sample.name, "_count"), sample.labels)
+			h.count, h.total = true, sample.value
+		}
+	}
+	for key, h := range histograms {
+		switch {
+		case len(h.bounds) == 0 || !math.IsInf(h.bounds[len(h.bounds)-1], 1):
+			fail("%s does not end in a +Inf bucket", key)
+		case !h.sum || !h.count:
+			fail("%s lacks a sum or a count", key)
+		case h.total != h.counts[len(h.counts)-1]:
+			fail("the +Inf bucket of %s is not its count", key)
+		}
+	}
+}
@@ -1,46 +1,63 @@
 package models
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// ErrLoadNotFound is wrapped by the error Return gives for a load the account
+// should keep but does not.
+var ErrLoadNotFound = errors.New("load not found")
+
 // Account...
 type Account struct {
 	CustomerID  string
-	Balance     float64
+	Balance     Money
 	DailyLimit  *DailyLimit
 	WeeklyLimit *WeeklyLimit
+	// Loads are the accepted loads of the current and previous week, and
+	// those still inside the window of a configured rule.
+	Loads []Load `json:",omitempty"`
 }
 
-// DailyLimit...
+// DailyLimit holds the headroom left for the day in MaxLoadLimit and
+// MaxTransactions, and the configured values in LoadLimit and TransactionLimit.
 type DailyLimit struct {
-	Date            time.Time
-	MaxLoadLimit    float64
-	MaxTransactions int
+	Date             time.Time
+	MaxLoadLimit     Money
+	MaxTransactions  int
+	LoadLimit        Money
+	TransactionLimit int
 }
 
-// WeeklyLimit...
+// WeeklyLimit holds the headroom left for the week in MaxLoadLimit and the
+// configured value in LoadLimit.
 type WeeklyLimit struct {
 	Date         time.Time
-	MaxLoadLimit float64
+	MaxLoadLimit Money
+	LoadLimit    Money
 }
 
-// NewDailyLimit...
-func NewDailyLimit(d time.Time, maxLoadLimit float64, maxTransactions int) *DailyLimit {
+// NewDailyLimit starts the limit on the day d falls in.
+func NewDailyLimit(d time.Time, maxLoadLimit Money, maxTransactions int, boundaries Boundaries) *DailyLimit {
 	return &DailyLimit{
-		Date:            getBeginningOfDay(d),
-		MaxLoadLimit:    maxLoadLimit,
-		MaxTransactions: maxTransactions,
+		Date:             boundaries.StartOfDay(d),
+		MaxLoadLimit:     maxLoadLimit,
+		MaxTransactions:  maxTransactions,
+		LoadLimit:        maxLoadLimit,
+		TransactionLimit: maxTransactions,
 	}
 }
 
-// NewWeeklyLimit...
-func NewWeeklyLimit(d time.Time, maxLoadLimit float64) *WeeklyLimit {
+// NewWeeklyLimit starts the limit on the week d falls in.
+func NewWeeklyLimit(d time.Time, maxLoadLimit Money, boundaries Boundaries) *WeeklyLimit {
 	return &WeeklyLimit{
-		Date:         getBeginningOfWeek(d),
+		Date:         boundaries.StartOfWeek(d),
 		MaxLoadLimit: maxLoadLimit,
+		LoadLimit:    maxLoadLimit,
 	}
 }
 
@@ -51,77 +68,259 @@ func NewAccount(customerID string) *Account {
 	}
 }
 
-// Validate Daily Limit...
-func (dl *DailyLimit) Validate(amount float64) bool {
-	if dl.MaxLoadLimit-amount < 0 {
-		return false
+// Clone returns a deep copy of the account.
+func (a *Account) Clone() *Account {
+	clone := *a
+	if a.DailyLimit != nil {
+		dailyLimit := *a.DailyLimit
+		clone.DailyLimit = &dailyLimit
+	}
+	if a.WeeklyLimit != nil {
+		weeklyLimit := *a.WeeklyLimit
+		clone.WeeklyLimit = &weeklyLimit
+	}
+	if a.Loads != nil {
+		clone.Loads = append([]Load(nil), a.Loads...)
 	}
-	if dl.MaxTransactions-1 < 0 {
-		return false
+	return &clone
+}
+
+// Validate checks amount against the daily_amount and daily_count default
+// rules, with what the day has used of them.
+func (dl *DailyLimit) Validate(amount Money) Decision {
+	used, count := dl.LoadLimit.Sub(dl.MaxLoadLimit), dl.TransactionLimit-dl.MaxTransactions
+	if decision := dailyAmountRule(dl.LoadLimit).decide(used, count, amount); !decision.Accepted {
+		return decision
 	}
-	return true
+	return dailyCountRule(dl.TransactionLimit).decide(used, count, amount)
 }
 
 // Apply DailyLimit
-func (dl *DailyLimit) Apply(amount float64) {
-	dl.MaxLoadLimit -= amount
+func (dl *DailyLimit) Apply(amount Money) {
+	dl.MaxLoadLimit = dl.MaxLoadLimit.Sub(amount)
 	dl.MaxTransactions--
 }
 
-// Validate Weekly limit
-func (wl *WeeklyLimit) Validate(amount float64) bool {
-	if wl.MaxLoadLimit-amount < 0 {
-		return false
+// Restore gives amount, and with count a transaction, back to the day. The
+// headroom never exceeds the configured limits.
+func (dl *DailyLimit) Restore(amount Money, count bool) {
+	if dl.MaxLoadLimit = dl.MaxLoadLimit.Add(amount); dl.LoadLimit.Sub(dl.MaxLoadLimit).IsNegative() {
+		dl.MaxLoadLimit = dl.LoadLimit
+	}
+	if count && dl.MaxTransactions < dl.TransactionLimit {
+		dl.MaxTransactions++
 	}
-	return true
+}
+
+// Validate checks amount against the weekly_amount default rule, with what
+// the week has used of it.
+func (wl *WeeklyLimit) Validate(amount Money) Decision {
+	return weeklyAmountRule(wl.LoadLimit).decide(wl.LoadLimit.Sub(wl.MaxLoadLimit), 0, amount)
 }
 
 // Apply  weekly limit
-func (wl *WeeklyLimit) Apply(amount float64) {
-	wl.MaxLoadLimit -= amount
+func (wl *WeeklyLimit) Apply(amount Money) {
+	wl.MaxLoadLimit = wl.MaxLoadLimit.Sub(amount)
+}
+
+// Restore gives amount back to the week. The headroom never exceeds the
+// configured limit.
+func (wl *WeeklyLimit) Restore(amount Money) {
+	if wl.MaxLoadLimit = wl.MaxLoadLimit.Add(amount); wl.LoadLimit.Sub(wl.MaxLoadLimit).IsNegative() {
+		wl.MaxLoadLimit = wl.LoadLimit
+	}
 }
 
-// ResetLapsedLimits ...
-func (a *Account) ResetLapsedLimits(t time.Time, maxDailyLoadLimit float64, maxTransactions int, maxWeeklyLoadLimit float64) {
-	transactionDay := getBeginningOfDay(t)
+// ResetLapsedLimits starts new daily and weekly limits when t is in a later
+// day or week than the current ones.
+func (a *Account) ResetLapsedLimits(t time.Time, maxDailyLoadLimit Money, maxTransactions int, maxWeeklyLoadLimit Money, boundaries Boundaries) {
+	transactionDay := boundaries.StartOfDay(t)
 	if transactionDay.After(a.DailyLimit.Date) {
 		a.DailyLimit.Date = transactionDay
 		a.DailyLimit.MaxLoadLimit = maxDailyLoadLimit
 		a.DailyLimit.MaxTransactions = maxTransactions
+		a.DailyLimit.LoadLimit = maxDailyLoadLimit
+		a.DailyLimit.TransactionLimit = maxTransactions
 	}
-	transactionWeek := getBeginningOfWeek(t)
+	transactionWeek := boundaries.StartOfWeek(t)
 	if transactionWeek.After(a.WeeklyLimit.Date) {
 		a.WeeklyLimit.Date = transactionWeek
 		a.WeeklyLimit.MaxLoadLimit = maxWeeklyLoadLimit
+		a.WeeklyLimit.LoadLimit = maxWeeklyLoadLimit
 	}
 }
 
-// LoadFunds ...
-func (a *Account) LoadFunds(r *Request) bool {
+// LoadFunds applies the load if the default rules, the daily and weekly
+// limits, and every configured rule allow it, and otherwise returns the first
+// decline. A load from an earlier day than the current one is decided by
+// loadLate.
+func (a *Account) LoadFunds(r *Request, rules []Rule, boundaries Boundaries) Decision {
+	if boundaries.StartOfDay(r.ParsedTime).Before(a.DailyLimit.Date) {
+		return a.loadLate(r, rules, boundaries)
+	}
 	// Validate if daily limits
-	if a.DailyLimit.Validate(r.ParsedAmount) == false {
-		logrus.Debugln("Daily limit reached. request rejected: ", r.ID)
-		return false
+	if decision := a.DailyLimit.Validate(r.ParsedAmount); !decision.Accepted {
+		logrus.Debugln("Daily limit reached. request rejected: ", r.ID, decision.Reason)
+		return decision
 	}
 	// Validate if weekly limits
-	if a.WeeklyLimit.Validate(r.ParsedAmount) == false {
-		logrus.Debugln("Weekly limit reached. request rejected: ", r.ID)
-		return false
+	if decision := a.WeeklyLimit.Validate(r.ParsedAmount); !decision.Accepted {
+		logrus.Debugln("Weekly limit reached. request rejected: ", r.ID, decision.Reason)
+		return decision
+	}
+	// Validate the configured rules against the loads kept for them
+	for _, rule := range rules {
+		if decision := rule.Check(a.Loads, r.ParsedTime, r.ParsedAmount, boundaries); !decision.Accepted {
+			logrus.Debugln("Rule limit reached. request rejected: ", r.ID, decision.Reason)
+			return decision
+		}
 	}
-	a.Balance += r.ParsedAmount
+	a.Balance = a.Balance.Add(r.ParsedAmount)
 	// Update the limits after acting on this transactions
 	a.DailyLimit.Apply(r.ParsedAmount)
 	a.WeeklyLimit.Apply(r.ParsedAmount)
+	a.recordLoad(r.ID, r.ParsedTime, r.ParsedAmount, rules, boundaries)
 	logrus.Debugln("Transaction approved: ", r.ID)
-	return true
+	return NewAcceptedDecision()
+}
+
+// loadLate decides a load from an earlier day than the current one against
+// the day and week it was made in, from the loads kept on the account rather
+// than the current headroom. A load from before the previous week is declined
+// as late, the account no longer knows what was loaded then.
+func (a *Account) loadLate(r *Request, rules []Rule, boundaries Boundaries) Decision {
+	if r.ParsedTime.Before(a.previousWeek(boundaries)) {
+		logrus.Debugln("Load before the previous week. request rejected: ", r.ID)
+		return NewLateDecision()
+	}
+	day := boundaries.StartOfDay(r.ParsedTime)
+	// days are 23 to 25 hours long, so a day and a half later is the next one
+	used, count := a.loadedBetween(day, boundaries.StartOfDay(day.Add(36*time.Hour)))
+	if decision := dailyAmountRule(a.DailyLimit.LoadLimit).decide(used, count, r.ParsedAmount); !decision.Accepted {
+		logrus.Debugln("Daily limit reached. request rejected: ", r.ID, decision.Reason)
+		return decision
+	}
+	if decision := dailyCountRule(a.DailyLimit.TransactionLimit).decide(used, count, r.ParsedAmount); !decision.Accepted {
+		logrus.Debugln("Daily limit reached. request rejected: ", r.ID, decision.Reason)
+		return decision
+	}
+	currentWeek := boundaries.StartOfWeek(r.ParsedTime).Equal(a.WeeklyLimit.Date)
+	decision := a.WeeklyLimit.Validate(r.ParsedAmount)
+	if !currentWeek {
+		used, count = a.loadedBetween(a.previousWeek(boundaries), a.WeeklyLimit.Date)
+		decision = weeklyAmountRule(a.WeeklyLimit.LoadLimit).decide(used, count, r.ParsedAmount)
+	}
+	if !decision.Accepted {
+		logrus.Debugln("Weekly limit reached. request rejected: ", r.ID, decision.Reason)
+		return decision
+	}
+	for _, rule := range rules {
+		if decision := rule.Check(a.Loads, r.ParsedTime, r.ParsedAmount, boundaries); !decision.Accepted {
+			logrus.Debugln("Rule limit reached. request rejected: ", r.ID, decision.Reason)
+			return decision
+		}
+	}
+	a.Balance = a.Balance.Add(r.ParsedAmount)
+	if currentWeek {
+		a.WeeklyLimit.Apply(r.ParsedAmount)
+	}
+	a.recordLoad(r.ID, r.ParsedTime, r.ParsedAmount, rules, boundaries)
+	logrus.Debugln("Late transaction approved: ", r.ID)
+	return NewAcceptedDecision()
+}
+
+// loadedBetween returns the amount and number of the kept loads made from
+// start up to end.
+func (a *Account) loadedBetween(start, end time.Time) (Money, int) {
+	var used Money
+	count := 0
+	for _, load := range a.Loads {
+		if !load.Time.Before(start) && load.Time.Before(end) {
+			used = used.Add(load.Amount)
+			count++
+		}
+	}
+	return used, count
+}
+
+// previousWeek returns the start of the week before the current one.
+func (a *Account) previousWeek(boundaries Boundaries) time.Time {
+	return boundaries.StartOfWeek(a.WeeklyLimit.Date.Add(-time.Nanosecond))
+}
+
+// Withdraw takes amount off the balance if the balance covers it.
+func (a *Account) Withdraw(amount Money) Decision {
+	if a.Balance.Sub(amount).IsNegative() {
+		return NewInsufficientBalanceDecision()
+	}
+	a.Balance = a.Balance.Sub(amount)
+	return NewAcceptedDecision()
+}
+
+// Return takes amount of the load loadID made at loadTime back off the
+// balance, for a reversal or refund. With restoreHeadroom the amount is given back to the
+// daily and weekly limits and the rules the load counted against, if they are
+// still current. With restoreCount the load is also given back to the daily
+// count, and to the count rules once nothing is left of it. A load of the
+// current or previous week that is not kept on the account is an error, and
+// the account is left unchanged.
+func (a *Account) Return(amount Money, loadID string, loadTime time.Time, restoreHeadroom, restoreCount bool, boundaries Boundaries) (Decision, error) {
+	i := 0
+	for i < len(a.Loads) && a.Loads[i].ID != loadID {
+		i++
+	}
+	if i == len(a.Loads) && !loadTime.Before(a.previousWeek(boundaries)) {
+		return Decision{}, fmt.Errorf("%w: %s of customer %s", ErrLoadNotFound, loadID, a.CustomerID)
+	}
+	if a.Balance.Sub(amount).IsNegative() {
+		return NewInsufficientBalanceDecision(), nil
+	}
+	a.Balance = a.Balance.Sub(amount)
+	if !restoreHeadroom {
+		return NewAcceptedDecision(), nil
+	}
+	if a.DailyLimit.Date.Equal(boundaries.StartOfDay(loadTime)) {
+		a.DailyLimit.Restore(amount, restoreCount)
+	}
+	if a.WeeklyLimit.Date.Equal(boundaries.StartOfWeek(loadTime)) {
+		a.WeeklyLimit.Restore(amount)
+	}
+	// loads from before the previous week may have been forgotten
+	if i < len(a.Loads) {
+		if a.Loads[i].Amount = a.Loads[i].Amount.Sub(amount); a.Loads[i].Amount.IsNegative() {
+			a.Loads[i].Amount = 0
+		}
+		if restoreCount && a.Loads[i].Amount == 0 {
+			a.Loads = append(a.Loads[:i], a.Loads[i+1:]...)
+		}
+	}
+	return NewAcceptedDecision(), nil
+}
+
+// recordLoad keeps the load and forgets the loads that are from before the
+// previous week and outside of every rule's window at t.
+func (a *Account) recordLoad(id string, t time.Time, amount Money, rules []Rule, boundaries Boundaries) {
+	horizon := a.previousWeek(boundaries)
+	for _, rule := range rules {
+		if start := rule.WindowStart(t, boundaries); start.Before(horizon) {
+			horizon = start
+		}
+	}
+	loads := a.Loads[:0]
+	for _, load := range a.Loads {
+		if !load.Time.Before(horizon) {
+			loads = append(loads, load)
+		}
+	}
+	a.Loads = append(loads, Load{ID: id, Time: t, Amount: amount})
 }
 
 // getBeginningOfDay
 func getBeginningOfDay(d time.Time) time.Time {
-	return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+	return DefaultBoundaries.StartOfDay(d)
 }
 
 // getBeginningOfWeek
 func getBeginningOfWeek(d time.Time) time.Time {
-	return time.Date(d.Year(), d.Month(), d.Day()+int(time.Monday-d.Weekday()), 0, 0, 0, 0, time.UTC)
+	return DefaultBoundaries.StartOfWeek(d)
 }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -9,6 +10,10 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// testTime stands in for the current time, so the tests do not depend on the
+// wall clock.
+var testTime = time.Date(2000, 1, 5, 10, 30, 0, 0, time.UTC)
+
 func TestNewAccount(t *testing.T) {
 	t.Run("returns expected account", func(t *testing.T) {
 		expectedAccount := &Account{
@@ -21,193 +26,433 @@ func TestNewAccount(t *testing.T) {
 
 func TestNewDailyLimit(t *testing.T) {
 	expectedDailyLimit := &DailyLimit{
-		Date:            getBeginningOfDay(time.Now()),
-		MaxLoadLimit:    0,
+		Date:            getBeginningOfDay(testTime),
+		MaxLoadLimit:    Dollars(0),
 		MaxTransactions: 0,
 	}
-	actualDailyLimit := NewDailyLimit(time.Now(), 0, 0)
+	actualDailyLimit := NewDailyLimit(testTime, Dollars(0), 0, DefaultBoundaries)
 	assert.Equal(t, expectedDailyLimit, actualDailyLimit)
 }
 
 func TestNewWeeklyLimit(t *testing.T) {
 	expectedWeeklyLimit := &WeeklyLimit{
-		Date:         getBeginningOfWeek(time.Now()),
-		MaxLoadLimit: 0,
+		Date:         getBeginningOfWeek(testTime),
+		MaxLoadLimit: Dollars(0),
 	}
-	actualWeeklyLimit := NewWeeklyLimit(time.Now(), 0)
+	actualWeeklyLimit := NewWeeklyLimit(testTime, Dollars(0), DefaultBoundaries)
 	assert.Equal(t, expectedWeeklyLimit, actualWeeklyLimit)
 }
 
 func TestValidateDailyLimit(t *testing.T) {
 	t.Run("returns true when loading below max load limit", func(t *testing.T) {
-		dailyLimit := NewDailyLimit(time.Now(), float64(2000), 3)
-		valid := dailyLimit.Validate(1)
-		assert.True(t, valid)
+		dailyLimit := NewDailyLimit(testTime, Dollars(2000), 3, DefaultBoundaries)
+		valid := dailyLimit.Validate(Dollars(1))
+		assert.True(t, valid.Accepted)
 	})
 	t.Run("returns true when loading exactly max load limit", func(t *testing.T) {
-		dailyLimit := NewDailyLimit(time.Now(), float64(2000), 3)
-		valid := dailyLimit.Validate(2000)
-		assert.True(t, valid)
+		dailyLimit := NewDailyLimit(testTime, Dollars(2000), 3, DefaultBoundaries)
+		valid := dailyLimit.Validate(Dollars(2000))
+		assert.True(t, valid.Accepted)
 	})
 	t.Run("returns false when loading more max load limit", func(t *testing.T) {
-		dailyLimit := NewDailyLimit(time.Now(), float64(2000), 3)
-		valid := dailyLimit.Validate(2001)
-		assert.False(t, valid)
+		dailyLimit := NewDailyLimit(testTime, Dollars(2000), 3, DefaultBoundaries)
+		valid := dailyLimit.Validate(Dollars(2001))
+		assert.False(t, valid.Accepted)
 	})
 	t.Run("returns true when loading below max transactions limit", func(t *testing.T) {
-		dailyLimit := NewDailyLimit(time.Now(), float64(2000), 3)
-		valid := dailyLimit.Validate(1)
-		assert.True(t, valid)
+		dailyLimit := NewDailyLimit(testTime, Dollars(2000), 3, DefaultBoundaries)
+		valid := dailyLimit.Validate(Dollars(1))
+		assert.True(t, valid.Accepted)
 	})
 	t.Run("returns true when loading exactly max transactions limit", func(t *testing.T) {
-		dailyLimit := NewDailyLimit(time.Now(), float64(2000), 1)
-		valid := dailyLimit.Validate(2000)
-		assert.True(t, valid)
+		dailyLimit := NewDailyLimit(testTime, Dollars(2000), 1, DefaultBoundaries)
+		valid := dailyLimit.Validate(Dollars(2000))
+		assert.True(t, valid.Accepted)
 	})
 	t.Run("returns false when loading more max transactions limit", func(t *testing.T) {
-		dailyLimit := NewDailyLimit(time.Now(), float64(2000), 0)
-		valid := dailyLimit.Validate(2001)
-		assert.False(t, valid)
+		dailyLimit := NewDailyLimit(testTime, Dollars(2000), 0, DefaultBoundaries)
+		valid := dailyLimit.Validate(Dollars(2001))
+		assert.False(t, valid.Accepted)
+	})
+	t.Run("returns daily count reason when the amount fits but no loads are left", func(t *testing.T) {
+		dailyLimit := NewDailyLimit(testTime, Dollars(2000), 3, DefaultBoundaries)
+		dailyLimit.Apply(Dollars(100))
+		dailyLimit.Apply(Dollars(100))
+		dailyLimit.Apply(Dollars(100))
+		decision := dailyLimit.Validate(Dollars(100))
+		assert.Equal(t, NewCountDecline(ReasonDailyCountExceeded, LimitDailyCount, 0, 3), decision)
+	})
+	t.Run("returns daily amount reason with remaining headroom", func(t *testing.T) {
+		dailyLimit := NewDailyLimit(testTime, Dollars(2000), 3, DefaultBoundaries)
+		dailyLimit.Apply(Dollars(1500))
+		decision := dailyLimit.Validate(Dollars(501))
+		assert.Equal(t, NewAmountDecline(ReasonDailyAmountExceeded, LimitDailyAmount, Dollars(500), Dollars(2000)), decision)
 	})
 }
 
 func TestValidateWeeklyLimit(t *testing.T) {
 	t.Run("returns ture when loading below max limit", func(t *testing.T) {
-		WeeklyLimit := NewWeeklyLimit(time.Now(), 20000)
-		valid := WeeklyLimit.Validate(200)
-		assert.True(t, valid)
+		WeeklyLimit := NewWeeklyLimit(testTime, Dollars(20000), DefaultBoundaries)
+		valid := WeeklyLimit.Validate(Dollars(200))
+		assert.True(t, valid.Accepted)
 	})
 	t.Run("returns ture when loading equal to  max limit", func(t *testing.T) {
-		WeeklyLimit := NewWeeklyLimit(time.Now(), 20000)
-		valid := WeeklyLimit.Validate(20000)
-		assert.True(t, valid)
+		WeeklyLimit := NewWeeklyLimit(testTime, Dollars(20000), DefaultBoundaries)
+		valid := WeeklyLimit.Validate(Dollars(20000))
+		assert.True(t, valid.Accepted)
 	})
 	t.Run("returns false when loading more than  max limit", func(t *testing.T) {
-		WeeklyLimit := NewWeeklyLimit(time.Now(), 20000)
-		valid := WeeklyLimit.Validate(200000)
-		assert.False(t, valid)
+		WeeklyLimit := NewWeeklyLimit(testTime, Dollars(20000), DefaultBoundaries)
+		valid := WeeklyLimit.Validate(Dollars(200000))
+		assert.False(t, valid.Accepted)
 	})
 }
 
 func TestApplyWeeklyLimit(t *testing.T) {
 	t.Run("reduces weekly max", func(t *testing.T) {
-		weeklyLimit := NewWeeklyLimit(time.Now(), 10)
-		weeklyLimit.Apply(float64(2))
-		assert.Equal(t, float64(8), weeklyLimit.MaxLoadLimit)
+		weeklyLimit := NewWeeklyLimit(testTime, Dollars(10), DefaultBoundaries)
+		weeklyLimit.Apply(Dollars(2))
+		assert.Equal(t, Dollars(8), weeklyLimit.MaxLoadLimit)
 	})
 }
 func TestApplyDailyLimit(t *testing.T) {
 	t.Run("reduces daily max", func(t *testing.T) {
-		dailyLimit := NewDailyLimit(time.Now(), 10, 1)
-		dailyLimit.Apply(float64(2))
-		assert.Equal(t, float64(8), dailyLimit.MaxLoadLimit)
+		dailyLimit := NewDailyLimit(testTime, Dollars(10), 1, DefaultBoundaries)
+		dailyLimit.Apply(Dollars(2))
+		assert.Equal(t, Dollars(8), dailyLimit.MaxLoadLimit)
 	})
 }
 
 func TestGetBeginningOfDay(t *testing.T) {
-	now := time.Now()
+	now := testTime
 	expectedDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
 	actualDay := getBeginningOfDay(now)
 	assert.Equal(t, expectedDay, actualDay)
 }
 
 func TestGetBeginningOfWeek(t *testing.T) {
-	now := time.Now()
-	expectedWeek := time.Date(now.Year(), now.Month(), now.Day()+int(time.Monday-now.Weekday()), 0, 0, 0, 0, time.UTC)
-	actualWeek := getBeginningOfWeek(now)
-	assert.Equal(t, expectedWeek, actualWeek)
+	monday := time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, monday, getBeginningOfWeek(monday))
+	assert.Equal(t, monday, getBeginningOfWeek(time.Date(2000, 1, 5, 12, 0, 0, 0, time.UTC)))
+	// Sunday is the last day of the week
+	assert.Equal(t, monday, getBeginningOfWeek(time.Date(2000, 1, 9, 23, 59, 59, 0, time.UTC)))
+	assert.Equal(t, monday.AddDate(0, 0, 7), getBeginningOfWeek(time.Date(2000, 1, 10, 0, 0, 0, 0, time.UTC)))
 }
 
 func TestRestLapsedLimits(t *testing.T) {
 	t.Run("limits are not reset if they not before the transactions", func(t *testing.T) {
 		account := NewAccount("1")
-		now := time.Now()
-		account.DailyLimit = NewDailyLimit(now, 1, 1)
-		account.WeeklyLimit = NewWeeklyLimit(now, 1)
-		account.ResetLapsedLimits(time.Now(), 2, 2, 2)
+		now := testTime
+		account.DailyLimit = NewDailyLimit(now, Dollars(1), 1, DefaultBoundaries)
+		account.WeeklyLimit = NewWeeklyLimit(now, Dollars(1), DefaultBoundaries)
+		account.ResetLapsedLimits(testTime, Dollars(2), 2, Dollars(2), DefaultBoundaries)
 		assert.Equal(t, getBeginningOfDay(now), account.DailyLimit.Date)
-		assert.Equal(t, float64(1), account.DailyLimit.MaxLoadLimit)
+		assert.Equal(t, Dollars(1), account.DailyLimit.MaxLoadLimit)
 		assert.Equal(t, 1, account.DailyLimit.MaxTransactions)
 		assert.Equal(t, getBeginningOfWeek(now), account.WeeklyLimit.Date)
-		assert.Equal(t, float64(1), account.WeeklyLimit.MaxLoadLimit)
+		assert.Equal(t, Dollars(1), account.WeeklyLimit.MaxLoadLimit)
 
 	})
 	t.Run("limits are  reset if they after the transactions", func(t *testing.T) {
 		account := NewAccount("1")
-		yearAgo := time.Now().AddDate(-1, 0, 0)
-		account.DailyLimit = NewDailyLimit(yearAgo, 1, 1)
-		account.WeeklyLimit = NewWeeklyLimit(yearAgo, 1)
-		now := time.Now()
-		account.ResetLapsedLimits(now, 2, 2, 2)
+		yearAgo := testTime.AddDate(-1, 0, 0)
+		account.DailyLimit = NewDailyLimit(yearAgo, Dollars(1), 1, DefaultBoundaries)
+		account.WeeklyLimit = NewWeeklyLimit(yearAgo, Dollars(1), DefaultBoundaries)
+		now := testTime
+		account.ResetLapsedLimits(now, Dollars(2), 2, Dollars(2), DefaultBoundaries)
 		assert.Equal(t, getBeginningOfDay(now), account.DailyLimit.Date)
-		assert.Equal(t, float64(2), account.DailyLimit.MaxLoadLimit)
+		assert.Equal(t, Dollars(2), account.DailyLimit.MaxLoadLimit)
 		assert.Equal(t, 2, account.DailyLimit.MaxTransactions)
 		assert.Equal(t, getBeginningOfWeek(now), account.WeeklyLimit.Date)
-		assert.Equal(t, float64(2), account.WeeklyLimit.MaxLoadLimit)
+		assert.Equal(t, Dollars(2), account.WeeklyLimit.MaxLoadLimit)
 	})
 }
 
 func TestLoadFunds(t *testing.T) {
 	t.Run("returns true when loading max daily load  or weekly limit is not reached and limits are updated", func(t *testing.T) {
 		account := NewAccount("528")
-		account.DailyLimit = NewDailyLimit(time.Now(), 4000, 2)
-		account.WeeklyLimit = NewWeeklyLimit(time.Now(), 5000)
-		request, err := NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3000\",\"time\":\"2000-01-01T00:00:00Z\"}")
+		account.DailyLimit = NewDailyLimit(testTime, Dollars(4000), 2, DefaultBoundaries)
+		account.WeeklyLimit = NewWeeklyLimit(testTime, Dollars(5000), DefaultBoundaries)
+		request, err := NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3000\",\"time\":\"2000-01-05T10:30:00Z\"}")
 		require.NoError(t, err)
-		success := account.LoadFunds(request)
-		assert.True(t, success)
-		assert.Equal(t, getBeginningOfDay(time.Now()), account.DailyLimit.Date)
-		assert.Equal(t, float64(1000), account.DailyLimit.MaxLoadLimit)
+		success := account.LoadFunds(request, nil, DefaultBoundaries)
+		assert.Equal(t, NewAcceptedDecision(), success)
+		assert.Equal(t, getBeginningOfDay(testTime), account.DailyLimit.Date)
+		assert.Equal(t, Dollars(1000), account.DailyLimit.MaxLoadLimit)
 		assert.Equal(t, 1, account.DailyLimit.MaxTransactions)
-		assert.Equal(t, getBeginningOfWeek(time.Now()), account.WeeklyLimit.Date)
-		assert.Equal(t, float64(2000), account.WeeklyLimit.MaxLoadLimit)
-		assert.Equal(t, float64(3000), account.Balance)
+		assert.Equal(t, getBeginningOfWeek(testTime), account.WeeklyLimit.Date)
+		assert.Equal(t, Dollars(2000), account.WeeklyLimit.MaxLoadLimit)
+		assert.Equal(t, Dollars(3000), account.Balance)
 	})
 	t.Run("returns false when  when loading max daily load is reached and limits are not updated", func(t *testing.T) {
 		account := NewAccount("528")
-		account.DailyLimit = NewDailyLimit(time.Now(), 2000, 2)
-		account.WeeklyLimit = NewWeeklyLimit(time.Now(), 5000)
-		request, err := NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3000\",\"time\":\"2000-01-01T00:00:00Z\"}")
+		account.DailyLimit = NewDailyLimit(testTime, Dollars(2000), 2, DefaultBoundaries)
+		account.WeeklyLimit = NewWeeklyLimit(testTime, Dollars(5000), DefaultBoundaries)
+		request, err := NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3000\",\"time\":\"2000-01-05T10:30:00Z\"}")
 		require.NoError(t, err)
-		success := account.LoadFunds(request)
-		assert.False(t, success)
-		assert.Equal(t, getBeginningOfDay(time.Now()), account.DailyLimit.Date)
-		assert.Equal(t, float64(2000), account.DailyLimit.MaxLoadLimit)
+		success := account.LoadFunds(request, nil, DefaultBoundaries)
+		assert.Equal(t, NewAmountDecline(ReasonDailyAmountExceeded, LimitDailyAmount, Dollars(2000), Dollars(2000)), success)
+		assert.Equal(t, getBeginningOfDay(testTime), account.DailyLimit.Date)
+		assert.Equal(t, Dollars(2000), account.DailyLimit.MaxLoadLimit)
 		assert.Equal(t, 2, account.DailyLimit.MaxTransactions)
-		assert.Equal(t, getBeginningOfWeek(time.Now()), account.WeeklyLimit.Date)
-		assert.Equal(t, float64(5000), account.WeeklyLimit.MaxLoadLimit)
-		assert.Equal(t, float64(0), account.Balance)
+		assert.Equal(t, getBeginningOfWeek(testTime), account.WeeklyLimit.Date)
+		assert.Equal(t, Dollars(5000), account.WeeklyLimit.MaxLoadLimit)
+		assert.Equal(t, Dollars(0), account.Balance)
 
 	})
 	t.Run("returns false when  when loading max daily transactions is reached and limits are not updated", func(t *testing.T) {
 		account := NewAccount("528")
-		account.DailyLimit = NewDailyLimit(time.Now(), 4000, 0)
-		account.WeeklyLimit = NewWeeklyLimit(time.Now(), 5000)
-		request, err := NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3000\",\"time\":\"2000-01-01T00:00:00Z\"}")
+		account.DailyLimit = NewDailyLimit(testTime, Dollars(4000), 0, DefaultBoundaries)
+		account.WeeklyLimit = NewWeeklyLimit(testTime, Dollars(5000), DefaultBoundaries)
+		request, err := NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3000\",\"time\":\"2000-01-05T10:30:00Z\"}")
 		require.NoError(t, err)
-		success := account.LoadFunds(request)
-		assert.False(t, success)
-		assert.Equal(t, getBeginningOfDay(time.Now()), account.DailyLimit.Date)
-		assert.Equal(t, float64(4000), account.DailyLimit.MaxLoadLimit)
+		success := account.LoadFunds(request, nil, DefaultBoundaries)
+		assert.Equal(t, NewCountDecline(ReasonDailyCountExceeded, LimitDailyCount, 0, 0), success)
+		assert.Equal(t, getBeginningOfDay(testTime), account.DailyLimit.Date)
+		assert.Equal(t, Dollars(4000), account.DailyLimit.MaxLoadLimit)
 		assert.Equal(t, 0, account.DailyLimit.MaxTransactions)
-		assert.Equal(t, getBeginningOfWeek(time.Now()), account.WeeklyLimit.Date)
-		assert.Equal(t, float64(5000), account.WeeklyLimit.MaxLoadLimit)
-		assert.Equal(t, float64(0), account.Balance)
+		assert.Equal(t, getBeginningOfWeek(testTime), account.WeeklyLimit.Date)
+		assert.Equal(t, Dollars(5000), account.WeeklyLimit.MaxLoadLimit)
+		assert.Equal(t, Dollars(0), account.Balance)
 
 	})
 	t.Run("returns false when  when loading max weekly load is reached and limits are not updated", func(t *testing.T) {
 		account := NewAccount("528")
-		account.DailyLimit = NewDailyLimit(time.Now(), 4000, 1)
-		account.WeeklyLimit = NewWeeklyLimit(time.Now(), 1000)
-		request, err := NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3000\",\"time\":\"2000-01-01T00:00:00Z\"}")
+		account.DailyLimit = NewDailyLimit(testTime, Dollars(4000), 1, DefaultBoundaries)
+		account.WeeklyLimit = NewWeeklyLimit(testTime, Dollars(1000), DefaultBoundaries)
+		request, err := NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3000\",\"time\":\"2000-01-05T10:30:00Z\"}")
 		require.NoError(t, err)
-		success := account.LoadFunds(request)
-		assert.False(t, success)
-		assert.Equal(t, getBeginningOfDay(time.Now()), account.DailyLimit.Date)
-		assert.Equal(t, float64(4000), account.DailyLimit.MaxLoadLimit)
+		success := account.LoadFunds(request, nil, DefaultBoundaries)
+		assert.Equal(t, NewAmountDecline(ReasonWeeklyAmountExceeded, LimitWeeklyAmount, Dollars(1000), Dollars(1000)), success)
+		assert.Equal(t, getBeginningOfDay(testTime), account.DailyLimit.Date)
+		assert.Equal(t, Dollars(4000), account.DailyLimit.MaxLoadLimit)
 		assert.Equal(t, 1, account.DailyLimit.MaxTransactions)
-		assert.Equal(t, getBeginningOfWeek(time.Now()), account.WeeklyLimit.Date)
-		assert.Equal(t, float64(1000), account.WeeklyLimit.MaxLoadLimit)
-		assert.Equal(t, float64(0), account.Balance)
+		assert.Equal(t, getBeginningOfWeek(testTime), account.WeeklyLimit.Date)
+		assert.Equal(t, Dollars(1000), account.WeeklyLimit.MaxLoadLimit)
+		assert.Equal(t, Dollars(0), account.Balance)
+	})
+}
+
+func TestLoadFundsWithRules(t *testing.T) {
+	rules := []Rule{
+		{Name: "rolling_day_amount", Window: WindowSliding, Duration: 24 * time.Hour, Metric: MetricSum, MaxAmount: Dollars(5000)},
+		{Name: "monthly_count", Window: WindowCalendar, Period: PeriodMonth, Metric: MetricCount, MaxCount: 3},
+	}
+	load := func(account *Account, id, amount, at string) Decision {
+		request, err := ParseRequest(id, "528", amount, at)
+		require.NoError(t, err)
+		account.ResetLapsedLimits(request.ParsedTime, Dollars(5000), 3, Dollars(20000), DefaultBoundaries)
+		return account.LoadFunds(request, rules, DefaultBoundaries)
+	}
+	t.Run("applies the load only when every rule passes", func(t *testing.T) {
+		account := NewAccount("528")
+		account.DailyLimit = NewDailyLimit(time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC), Dollars(5000), 3, DefaultBoundaries)
+		account.WeeklyLimit = NewWeeklyLimit(time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC), Dollars(20000), DefaultBoundaries)
+		assert.True(t, load(account, "1", "$4000", "2000-01-03T20:00:00Z").Accepted)
+		// a new calendar day, but still within the rolling 24 hours
+		decision := load(account, "2", "$2000", "2000-01-04T08:00:00Z")
+		assert.Equal(t, NewAmountDecline("rolling_day_amount_exceeded", "rolling_day_amount", Dollars(1000), Dollars(5000)), decision)
+		assert.Equal(t, Dollars(5000), account.DailyLimit.MaxLoadLimit)
+		assert.Equal(t, Dollars(4000), account.Balance)
+		assert.Len(t, account.Loads, 1)
+
+		assert.True(t, load(account, "3", "$2000", "2000-01-04T20:00:00Z").Accepted)
+		assert.True(t, load(account, "4", "$1", "2000-01-05T20:00:00Z").Accepted)
+		decision = load(account, "5", "$1", "2000-01-06T20:00:00Z")
+		assert.Equal(t, NewCountDecline("monthly_count_exceeded", "monthly_count", 0, 3), decision)
+		assert.True(t, load(account, "6", "$1", "2000-02-01T00:00:00Z").Accepted)
+	})
+	t.Run("forgets loads from before the previous week outside of every window", func(t *testing.T) {
+		account := NewAccount("528")
+		account.DailyLimit = NewDailyLimit(time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC), Dollars(5000), 3, DefaultBoundaries)
+		account.WeeklyLimit = NewWeeklyLimit(time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC), Dollars(20000), DefaultBoundaries)
+		assert.True(t, load(account, "1", "$1", "2000-01-21T23:00:00Z").Accepted)
+		assert.True(t, load(account, "2", "$1", "2000-02-01T10:00:00Z").Accepted)
+		assert.True(t, load(account, "3", "$1", "2000-02-02T10:00:00Z").Accepted)
+		assert.Equal(t, []Load{
+			{ID: "2", Time: time.Date(2000, 2, 1, 10, 0, 0, 0, time.UTC), Amount: Dollars(1)},
+			{ID: "3", Time: time.Date(2000, 2, 2, 10, 0, 0, 0, time.UTC), Amount: Dollars(1)},
+		}, account.Loads)
+	})
+}
+
+func TestLoadFundsLate(t *testing.T) {
+	// Wednesday 2000-01-12, a week after the loads of 2000-01-05
+	now := time.Date(2000, 1, 12, 10, 0, 0, 0, time.UTC)
+	newLoadedAccount := func(t *testing.T) *Account {
+		account := NewAccount("528")
+		account.DailyLimit = NewDailyLimit(testTime, Dollars(5000), 3, DefaultBoundaries)
+		account.WeeklyLimit = NewWeeklyLimit(testTime, Dollars(20000), DefaultBoundaries)
+		for i, at := range []time.Time{testTime, testTime.Add(time.Hour), now} {
+			request, err := ParseRequest(string(rune('1'+i)), "528", "$2000", at.Format(time.RFC3339))
+			require.NoError(t, err)
+			account.ResetLapsedLimits(at, Dollars(5000), 3, Dollars(20000), DefaultBoundaries)
+			require.True(t, account.LoadFunds(request, nil, DefaultBoundaries).Accepted)
+		}
+		return account
+	}
+	load := func(account *Account, amount string, at time.Time) Decision {
+		request, err := ParseRequest("late", "528", amount, at.Format(time.RFC3339))
+		require.NoError(t, err)
+		return account.LoadFunds(request, nil, DefaultBoundaries)
+	}
+
+	t.Run("decides a load of an earlier week against its own day", func(t *testing.T) {
+		account := newLoadedAccount(t)
+		decision := load(account, "$1001", testTime.Add(2*time.Hour))
+		assert.Equal(t, NewAmountDecline(ReasonDailyAmountExceeded, LimitDailyAmount, Dollars(1000), Dollars(5000)), decision)
+		assert.True(t, load(account, "$1000", testTime.Add(2*time.Hour)).Accepted)
+		assert.Equal(t, Dollars(7000), account.Balance)
+		assert.Equal(t, Dollars(3000), account.DailyLimit.MaxLoadLimit)
+		assert.Equal(t, Dollars(18000), account.WeeklyLimit.MaxLoadLimit)
+		assert.Len(t, account.Loads, 4)
+	})
+	t.Run("decides a load of an earlier week against its own week", func(t *testing.T) {
+		account := newLoadedAccount(t)
+		// the other weekdays of the week of testTime, a Wednesday
+		for _, days := range []int{-2, -1, 1, 2} {
+			require.True(t, load(account, "$4000", testTime.AddDate(0, 0, days)).Accepted, "%d days", days)
+		}
+		decision := load(account, "$1", testTime.AddDate(0, 0, 3))
+		assert.Equal(t, NewAmountDecline(ReasonWeeklyAmountExceeded, LimitWeeklyAmount, Money(0), Dollars(20000)), decision)
+		assert.Equal(t, Dollars(18000), account.WeeklyLimit.MaxLoadLimit)
+	})
+	t.Run("decides a load of an earlier day of the current week against the current week", func(t *testing.T) {
+		account := newLoadedAccount(t)
+		account.WeeklyLimit.Apply(Dollars(17000))
+		decision := load(account, "$2000", now.AddDate(0, 0, -1))
+		assert.Equal(t, NewAmountDecline(ReasonWeeklyAmountExceeded, LimitWeeklyAmount, Dollars(1000), Dollars(20000)), decision)
+		assert.True(t, load(account, "$1000", now.AddDate(0, 0, -1)).Accepted)
+		assert.Equal(t, Dollars(0), account.WeeklyLimit.MaxLoadLimit)
+		assert.Equal(t, Dollars(3000), account.DailyLimit.MaxLoadLimit)
+	})
+	t.Run("declines a load from before the previous week as late", func(t *testing.T) {
+		account := newLoadedAccount(t)
+		assert.Equal(t, NewLateDecision(), load(account, "$1", testTime.AddDate(0, 0, -7)))
+		assert.Equal(t, Dollars(6000), account.Balance)
+	})
+}
+
+func TestWithdraw(t *testing.T) {
+	t.Run("takes the amount off the balance", func(t *testing.T) {
+		account := NewAccount("528")
+		account.Balance = Dollars(10)
+		assert.Equal(t, NewAcceptedDecision(), account.Withdraw(Dollars(10)))
+		assert.Equal(t, Money(0), account.Balance)
+	})
+	t.Run("never takes the balance below zero", func(t *testing.T) {
+		account := NewAccount("528")
+		account.Balance = Dollars(10)
+		assert.Equal(t, NewInsufficientBalanceDecision(), account.Withdraw(Cents(1001)))
+		assert.Equal(t, Dollars(10), account.Balance)
+	})
+}
+
+func TestReturn(t *testing.T) {
+	loadTime := time.Date(2000, 1, 5, 10, 0, 0, 0, time.UTC)
+	newLoadedAccount := func() *Account {
+		account := NewAccount("528")
+		account.DailyLimit = NewDailyLimit(loadTime, Dollars(5000), 3, DefaultBoundaries)
+		account.WeeklyLimit = NewWeeklyLimit(loadTime, Dollars(20000), DefaultBoundaries)
+		rules := []Rule{{Name: "hourly_count", Window: WindowSliding, Duration: time.Hour, Metric: MetricCount, MaxCount: 1}}
+		request, err := ParseRequest("1", "528", "$3000", loadTime.Format(time.RFC3339))
+		require.NoError(t, err)
+		require.True(t, account.LoadFunds(request, rules, DefaultBoundaries).Accepted)
+		return account
+	}
+
+	t.Run("restores the headroom and count of a reversed load", func(t *testing.T) {
+		account := newLoadedAccount()
+		decision, err := account.Return(Dollars(3000), "1", loadTime, true, true, DefaultBoundaries)
+		require.NoError(t, err)
+		assert.Equal(t, NewAcceptedDecision(), decision)
+		assert.Equal(t, Money(0), account.Balance)
+		assert.Equal(t, Dollars(5000), account.DailyLimit.MaxLoadLimit)
+		assert.Equal(t, 3, account.DailyLimit.MaxTransactions)
+		assert.Equal(t, Dollars(20000), account.WeeklyLimit.MaxLoadLimit)
+		assert.Empty(t, account.Loads)
+	})
+	t.Run("restores only the amount of a refund", func(t *testing.T) {
+		account := newLoadedAccount()
+		decision, err := account.Return(Dollars(1000), "1", loadTime, true, false, DefaultBoundaries)
+		require.NoError(t, err)
+		assert.True(t, decision.Accepted)
+		assert.Equal(t, Dollars(2000), account.Balance)
+		assert.Equal(t, Dollars(3000), account.DailyLimit.MaxLoadLimit)
+		assert.Equal(t, 2, account.DailyLimit.MaxTransactions)
+		assert.Equal(t, Dollars(18000), account.WeeklyLimit.MaxLoadLimit)
+		assert.Equal(t, []Load{{ID: "1", Time: loadTime, Amount: Dollars(2000)}}, account.Loads)
+	})
+	t.Run("returns the load by its ID among loads made at the same time", func(t *testing.T) {
+		account := newLoadedAccount()
+		request, err := ParseRequest("2", "528", "$500", loadTime.Format(time.RFC3339))
+		require.NoError(t, err)
+		require.True(t, account.LoadFunds(request, []Rule{{Name: "daily", Window: WindowCalendar, Period: PeriodDay, Metric: MetricSum, MaxAmount: Dollars(5000)}}, DefaultBoundaries).Accepted)
+		decision, err := account.Return(Dollars(500), "2", loadTime, true, true, DefaultBoundaries)
+		require.NoError(t, err)
+		assert.True(t, decision.Accepted)
+		assert.Equal(t, []Load{{ID: "1", Time: loadTime, Amount: Dollars(3000)}}, account.Loads)
+	})
+	t.Run("returns error for a load that is not kept", func(t *testing.T) {
+		account := newLoadedAccount()
+		account.Loads[0].ID = ""
+		_, err := account.Return(Dollars(1000), "1", loadTime, true, false, DefaultBoundaries)
+		assert.True(t, errors.Is(err, ErrLoadNotFound))
+		assert.Equal(t, Dollars(3000), account.Balance)
+		assert.Equal(t, Dollars(2000), account.DailyLimit.MaxLoadLimit)
+	})
+	t.Run("returns a load from before the previous week without it", func(t *testing.T) {
+		account := newLoadedAccount()
+		decision, err := account.Return(Dollars(1000), "0", loadTime.AddDate(0, 0, -14), true, false, DefaultBoundaries)
+		require.NoError(t, err)
+		assert.True(t, decision.Accepted)
+		assert.Equal(t, Dollars(2000), account.Balance)
+		assert.Equal(t, Dollars(2000), account.DailyLimit.MaxLoadLimit)
+	})
+	t.Run("does not restore limits that have lapsed", func(t *testing.T) {
+		account := newLoadedAccount()
+		account.ResetLapsedLimits(loadTime.AddDate(0, 0, 1), Dollars(5000), 3, Dollars(20000), DefaultBoundaries)
+		account.DailyLimit.Apply(Dollars(100))
+		decision, err := account.Return(Dollars(3000), "1", loadTime, true, true, DefaultBoundaries)
+		require.NoError(t, err)
+		assert.True(t, decision.Accepted)
+		assert.Equal(t, Dollars(4900), account.DailyLimit.MaxLoadLimit)
+		assert.Equal(t, 2, account.DailyLimit.MaxTransactions)
+		assert.Equal(t, Dollars(20000), account.WeeklyLimit.MaxLoadLimit)
+	})
+	t.Run("keeps the limits without restoring headroom", func(t *testing.T) {
+		account := newLoadedAccount()
+		decision, err := account.Return(Dollars(3000), "1", loadTime, false, true, DefaultBoundaries)
+		require.NoError(t, err)
+		assert.True(t, decision.Accepted)
+		assert.Equal(t, Dollars(2000), account.DailyLimit.MaxLoadLimit)
+		assert.Equal(t, 2, account.DailyLimit.MaxTransactions)
+		assert.Len(t, account.Loads, 1)
+	})
+	t.Run("never takes the balance below zero", func(t *testing.T) {
+		account := newLoadedAccount()
+		require.True(t, account.Withdraw(Dollars(2500)).Accepted)
+		decision, err := account.Return(Dollars(3000), "1", loadTime, true, true, DefaultBoundaries)
+		require.NoError(t, err)
+		assert.Equal(t, NewInsufficientBalanceDecision(), decision)
+		assert.Equal(t, Dollars(500), account.Balance)
+		assert.Equal(t, Dollars(2000), account.DailyLimit.MaxLoadLimit)
+	})
+}
+
+func TestAccountClone(t *testing.T) {
+	t.Run("returns an independent copy", func(t *testing.T) {
+		account := NewAccount("1")
+		account.DailyLimit = NewDailyLimit(testTime, Dollars(10), 1, DefaultBoundaries)
+		account.WeeklyLimit = NewWeeklyLimit(testTime, Dollars(10), DefaultBoundaries)
+		account.Loads = []Load{{Time: testTime, Amount: Dollars(1)}}
+		clone := account.Clone()
+		assert.Equal(t, account, clone)
+		clone.Loads[0].Amount = Dollars(2)
+		assert.Equal(t, Dollars(1), account.Loads[0].Amount)
+		clone.DailyLimit.Apply(Dollars(1))
+		clone.WeeklyLimit.Apply(Dollars(1))
+		assert.Equal(t, Dollars(10), account.DailyLimit.MaxLoadLimit)
+		assert.Equal(t, Dollars(10), account.WeeklyLimit.MaxLoadLimit)
 	})
 }
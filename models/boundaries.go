@@ -0,0 +1,100 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidBoundaries is wrapped by the errors LoadBoundaries returns.
+var ErrInvalidBoundaries = errors.New("invalid boundaries")
+
+// Boundaries decide where a customer's days and weeks start: at midnight in
+// Location, with weeks starting on FirstWeekday. Days around daylight saving
+// changes are 23 or 25 hours long.
+type Boundaries struct {
+	Location     *time.Location
+	FirstWeekday time.Weekday
+}
+
+// DefaultBoundaries start days at midnight UTC and weeks on Monday.
+var DefaultBoundaries = Boundaries{Location: time.UTC, FirstWeekday: time.Monday}
+
+// locations caches the loaded time zones, loading one reads the zoneinfo
+// database.
+var locations sync.Map
+
+// LoadBoundaries returns the boundaries for an IANA time zone such as
+// "America/New_York" and a week start such as "sunday". Empty values keep
+// those of DefaultBoundaries.
+func LoadBoundaries(timeZone, weekStart string) (Boundaries, error) {
+	boundaries := DefaultBoundaries
+	if timeZone != "" {
+		location, ok := locations.Load(timeZone)
+		if !ok {
+			loaded, err := time.LoadLocation(timeZone)
+			if err != nil {
+				return boundaries, fmt.Errorf("%w: time zone %q: %v", ErrInvalidBoundaries, timeZone, err)
+			}
+			location, _ = locations.LoadOrStore(timeZone, loaded)
+		}
+		boundaries.Location = location.(*time.Location)
+	}
+	if weekStart != "" {
+		weekday, ok := parseWeekday(weekStart)
+		if !ok {
+			return boundaries, fmt.Errorf("%w: week start %q is not a day of the week", ErrInvalidBoundaries, weekStart)
+		}
+		boundaries.FirstWeekday = weekday
+	}
+	return boundaries, nil
+}
+
+func parseWeekday(s string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(s, day.String()) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// StartOfDay returns the start of the local day t falls in, in UTC.
+func (b Boundaries) StartOfDay(t time.Time) time.Time {
+	local := t.In(b.location())
+	return b.midnight(local.Year(), local.Month(), local.Day())
+}
+
+// StartOfWeek returns the start of the local week t falls in, in UTC.
+func (b Boundaries) StartOfWeek(t time.Time) time.Time {
+	local := t.In(b.location())
+	offset := (int(local.Weekday()) - int(b.FirstWeekday) + 7) % 7
+	return b.midnight(local.Year(), local.Month(), local.Day()-offset)
+}
+
+// StartOfMonth returns the start of the local month t falls in, in UTC.
+func (b Boundaries) StartOfMonth(t time.Time) time.Time {
+	local := t.In(b.location())
+	return b.midnight(local.Year(), local.Month(), 1)
+}
+
+// midnight returns the first instant of the local date. Where a daylight
+// saving change skips midnight the day starts when the clocks resume.
+func (b Boundaries) midnight(year int, month time.Month, day int) time.Time {
+	location := b.location()
+	start := time.Date(year, month, day, 0, 0, 0, 0, location)
+	date := time.Date(year, month, day, 12, 0, 0, 0, location)
+	for start.In(location).Day() != date.Day() {
+		start = start.Add(time.Hour)
+	}
+	return start.UTC()
+}
+
+func (b Boundaries) location() *time.Location {
+	if b.Location == nil {
+		return time.UTC
+	}
+	return b.Location
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadTestBoundaries(t *testing.T, timeZone, weekStart string) Boundaries {
+	if _, err := time.LoadLocation(timeZone); err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	boundaries, err := LoadBoundaries(timeZone, weekStart)
+	require.NoError(t, err)
+	return boundaries
+}
+
+func TestLoadBoundaries(t *testing.T) {
+	t.Run("defaults to UTC and Monday", func(t *testing.T) {
+		boundaries, err := LoadBoundaries("", "")
+		require.NoError(t, err)
+		assert.Equal(t, DefaultBoundaries, boundaries)
+	})
+	t.Run("loads a time zone and week start", func(t *testing.T) {
+		boundaries := loadTestBoundaries(t, "America/New_York", "Sunday")
+		assert.Equal(t, "America/New_York", boundaries.Location.String())
+		assert.Equal(t, time.Sunday, boundaries.FirstWeekday)
+	})
+	t.Run("returns error for an unknown time zone or weekday", func(t *testing.T) {
+		_, err := LoadBoundaries("Mars/Olympus_Mons", "")
+		assert.True(t, errors.Is(err, ErrInvalidBoundaries))
+		_, err = LoadBoundaries("", "someday")
+		assert.True(t, errors.Is(err, ErrInvalidBoundaries))
+	})
+}
+
+func TestBoundariesStartOfDay(t *testing.T) {
+	newYork := loadTestBoundaries(t, "America/New_York", "")
+	t.Run("starts days at local midnight", func(t *testing.T) {
+		// 02:00 UTC is still the previous evening in New York
+		assert.Equal(t, time.Date(2000, 1, 4, 5, 0, 0, 0, time.UTC), newYork.StartOfDay(time.Date(2000, 1, 5, 2, 0, 0, 0, time.UTC)))
+		assert.Equal(t, time.Date(2000, 1, 5, 5, 0, 0, 0, time.UTC), newYork.StartOfDay(time.Date(2000, 1, 5, 5, 0, 0, 0, time.UTC)))
+	})
+	t.Run("the day clocks spring forward is 23 hours long", func(t *testing.T) {
+		start := newYork.StartOfDay(time.Date(2021, 3, 14, 12, 0, 0, 0, time.UTC))
+		next := newYork.StartOfDay(time.Date(2021, 3, 15, 12, 0, 0, 0, time.UTC))
+		assert.Equal(t, time.Date(2021, 3, 14, 5, 0, 0, 0, time.UTC), start)
+		assert.Equal(t, 23*time.Hour, next.Sub(start))
+		// the last second of the short day still belongs to it
+		assert.Equal(t, start, newYork.StartOfDay(next.Add(-time.Second)))
+	})
+	t.Run("the day clocks fall back is 25 hours long", func(t *testing.T) {
+		start := newYork.StartOfDay(time.Date(2021, 11, 7, 12, 0, 0, 0, time.UTC))
+		next := newYork.StartOfDay(time.Date(2021, 11, 8, 12, 0, 0, 0, time.UTC))
+		assert.Equal(t, time.Date(2021, 11, 7, 4, 0, 0, 0, time.UTC), start)
+		assert.Equal(t, 25*time.Hour, next.Sub(start))
+		assert.Equal(t, start, newYork.StartOfDay(next.Add(-time.Second)))
+	})
+	t.Run("starts the day when clocks skip midnight", func(t *testing.T) {
+		// Santiago moved from 00:00 -04 straight to 01:00 -03 on 2022-09-11
+		santiago := loadTestBoundaries(t, "America/Santiago", "")
+		start := santiago.StartOfDay(time.Date(2022, 9, 11, 12, 0, 0, 0, time.UTC))
+		assert.Equal(t, time.Date(2022, 9, 11, 4, 0, 0, 0, time.UTC), start)
+		assert.Equal(t, 11, start.In(santiago.Location).Day())
+		assert.Equal(t, 23*time.Hour, santiago.StartOfDay(time.Date(2022, 9, 12, 12, 0, 0, 0, time.UTC)).Sub(start))
+	})
+}
+
+func TestBoundariesStartOfWeek(t *testing.T) {
+	t.Run("starts weeks on the configured day", func(t *testing.T) {
+		sunday := loadTestBoundaries(t, "", "sunday")
+		// Saturday 2000-01-08
+		assert.Equal(t, time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC), sunday.StartOfWeek(time.Date(2000, 1, 8, 23, 0, 0, 0, time.UTC)))
+		assert.Equal(t, time.Date(2000, 1, 9, 0, 0, 0, 0, time.UTC), sunday.StartOfWeek(time.Date(2000, 1, 9, 0, 0, 0, 0, time.UTC)))
+	})
+	t.Run("a week across a daylight saving change is 167 hours long", func(t *testing.T) {
+		newYork := loadTestBoundaries(t, "America/New_York", "sunday")
+		start := newYork.StartOfWeek(time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC))
+		assert.Equal(t, time.Date(2021, 3, 14, 5, 0, 0, 0, time.UTC), start)
+		next := newYork.StartOfWeek(time.Date(2021, 3, 21, 12, 0, 0, 0, time.UTC))
+		assert.Equal(t, 7*24*time.Hour-time.Hour, next.Sub(start))
+	})
+}
+
+func TestBoundariesStartOfMonth(t *testing.T) {
+	t.Run("starts months on the local first", func(t *testing.T) {
+		newYork := loadTestBoundaries(t, "America/New_York", "")
+		assert.Equal(t, time.Date(2000, 1, 1, 5, 0, 0, 0, time.UTC), newYork.StartOfMonth(time.Date(2000, 2, 1, 4, 0, 0, 0, time.UTC)))
+	})
+}
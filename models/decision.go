@@ -0,0 +1,128 @@
+package models
+
+import "strconv"
+
+// Reason explains why a load was accepted or declined.
+type Reason string
+
+const (
+	ReasonAccepted             Reason = "accepted"
+	ReasonDuplicate            Reason = "duplicate"
+	ReasonDailyAmountExceeded  Reason = "daily_amount_exceeded"
+	ReasonDailyCountExceeded   Reason = "daily_count_exceeded"
+	ReasonWeeklyAmountExceeded Reason = "weekly_amount_exceeded"
+	ReasonLate                 Reason = "late"
+	ReasonConflict             Reason = "conflict"
+	ReasonInsufficientBalance  Reason = "insufficient_balance"
+	ReasonUnknownLoad          Reason = "unknown_load"
+	ReasonExceedsLoad          Reason = "exceeds_load"
+	ReasonInvalid              Reason = "invalid"
+)
+
+// Limit names the velocity limit a decision was made against.
+type Limit string
+
+const (
+	LimitDailyAmount  Limit = "daily_amount"
+	LimitDailyCount   Limit = "daily_count"
+	LimitWeeklyAmount Limit = "weekly_amount"
+)
+
+// Decision is the outcome of checking a load against the velocity limits.
+// For declines it records which limit was hit, the headroom that was left
+// and the configured value of that limit. Amount limits use Remaining and
+// LimitValue, count limits use RemainingCount and LimitCount.
+type Decision struct {
+	Accepted       bool
+	Reason         Reason
+	Limit          Limit
+	Remaining      Money
+	LimitValue     Money
+	RemainingCount int
+	LimitCount     int
+	// CountLimit is set when Limit counts loads rather than amounts.
+	CountLimit bool
+}
+
+// NewAcceptedDecision ...
+func NewAcceptedDecision() Decision {
+	return Decision{Accepted: true, Reason: ReasonAccepted}
+}
+
+// NewDuplicateDecision ...
+func NewDuplicateDecision() Decision {
+	return Decision{Reason: ReasonDuplicate}
+}
+
+// NewConflictDecision declines a load reusing the ID of an earlier load with
+// a different amount or time.
+func NewConflictDecision() Decision {
+	return Decision{Reason: ReasonConflict}
+}
+
+// NewInsufficientBalanceDecision declines taking more off the balance than
+// it holds.
+func NewInsufficientBalanceDecision() Decision {
+	return Decision{Reason: ReasonInsufficientBalance}
+}
+
+// NewUnknownLoadDecision declines a reversal or refund of a load the customer
+// did not make or that was not accepted.
+func NewUnknownLoadDecision() Decision {
+	return Decision{Reason: ReasonUnknownLoad}
+}
+
+// NewExceedsLoadDecision declines giving back more than is left of a load.
+func NewExceedsLoadDecision() Decision {
+	return Decision{Reason: ReasonExceedsLoad}
+}
+
+// NewLateDecision declines a load that arrived too late to be put in time
+// order.
+func NewLateDecision() Decision {
+	return Decision{Reason: ReasonLate}
+}
+
+// NewInvalidDecision declines a request that breaks a validation rule.
+func NewInvalidDecision() Decision {
+	return Decision{Reason: ReasonInvalid}
+}
+
+// NewAmountDecline declines a load that would exceed an amount limit.
+func NewAmountDecline(reason Reason, limit Limit, remaining, limitValue Money) Decision {
+	return Decision{Reason: reason, Limit: limit, Remaining: remaining, LimitValue: limitValue}
+}
+
+// NewCountDecline declines a load that would exceed a count limit.
+func NewCountDecline(reason Reason, limit Limit, remaining, limitValue int) Decision {
+	return Decision{Reason: reason, Limit: limit, RemainingCount: remaining, LimitCount: limitValue, CountLimit: true}
+}
+
+// FormatRemaining returns the remaining headroom as "$1,234.56" for amount
+// limits, a number of loads for count limits and "" if no limit was hit.
+func (d Decision) FormatRemaining() string {
+	switch {
+	case d.Limit == "":
+		return ""
+	case d.IsCountLimit():
+		return strconv.Itoa(d.RemainingCount)
+	}
+	return d.Remaining.String()
+}
+
+// FormatLimitValue returns the value of the limit hit, formatted like
+// FormatRemaining.
+func (d Decision) FormatLimitValue() string {
+	switch {
+	case d.Limit == "":
+		return ""
+	case d.IsCountLimit():
+		return strconv.Itoa(d.LimitCount)
+	}
+	return d.LimitValue.String()
+}
+
+// IsCountLimit reports whether the decision was made against a count limit.
+func (d Decision) IsCountLimit() bool {
+	return d.CountLimit
+}
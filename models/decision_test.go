@@ -0,0 +1,25 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecisionFormat(t *testing.T) {
+	t.Run("formats amount limits as money", func(t *testing.T) {
+		decision := NewAmountDecline(ReasonDailyAmountExceeded, LimitDailyAmount, Cents(150), Dollars(5000))
+		assert.Equal(t, "$1.50", decision.FormatRemaining())
+		assert.Equal(t, "$5,000.00", decision.FormatLimitValue())
+	})
+	t.Run("formats count limits as a number of loads", func(t *testing.T) {
+		decision := NewCountDecline(ReasonDailyCountExceeded, LimitDailyCount, 0, 3)
+		assert.Equal(t, "0", decision.FormatRemaining())
+		assert.Equal(t, "3", decision.FormatLimitValue())
+	})
+	t.Run("formats nothing when no limit was hit", func(t *testing.T) {
+		decision := NewAcceptedDecision()
+		assert.Equal(t, "", decision.FormatRemaining())
+		assert.Equal(t, "", decision.FormatLimitValue())
+	})
+}
@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Headroom is what a customer can still load before hitting their limits.
+type Headroom struct {
+	CustomerID string         `json:"customer_id"`
+	Daily      DailyHeadroom  `json:"daily"`
+	Weekly     WeeklyHeadroom `json:"weekly"`
+}
+
+// DailyHeadroom ...
+type DailyHeadroom struct {
+	Start           time.Time `json:"start"`
+	RemainingAmount Money     `json:"remaining_amount"`
+	LimitAmount     Money     `json:"limit_amount"`
+	RemainingLoads  int       `json:"remaining_loads"`
+	LimitLoads      int       `json:"limit_loads"`
+}
+
+// WeeklyHeadroom ...
+type WeeklyHeadroom struct {
+	Start           time.Time `json:"start"`
+	RemainingAmount Money     `json:"remaining_amount"`
+	LimitAmount     Money     `json:"limit_amount"`
+}
+
+// NewHeadroom reads the headroom off the account's current limits.
+func NewHeadroom(a *Account) *Headroom {
+	return &Headroom{
+		CustomerID: a.CustomerID,
+		Daily: DailyHeadroom{
+			Start:           a.DailyLimit.Date,
+			RemainingAmount: a.DailyLimit.MaxLoadLimit,
+			LimitAmount:     a.DailyLimit.LoadLimit,
+			RemainingLoads:  a.DailyLimit.MaxTransactions,
+			LimitLoads:      a.DailyLimit.TransactionLimit,
+		},
+		Weekly: WeeklyHeadroom{
+			Start:           a.WeeklyLimit.Date,
+			RemainingAmount: a.WeeklyLimit.MaxLoadLimit,
+			LimitAmount:     a.WeeklyLimit.LoadLimit,
+		},
+	}
+}
+
+// WhatIf is what a hypothetical load would get: its decision, and what the
+// customer could load at its time before and after it.
+type WhatIf struct {
+	CustomerID string    `json:"customer_id"`
+	Amount     Money     `json:"load_amount"`
+	Time       time.Time `json:"time"`
+	Accepted   bool      `json:"accepted"`
+	Reason     Reason    `json:"reason"`
+	Limit      Limit     `json:"limit,omitempty"`
+	Headroom   *Headroom `json:"headroom"`
+	// After is the same as Headroom for a declined load.
+	After *Headroom `json:"headroom_after"`
+}
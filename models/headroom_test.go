@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHeadroom(t *testing.T) {
+	t.Run("returns the remaining and configured limits", func(t *testing.T) {
+		now := time.Date(2000, 1, 5, 10, 0, 0, 0, time.UTC)
+		account := NewAccount("1")
+		account.DailyLimit = NewDailyLimit(now, Dollars(5000), 3, DefaultBoundaries)
+		account.WeeklyLimit = NewWeeklyLimit(now, Dollars(20000), DefaultBoundaries)
+		account.DailyLimit.Apply(Dollars(100))
+		account.WeeklyLimit.Apply(Dollars(100))
+		expected := &Headroom{
+			CustomerID: "1",
+			Daily: DailyHeadroom{
+				Start:           time.Date(2000, 1, 5, 0, 0, 0, 0, time.UTC),
+				RemainingAmount: Dollars(4900),
+				LimitAmount:     Dollars(5000),
+				RemainingLoads:  2,
+				LimitLoads:      3,
+			},
+			Weekly: WeeklyHeadroom{
+				Start:           time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC),
+				RemainingAmount: Dollars(19900),
+				LimitAmount:     Dollars(20000),
+			},
+		}
+		assert.Equal(t, expected, NewHeadroom(account))
+	})
+}
@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+// Limits are the daily and weekly limits that apply to a customer, and the
+// time zone and week start their days and weeks follow.
+type Limits struct {
+	MaxDailyLoadLimit    Money
+	MaxDailyTransactions int
+	MaxWeeklyLoadLimit   Money
+	TimeZone             string
+	WeekStart            string
+}
+
+// Boundaries loads the boundaries of TimeZone and WeekStart.
+func (l Limits) Boundaries() (Boundaries, error) {
+	return LoadBoundaries(l.TimeZone, l.WeekStart)
+}
+
+// Override replaces some of a customer's limits, e.g. for a VIP exception.
+// Unset fields keep the value of the customer's tier.
+type Override struct {
+	MaxDailyLoadLimit    *Money `json:"max_daily_load_limit,omitempty"`
+	MaxDailyTransactions *int   `json:"max_daily_transactions,omitempty"`
+	MaxWeeklyLoadLimit   *Money `json:"max_weekly_load_limit,omitempty"`
+	// Expires is when the override stops applying. Nil never expires.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// CustomerLimits assigns a customer to a tier and holds the overrides of its
+// limits. TimeZone and WeekStart, when set, replace those of the tier.
+type CustomerLimits struct {
+	CustomerID string     `json:"customer_id"`
+	Tier       string     `json:"tier,omitempty"`
+	TimeZone   string     `json:"time_zone,omitempty"`
+	WeekStart  string     `json:"week_start,omitempty"`
+	Overrides  []Override `json:"overrides,omitempty"`
+}
+
+// Active reports whether the override applies at t.
+func (o Override) Active(t time.Time) bool {
+	return o.Expires == nil || t.Before(*o.Expires)
+}
+
+// Apply returns the tier limits with the customer's boundaries and the
+// overrides active at t applied. Later overrides win over earlier ones.
+func (c *CustomerLimits) Apply(limits Limits, t time.Time) Limits {
+	if c.TimeZone != "" {
+		limits.TimeZone = c.TimeZone
+	}
+	if c.WeekStart != "" {
+		limits.WeekStart = c.WeekStart
+	}
+	for _, override := range c.Overrides {
+		if !override.Active(t) {
+			continue
+		}
+		if override.MaxDailyLoadLimit != nil {
+			limits.MaxDailyLoadLimit = *override.MaxDailyLoadLimit
+		}
+		if override.MaxDailyTransactions != nil {
+			limits.MaxDailyTransactions = *override.MaxDailyTransactions
+		}
+		if override.MaxWeeklyLoadLimit != nil {
+			limits.MaxWeeklyLoadLimit = *override.MaxWeeklyLoadLimit
+		}
+	}
+	return limits
+}
+
+// Clone returns a deep copy of the customer limits.
+func (c *CustomerLimits) Clone() *CustomerLimits {
+	clone := *c
+	if c.Overrides != nil {
+		clone.Overrides = append([]Override(nil), c.Overrides...)
+	}
+	return &clone
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCustomerLimitsApply(t *testing.T) {
+	tier := Limits{MaxDailyLoadLimit: Dollars(5000), MaxDailyTransactions: 3, MaxWeeklyLoadLimit: Dollars(20000)}
+	at := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	money := func(m Money) *Money { return &m }
+	count := func(n int) *int { return &n }
+	t.Run("returns the tier limits without overrides", func(t *testing.T) {
+		customer := &CustomerLimits{CustomerID: "1", Tier: "basic"}
+		assert.Equal(t, tier, customer.Apply(tier, at))
+	})
+	t.Run("overrides only the fields that are set", func(t *testing.T) {
+		customer := &CustomerLimits{CustomerID: "1", Overrides: []Override{{MaxDailyTransactions: count(10)}}}
+		expected := tier
+		expected.MaxDailyTransactions = 10
+		assert.Equal(t, expected, customer.Apply(tier, at))
+	})
+	t.Run("later overrides win", func(t *testing.T) {
+		customer := &CustomerLimits{CustomerID: "1", Overrides: []Override{
+			{MaxDailyLoadLimit: money(Dollars(6000))},
+			{MaxDailyLoadLimit: money(Dollars(7000))},
+		}}
+		assert.Equal(t, Dollars(7000), customer.Apply(tier, at).MaxDailyLoadLimit)
+	})
+	t.Run("ignores expired overrides", func(t *testing.T) {
+		expires := at.Add(time.Hour)
+		customer := &CustomerLimits{CustomerID: "1", Overrides: []Override{{MaxWeeklyLoadLimit: money(Dollars(50000)), Expires: &expires}}}
+		assert.Equal(t, Dollars(50000), customer.Apply(tier, at).MaxWeeklyLoadLimit)
+		assert.Equal(t, Dollars(20000), customer.Apply(tier, expires).MaxWeeklyLoadLimit)
+	})
+}
+
+func TestCustomerLimitsClone(t *testing.T) {
+	t.Run("returns an independent copy", func(t *testing.T) {
+		customer := &CustomerLimits{CustomerID: "1", Tier: "vip", Overrides: []Override{{}}}
+		clone := customer.Clone()
+		assert.Equal(t, customer, clone)
+		clone.Overrides[0].Expires = &time.Time{}
+		assert.Nil(t, customer.Overrides[0].Expires)
+	})
+}
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of currency held as a whole number of cents, so adding
+// and subtracting amounts never drifts the way float64 arithmetic does.
+type Money int64
+
+// ErrInvalidMoney is returned when an amount string cannot be parsed exactly.
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// Dollars returns the Money value of a whole number of dollars.
+func Dollars(d int64) Money {
+	return Money(d * 100)
+}
+
+// Cents returns the Money value of a number of cents.
+func Cents(c int64) Money {
+	return Money(c)
+}
+
+// ParseMoney parses amounts such as "$1,234.56", "3318.47" or "-$5".
+// At most two decimal places are accepted so the result is always exact, and
+// commas only between groups of three digits of the whole dollars.
+func ParseMoney(s string) (Money, error) {
+	str := strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(str, "-") {
+		negative = true
+		str = str[1:]
+	}
+	str = strings.TrimPrefix(str, "$")
+	if strings.HasPrefix(str, "-") && !negative {
+		negative = true
+		str = str[1:]
+	}
+
+	whole, fraction := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		whole, fraction = str[:i], str[i+1:]
+	}
+	if !isGrouped(whole) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	whole = strings.Replace(whole, ",", "", -1)
+	if whole == "" && fraction == "" || len(fraction) > 2 || !isDigits(whole) || !isDigits(fraction) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	cents, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	if negative {
+		cents = -cents
+	}
+	return Money(cents), nil
+}
+
+// isGrouped reports whether the commas of whole, if any, separate its digits
+// into thousands: a first group of one to three digits and then groups of
+// three.
+func isGrouped(whole string) bool {
+	groups := strings.Split(whole, ",")
+	if len(groups) == 1 {
+		return true
+	}
+	if len(groups[0]) == 0 || len(groups[0]) > 3 {
+		return false
+	}
+	for _, group := range groups[1:] {
+		if len(group) != 3 {
+			return false
+		}
+	}
+	return true
+}
+
+// MustParseMoney is like ParseMoney but panics if the amount is invalid.
+func MustParseMoney(s string) Money {
+	m, err := ParseMoney(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Cents returns the amount as a number of cents.
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+// Add returns m + o.
+func (m Money) Add(o Money) Money {
+	return m + o
+}
+
+// Sub returns m - o.
+func (m Money) Sub(o Money) Money {
+	return m - o
+}
+
+// Cmp returns -1, 0 or +1 depending on whether m is less than, equal to or
+// greater than o.
+func (m Money) Cmp(o Money) int {
+	switch {
+	case m < o:
+		return -1
+	case m > o:
+		return 1
+	}
+	return 0
+}
+
+// IsNegative reports whether m is below zero.
+func (m Money) IsNegative() bool {
+	return m < 0
+}
+
+// String formats the amount as "$1,234.56".
+func (m Money) String() string {
+	cents := int64(m)
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	whole := strconv.FormatInt(cents/100, 10)
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteByte(',')
+		}
+		grouped.WriteRune(digit)
+	}
+	return fmt.Sprintf("%s$%s.%02d", sign, grouped.String(), cents%100)
+}
+
+// MarshalJSON writes the amount as a formatted string.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON reads an amount written by MarshalJSON.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// isDigits reports whether s only contains ASCII digits.
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	t.Run("parses valid amounts", func(t *testing.T) {
+		cases := map[string]Money{
+			"$3318.47":   Cents(331847),
+			"3318.47":    Cents(331847),
+			"$1,234.56":  Cents(123456),
+			"1,234,567":  Dollars(1234567),
+			"$12,345":    Dollars(12345),
+			"$123,456.7": Cents(12345670),
+			"$100":       Dollars(100),
+			"$0.1":       Cents(10),
+			"$.05":       Cents(5),
+			"-$5":        Dollars(-5),
+			"$-5.50":     Cents(-550),
+		}
+		for input, expected := range cases {
+			actual, err := ParseMoney(input)
+			require.NoError(t, err, input)
+			assert.Equal(t, expected, actual, input)
+		}
+	})
+	t.Run("returns error for invalid amounts", func(t *testing.T) {
+		for _, input := range []string{"", "$", "@100", "$1.234", "$1.2.3", "$abc", "--5", "$99999999999999999999",
+			"1,2,3", "$1,23", "$1234,567", ",123", "$1,", "$1,,234", "$1,234.5,6", "$0.1,2"} {
+			_, err := ParseMoney(input)
+			assert.True(t, errors.Is(err, ErrInvalidMoney), input)
+		}
+	})
+}
+
+func TestMoneyString(t *testing.T) {
+	cases := map[Money]string{
+		Cents(0):          "$0.00",
+		Cents(5):          "$0.05",
+		Cents(123456):     "$1,234.56",
+		Dollars(1000000):  "$1,000,000.00",
+		Dollars(100):      "$100.00",
+		Cents(-123456):    "-$1,234.56",
+		Cents(99999999):   "$999,999.99",
+		Dollars(12345678): "$12,345,678.00",
+	}
+	for input, expected := range cases {
+		assert.Equal(t, expected, input.String())
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	assert.Equal(t, Cents(150), Dollars(1).Add(Cents(50)))
+	assert.Equal(t, Cents(50), Dollars(1).Sub(Cents(50)))
+	assert.Equal(t, -1, Dollars(1).Cmp(Dollars(2)))
+	assert.Equal(t, 0, Dollars(2).Cmp(Cents(200)))
+	assert.Equal(t, 1, Dollars(3).Cmp(Dollars(2)))
+	assert.True(t, Cents(-1).IsNegative())
+	assert.False(t, Cents(0).IsNegative())
+}
+
+func TestMoneyJSON(t *testing.T) {
+	bytes, err := json.Marshal(Cents(123456))
+	require.NoError(t, err)
+	assert.Equal(t, `"$1,234.56"`, string(bytes))
+
+	var m Money
+	require.NoError(t, json.Unmarshal(bytes, &m))
+	assert.Equal(t, Cents(123456), m)
+}
+
+func TestMoneyHasNoRoundingDrift(t *testing.T) {
+	t.Run("adding and subtracting a cent millions of times is exact", func(t *testing.T) {
+		const operations = 10000000
+		cent := MustParseMoney("$0.01")
+		total := Cents(0)
+		for i := 0; i < operations; i++ {
+			total = total.Add(cent)
+		}
+		assert.Equal(t, Dollars(operations/100), total)
+		for i := 0; i < operations; i++ {
+			total = total.Sub(cent)
+		}
+		assert.Equal(t, Cents(0), total)
+	})
+	t.Run("loading exactly the remaining headroom after many applies is accepted", func(t *testing.T) {
+		dailyLimit := NewDailyLimit(testTime, Dollars(5000), 1, DefaultBoundaries)
+		tenCents := MustParseMoney("$0.10")
+		for i := 0; i < 49999; i++ {
+			dailyLimit.Apply(tenCents)
+			dailyLimit.MaxTransactions++
+		}
+		assert.Equal(t, tenCents, dailyLimit.MaxLoadLimit)
+		assert.True(t, dailyLimit.Validate(tenCents).Accepted)
+		assert.False(t, dailyLimit.Validate(MustParseMoney("$0.11")).Accepted)
+	})
+}
@@ -2,42 +2,136 @@ package models
 
 import (
 	"encoding/json"
-	"strconv"
-	"strings"
+	"errors"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// ErrMissingField is wrapped in the FieldError of a required field that was
+// empty or absent.
+var ErrMissingField = errors.New("missing")
+
+// ErrUnknownType is wrapped in the FieldError of a request type that is not
+// one of the RequestType constants.
+var ErrUnknownType = errors.New("unknown request type")
+
+// ErrNotPositive is wrapped in the FieldError of the amount of a load or
+// withdrawal that is zero or negative.
+var ErrNotPositive = errors.New("must be positive")
+
+// RequestType is what a request asks for. Requests without a type are loads.
+type RequestType string
+
+const (
+	RequestLoad RequestType = "load"
+	// RequestReversal cancels what is left of an accepted load.
+	RequestReversal RequestType = "reversal"
+	// RequestRefund returns part of an accepted load.
+	RequestRefund RequestType = "refund"
+	// RequestWithdrawal takes funds off the balance.
+	RequestWithdrawal RequestType = "withdrawal"
+)
+
+// IsLoad reports whether the type is a load.
+func (t RequestType) IsLoad() bool {
+	return t == "" || t == RequestLoad
+}
+
+// Returns reports whether the type gives back funds of an earlier load.
+func (t RequestType) Returns() bool {
+	return t == RequestReversal || t == RequestRefund
+}
+
+// FieldError reports a request field that could not be parsed.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
 // Request ...
 type Request struct {
-	ID           string    `json:"id"`
-	CustomerID   string    `json:"customer_id"`
+	ID         string      `json:"id"`
+	CustomerID string      `json:"customer_id"`
+	Type       RequestType `json:"type,omitempty"`
+	// LoadID is the load a reversal or refund gives funds back of.
+	LoadID string `json:"load_id,omitempty"`
+	// Amount is ignored by reversals, they give back what is left of the
+	// load.
 	Amount       string    `json:"load_amount"`
 	Time         string    `json:"time"`
-	ParsedAmount float64   `json:"-"`
+	ParsedAmount Money     `json:"-"`
 	ParsedTime   time.Time `json:"-"`
 }
 
 // NewRequest ...
 func NewRequest(reqStr string) (*Request, error) {
 	var r Request
-	var err error
 
-	if err = json.Unmarshal([]byte(reqStr), &r); err != nil {
+	if err := json.Unmarshal([]byte(reqStr), &r); err != nil {
 		logrus.Errorln("Error parsing line: ", err)
 		return nil, err
 	}
 
-	if r.ParsedAmount, err = strconv.ParseFloat(strings.Trim(r.Amount, "$"), 64); err != nil {
-		logrus.Errorln("Error parsing amount: ", err)
+	if err := r.parse(); err != nil {
 		return nil, err
 	}
+	return &r, nil
+}
+
+// ParseRequest builds a request from its raw field values.
+func ParseRequest(id, customerID, amount, t string) (*Request, error) {
+	r := Request{ID: id, CustomerID: customerID, Amount: amount, Time: t}
+	if err := r.parse(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// parse checks the required fields and fills in ParsedAmount and ParsedTime.
+// The amount of a load or withdrawal must be positive.
+func (r *Request) parse() error {
+	var err error
+
+	if r.ID == "" {
+		return &FieldError{Field: "id", Err: ErrMissingField}
+	}
+	if r.CustomerID == "" {
+		return &FieldError{Field: "customer_id", Err: ErrMissingField}
+	}
+	switch r.Type {
+	case "", RequestLoad, RequestWithdrawal:
+	case RequestReversal, RequestRefund:
+		if r.LoadID == "" {
+			return &FieldError{Field: "load_id", Err: ErrMissingField}
+		}
+	default:
+		return &FieldError{Field: "type", Err: ErrUnknownType}
+	}
+
+	if r.Type == RequestReversal && r.Amount == "" {
+		r.ParsedAmount = 0
+	} else if r.ParsedAmount, err = ParseMoney(r.Amount); err != nil {
+		logrus.Errorln("Error parsing amount: ", err)
+		return &FieldError{Field: "load_amount", Err: err}
+	}
 
 	if r.ParsedTime, err = time.Parse(time.RFC3339, r.Time); err != nil {
 		logrus.Errorln("Error parsing time: ", err)
-		return nil, err
+		return &FieldError{Field: "time", Err: err}
 	}
 
-	return &r, nil
+	// a negative load would give headroom back, a negative withdrawal funds
+	if (r.Type.IsLoad() || r.Type == RequestWithdrawal) && r.ParsedAmount <= 0 {
+		return &FieldError{Field: "load_amount", Err: ErrNotPositive}
+	}
+	return nil
 }
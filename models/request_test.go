@@ -1,8 +1,7 @@
 package models
 
 import (
-	"strconv"
-	"strings"
+	"errors"
 	"testing"
 	"time"
 
@@ -12,7 +11,7 @@ import (
 
 func TestNewRequest(t *testing.T) {
 	t.Run("returns expected response", func(t *testing.T) {
-		parsedAmount, _ := strconv.ParseFloat(strings.Trim("$100", "$"), 64)
+		parsedAmount := Dollars(100)
 		parsedTime, _ := time.Parse(time.RFC3339, "2000-01-01T06:08:12Z")
 		expectedRequest := &Request{
 			ID:           "1",
@@ -33,9 +32,87 @@ func TestNewRequest(t *testing.T) {
 	t.Run("returns error when parsing invalid amount string", func(t *testing.T) {
 		_, err := NewRequest("{\"id\":\"1\",\"customer_id\":\"1\",\"load_amount\":\"@100\",\"time\":\"2000-01-01T06:08:12Z\"}")
 		require.Error(t, err)
+		var fieldErr *FieldError
+		require.True(t, errors.As(err, &fieldErr))
+		assert.Equal(t, "load_amount", fieldErr.Field)
+		assert.True(t, errors.Is(err, ErrInvalidMoney))
+	})
+	t.Run("returns error for a load or withdrawal that is not positive", func(t *testing.T) {
+		for _, line := range []string{
+			`{"id":"1","customer_id":"1","load_amount":"-$5","time":"2000-01-01T06:08:12Z"}`,
+			`{"id":"1","customer_id":"1","load_amount":"$-5.50","time":"2000-01-01T06:08:12Z"}`,
+			`{"id":"1","customer_id":"1","load_amount":"$0.00","time":"2000-01-01T06:08:12Z"}`,
+			`{"id":"1","customer_id":"1","type":"load","load_amount":"-1","time":"2000-01-01T06:08:12Z"}`,
+			`{"id":"1","customer_id":"1","type":"withdrawal","load_amount":"-$5","time":"2000-01-01T06:08:12Z"}`,
+		} {
+			_, err := NewRequest(line)
+			var fieldErr *FieldError
+			require.True(t, errors.As(err, &fieldErr), line)
+			assert.Equal(t, "load_amount", fieldErr.Field, line)
+			assert.True(t, errors.Is(err, ErrNotPositive), line)
+		}
 	})
 	t.Run("returns error when parsing invalid time string", func(t *testing.T) {
 		_, err := NewRequest("{\"id\":\"1\",\"customer_id\":\"1\",\"load_amount\":\"$100\",\"time\":\"2000-0101T06:08:12Z\"}")
 		require.Error(t, err)
+		var fieldErr *FieldError
+		require.True(t, errors.As(err, &fieldErr))
+		assert.Equal(t, "time", fieldErr.Field)
+	})
+	t.Run("returns error when a required field is missing", func(t *testing.T) {
+		_, err := NewRequest("{\"id\":\"1\",\"load_amount\":\"$100\",\"time\":\"2000-01-01T06:08:12Z\"}")
+		var fieldErr *FieldError
+		require.True(t, errors.As(err, &fieldErr))
+		assert.Equal(t, "customer_id", fieldErr.Field)
+		assert.True(t, errors.Is(err, ErrMissingField))
+	})
+	t.Run("parses reversals, refunds and withdrawals", func(t *testing.T) {
+		request, err := NewRequest(`{"id":"2","customer_id":"1","type":"reversal","load_id":"1","time":"2000-01-01T06:08:12Z"}`)
+		require.NoError(t, err)
+		assert.Equal(t, RequestReversal, request.Type)
+		assert.Equal(t, "1", request.LoadID)
+		assert.Equal(t, Money(0), request.ParsedAmount)
+		request, err = NewRequest(`{"id":"3","customer_id":"1","type":"refund","load_id":"1","load_amount":"$5","time":"2000-01-01T06:08:12Z"}`)
+		require.NoError(t, err)
+		assert.True(t, request.Type.Returns())
+		assert.Equal(t, Dollars(5), request.ParsedAmount)
+		request, err = NewRequest(`{"id":"4","customer_id":"1","type":"withdrawal","load_amount":"$5","time":"2000-01-01T06:08:12Z"}`)
+		require.NoError(t, err)
+		assert.False(t, request.Type.IsLoad())
+		assert.False(t, request.Type.Returns())
+	})
+	t.Run("returns error for an unknown type or a missing load", func(t *testing.T) {
+		_, err := NewRequest(`{"id":"2","customer_id":"1","type":"chargeback","load_amount":"$5","time":"2000-01-01T06:08:12Z"}`)
+		var fieldErr *FieldError
+		require.True(t, errors.As(err, &fieldErr))
+		assert.Equal(t, "type", fieldErr.Field)
+		assert.True(t, errors.Is(err, ErrUnknownType))
+		_, err = NewRequest(`{"id":"2","customer_id":"1","type":"refund","load_amount":"$5","time":"2000-01-01T06:08:12Z"}`)
+		require.True(t, errors.As(err, &fieldErr))
+		assert.Equal(t, "load_id", fieldErr.Field)
+		_, err = NewRequest(`{"id":"2","customer_id":"1","type":"refund","load_id":"1","time":"2000-01-01T06:08:12Z"}`)
+		require.True(t, errors.As(err, &fieldErr))
+		assert.Equal(t, "load_amount", fieldErr.Field)
+	})
+}
+
+func TestParseRequest(t *testing.T) {
+	t.Run("returns expected request", func(t *testing.T) {
+		actualRequest, err := ParseRequest("1", "2", "$1,000.50", "2000-01-01T06:08:12Z")
+		require.NoError(t, err)
+		assert.Equal(t, Cents(100050), actualRequest.ParsedAmount)
+		assert.Equal(t, time.Date(2000, 1, 1, 6, 8, 12, 0, time.UTC), actualRequest.ParsedTime)
+	})
+	t.Run("returns field error for a negative or zero amount", func(t *testing.T) {
+		for _, amount := range []string{"-$5", "$-5.50", "0"} {
+			_, err := ParseRequest("1", "2", amount, "2000-01-01T06:08:12Z")
+			assert.True(t, errors.Is(err, ErrNotPositive), amount)
+		}
+	})
+	t.Run("returns field error for invalid amount", func(t *testing.T) {
+		_, err := ParseRequest("1", "2", "lots", "2000-01-01T06:08:12Z")
+		var fieldErr *FieldError
+		require.True(t, errors.As(err, &fieldErr))
+		assert.Equal(t, "load_amount", fieldErr.Field)
 	})
 }
@@ -1,10 +1,24 @@
 package models
 
+import "encoding/json"
+
 // Response ...
 type Response struct {
+	ID         string    `json:"id"`
+	CustomerID string    `json:"customer_id"`
+	Accepted   bool      `json:"accepted"`
+	Decision   *Decision `json:"-"`
+}
+
+// detailedResponse is the response line written when reasons are requested.
+type detailedResponse struct {
 	ID         string `json:"id"`
 	CustomerID string `json:"customer_id"`
 	Accepted   bool   `json:"accepted"`
+	Reason     Reason `json:"reason,omitempty"`
+	Limit      Limit  `json:"limit,omitempty"`
+	Remaining  string `json:"remaining,omitempty"`
+	LimitValue string `json:"limit_value,omitempty"`
 }
 
 // NewResponse ...
@@ -15,3 +29,37 @@ func NewResponse(id string, custID string, accepted bool) *Response {
 		Accepted:   accepted,
 	}
 }
+
+// NewDecisionResponse builds the response for a decision made on a request.
+func NewDecisionResponse(id string, custID string, decision Decision) *Response {
+	return &Response{
+		ID:         id,
+		CustomerID: custID,
+		Accepted:   decision.Accepted,
+		Decision:   &decision,
+	}
+}
+
+// Conflicting reports whether the load was declined for reusing the ID of a
+// different load.
+func (r *Response) Conflicting() bool {
+	return r.Decision != nil && r.Decision.Reason == ReasonConflict
+}
+
+// MarshalLine returns the JSON line written for the response. By default it
+// is the three field {"id","customer_id","accepted"} object; withReason adds
+// the decision's reason, the limit hit and its remaining headroom.
+func (r *Response) MarshalLine(withReason bool) ([]byte, error) {
+	if !withReason || r.Decision == nil {
+		return json.Marshal(r)
+	}
+	return json.Marshal(detailedResponse{
+		ID:         r.ID,
+		CustomerID: r.CustomerID,
+		Accepted:   r.Accepted,
+		Reason:     r.Decision.Reason,
+		Limit:      r.Decision.Limit,
+		Remaining:  r.Decision.FormatRemaining(),
+		LimitValue: r.Decision.FormatLimitValue(),
+	})
+}
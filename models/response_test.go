@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewResponse(t *testing.T) {
@@ -18,3 +19,38 @@ func TestNewResponse(t *testing.T) {
 
 	})
 }
+
+func TestNewDecisionResponse(t *testing.T) {
+	t.Run("copies the decision outcome", func(t *testing.T) {
+		decision := NewDuplicateDecision()
+		actualResponse := NewDecisionResponse("1", "2", decision)
+		assert.Equal(t, &Response{ID: "1", CustomerID: "2", Accepted: false, Decision: &decision}, actualResponse)
+	})
+}
+
+func TestResponseMarshalLine(t *testing.T) {
+	t.Run("writes the three field format by default", func(t *testing.T) {
+		response := NewDecisionResponse("1", "2", NewAmountDecline(ReasonWeeklyAmountExceeded, LimitWeeklyAmount, Dollars(10), Dollars(20000)))
+		line, err := response.MarshalLine(false)
+		require.NoError(t, err)
+		assert.Equal(t, `{"id":"1","customer_id":"2","accepted":false}`, string(line))
+	})
+	t.Run("writes the reason and amount headroom when requested", func(t *testing.T) {
+		response := NewDecisionResponse("1", "2", NewAmountDecline(ReasonWeeklyAmountExceeded, LimitWeeklyAmount, Dollars(10), Dollars(20000)))
+		line, err := response.MarshalLine(true)
+		require.NoError(t, err)
+		assert.Equal(t, `{"id":"1","customer_id":"2","accepted":false,"reason":"weekly_amount_exceeded","limit":"weekly_amount","remaining":"$10.00","limit_value":"$20,000.00"}`, string(line))
+	})
+	t.Run("writes count headroom as a number of loads", func(t *testing.T) {
+		response := NewDecisionResponse("1", "2", NewCountDecline(ReasonDailyCountExceeded, LimitDailyCount, 0, 3))
+		line, err := response.MarshalLine(true)
+		require.NoError(t, err)
+		assert.Equal(t, `{"id":"1","customer_id":"2","accepted":false,"reason":"daily_count_exceeded","limit":"daily_count","remaining":"0","limit_value":"3"}`, string(line))
+	})
+	t.Run("writes only the reason for accepted loads", func(t *testing.T) {
+		response := NewDecisionResponse("1", "2", NewAcceptedDecision())
+		line, err := response.MarshalLine(true)
+		require.NoError(t, err)
+		assert.Equal(t, `{"id":"1","customer_id":"2","accepted":true,"reason":"accepted"}`, string(line))
+	})
+}
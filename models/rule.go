@@ -0,0 +1,192 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Window is how a rule decides which loads fall in its window.
+type Window string
+
+const (
+	// WindowCalendar windows start at the beginning of a calendar Period.
+	WindowCalendar Window = "calendar"
+	// WindowSliding windows cover the Duration up to the load being checked.
+	WindowSliding Window = "sliding"
+)
+
+// Period is the length of a calendar window.
+type Period string
+
+const (
+	PeriodDay   Period = "day"
+	PeriodWeek  Period = "week"
+	PeriodMonth Period = "month"
+)
+
+// Metric is what a rule limits within its window.
+type Metric string
+
+const (
+	// MetricSum limits the total amount loaded.
+	MetricSum Metric = "sum"
+	// MetricCount limits the number of loads.
+	MetricCount Metric = "count"
+)
+
+// ErrInvalidRule is wrapped by the errors Rule.Validate returns.
+var ErrInvalidRule = errors.New("invalid rule")
+
+// Rule is a velocity limit, e.g. at most $40,000 per calendar month or at
+// most two loads per rolling hour. Sum rules use MaxAmount, count rules
+// MaxCount. The daily and weekly limits are the default rules, the config
+// declares further ones.
+type Rule struct {
+	Name      string
+	Window    Window
+	Period    Period
+	Duration  time.Duration
+	Metric    Metric
+	MaxAmount Money
+	MaxCount  int
+}
+
+// Load is an accepted load kept on the account for the rules to look back on.
+// ID is that of the load request, for reversals and refunds to find it by.
+type Load struct {
+	ID     string
+	Time   time.Time
+	Amount Money
+}
+
+// DefaultRules are the daily and weekly limits as rules. Their state is the
+// headroom kept in the DailyLimit and WeeklyLimit of an account rather than
+// its loads, and their names are reserved.
+func DefaultRules(limits Limits) []Rule {
+	return []Rule{
+		dailyAmountRule(limits.MaxDailyLoadLimit),
+		dailyCountRule(limits.MaxDailyTransactions),
+		weeklyAmountRule(limits.MaxWeeklyLoadLimit),
+	}
+}
+
+func dailyAmountRule(maxAmount Money) Rule {
+	return Rule{Name: string(LimitDailyAmount), Window: WindowCalendar, Period: PeriodDay, Metric: MetricSum, MaxAmount: maxAmount}
+}
+
+func dailyCountRule(maxCount int) Rule {
+	return Rule{Name: string(LimitDailyCount), Window: WindowCalendar, Period: PeriodDay, Metric: MetricCount, MaxCount: maxCount}
+}
+
+func weeklyAmountRule(maxAmount Money) Rule {
+	return Rule{Name: string(LimitWeeklyAmount), Window: WindowCalendar, Period: PeriodWeek, Metric: MetricSum, MaxAmount: maxAmount}
+}
+
+// ReservedRuleName reports whether name is that of a default rule. A
+// configured rule named like it would decline with the reason and limit of
+// the built-in one.
+func ReservedRuleName(name string) bool {
+	for _, rule := range DefaultRules(Limits{}) {
+		if rule.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate reports whether the rule is complete and consistent.
+func (r Rule) Validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w %q: %s", ErrInvalidRule, r.Name, fmt.Sprintf(format, args...))
+	}
+	if r.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidRule)
+	}
+	switch r.Window {
+	case WindowCalendar:
+		if r.Period != PeriodDay && r.Period != PeriodWeek && r.Period != PeriodMonth {
+			return invalid("period must be day, week or month, got %q", r.Period)
+		}
+	case WindowSliding:
+		if r.Duration <= 0 {
+			return invalid("duration must be positive, got %s", r.Duration)
+		}
+	default:
+		return invalid("window must be calendar or sliding, got %q", r.Window)
+	}
+	switch r.Metric {
+	case MetricSum:
+		if r.MaxAmount.IsNegative() {
+			return invalid("threshold must not be negative, got %s", r.MaxAmount)
+		}
+	case MetricCount:
+		if r.MaxCount < 0 {
+			return invalid("threshold must not be negative, got %d", r.MaxCount)
+		}
+	default:
+		return invalid("metric must be sum or count, got %q", r.Metric)
+	}
+	return nil
+}
+
+// WindowStart returns the earliest time of the window a load at t falls in.
+// Sliding windows exclude their start, calendar windows include it.
+func (r Rule) WindowStart(t time.Time, boundaries Boundaries) time.Time {
+	if r.Window == WindowSliding {
+		return t.Add(-r.Duration)
+	}
+	switch r.Period {
+	case PeriodWeek:
+		return boundaries.StartOfWeek(t)
+	case PeriodMonth:
+		return boundaries.StartOfMonth(t)
+	}
+	return boundaries.StartOfDay(t)
+}
+
+// inWindow reports whether a load at loadTime counts against a load at t.
+func (r Rule) inWindow(loadTime, t time.Time, boundaries Boundaries) bool {
+	start := r.WindowStart(t, boundaries)
+	if loadTime.After(t) || loadTime.Before(start) {
+		return false
+	}
+	return r.Window == WindowCalendar || loadTime.After(start)
+}
+
+// Check decides whether amount can be loaded at t given the earlier loads.
+func (r Rule) Check(loads []Load, t time.Time, amount Money, boundaries Boundaries) Decision {
+	var used Money
+	count := 0
+	for _, load := range loads {
+		if r.inWindow(load.Time, t, boundaries) {
+			used = used.Add(load.Amount)
+			count++
+		}
+	}
+	return r.decide(used, count, amount)
+}
+
+// decide declines amount if, with used loaded in count loads in the window,
+// it would exceed the rule.
+func (r Rule) decide(used Money, count int, amount Money) Decision {
+	reason, limit := Reason(r.Name+"_exceeded"), Limit(r.Name)
+	if r.Metric == MetricCount {
+		if count+1 > r.MaxCount {
+			remaining := r.MaxCount - count
+			if remaining < 0 {
+				remaining = 0
+			}
+			return NewCountDecline(reason, limit, remaining, r.MaxCount)
+		}
+		return NewAcceptedDecision()
+	}
+	remaining := r.MaxAmount.Sub(used)
+	if remaining.Sub(amount).IsNegative() {
+		if remaining.IsNegative() {
+			remaining = 0
+		}
+		return NewAmountDecline(reason, limit, remaining, r.MaxAmount)
+	}
+	return NewAcceptedDecision()
+}
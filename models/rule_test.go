@@ -0,0 +1,103 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleValidate(t *testing.T) {
+	t.Run("accepts calendar and sliding rules", func(t *testing.T) {
+		assert.NoError(t, Rule{Name: "monthly", Window: WindowCalendar, Period: PeriodMonth, Metric: MetricSum, MaxAmount: Dollars(1)}.Validate())
+		assert.NoError(t, Rule{Name: "hourly", Window: WindowSliding, Duration: time.Hour, Metric: MetricCount, MaxCount: 2}.Validate())
+	})
+	t.Run("rejects incomplete rules", func(t *testing.T) {
+		for name, rule := range map[string]Rule{
+			"no name":         {Window: WindowCalendar, Period: PeriodDay, Metric: MetricSum},
+			"no period":       {Name: "r", Window: WindowCalendar, Metric: MetricSum},
+			"no duration":     {Name: "r", Window: WindowSliding, Metric: MetricSum},
+			"unknown window":  {Name: "r", Window: "fixed", Period: PeriodDay, Metric: MetricSum},
+			"unknown metric":  {Name: "r", Window: WindowCalendar, Period: PeriodDay, Metric: "avg"},
+			"negative amount": {Name: "r", Window: WindowCalendar, Period: PeriodDay, Metric: MetricSum, MaxAmount: Dollars(-1)},
+			"negative count":  {Name: "r", Window: WindowCalendar, Period: PeriodDay, Metric: MetricCount, MaxCount: -1},
+		} {
+			assert.True(t, errors.Is(rule.Validate(), ErrInvalidRule), name)
+		}
+	})
+}
+
+func TestRuleWindowStart(t *testing.T) {
+	at := time.Date(2000, 1, 20, 15, 30, 0, 0, time.UTC)
+	t.Run("starts calendar windows at the beginning of the period", func(t *testing.T) {
+		assert.Equal(t, time.Date(2000, 1, 20, 0, 0, 0, 0, time.UTC), Rule{Window: WindowCalendar, Period: PeriodDay}.WindowStart(at, DefaultBoundaries))
+		assert.Equal(t, time.Date(2000, 1, 17, 0, 0, 0, 0, time.UTC), Rule{Window: WindowCalendar, Period: PeriodWeek}.WindowStart(at, DefaultBoundaries))
+		assert.Equal(t, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), Rule{Window: WindowCalendar, Period: PeriodMonth}.WindowStart(at, DefaultBoundaries))
+	})
+	t.Run("starts sliding windows a duration back", func(t *testing.T) {
+		assert.Equal(t, at.Add(-24*time.Hour), Rule{Window: WindowSliding, Duration: 24 * time.Hour}.WindowStart(at, DefaultBoundaries))
+	})
+}
+
+func TestRuleCheck(t *testing.T) {
+	at := time.Date(2000, 1, 20, 15, 30, 0, 0, time.UTC)
+	t.Run("sums the amounts loaded in a calendar month", func(t *testing.T) {
+		rule := Rule{Name: "monthly_amount", Window: WindowCalendar, Period: PeriodMonth, Metric: MetricSum, MaxAmount: Dollars(100)}
+		loads := []Load{
+			{Time: time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC), Amount: Dollars(100)},
+			{Time: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), Amount: Dollars(60)},
+		}
+		assert.Equal(t, NewAcceptedDecision(), rule.Check(loads, at, Dollars(40), DefaultBoundaries))
+		assert.Equal(t, NewAmountDecline("monthly_amount_exceeded", "monthly_amount", Dollars(40), Dollars(100)), rule.Check(loads, at, Dollars(41), DefaultBoundaries))
+	})
+	t.Run("counts the loads in a sliding hour", func(t *testing.T) {
+		rule := Rule{Name: "hourly_count", Window: WindowSliding, Duration: time.Hour, Metric: MetricCount, MaxCount: 2}
+		loads := []Load{
+			{Time: at.Add(-time.Hour), Amount: Dollars(1)},
+			{Time: at.Add(-59 * time.Minute), Amount: Dollars(1)},
+		}
+		assert.Equal(t, NewAcceptedDecision(), rule.Check(loads, at, Dollars(1), DefaultBoundaries))
+		loads = append(loads, Load{Time: at.Add(-time.Minute), Amount: Dollars(1)})
+		decision := rule.Check(loads, at, Dollars(1), DefaultBoundaries)
+		assert.Equal(t, NewCountDecline("hourly_count_exceeded", "hourly_count", 0, 2), decision)
+		assert.Equal(t, "0", decision.FormatRemaining())
+	})
+	t.Run("ignores loads after the time checked", func(t *testing.T) {
+		rule := Rule{Name: "daily_count", Window: WindowCalendar, Period: PeriodDay, Metric: MetricCount, MaxCount: 1}
+		assert.True(t, rule.Check([]Load{{Time: at.Add(time.Minute)}}, at, Dollars(1), DefaultBoundaries).Accepted)
+	})
+}
+
+func TestDefaultRules(t *testing.T) {
+	limits := Limits{MaxDailyLoadLimit: Dollars(5000), MaxDailyTransactions: 3, MaxWeeklyLoadLimit: Dollars(20000)}
+	t.Run("express the daily and weekly limits", func(t *testing.T) {
+		rules := DefaultRules(limits)
+		require.Len(t, rules, 3)
+		for _, rule := range rules {
+			assert.NoError(t, rule.Validate())
+		}
+		at := time.Date(2000, 1, 20, 15, 30, 0, 0, time.UTC)
+		loads := []Load{{Time: at.Add(-time.Hour), Amount: Dollars(4000)}, {Time: at.Add(-time.Minute), Amount: Dollars(500)}}
+		assert.Equal(t, NewAmountDecline(ReasonDailyAmountExceeded, LimitDailyAmount, Dollars(500), Dollars(5000)), rules[0].Check(loads, at, Dollars(501), DefaultBoundaries))
+		loads = append(loads, Load{Time: at.Add(-time.Second), Amount: Dollars(1)})
+		assert.Equal(t, NewCountDecline(ReasonDailyCountExceeded, LimitDailyCount, 0, 3), rules[1].Check(loads, at, Dollars(1), DefaultBoundaries))
+		assert.Equal(t, NewAmountDecline(ReasonWeeklyAmountExceeded, LimitWeeklyAmount, Dollars(15499), Dollars(20000)), rules[2].Check(loads, at, Dollars(15500), DefaultBoundaries))
+	})
+	t.Run("decide like the headroom kept on an account", func(t *testing.T) {
+		at := time.Date(2000, 1, 20, 15, 30, 0, 0, time.UTC)
+		dailyLimit := NewDailyLimit(at, limits.MaxDailyLoadLimit, limits.MaxDailyTransactions, DefaultBoundaries)
+		dailyLimit.Apply(Dollars(4000))
+		loads := []Load{{Time: at, Amount: Dollars(4000)}}
+		for _, amount := range []Money{Dollars(1000), Dollars(1001)} {
+			assert.Equal(t, DefaultRules(limits)[0].Check(loads, at, amount, DefaultBoundaries), dailyLimit.Validate(amount))
+		}
+	})
+	t.Run("reserve their names", func(t *testing.T) {
+		assert.True(t, ReservedRuleName("daily_amount"))
+		assert.True(t, ReservedRuleName("daily_count"))
+		assert.True(t, ReservedRuleName("weekly_amount"))
+		assert.False(t, ReservedRuleName("monthly_amount"))
+	})
+}
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Errors wrapped in the FieldError of a request that breaks a validation
+// rule.
+var (
+	ErrTooLong         = errors.New("too long")
+	ErrInvalidCharset  = errors.New("has characters that are not allowed")
+	ErrMissingCurrency = errors.New("missing currency symbol")
+	ErrOutOfRange      = errors.New("out of the accepted range")
+	ErrUnknownField    = errors.New("unknown field")
+)
+
+// requestFields are the fields of a request line.
+var requestFields = map[string]bool{
+	"id": true, "customer_id": true, "type": true, "load_id": true, "load_amount": true, "time": true,
+}
+
+// ValidationRules are the checks a request must pass besides being parsed.
+type ValidationRules struct {
+	// Strict declines requests that parse but break the rules below as
+	// invalid. Requests that cannot be parsed are still malformed. The rules
+	// only apply when it is set.
+	Strict bool
+	// MaxIDLength bounds the length of IDs, customer IDs and load IDs. Zero
+	// does not bound them.
+	MaxIDLength int
+	// IDPattern is a regular expression IDs, customer IDs and load IDs must
+	// match in full. Empty accepts any.
+	IDPattern string
+	// RequireCurrency declines amounts without a leading "$".
+	RequireCurrency bool
+	// EarliestTime and LatestTime bound the time of requests. Zero does not
+	// bound it.
+	EarliestTime time.Time
+	LatestTime   time.Time
+	// RejectUnknownFields declines requests with fields a request does not
+	// have.
+	RejectUnknownFields bool
+}
+
+// InvalidError reports a request that parses but breaks a validation rule. It
+// is declined with ReasonInvalid.
+type InvalidError struct {
+	Request *Request
+	Err     error
+}
+
+func (e *InvalidError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvalidError) Unwrap() error {
+	return e.Err
+}
+
+// Validator parses requests and checks them against ValidationRules.
+type Validator struct {
+	rules     ValidationRules
+	idPattern *regexp.Regexp
+}
+
+// NewValidator returns a validator of the rules. An IDPattern that does not
+// compile is left out with a warning.
+func NewValidator(rules ValidationRules) *Validator {
+	v := &Validator{rules: rules}
+	if rules.IDPattern != "" {
+		idPattern, err := CompileIDPattern(rules.IDPattern)
+		if err != nil {
+			logrus.Warnf("Not checking the characters of IDs: %v", err)
+		}
+		v.idPattern = idPattern
+	}
+	return v
+}
+
+// CompileIDPattern compiles an IDPattern to match IDs in full.
+func CompileIDPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// Parse parses the request line like NewRequest. With strict rules a request
+// that parses but breaks a rule is an *InvalidError holding the request, a
+// line that cannot be parsed is still an error like that of NewRequest.
+func (v *Validator) Parse(line string) (*Request, error) {
+	if !v.rules.Strict {
+		return NewRequest(line)
+	}
+	var r Request
+	if err := json.Unmarshal([]byte(line), &r); err != nil {
+		logrus.Errorln("Error parsing line: ", err)
+		return nil, err
+	}
+	// a request is parsed in full before its amount is found not positive
+	if err := r.parse(); err != nil && !errors.Is(err, ErrNotPositive) {
+		return nil, err
+	}
+	var err error
+	if v.rules.RejectUnknownFields {
+		err = unknownField(line)
+	}
+	if err == nil {
+		err = v.validate(&r)
+	}
+	if err != nil {
+		return nil, &InvalidError{Request: &r, Err: err}
+	}
+	return &r, nil
+}
+
+// ParseFields builds a request from the raw field values of fields, its ID,
+// customer, type, load, amount and time, like ParseRequest and validates it
+// like Parse.
+func (v *Validator) ParseFields(fields Request) (*Request, error) {
+	r := &Request{ID: fields.ID, CustomerID: fields.CustomerID, Type: fields.Type, LoadID: fields.LoadID, Amount: fields.Amount, Time: fields.Time}
+	err := r.parse()
+	if !v.rules.Strict {
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	if err != nil && !errors.Is(err, ErrNotPositive) {
+		return nil, err
+	}
+	if err := v.validate(r); err != nil {
+		return nil, &InvalidError{Request: r, Err: err}
+	}
+	return r, nil
+}
+
+// validate checks the parsed request against the rules.
+func (v *Validator) validate(r *Request) error {
+	ids := []struct{ field, value string }{{"id", r.ID}, {"customer_id", r.CustomerID}, {"load_id", r.LoadID}}
+	for _, id := range ids {
+		if id.value == "" {
+			continue
+		}
+		if v.rules.MaxIDLength > 0 && len(id.value) > v.rules.MaxIDLength {
+			return &FieldError{Field: id.field, Err: ErrTooLong}
+		}
+		if v.idPattern != nil && !v.idPattern.MatchString(id.value) {
+			return &FieldError{Field: id.field, Err: ErrInvalidCharset}
+		}
+	}
+	// reversals may leave out the amount
+	if !(r.Type == RequestReversal && r.Amount == "") {
+		amount := strings.TrimPrefix(strings.TrimSpace(r.Amount), "-")
+		if v.rules.RequireCurrency && !strings.HasPrefix(amount, "$") {
+			return &FieldError{Field: "load_amount", Err: ErrMissingCurrency}
+		}
+		if r.ParsedAmount <= 0 {
+			return &FieldError{Field: "load_amount", Err: ErrNotPositive}
+		}
+	}
+	if !v.rules.EarliestTime.IsZero() && r.ParsedTime.Before(v.rules.EarliestTime) ||
+		!v.rules.LatestTime.IsZero() && r.ParsedTime.After(v.rules.LatestTime) {
+		return &FieldError{Field: "time", Err: ErrOutOfRange}
+	}
+	return nil
+}
+
+// unknownField returns a FieldError for the first field, by name, of the
+// line that a request does not have.
+func unknownField(line string) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return err
+	}
+	var unknown []string
+	for field := range fields {
+		if !requestFields[field] {
+			unknown = append(unknown, field)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return &FieldError{Field: unknown[0], Err: ErrUnknownField}
+}
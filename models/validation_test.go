@@ -0,0 +1,114 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStrictValidator() *Validator {
+	return NewValidator(ValidationRules{
+		Strict:              true,
+		MaxIDLength:         8,
+		IDPattern:           "[A-Za-z0-9-]+",
+		RequireCurrency:     true,
+		EarliestTime:        time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		LatestTime:          time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+		RejectUnknownFields: true,
+	})
+}
+
+func TestValidatorParse(t *testing.T) {
+	t.Run("accepts a valid request", func(t *testing.T) {
+		request, err := newStrictValidator().Parse(`{"id":"1","customer_id":"c-1","load_amount":"$100.50","time":"2000-01-01T06:08:12Z"}`)
+		require.NoError(t, err)
+		assert.Equal(t, Cents(10050), request.ParsedAmount)
+		request, err = newStrictValidator().Parse(`{"id":"2","customer_id":"c-1","type":"reversal","load_id":"1","time":"2000-01-01T06:08:12Z"}`)
+		require.NoError(t, err)
+		assert.Equal(t, RequestReversal, request.Type)
+	})
+	t.Run("returns invalid error for a request breaking a rule", func(t *testing.T) {
+		for line, expected := range map[string]struct {
+			field string
+			err   error
+		}{
+			`{"id":"1","customer_id":"123456789","load_amount":"$1","time":"2000-01-01T00:00:00Z"}`:                         {"customer_id", ErrTooLong},
+			`{"id":"1","customer_id":"a b","load_amount":"$1","time":"2000-01-01T00:00:00Z"}`:                               {"customer_id", ErrInvalidCharset},
+			`{"id":"1","customer_id":"1","type":"refund","load_id":"1/2","load_amount":"$1","time":"2000-01-01T00:00:00Z"}`: {"load_id", ErrInvalidCharset},
+			`{"id":"1","customer_id":"1","load_amount":"100","time":"2000-01-01T00:00:00Z"}`:                                {"load_amount", ErrMissingCurrency},
+			`{"id":"1","customer_id":"1","load_amount":"$0.00","time":"2000-01-01T00:00:00Z"}`:                              {"load_amount", ErrNotPositive},
+			`{"id":"1","customer_id":"1","load_amount":"-$5","time":"2000-01-01T00:00:00Z"}`:                                {"load_amount", ErrNotPositive},
+			`{"id":"1","customer_id":"1","load_amount":"$1","time":"1999-12-31T23:59:59Z"}`:                                 {"time", ErrOutOfRange},
+			`{"id":"1","customer_id":"1","load_amount":"$1","time":"2100-01-01T00:00:01Z"}`:                                 {"time", ErrOutOfRange},
+			`{"id":"1","customer_id":"1","load_amount":"$1","time":"2000-01-01T00:00:00Z","note":"x","extra":1}`:            {"extra", ErrUnknownField},
+		} {
+			_, err := newStrictValidator().Parse(line)
+			var invalid *InvalidError
+			require.True(t, errors.As(err, &invalid), line)
+			assert.Equal(t, "1", invalid.Request.ID, line)
+			var fieldErr *FieldError
+			require.True(t, errors.As(err, &fieldErr), line)
+			assert.Equal(t, expected.field, fieldErr.Field, line)
+			assert.True(t, errors.Is(err, expected.err), line)
+		}
+	})
+	t.Run("returns plain error for a request that cannot be parsed", func(t *testing.T) {
+		for _, line := range []string{
+			`{"id":`,
+			`{"id":"1","customer_id":"1","load_amount":"$1.001","time":"2000-01-01T00:00:00Z"}`,
+			`{"id":"1","customer_id":"1","load_amount":"lots","time":"2000-01-01T00:00:00Z"}`,
+			`{"id":"1","customer_id":"1","load_amount":"$1","time":"yesterday"}`,
+			`{"id":"1","customer_id":"1","type":"transfer","load_amount":"$1","time":"2000-01-01T00:00:00Z"}`,
+			`{"customer_id":"1","load_amount":"$1","time":"2000-01-01T00:00:00Z"}`,
+			`{"id":"1","load_amount":"$1","time":"2000-01-01T00:00:00Z"}`,
+		} {
+			_, err := newStrictValidator().Parse(line)
+			require.Error(t, err, line)
+			var invalid *InvalidError
+			assert.False(t, errors.As(err, &invalid), line)
+		}
+	})
+	t.Run("parses like NewRequest without strict rules", func(t *testing.T) {
+		validator := NewValidator(ValidationRules{MaxIDLength: 1, RequireCurrency: true})
+		request, err := validator.Parse(`{"id":"12","customer_id":"1","load_amount":"100","time":"2000-01-01T00:00:00Z","note":"x"}`)
+		require.NoError(t, err)
+		assert.Equal(t, Dollars(100), request.ParsedAmount)
+		_, err = validator.Parse(`{"id":"1","customer_id":"1","load_amount":"lots","time":"2000-01-01T00:00:00Z"}`)
+		var invalid *InvalidError
+		assert.False(t, errors.As(err, &invalid))
+	})
+	t.Run("leaves out an id pattern that does not compile", func(t *testing.T) {
+		validator := NewValidator(ValidationRules{Strict: true, IDPattern: "[a-z"})
+		_, err := validator.Parse(`{"id":"A B","customer_id":"1","load_amount":"$1","time":"2000-01-01T00:00:00Z"}`)
+		assert.NoError(t, err)
+	})
+}
+
+func TestValidatorParseFields(t *testing.T) {
+	fields := func(id, amount string) Request {
+		return Request{ID: id, CustomerID: "1", Amount: amount, Time: "2000-01-01T00:00:00Z"}
+	}
+	t.Run("validates the fields like a line", func(t *testing.T) {
+		request, err := newStrictValidator().ParseFields(fields("1", "$1"))
+		require.NoError(t, err)
+		assert.Equal(t, Dollars(1), request.ParsedAmount)
+		_, err = newStrictValidator().ParseFields(fields("1", "1"))
+		assert.True(t, errors.Is(err, ErrMissingCurrency))
+		_, err = newStrictValidator().ParseFields(fields("", "$1"))
+		assert.True(t, errors.Is(err, ErrMissingField))
+	})
+	t.Run("parses the type and load of a reversal", func(t *testing.T) {
+		reversal := fields("2", "")
+		reversal.Type, reversal.LoadID = RequestReversal, "1"
+		request, err := newStrictValidator().ParseFields(reversal)
+		require.NoError(t, err)
+		assert.Equal(t, RequestReversal, request.Type)
+		assert.Equal(t, "1", request.LoadID)
+		reversal.LoadID = ""
+		_, err = NewValidator(ValidationRules{}).ParseFields(reversal)
+		assert.True(t, errors.Is(err, ErrMissingField))
+	})
+}
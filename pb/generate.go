@@ -0,0 +1,6 @@
+// Package pb holds the protobuf and gRPC definitions of the velocity limit
+// service. The Go files are generated from velocitylimits.proto with buf,
+// protoc-gen-go and protoc-gen-go-grpc.
+package pb
+
+//go:generate sh -c "cd .. && buf generate"
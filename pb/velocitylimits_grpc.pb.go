@@ -0,0 +1,216 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// VelocityLimitsClient is the client API for VelocityLimits service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type VelocityLimitsClient interface {
+	// AttemptLoad validates and, if the limits allow it, applies one load.
+	AttemptLoad(ctx context.Context, in *LoadRequest, opts ...grpc.CallOption) (*LoadResponse, error)
+	// AttemptLoadStream attempts every load sent on the stream and answers each
+	// one in order. Requests that cannot be parsed are answered with error set
+	// instead of ending the stream.
+	AttemptLoadStream(ctx context.Context, opts ...grpc.CallOption) (VelocityLimits_AttemptLoadStreamClient, error)
+	// GetAccountLimits returns the headroom a customer has left.
+	GetAccountLimits(ctx context.Context, in *GetAccountLimitsRequest, opts ...grpc.CallOption) (*AccountLimits, error)
+}
+
+type velocityLimitsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVelocityLimitsClient(cc grpc.ClientConnInterface) VelocityLimitsClient {
+	return &velocityLimitsClient{cc}
+}
+
+func (c *velocityLimitsClient) AttemptLoad(ctx context.Context, in *LoadRequest, opts ...grpc.CallOption) (*LoadResponse, error) {
+	out := new(LoadResponse)
+	err := c.cc.Invoke(ctx, "/velocitylimits.v1.VelocityLimits/AttemptLoad", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *velocityLimitsClient) AttemptLoadStream(ctx context.Context, opts ...grpc.CallOption) (VelocityLimits_AttemptLoadStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &VelocityLimits_ServiceDesc.Streams[0], "/velocitylimits.v1.VelocityLimits/AttemptLoadStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &velocityLimitsAttemptLoadStreamClient{stream}
+	return x, nil
+}
+
+type VelocityLimits_AttemptLoadStreamClient interface {
+	Send(*LoadRequest) error
+	Recv() (*LoadResponse, error)
+	grpc.ClientStream
+}
+
+type velocityLimitsAttemptLoadStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *velocityLimitsAttemptLoadStreamClient) Send(m *LoadRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *velocityLimitsAttemptLoadStreamClient) Recv() (*LoadResponse, error) {
+	m := new(LoadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *velocityLimitsClient) GetAccountLimits(ctx context.Context, in *GetAccountLimitsRequest, opts ...grpc.CallOption) (*AccountLimits, error) {
+	out := new(AccountLimits)
+	err := c.cc.Invoke(ctx, "/velocitylimits.v1.VelocityLimits/GetAccountLimits", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// VelocityLimitsServer is the server API for VelocityLimits service.
+// All implementations must embed UnimplementedVelocityLimitsServer
+// for forward compatibility
+type VelocityLimitsServer interface {
+	// AttemptLoad validates and, if the limits allow it, applies one load.
+	AttemptLoad(context.Context, *LoadRequest) (*LoadResponse, error)
+	// AttemptLoadStream attempts every load sent on the stream and answers each
+	// one in order. Requests that cannot be parsed are answered with error set
+	// instead of ending the stream.
+	AttemptLoadStream(VelocityLimits_AttemptLoadStreamServer) error
+	// GetAccountLimits returns the headroom a customer has left.
+	GetAccountLimits(context.Context, *GetAccountLimitsRequest) (*AccountLimits, error)
+	mustEmbedUnimplementedVelocityLimitsServer()
+}
+
+// UnimplementedVelocityLimitsServer must be embedded to have forward compatible implementations.
+type UnimplementedVelocityLimitsServer struct {
+}
+
+func (UnimplementedVelocityLimitsServer) AttemptLoad(context.Context, *LoadRequest) (*LoadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AttemptLoad not implemented")
+}
+func (UnimplementedVelocityLimitsServer) AttemptLoadStream(VelocityLimits_AttemptLoadStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method AttemptLoadStream not implemented")
+}
+func (UnimplementedVelocityLimitsServer) GetAccountLimits(context.Context, *GetAccountLimitsRequest) (*AccountLimits, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAccountLimits not implemented")
+}
+func (UnimplementedVelocityLimitsServer) mustEmbedUnimplementedVelocityLimitsServer() {}
+
+// UnsafeVelocityLimitsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VelocityLimitsServer will
+// result in compilation errors.
+type UnsafeVelocityLimitsServer interface {
+	mustEmbedUnimplementedVelocityLimitsServer()
+}
+
+func RegisterVelocityLimitsServer(s grpc.ServiceRegistrar, srv VelocityLimitsServer) {
+	s.RegisterService(&VelocityLimits_ServiceDesc, srv)
+}
+
+func _VelocityLimits_AttemptLoad_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VelocityLimitsServer).AttemptLoad(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/velocitylimits.v1.VelocityLimits/AttemptLoad",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VelocityLimitsServer).AttemptLoad(ctx, req.(*LoadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VelocityLimits_AttemptLoadStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(VelocityLimitsServer).AttemptLoadStream(&velocityLimitsAttemptLoadStreamServer{stream})
+}
+
+type VelocityLimits_AttemptLoadStreamServer interface {
+	Send(*LoadResponse) error
+	Recv() (*LoadRequest, error)
+	grpc.ServerStream
+}
+
+type velocityLimitsAttemptLoadStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *velocityLimitsAttemptLoadStreamServer) Send(m *LoadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *velocityLimitsAttemptLoadStreamServer) Recv() (*LoadRequest, error) {
+	m := new(LoadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _VelocityLimits_GetAccountLimits_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAccountLimitsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VelocityLimitsServer).GetAccountLimits(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/velocitylimits.v1.VelocityLimits/GetAccountLimits",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VelocityLimitsServer).GetAccountLimits(ctx, req.(*GetAccountLimitsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// VelocityLimits_ServiceDesc is the grpc.ServiceDesc for VelocityLimits service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var VelocityLimits_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "velocitylimits.v1.VelocityLimits",
+	HandlerType: (*VelocityLimitsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AttemptLoad",
+			Handler:    _VelocityLimits_AttemptLoad_Handler,
+		},
+		{
+			MethodName: "GetAccountLimits",
+			Handler:    _VelocityLimits_GetAccountLimits_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "AttemptLoadStream",
+			Handler:       _VelocityLimits_AttemptLoadStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "pb/velocitylimits.proto",
+}
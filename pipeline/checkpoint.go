@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"velocitylimits/cache"
+)
+
+// ErrNotCheckpointable is returned by Run when checkpoints are configured for
+// a cache that cannot save its state.
+var ErrNotCheckpointable = errors.New("cache does not support checkpoints")
+
+// Checkpointer is a cache whose state can be saved in checkpoints and
+// restored from them.
+type Checkpointer interface {
+	State() cache.State
+	Restore(state cache.State) error
+}
+
+// AuditLog is an auditor that can tell how far its log goes, so checkpoints
+// can record it.
+type AuditLog interface {
+	Offset() int64
+}
+
+// Checkpoint is how far a run got. Every line before InputOffset has been
+// answered, dead lettered or routed to the late file, or is held back in the
+// reorder buffer, and the writers were flushed at the offsets recorded.
+type Checkpoint struct {
+	InputOffset      int64 `json:"input_offset"`
+	OutputOffset     int64 `json:"output_offset"`
+	DeadLetterOffset int64 `json:"dead_letter_offset"`
+	LateOffset       int64 `json:"late_offset"`
+	// AuditOffset is the size of the audit log, nil if the run is not
+	// audited to an AuditLog.
+	AuditOffset *int64  `json:"audit_offset,omitempty"`
+	Summary     Summary `json:"summary"`
+	// Watermark and Buffered are the state of the reorder buffer, Buffered
+	// holds its requests as JSON lines in arrival order.
+	Watermark time.Time   `json:"watermark"`
+	Buffered  []string    `json:"buffered,omitempty"`
+	Cache     cache.State `json:"cache"`
+}
+
+// ReadCheckpoint reads the checkpoint at path. It returns nil if there is
+// none.
+func ReadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// writeCheckpoint atomically replaces the checkpoint at path.
+func writeCheckpoint(path string, checkpoint *Checkpoint) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := json.NewEncoder(tmp).Encode(checkpoint); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// barrier pauses the pipeline for a checkpoint. Every worker passes it on to
+// the Responder and waits, so the cache holds exactly the decisions on the
+// jobs before it. The Responder writes those and flushes the output, then
+// the checkpoint is saved and the workers carry on.
+type barrier struct {
+	checkpoint *Checkpoint
+	workers    int
+	arrived    int
+	flushed    chan struct{}
+	done       chan struct{}
+}
+
+func newBarrier(checkpoint *Checkpoint) *barrier {
+	return &barrier{checkpoint: checkpoint, flushed: make(chan struct{}), done: make(chan struct{})}
+}
+
+// countingWriter counts the bytes written through it, starting from an
+// offset.
+type countingWriter struct {
+	w      io.Writer
+	offset int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.offset += int64(n)
+	return n, err
+}
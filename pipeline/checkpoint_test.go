@@ -0,0 +1,195 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"velocitylimits/cache"
+	"velocitylimits/engine"
+	"velocitylimits/service/servicefakes"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errKilled = errors.New("killed")
+
+// killingReader fails once n bytes have been read, like a run killed part
+// way through its input.
+type killingReader struct {
+	r io.Reader
+	n int
+}
+
+func (k *killingReader) Read(p []byte) (int, error) {
+	if k.n <= 0 {
+		return 0, errKilled
+	}
+	if len(p) > k.n {
+		p = p[:k.n]
+	}
+	n, err := k.r.Read(p)
+	k.n -= n
+	return n, err
+}
+
+// generateMessyInput returns requests that are partly out of order, with
+// duplicates, malformed and blank lines.
+func generateMessyInput(random *rand.Rand, n int) []byte {
+	lines := strings.SplitAfter(string(generateInput(n, 10)), "\n")
+	lines = lines[:len(lines)-1]
+	for i := 1; i < len(lines); i++ {
+		if random.Intn(5) == 0 {
+			lines[i-1], lines[i] = lines[i], lines[i-1]
+		}
+	}
+	var input bytes.Buffer
+	for _, line := range lines {
+		input.WriteString(line)
+		switch random.Intn(20) {
+		case 0:
+			input.WriteString("not json\n")
+		case 1:
+			input.WriteString(line)
+		case 2:
+			input.WriteString("\n")
+		}
+	}
+	return input.Bytes()
+}
+
+// offsetAuditor counts the records it is given as the offset of its log.
+type offsetAuditor struct {
+	recordingAuditor
+}
+
+func (a *offsetAuditor) Offset() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return int64(len(a.records))
+}
+
+func newCheckpointDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestCheckpoint(t *testing.T) {
+	t.Run("resumed runs write the same output as an uninterrupted one", func(t *testing.T) {
+		random := rand.New(rand.NewSource(1))
+		for trial := 0; trial < 20; trial++ {
+			input := generateMessyInput(random, 300)
+			config := newTestConfig(1+random.Intn(4), true)
+			config.Output.IncludeReason = true
+			config.Reorder.Lateness = time.Duration(random.Intn(3)) * time.Minute
+			config.Reorder.LatePolicy = "reject"
+
+			var expected, expectedDeadLetter bytes.Buffer
+			expectedSummary, err := run(context.Background(), config, bytes.NewReader(input), Sinks{Output: &expected, DeadLetter: &expectedDeadLetter}, cache.NewCache(), nil)
+			require.NoError(t, err)
+
+			config.Checkpoint.File = filepath.Join(newCheckpointDir(t), "checkpoint.json")
+			config.Checkpoint.Every = 1 + random.Intn(40)
+
+			// kill the run at random points until it gets through, resuming
+			// each time from the last checkpoint
+			var output, deadLetter bytes.Buffer
+			cache := cache.NewCache()
+			var from *Checkpoint
+			var summary Summary
+			for {
+				offset := 0
+				if from != nil {
+					offset = int(from.InputOffset)
+				}
+				reader := &killingReader{r: bytes.NewReader(input[offset:]), n: random.Intn(len(input) / 3)}
+				summary, err = run(context.Background(), config, reader, Sinks{Output: &output, DeadLetter: &deadLetter}, cache, from)
+				if err == nil {
+					break
+				}
+				require.True(t, errors.Is(err, errKilled), err)
+				// the files are cut back to the checkpoint as on resuming
+				from, err = ReadCheckpoint(config.Checkpoint.File)
+				require.NoError(t, err)
+				// a run killed before its first checkpoint was saved did not
+				// touch the cache, it starts over
+				if from == nil {
+					output.Reset()
+					deadLetter.Reset()
+					continue
+				}
+				require.True(t, int64(output.Len()) >= from.OutputOffset)
+				output.Truncate(int(from.OutputOffset))
+				deadLetter.Truncate(int(from.DeadLetterOffset))
+			}
+			message := fmt.Sprintf("trial %d", trial)
+			assert.Equal(t, expected.String(), output.String(), message)
+			assert.Equal(t, expectedDeadLetter.String(), deadLetter.String(), message)
+			assert.Equal(t, expectedSummary, summary, message)
+		}
+	})
+	t.Run("checkpoints how far the run got", func(t *testing.T) {
+		input := "not json\n" + string(generateInput(10, 2))
+		config := newTestConfig(2, true)
+		config.Checkpoint.File = filepath.Join(newCheckpointDir(t), "checkpoint.json")
+		config.Checkpoint.Every = 5
+		var output, deadLetter bytes.Buffer
+		_, err := run(context.Background(), config, strings.NewReader(input), Sinks{Output: &output, DeadLetter: &deadLetter}, cache.NewCache(), nil)
+		require.NoError(t, err)
+		checkpoint, err := ReadCheckpoint(config.Checkpoint.File)
+		require.NoError(t, err)
+		require.NotNil(t, checkpoint)
+		// the last checkpoint is taken before line 11
+		lines := strings.SplitAfter(input, "\n")
+		assert.Equal(t, int64(len(strings.Join(lines[:10], ""))), checkpoint.InputOffset)
+		assert.Equal(t, 10, checkpoint.Summary.Lines)
+		assert.Equal(t, 1, checkpoint.Summary.Malformed)
+		assert.Equal(t, 9, checkpoint.Summary.Accepted+checkpoint.Summary.Declined)
+		outputLines := strings.SplitAfter(output.String(), "\n")
+		assert.Equal(t, int64(len(strings.Join(outputLines[:9], ""))), checkpoint.OutputOffset)
+		assert.Equal(t, int64(deadLetter.Len()), checkpoint.DeadLetterOffset)
+		assert.Len(t, checkpoint.Cache.Transactions, 9)
+	})
+	t.Run("checkpoints the offset of the audit log", func(t *testing.T) {
+		config := newTestConfig(2, true)
+		config.Checkpoint.File = filepath.Join(newCheckpointDir(t), "checkpoint.json")
+		config.Checkpoint.Every = 5
+		auditor := &offsetAuditor{}
+		engine := engine.New(engine.WithConfig(config), engine.WithAuditor(auditor))
+		_, err := Run(context.Background(), config, bytes.NewReader(generateInput(12, 2)), Sinks{Output: ioutil.Discard}, engine, nil)
+		require.NoError(t, err)
+		checkpoint, err := ReadCheckpoint(config.Checkpoint.File)
+		require.NoError(t, err)
+		require.NotNil(t, checkpoint.AuditOffset)
+		// the decisions of the first ten lines were audited
+		assert.Equal(t, int64(10), *checkpoint.AuditOffset)
+		_, err = run(context.Background(), config, bytes.NewReader(generateInput(12, 2)), Sinks{Output: ioutil.Discard}, cache.NewCache(), nil)
+		require.NoError(t, err)
+		checkpoint, err = ReadCheckpoint(config.Checkpoint.File)
+		require.NoError(t, err)
+		assert.Nil(t, checkpoint.AuditOffset)
+	})
+	t.Run("returns nil without a checkpoint", func(t *testing.T) {
+		checkpoint, err := ReadCheckpoint(filepath.Join(newCheckpointDir(t), "checkpoint.json"))
+		require.NoError(t, err)
+		assert.Nil(t, checkpoint)
+	})
+	t.Run("returns error for a cache without checkpoints", func(t *testing.T) {
+		config := newTestConfig(1, true)
+		config.Checkpoint.File = filepath.Join(newCheckpointDir(t), "checkpoint.json")
+		_, err := run(context.Background(), config, strings.NewReader(""), Sinks{Output: ioutil.Discard}, new(servicefakes.FakeCache), nil)
+		assert.True(t, errors.Is(err, ErrNotCheckpointable))
+	})
+}
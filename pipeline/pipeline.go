@@ -0,0 +1,631 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+	"time"
+
+	"velocitylimits/config"
+	"velocitylimits/engine"
+	"velocitylimits/metrics"
+	"velocitylimits/models"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+const defaultQueueSize = 64
+
+// Job is a request tagged with its position in the input.
+type Job struct {
+	Seq     int
+	Request *models.Request
+	// Late is set when the request is declined for arriving behind the
+	// watermark rather than attempted.
+	Late bool
+	// Invalid is set when the request is declined for breaking a validation
+	// rule rather than attempted.
+	Invalid bool
+	// barrier is set, and Request is not, for the checkpoints of a run.
+	barrier *barrier
+}
+
+// Result is the response to the Job with the same Seq.
+type Result struct {
+	Seq      int
+	Response *models.Response
+	barrier  *barrier
+}
+
+// ErrErrorRateExceeded is returned when more of the input is malformed than
+// Pipeline.MaxErrorRate allows.
+var ErrErrorRateExceeded = errors.New("malformed line rate exceeded")
+
+// DeadLetter is an input line that could not be parsed into a request.
+type DeadLetter struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+	Input string `json:"input"`
+}
+
+// LateEvent is a request that arrived behind the watermark, written to the
+// late file under the "file" late policy.
+type LateEvent struct {
+	Line      int       `json:"line"`
+	Watermark time.Time `json:"watermark"`
+	Input     string    `json:"input"`
+}
+
+// Summary counts what happened to the input of a run.
+type Summary struct {
+	Lines     int `json:"lines"`
+	Malformed int `json:"malformed"`
+	Late      int `json:"late"`
+	// Invalid counts the lines declined for breaking a validation rule, they
+	// are counted in Declined too.
+	Invalid  int `json:"invalid"`
+	Accepted int `json:"accepted"`
+	Declined int `json:"declined"`
+}
+
+// String ...
+func (s Summary) String() string {
+	return fmt.Sprintf("%d lines read: %d accepted, %d declined, %d malformed, %d late, %d invalid",
+		s.Lines, s.Accepted, s.Declined, s.Malformed, s.Late, s.Invalid)
+}
+
+// Sinks are where a run writes besides the store. DeadLetter, Late and Metrics
+// may be nil.
+type Sinks struct {
+	Output     io.Writer
+	DeadLetter io.Writer
+	Late       io.Writer
+	Metrics    *metrics.Metrics
+}
+
+// GetRequest reads the input and converts each line to a request. Lines that
+// cannot be parsed are written to deadLetter, which may be nil, and counted in
+// summary instead of stopping the run. Requests that break the Validation
+// rules are declined as invalid as they are read. Blank lines are skipped.
+// Reading stops before the next line once ctx is done, a line being read is
+// waited for.
+//
+// Requests are put back in time order within Reorder.Lateness. Requests that
+// arrive behind the watermark are handled by Reorder.LatePolicy, those routed
+// to a file are written to late, which may be nil. Lines are counted in stats,
+// which may be nil too.
+//
+// A run resumed from a checkpoint, which may be nil, is given the input from
+// its InputOffset on and starts with the reorder buffer it recorded. A
+// checkpoint is passed down the pipeline when a fresh run starts and every
+// Checkpoint.Every lines. A run stopped by ctx checkpoints where it stopped,
+// otherwise the requests in the reorder buffer are still sent. Progress and
+// errors are logged to logger.
+func GetRequest(ctx context.Context, config *config.Configurations, input io.Reader, deadLetter, late io.Writer, summary *Summary, stats *metrics.Metrics, from *Checkpoint, logger logrus.FieldLogger) (<-chan *Job, func() error) {
+	jobC := make(chan *Job)
+	resumed := from != nil
+	if !resumed {
+		from = &Checkpoint{}
+	}
+	parser := func() (err error) {
+		// close the channel so the later stages drain and stop
+		defer close(jobC)
+		var deadLetters, lateEvents *json.Encoder
+		var writers []*bufio.Writer
+		deadLetterCount := &countingWriter{w: deadLetter, offset: from.DeadLetterOffset}
+		lateCount := &countingWriter{w: late, offset: from.LateOffset}
+		if deadLetter != nil {
+			writer := bufio.NewWriter(deadLetterCount)
+			writers = append(writers, writer)
+			deadLetters = json.NewEncoder(writer)
+		}
+		if late != nil && routesLate(config.Reorder) {
+			writer := bufio.NewWriter(lateCount)
+			writers = append(writers, writer)
+			lateEvents = json.NewEncoder(writer)
+		}
+		flush := func() error {
+			for _, writer := range writers {
+				if err := writer.Flush(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		defer func() {
+			if flushErr := flush(); err == nil {
+				err = flushErr
+			}
+		}()
+
+		validator := models.NewValidator(config.Validation)
+		buffer := newReorderBuffer(config.Reorder)
+		restored := make([]*models.Request, 0, len(from.Buffered))
+		for _, line := range from.Buffered {
+			request, err := models.NewRequest(line)
+			if err != nil {
+				return fmt.Errorf("checkpoint: %w", err)
+			}
+			restored = append(restored, request)
+		}
+		buffer.restore(restored, from.Watermark)
+		seq := 0
+		emit := func(request *models.Request, rejectLate bool) {
+			// add the request to the request channel
+			jobC <- &Job{Seq: seq, Request: request, Late: rejectLate}
+			seq++
+		}
+		checkpoint := func(inputOffset int64) error {
+			if err := flush(); err != nil {
+				return err
+			}
+			checkpoint := &Checkpoint{
+				InputOffset:      inputOffset,
+				OutputOffset:     from.OutputOffset,
+				DeadLetterOffset: deadLetterCount.offset,
+				LateOffset:       lateCount.offset,
+				Summary:          Summary{Lines: summary.Lines, Malformed: summary.Malformed, Late: summary.Late, Invalid: summary.Invalid},
+				Watermark:        buffer.watermark,
+			}
+			for _, request := range buffer.buffered() {
+				line, err := json.Marshal(request)
+				if err != nil {
+					return err
+				}
+				checkpoint.Buffered = append(checkpoint.Buffered, string(line))
+			}
+			jobC <- &Job{Seq: seq, barrier: newBarrier(checkpoint)}
+			return nil
+		}
+		checkpointEvery := 0
+		if config.Checkpoint.File != "" {
+			checkpointEvery = config.Checkpoint.Every
+		}
+		checkpointLines := summary.Lines
+		// a fresh run checkpoints the cache it starts from, so a run killed
+		// before the next checkpoint does not resume with what it changed
+		if checkpointEvery > 0 && !resumed {
+			if err := checkpoint(from.InputOffset); err != nil {
+				return err
+			}
+		}
+
+		// count the bytes the lines take up, separators included
+		inputOffset := from.InputOffset
+		scanner := bufio.NewScanner(input)
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			advance, token, err := bufio.ScanLines(data, atEOF)
+			inputOffset += int64(advance)
+			return advance, token, err
+		})
+		lineOffset := inputOffset
+		for scanner.Scan() {
+			// everything before this line has been handled
+			if ctx.Err() != nil {
+				logger.Infof("Stopped reading the input after line %d", summary.Lines)
+				if checkpointEvery > 0 {
+					// the buffered requests are kept in the checkpoint
+					return checkpoint(lineOffset)
+				}
+				break
+			}
+			if checkpointEvery > 0 && summary.Lines-checkpointLines >= checkpointEvery {
+				if err := checkpoint(lineOffset); err != nil {
+					return err
+				}
+				checkpointLines = summary.Lines
+			}
+			lineOffset = inputOffset
+			summary.Lines++
+			stats.RequestRead()
+			start := time.Now()
+			line := scanner.Text()
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			request, err := validator.Parse(line)
+			var invalid *models.InvalidError
+			if errors.As(err, &invalid) {
+				// answered at once, its time may not even be known
+				summary.Invalid++
+				logger.Infof("Declining line %d as invalid: %v", summary.Lines, invalid.Err)
+				jobC <- &Job{Seq: seq, Request: invalid.Request, Invalid: true}
+				seq++
+				continue
+			}
+			if err != nil {
+				summary.Malformed++
+				stats.ParseError()
+				if deadLetters != nil {
+					if err := deadLetters.Encode(DeadLetter{Line: summary.Lines, Error: err.Error(), Input: line}); err != nil {
+						logger.Errorf("Error writing dead letter:%v", err)
+						return err
+					}
+				}
+				if errorRateExceeded(config.Pipeline, summary) {
+					return fmt.Errorf("%w: %d of %d lines", ErrErrorRateExceeded, summary.Malformed, summary.Lines)
+				}
+				continue
+			}
+			ready, isLate := buffer.push(request)
+			stats.ObserveStage(metrics.StageGetRequest, start)
+			if isLate {
+				summary.Late++
+				stats.Late()
+				switch {
+				case rejectsLate(config.Reorder):
+					emit(request, true)
+				case routesLate(config.Reorder):
+					if lateEvents != nil {
+						if err := lateEvents.Encode(LateEvent{Line: summary.Lines, Watermark: buffer.watermark, Input: line}); err != nil {
+							logger.Errorf("Error writing late event:%v", err)
+							return err
+						}
+					}
+				default:
+					emit(request, false)
+				}
+			}
+			for _, request := range ready {
+				emit(request, false)
+			}
+		}
+		// error reading file
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		for _, request := range buffer.flush() {
+			emit(request, false)
+		}
+		return nil
+	}
+	return jobC, parser
+}
+
+// errorRateExceeded reports whether the malformed lines are over the
+// configured percentage of the lines read.
+func errorRateExceeded(pipeline config.Pipeline, summary *Summary) bool {
+	if pipeline.MaxErrorRate <= 0 || summary.Lines < pipeline.ErrorRateMinLines {
+		return false
+	}
+	return float64(summary.Malformed)*100 > pipeline.MaxErrorRate*float64(summary.Lines)
+}
+
+func rejectsLate(reorder config.Reorder) bool {
+	return reorder.LatePolicy == config.LatePolicyReject
+}
+
+func routesLate(reorder config.Reorder) bool {
+	return reorder.LatePolicy == config.LatePolicyFile
+}
+
+// AttemptLoad fans the requests out to a pool of workers that validate and
+// attempt each load. Requests are sharded by customer ID, so every request of
+// a customer is handled by the same worker in input order and no two workers
+// ever touch the same account. Loads are evaluated by engine, the latency and
+// queued jobs are recorded in stats, which may be nil.
+//
+// The workers stop once ctx is done. Whatever is still sent on jobC after
+// that is dropped, so the stage before never blocks.
+func AttemptLoad(ctx context.Context, config *config.Configurations, jobC <-chan *Job, engine *engine.Engine, stats *metrics.Metrics) (<-chan *Result, func() error) {
+	workers := config.Pipeline.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	queueSize := config.Pipeline.QueueSize
+	if queueSize < 1 {
+		queueSize = defaultQueueSize
+	}
+	checkpointer, _ := engine.Store().(Checkpointer)
+	auditLog, _ := engine.Auditor().(AuditLog)
+	resultC := make(chan *Result, workers)
+	shards := make([]chan *Job, workers)
+	for i := range shards {
+		shards[i] = make(chan *Job, queueSize)
+	}
+	stats.WatchBacklog("jobs", func() int {
+		backlog := 0
+		for _, shardC := range shards {
+			backlog += len(shardC)
+		}
+		return backlog
+	})
+	stats.WatchBacklog("results", func() int { return len(resultC) })
+	attemptLoader := func() error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		workerGroup := errgroup.Group{}
+		for i := range shards {
+			shardC := shards[i]
+			workerGroup.Go(func() error {
+				for job := range shardC {
+					if job.barrier != nil {
+						if err := sendResult(ctx, resultC, &Result{Seq: job.Seq, barrier: job.barrier}); err != nil {
+							return err
+						}
+						select {
+						case <-job.barrier.done:
+						case <-ctx.Done():
+							return ctx.Err()
+						}
+						continue
+					}
+					start := time.Now()
+					var response *models.Response
+					var err error
+					switch {
+					case job.Late:
+						// audited, but nothing is loaded
+						response, err = engine.Decline(ctx, job.Request, models.NewLateDecision())
+					case job.Invalid:
+						response, err = engine.Decline(ctx, job.Request, models.NewInvalidDecision())
+					default:
+						// attempt to load
+						response, err = engine.Evaluate(ctx, job.Request)
+					}
+					if err != nil {
+						return err
+					}
+					stats.ObserveStage(metrics.StageAttemptLoad, start)
+					// adds the response to the response channel
+					if err := sendResult(ctx, resultC, &Result{Seq: job.Seq, Response: response}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+		err := dispatch(ctx, config, jobC, shards, checkpointer, auditLog, engine.Logger())
+		if err != nil {
+			cancel()
+		}
+		for _, shardC := range shards {
+			close(shardC)
+		}
+		if workersErr := workerGroup.Wait(); err == nil {
+			err = workersErr
+		}
+		// close the response channel
+		close(resultC)
+		// drop whatever is still sent, so the reader is not left blocked
+		go func() {
+			for range jobC {
+			}
+		}()
+		return err
+	}
+	return resultC, attemptLoader
+}
+
+// Responder writes the responses to output and counts them in summary and
+// stats, which may be nil. With Pipeline.PreserveOrder set responses are
+// written in input order, otherwise as soon as they complete. It stops once
+// ctx is done, the responses written until then are flushed. Errors are
+// logged to logger.
+func Responder(ctx context.Context, config *config.Configurations, output io.Writer, resultC <-chan *Result, summary *Summary, stats *metrics.Metrics, logger logrus.FieldLogger) func() error {
+	responder := func() (err error) {
+		written := &countingWriter{w: output}
+		writer := bufio.NewWriter(written)
+		defer func() {
+			if flushErr := writer.Flush(); err == nil {
+				err = flushErr
+			}
+		}()
+		write := func(response *models.Response) error {
+			start := time.Now()
+			defer stats.ObserveStage(metrics.StageResponder, start)
+			if response.Accepted {
+				summary.Accepted++
+			} else {
+				summary.Declined++
+			}
+			if response.Decision != nil {
+				stats.Decision(*response.Decision)
+			}
+			resBytes, err := response.MarshalLine(config.Output.IncludeReason)
+			if err != nil {
+				logger.Errorf("Error marshalling json:%v", err)
+				return err
+			}
+			// write to file
+			if _, err = writer.WriteString(string(resBytes) + "\n"); err != nil {
+				logger.Errorf("Error writing to file file:%v", err)
+				return err
+			}
+			return nil
+		}
+
+		// results that completed ahead of an earlier one, keyed by Seq
+		pending := make(map[int]*models.Response)
+		next := 0
+		for {
+			var result *Result
+			var ok bool
+			select {
+			case result, ok = <-resultC:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if !ok {
+				return nil
+			}
+			if b := result.barrier; b != nil {
+				// once every worker is at the barrier, every job before it
+				// has been answered
+				if b.arrived++; b.arrived < b.workers {
+					continue
+				}
+				if err := writer.Flush(); err != nil {
+					return err
+				}
+				b.checkpoint.OutputOffset += written.offset
+				b.checkpoint.Summary.Accepted = summary.Accepted
+				b.checkpoint.Summary.Declined = summary.Declined
+				close(b.flushed)
+				continue
+			}
+			if !config.Pipeline.PreserveOrder {
+				if err := write(result.Response); err != nil {
+					return err
+				}
+				continue
+			}
+			pending[result.Seq] = result.Response
+			for response, ok := pending[next]; ok; response, ok = pending[next] {
+				if err := write(response); err != nil {
+					return err
+				}
+				delete(pending, next)
+				next++
+			}
+		}
+	}
+
+	return responder
+}
+
+// Run wires the three stages together and waits for them to finish. The
+// summary covers whatever was processed, also when an error is returned.
+//
+// Once ctx is done no more input is read, the requests read until then are
+// still answered and the output flushed, and ctx.Err() is returned. An error
+// in any stage stops the others.
+//
+// A run resumed from a checkpoint, which may be nil, restores the store of
+// the engine and the summary it recorded and must be given the input from
+// its InputOffset on. The output, dead letter, late and audit files must have
+// been cut back to its offsets.
+func Run(ctx context.Context, config *config.Configurations, input io.Reader, sinks Sinks, engine *engine.Engine, from *Checkpoint) (Summary, error) {
+	var summary Summary
+	if config.Checkpoint.File != "" || from != nil {
+		checkpointer, ok := engine.Store().(Checkpointer)
+		if !ok {
+			return summary, ErrNotCheckpointable
+		}
+		if from != nil {
+			if err := checkpointer.Restore(from.Cache); err != nil {
+				return summary, err
+			}
+			summary = from.Summary
+		}
+	}
+	if sizer, ok := engine.Store().(metrics.CacheSizer); ok {
+		sinks.Metrics.WatchCache(sizer)
+	}
+	// the stages are stopped by an error in any of them, reading also by ctx
+	errGroup, abortCtx := errgroup.WithContext(context.Background())
+	readCtx, stopReading := context.WithCancel(ctx)
+	defer stopReading()
+	go func() {
+		select {
+		case <-abortCtx.Done():
+			stopReading()
+		case <-readCtx.Done():
+		}
+	}()
+	// go routine to read the file
+	jobC, getRequest := GetRequest(readCtx, config, input, sinks.DeadLetter, sinks.Late, &summary, sinks.Metrics, from, engine.Logger())
+	errGroup.Go(getRequest)
+	// go routines to attempt load and validate
+	resultC, attemptLoad := AttemptLoad(abortCtx, config, jobC, engine, sinks.Metrics)
+	errGroup.Go(attemptLoad)
+	// go routine to write the response back to file
+	errGroup.Go(Responder(abortCtx, config, sinks.Output, resultC, &summary, sinks.Metrics, engine.Logger()))
+	if err := errGroup.Wait(); err != nil {
+		return summary, err
+	}
+	return summary, ctx.Err()
+}
+
+// saveCheckpoint adds the state of the cache to the checkpoint and writes it.
+func saveCheckpoint(path string, checkpoint *Checkpoint, checkpointer Checkpointer, logger logrus.FieldLogger) error {
+	if checkpointer == nil {
+		return ErrNotCheckpointable
+	}
+	checkpoint.Cache = checkpointer.State()
+	if err := writeCheckpoint(path, checkpoint); err != nil {
+		return err
+	}
+	logger.Debugf("Checkpoint at line %d, input offset %d", checkpoint.Summary.Lines, checkpoint.InputOffset)
+	return nil
+}
+
+// dispatch sends each job to the worker of its customer until jobC is closed.
+// A checkpoint is passed to every worker and saved once the Responder has
+// flushed the responses before it, with the offset of auditLog, which may be
+// nil.
+func dispatch(ctx context.Context, config *config.Configurations, jobC <-chan *Job, shards []chan *Job, checkpointer Checkpointer, auditLog AuditLog, logger logrus.FieldLogger) error {
+	for {
+		var job *Job
+		var ok bool
+		select {
+		case job, ok = <-jobC:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if !ok {
+			return nil
+		}
+		if job.barrier == nil {
+			if err := send(ctx, shards[shard(job.Request.CustomerID, len(shards))], job); err != nil {
+				return err
+			}
+			continue
+		}
+		// every worker stops at the barrier, then the Responder flushes
+		job.barrier.workers = len(shards)
+		for _, shardC := range shards {
+			if err := send(ctx, shardC, job); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-job.barrier.flushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if auditLog != nil {
+			// the workers wait at the barrier, nothing is being audited
+			offset := auditLog.Offset()
+			job.barrier.checkpoint.AuditOffset = &offset
+		}
+		if err := saveCheckpoint(config.Checkpoint.File, job.barrier.checkpoint, checkpointer, logger); err != nil {
+			logger.Errorf("Error writing checkpoint:%v", err)
+			return err
+		}
+		close(job.barrier.done)
+	}
+}
+
+// send sends the job on jobC unless ctx is done first.
+func send(ctx context.Context, jobC chan<- *Job, job *Job) error {
+	select {
+	case jobC <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendResult sends the result on resultC unless ctx is done first.
+func sendResult(ctx context.Context, resultC chan<- *Result, result *Result) error {
+	select {
+	case resultC <- result:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shard picks the worker for a customer.
+func shard(customerID string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(customerID))
+	return int(h.Sum32() % uint32(workers))
+}
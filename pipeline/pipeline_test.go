@@ -0,0 +1,593 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"velocitylimits/audit"
+	"velocitylimits/cache"
+	"velocitylimits/config"
+	"velocitylimits/engine"
+	"velocitylimits/generator"
+	"velocitylimits/metrics"
+	"velocitylimits/models"
+	"velocitylimits/service"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConfig(workers int, preserveOrder bool) *config.Configurations {
+	return &config.Configurations{
+		VelocityLimit: config.VelocityLimit{
+			MaxDailyLoadLimit:    models.Dollars(5000),
+			MaxDailyTransactions: 3,
+			MaxWeeklyLoadLimit:   models.Dollars(20000),
+		},
+		Pipeline: config.Pipeline{Workers: workers, PreserveOrder: preserveOrder},
+	}
+}
+
+// run runs the pipeline with an engine over the store.
+func run(ctx context.Context, config *config.Configurations, input io.Reader, sinks Sinks, store service.Cache, from *Checkpoint) (Summary, error) {
+	return Run(ctx, config, input, sinks, engine.New(engine.WithConfig(config), engine.WithStore(store)), from)
+}
+
+// recordingAuditor keeps the records it is given. The workers of a run share
+// it.
+type recordingAuditor struct {
+	mu      sync.Mutex
+	records []audit.Record
+}
+
+func (a *recordingAuditor) Record(record audit.Record) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records = append(a.records, record)
+}
+
+// generateInput returns n load requests spread over the given number of
+// customers, one minute apart.
+func generateInput(n, customers int) []byte {
+	random := rand.New(rand.NewSource(1))
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	var input bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&input, `{"id":"%d","customer_id":"%d","load_amount":"%s","time":"%s"}`+"\n",
+			i, random.Intn(customers), models.Cents(random.Int63n(400000)), start.Add(time.Duration(i)*time.Minute).Format(time.RFC3339))
+	}
+	return input.Bytes()
+}
+
+func TestGetRequest(t *testing.T) {
+	t.Run("sends each line as a numbered job", func(t *testing.T) {
+		jobC, getRequest := GetRequest(context.Background(), newTestConfig(1, true), bytes.NewReader(generateInput(3, 2)), nil, nil, &Summary{}, nil, nil, logrus.StandardLogger())
+		errC := make(chan error)
+		go func() { errC <- getRequest() }()
+		seq := 0
+		for job := range jobC {
+			assert.Equal(t, seq, job.Seq)
+			assert.Equal(t, fmt.Sprint(seq), job.Request.ID)
+			seq++
+		}
+		assert.Equal(t, 3, seq)
+		assert.NoError(t, <-errC)
+	})
+	t.Run("dead letters malformed lines and keeps going", func(t *testing.T) {
+		input := `{"id":"1","customer_id":"1","load_amount":"$1","time":"2000-01-01T00:00:00Z"}
+not json
+{"id":"2","customer_id":"1","load_amount":"$abc","time":"2000-01-01T00:00:00Z"}
+
+{"id":"3","customer_id":"1","load_amount":"$1","time":"yesterday"}
+{"id":"4","load_amount":"$1","time":"2000-01-01T00:00:00Z"}
+{"id":"5","customer_id":"1","load_amount":"$1","time":"2000-01-01T00:00:00Z"}
+`
+		var deadLetter bytes.Buffer
+		var summary Summary
+		jobC, getRequest := GetRequest(context.Background(), newTestConfig(1, true), strings.NewReader(input), &deadLetter, nil, &summary, nil, nil, logrus.StandardLogger())
+		errC := make(chan error)
+		go func() { errC <- getRequest() }()
+		var ids []string
+		for job := range jobC {
+			assert.Equal(t, len(ids), job.Seq)
+			ids = append(ids, job.Request.ID)
+		}
+		require.NoError(t, <-errC)
+		assert.Equal(t, []string{"1", "5"}, ids)
+		assert.Equal(t, Summary{Lines: 7, Malformed: 4}, summary)
+
+		var letters []DeadLetter
+		decoder := json.NewDecoder(&deadLetter)
+		for decoder.More() {
+			var letter DeadLetter
+			require.NoError(t, decoder.Decode(&letter))
+			letters = append(letters, letter)
+		}
+		require.Len(t, letters, 4)
+		assert.Equal(t, DeadLetter{Line: 2, Error: letters[0].Error, Input: "not json"}, letters[0])
+		assert.Equal(t, 3, letters[1].Line)
+		assert.Contains(t, letters[1].Error, "load_amount")
+		assert.Equal(t, 5, letters[2].Line)
+		assert.Contains(t, letters[2].Error, "time")
+		assert.Equal(t, 6, letters[3].Line)
+		assert.Contains(t, letters[3].Error, "customer_id")
+	})
+	t.Run("aborts once the error rate is exceeded", func(t *testing.T) {
+		config := newTestConfig(1, true)
+		config.Pipeline.MaxErrorRate = 10
+		config.Pipeline.ErrorRateMinLines = 10
+		input := string(generateInput(9, 2)) + "not json\n" + "not json\n" + string(generateInput(100, 2))
+		var summary Summary
+		jobC, getRequest := GetRequest(context.Background(), config, strings.NewReader(input), nil, nil, &summary, nil, nil, logrus.StandardLogger())
+		errC := make(chan error)
+		go func() { errC <- getRequest() }()
+		jobs := 0
+		for range jobC {
+			jobs++
+		}
+		err := <-errC
+		assert.True(t, errors.Is(err, ErrErrorRateExceeded))
+		assert.Equal(t, 9, jobs)
+		assert.Equal(t, Summary{Lines: 11, Malformed: 2}, summary)
+	})
+	t.Run("does not check the error rate before the minimum lines", func(t *testing.T) {
+		config := newTestConfig(1, true)
+		config.Pipeline.MaxErrorRate = 10
+		config.Pipeline.ErrorRateMinLines = 100
+		var summary Summary
+		jobC, getRequest := GetRequest(context.Background(), config, strings.NewReader("not json\n"+string(generateInput(20, 2))), nil, nil, &summary, nil, nil, logrus.StandardLogger())
+		errC := make(chan error)
+		go func() { errC <- getRequest() }()
+		for range jobC {
+		}
+		assert.NoError(t, <-errC)
+		assert.Equal(t, Summary{Lines: 21, Malformed: 1}, summary)
+	})
+	t.Run("handles late requests by the late policy", func(t *testing.T) {
+		input := `{"id":"1","customer_id":"1","load_amount":"$1","time":"2000-01-01T00:05:00Z"}
+{"id":"2","customer_id":"1","load_amount":"$1","time":"2000-01-01T00:01:00Z"}
+{"id":"3","customer_id":"1","load_amount":"$1","time":"2000-01-01T00:30:00Z"}
+{"id":"4","customer_id":"1","load_amount":"$1","time":"2000-01-01T00:10:00Z"}
+`
+		tests := []struct {
+			policy string
+			ids    []string
+			late   []bool
+		}{
+			{config.LatePolicyReprocess, []string{"2", "1", "4", "3"}, []bool{false, false, false, false}},
+			{config.LatePolicyReject, []string{"2", "1", "4", "3"}, []bool{false, false, true, false}},
+			{config.LatePolicyFile, []string{"2", "1", "3"}, []bool{false, false, false}},
+		}
+		for _, test := range tests {
+			t.Run(test.policy, func(t *testing.T) {
+				testConfig := newTestConfig(1, true)
+				testConfig.Reorder.Lateness = 10 * time.Minute
+				testConfig.Reorder.LatePolicy = test.policy
+				var late bytes.Buffer
+				var summary Summary
+				jobC, getRequest := GetRequest(context.Background(), testConfig, strings.NewReader(input), nil, &late, &summary, nil, nil, logrus.StandardLogger())
+				errC := make(chan error)
+				go func() { errC <- getRequest() }()
+				var ids []string
+				var lates []bool
+				for job := range jobC {
+					assert.Equal(t, len(ids), job.Seq)
+					ids = append(ids, job.Request.ID)
+					lates = append(lates, job.Late)
+				}
+				require.NoError(t, <-errC)
+				assert.Equal(t, test.ids, ids)
+				assert.Equal(t, test.late, lates)
+				assert.Equal(t, Summary{Lines: 4, Late: 1}, summary)
+				if test.policy != config.LatePolicyFile {
+					assert.Zero(t, late.Len())
+					return
+				}
+				var event LateEvent
+				require.NoError(t, json.Unmarshal(late.Bytes(), &event))
+				assert.Equal(t, 4, event.Line)
+				assert.Equal(t, time.Date(2000, 1, 1, 0, 20, 0, 0, time.UTC), event.Watermark)
+				assert.Equal(t, strings.Split(input, "\n")[3], event.Input)
+			})
+		}
+	})
+}
+
+func TestAttemptLoad(t *testing.T) {
+	t.Run("workers make the same decisions as a single worker", func(t *testing.T) {
+		input := generateInput(5000, 50)
+		expected := decisionsByID(t, newTestConfig(1, false), input)
+		actual := decisionsByID(t, newTestConfig(8, false), input)
+		assert.Equal(t, expected, actual)
+	})
+}
+
+func TestResponder(t *testing.T) {
+	results := []*Result{
+		{Seq: 2, Response: models.NewResponse("c", "1", true)},
+		{Seq: 0, Response: models.NewResponse("a", "1", true)},
+		{Seq: 1, Response: models.NewResponse("b", "1", false)},
+	}
+	send := func() <-chan *Result {
+		resultC := make(chan *Result, len(results))
+		for _, result := range results {
+			resultC <- result
+		}
+		close(resultC)
+		return resultC
+	}
+	t.Run("writes in input order when preserving order", func(t *testing.T) {
+		var output bytes.Buffer
+		var summary Summary
+		require.NoError(t, Responder(context.Background(), newTestConfig(1, true), &output, send(), &summary, nil, logrus.StandardLogger())())
+		assert.Equal(t, Summary{Accepted: 2, Declined: 1}, summary)
+		assert.Equal(t, `{"id":"a","customer_id":"1","accepted":true}
+{"id":"b","customer_id":"1","accepted":false}
+{"id":"c","customer_id":"1","accepted":true}
+`, output.String())
+	})
+	t.Run("writes as completed otherwise", func(t *testing.T) {
+		var output bytes.Buffer
+		require.NoError(t, Responder(context.Background(), newTestConfig(1, false), &output, send(), &Summary{}, nil, logrus.StandardLogger())())
+		assert.Equal(t, `{"id":"c","customer_id":"1","accepted":true}
+{"id":"a","customer_id":"1","accepted":true}
+{"id":"b","customer_id":"1","accepted":false}
+`, output.String())
+	})
+}
+
+func TestRun(t *testing.T) {
+	t.Run("ordered output matches a single worker byte for byte", func(t *testing.T) {
+		input := generateInput(5000, 50)
+		var expected, actual bytes.Buffer
+		_, err := run(context.Background(), newTestConfig(1, true), bytes.NewReader(input), Sinks{Output: &expected}, cache.NewCache(), nil)
+		require.NoError(t, err)
+		_, err = run(context.Background(), newTestConfig(8, true), bytes.NewReader(input), Sinks{Output: &actual}, cache.NewCache(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, expected.String(), actual.String())
+	})
+	t.Run("unordered output has the same lines", func(t *testing.T) {
+		input := generateInput(5000, 50)
+		var expected, actual bytes.Buffer
+		_, err := run(context.Background(), newTestConfig(1, true), bytes.NewReader(input), Sinks{Output: &expected}, cache.NewCache(), nil)
+		require.NoError(t, err)
+		_, err = run(context.Background(), newTestConfig(8, false), bytes.NewReader(input), Sinks{Output: &actual}, cache.NewCache(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, sortedLines(expected.String()), sortedLines(actual.String()))
+	})
+	t.Run("answers the valid lines around malformed ones", func(t *testing.T) {
+		input := "not json\n" + string(generateInput(100, 5))
+		var output, deadLetter bytes.Buffer
+		summary, err := run(context.Background(), newTestConfig(4, true), strings.NewReader(input), Sinks{Output: &output, DeadLetter: &deadLetter}, cache.NewCache(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, 101, summary.Lines)
+		assert.Equal(t, 1, summary.Malformed)
+		assert.Equal(t, 100, summary.Accepted+summary.Declined)
+		assert.Len(t, sortedLines(output.String()), 100)
+		assert.Contains(t, deadLetter.String(), `"line":1`)
+	})
+	t.Run("declines rejected late requests without loading them", func(t *testing.T) {
+		config := newTestConfig(4, true)
+		config.Output.IncludeReason = true
+		config.Reorder.LatePolicy = "reject"
+		input := `{"id":"1","customer_id":"1","load_amount":"$1","time":"2000-01-01T00:05:00Z"}
+{"id":"2","customer_id":"1","load_amount":"$1","time":"2000-01-01T00:01:00Z"}
+`
+		var output bytes.Buffer
+		cache := cache.NewCache()
+		auditor := &recordingAuditor{}
+		engine := engine.New(engine.WithConfig(config), engine.WithStore(cache), engine.WithAuditor(auditor))
+		summary, err := Run(context.Background(), config, strings.NewReader(input), Sinks{Output: &output}, engine, nil)
+		require.NoError(t, err)
+		assert.Equal(t, Summary{Lines: 2, Late: 1, Accepted: 1, Declined: 1}, summary)
+		assert.Contains(t, output.String(), `{"id":"2","customer_id":"1","accepted":false,"reason":"late"}`)
+		assert.False(t, cache.IsDuplicateTransaction("2", "1"))
+
+		// the decline is audited like any other
+		require.Len(t, auditor.records, 2)
+		assert.Equal(t, models.ReasonLate, auditor.records[1].Decision.Reason)
+		assert.Equal(t, "2", auditor.records[1].Request.ID)
+		assert.Equal(t, auditor.records[0].After, auditor.records[1].Before)
+		assert.Equal(t, auditor.records[1].Before, auditor.records[1].After)
+	})
+	t.Run("declines invalid requests without loading them", func(t *testing.T) {
+		config := newTestConfig(4, true)
+		config.Output.IncludeReason = true
+		config.Validation = models.ValidationRules{Strict: true, RequireCurrency: true}
+		config.Pipeline.MaxErrorRate = 30
+		input := `{"id":"1","customer_id":"1","load_amount":"100","time":"2000-01-01T00:00:00Z"}
+{"id":"2","customer_id":"1","load_amount":"$1","time":"2000-01-01T00:01:00Z"}
+{"id":"3","customer_id":"1","load_amount":"-$1","time":"2000-01-01T00:02:00Z"}
+{"id":"4","customer_id":"1","load_amount":"lots","time":"2000-01-01T00:03:00Z"}
+`
+		var output, deadLetter bytes.Buffer
+		cache := cache.NewCache()
+		auditor := &recordingAuditor{}
+		stats := metrics.New()
+		engine := engine.New(engine.WithConfig(config), engine.WithStore(cache), engine.WithAuditor(auditor))
+		summary, err := Run(context.Background(), config, strings.NewReader(input), Sinks{Output: &output, DeadLetter: &deadLetter, Metrics: stats}, engine, nil)
+		require.NoError(t, err)
+		assert.Equal(t, Summary{Lines: 4, Malformed: 1, Invalid: 2, Accepted: 1, Declined: 2}, summary)
+		assert.Equal(t, `{"id":"1","customer_id":"1","accepted":false,"reason":"invalid"}
+{"id":"2","customer_id":"1","accepted":true,"reason":"accepted"}
+{"id":"3","customer_id":"1","accepted":false,"reason":"invalid"}
+`, output.String())
+		assert.Contains(t, deadLetter.String(), `"line":4`)
+		assert.NotContains(t, deadLetter.String(), `"line":1`)
+		assert.False(t, cache.IsDuplicateTransaction("1", "1"))
+
+		// the declines are audited and counted like any other
+		require.Len(t, auditor.records, 3)
+		assert.Equal(t, models.ReasonInvalid, auditor.records[0].Decision.Reason)
+		assert.Nil(t, auditor.records[0].After)
+		assert.Equal(t, models.ReasonInvalid, auditor.records[2].Decision.Reason)
+		assert.Equal(t, auditor.records[1].After, auditor.records[2].Before)
+		assert.Equal(t, auditor.records[2].Before, auditor.records[2].After)
+		assert.Empty(t, auditor.records[2].Transactions)
+		recorder := httptest.NewRecorder()
+		stats.Registry().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Contains(t, recorder.Body.String(), `velocitylimits_declined_total{reason="invalid"} 2`+"\n")
+	})
+	t.Run("logs to the logger of the engine", func(t *testing.T) {
+		config := newTestConfig(1, true)
+		config.Validation = models.ValidationRules{Strict: true, RequireCurrency: true}
+		var logs bytes.Buffer
+		logger := logrus.New()
+		logger.SetOutput(&logs)
+		engine := engine.New(engine.WithConfig(config), engine.WithLogger(logger))
+		input := `{"id":"1","customer_id":"1","load_amount":"100","time":"2000-01-01T00:00:00Z"}` + "\n"
+		_, err := Run(context.Background(), config, strings.NewReader(input), Sinks{Output: ioutil.Discard}, engine, nil)
+		require.NoError(t, err)
+		assert.Contains(t, logs.String(), "Declining line 1 as invalid")
+	})
+	t.Run("counts the run in the metrics", func(t *testing.T) {
+		input := "not json\n" + string(generateInput(100, 5)) + strings.SplitAfter(string(generateInput(1, 5)), "\n")[0]
+		stats := metrics.New()
+		cache := cache.NewCache()
+		summary, err := run(context.Background(), newTestConfig(4, true), strings.NewReader(input), Sinks{Output: ioutil.Discard, Metrics: stats}, cache, nil)
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		stats.Registry().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		body := recorder.Body.String()
+		for _, sample := range []string{
+			"velocitylimits_requests_read_total 102\n",
+			"velocitylimits_parse_errors_total 1\n",
+			fmt.Sprintf("velocitylimits_accepted_total %d\n", summary.Accepted),
+			"velocitylimits_duplicates_total 1\n",
+			`velocitylimits_stage_duration_seconds_count{stage="get_request"} 101` + "\n",
+			`velocitylimits_stage_duration_seconds_count{stage="attempt_load"} 101` + "\n",
+			`velocitylimits_stage_duration_seconds_count{stage="responder"} 101` + "\n",
+			`velocitylimits_channel_backlog{channel="jobs"} 0` + "\n",
+			`velocitylimits_channel_backlog{channel="results"} 0` + "\n",
+			`velocitylimits_cache_size{kind="accounts"} 5` + "\n",
+			`velocitylimits_cache_size{kind="transactions"} 100` + "\n",
+		} {
+			assert.Contains(t, body, sample)
+		}
+	})
+}
+
+func TestRunStops(t *testing.T) {
+	input := generateInput(5000, 50)
+	t.Run("on an error writing the output", func(t *testing.T) {
+		var err error
+		finishes(t, func() {
+			_, err = run(context.Background(), newTestConfig(8, true), bytes.NewReader(input), Sinks{Output: failingWriter{}}, cache.NewCache(), nil)
+		})
+		assert.True(t, errors.Is(err, errWriteFailed), err)
+	})
+	t.Run("on an error reading the input", func(t *testing.T) {
+		var err error
+		finishes(t, func() {
+			reader := &killingReader{r: bytes.NewReader(input), n: len(input) / 2}
+			_, err = run(context.Background(), newTestConfig(8, false), reader, Sinks{Output: ioutil.Discard}, cache.NewCache(), nil)
+		})
+		assert.True(t, errors.Is(err, errKilled), err)
+	})
+	t.Run("when the error rate is exceeded", func(t *testing.T) {
+		config := newTestConfig(8, true)
+		config.Pipeline.MaxErrorRate = 10
+		messy := string(input[:len(input)/2]) + strings.Repeat("not json\n", 1000) + string(input[len(input)/2:])
+		var err error
+		finishes(t, func() {
+			_, err = run(context.Background(), config, strings.NewReader(messy), Sinks{Output: ioutil.Discard}, cache.NewCache(), nil)
+		})
+		assert.True(t, errors.Is(err, ErrErrorRateExceeded), err)
+	})
+	t.Run("on an error writing a checkpoint", func(t *testing.T) {
+		config := newTestConfig(8, true)
+		config.Checkpoint.File = filepath.Join(newCheckpointDir(t), "missing", "checkpoint.json")
+		config.Checkpoint.Every = 100
+		var err error
+		finishes(t, func() {
+			_, err = run(context.Background(), config, bytes.NewReader(input), Sinks{Output: ioutil.Discard}, cache.NewCache(), nil)
+		})
+		var pathErr *os.PathError
+		assert.True(t, errors.As(err, &pathErr), err)
+	})
+	t.Run("reading when canceled and answers what was read", func(t *testing.T) {
+		for _, workers := range []int{1, 8} {
+			var expected bytes.Buffer
+			_, err := run(context.Background(), newTestConfig(workers, true), bytes.NewReader(input), Sinks{Output: &expected}, cache.NewCache(), nil)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var output bytes.Buffer
+			var summary Summary
+			finishes(t, func() {
+				reader := &cancelingReader{r: bytes.NewReader(input), n: len(input) / 2, cancel: cancel}
+				summary, err = run(ctx, newTestConfig(workers, true), reader, Sinks{Output: &output}, cache.NewCache(), nil)
+			})
+			assert.Equal(t, context.Canceled, err)
+			assert.True(t, summary.Lines > 0 && summary.Lines < 5000, summary.Lines)
+			// every request read was answered, the output is flushed
+			assert.Equal(t, summary.Lines, summary.Accepted+summary.Declined)
+			assert.Equal(t, strings.Join(strings.SplitAfter(expected.String(), "\n")[:summary.Lines], ""), output.String())
+		}
+	})
+	t.Run("when canceled and resumes from the checkpoint", func(t *testing.T) {
+		config := newTestConfig(8, true)
+		config.Reorder.Lateness = time.Minute
+		var expected bytes.Buffer
+		expectedSummary, err := run(context.Background(), config, bytes.NewReader(input), Sinks{Output: &expected}, cache.NewCache(), nil)
+		require.NoError(t, err)
+
+		config.Checkpoint.File = filepath.Join(newCheckpointDir(t), "checkpoint.json")
+		config.Checkpoint.Every = 1000
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		cache := cache.NewCache()
+		var output bytes.Buffer
+		finishes(t, func() {
+			reader := &cancelingReader{r: bytes.NewReader(input), n: len(input) / 3, cancel: cancel}
+			_, err = run(ctx, config, reader, Sinks{Output: &output}, cache, nil)
+		})
+		require.Equal(t, context.Canceled, err)
+		from, err := ReadCheckpoint(config.Checkpoint.File)
+		require.NoError(t, err)
+		require.NotNil(t, from)
+		// the run checkpoints where it stopped, not at the last multiple
+		assert.Equal(t, int64(output.Len()), from.OutputOffset)
+		summary, err := run(context.Background(), config, bytes.NewReader(input[from.InputOffset:]), Sinks{Output: &output}, cache, from)
+		require.NoError(t, err)
+		assert.Equal(t, expected.String(), output.String())
+		assert.Equal(t, expectedSummary, summary)
+	})
+	t.Run("before reading when already canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var output bytes.Buffer
+		var summary Summary
+		var err error
+		finishes(t, func() {
+			summary, err = run(ctx, newTestConfig(8, true), bytes.NewReader(input), Sinks{Output: &output}, cache.NewCache(), nil)
+		})
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, Summary{}, summary)
+		assert.Empty(t, output.String())
+	})
+}
+
+// finishes fails the test if run does not return in time, so a stage left
+// blocked does not hang the tests.
+func finishes(t *testing.T, run func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the run did not stop")
+	}
+}
+
+var errWriteFailed = errors.New("write failed")
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errWriteFailed
+}
+
+// cancelingReader cancels once n bytes have been read, like a signal arriving
+// part way through the input, and goes on reading.
+type cancelingReader struct {
+	r      io.Reader
+	n      int
+	cancel context.CancelFunc
+}
+
+func (c *cancelingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if c.n -= n; c.n <= 0 {
+		c.cancel()
+	}
+	return n, err
+}
+
+func TestShard(t *testing.T) {
+	t.Run("a customer always lands on the same worker", func(t *testing.T) {
+		assert.Equal(t, shard("528", 8), shard("528", 8))
+		for i := 0; i < 100; i++ {
+			assert.True(t, shard(fmt.Sprint(i), 8) < 8)
+		}
+	})
+}
+
+func BenchmarkRun(b *testing.B) {
+	input := generateInput(200000, 10000)
+	for _, workers := range []int{1, 2, 4, runtime.NumCPU()} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			config := newTestConfig(workers, true)
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				if _, err := run(context.Background(), config, bytes.NewReader(input), Sinks{Output: ioutil.Discard}, cache.NewCache(), nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRunGenerated runs a skewed input with duplicate, malformed and
+// out of order lines.
+func BenchmarkRunGenerated(b *testing.B) {
+	options := generator.DefaultOptions()
+	options.Lines, options.Customers = 200000, 10000
+	options.DuplicateRate, options.MalformedRate, options.OutOfOrderRate = 1, 0.1, 2
+	options.Span = 30 * 24 * time.Hour
+	var input bytes.Buffer
+	if err := generator.Generate(&input, options); err != nil {
+		b.Fatal(err)
+	}
+	config := newTestConfig(runtime.NumCPU(), true)
+	config.Reorder.Lateness = options.MaxDelay
+	b.SetBytes(int64(input.Len()))
+	for i := 0; i < b.N; i++ {
+		if _, err := run(context.Background(), config, bytes.NewReader(input.Bytes()), Sinks{Output: ioutil.Discard}, cache.NewCache(), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func decisionsByID(t *testing.T, config *config.Configurations, input []byte) map[string]bool {
+	jobC, getRequest := GetRequest(context.Background(), config, bytes.NewReader(input), nil, nil, &Summary{}, nil, nil, logrus.StandardLogger())
+	resultC, attemptLoad := AttemptLoad(context.Background(), config, jobC, engine.New(engine.WithConfig(config)), nil)
+	errC := make(chan error, 2)
+	go func() { errC <- getRequest() }()
+	go func() { errC <- attemptLoad() }()
+	decisions := make(map[string]bool)
+	for result := range resultC {
+		decisions[result.Response.ID] = result.Response.Accepted
+	}
+	require.NoError(t, <-errC)
+	require.NoError(t, <-errC)
+	return decisions
+}
+
+func sortedLines(s string) []string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	sort.Strings(lines)
+	return lines
+}
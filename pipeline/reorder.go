@@ -0,0 +1,110 @@
+package pipeline
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+
+	"velocitylimits/config"
+	"velocitylimits/models"
+)
+
+// reorderBuffer holds requests back until the watermark passes them, so they
+// are released in time order. The watermark trails the newest time seen by
+// Reorder.Lateness and never moves back. Requests older than the watermark
+// arrive too late to be put in order.
+type reorderBuffer struct {
+	lateness  time.Duration
+	size      int
+	requests  requestHeap
+	watermark time.Time
+	arrivals  int
+}
+
+func newReorderBuffer(reorder config.Reorder) *reorderBuffer {
+	return &reorderBuffer{lateness: reorder.Lateness, size: reorder.BufferSize}
+}
+
+// push adds the request and returns the requests the watermark has passed,
+// in time order. A request behind the watermark is not added and late is
+// set instead.
+func (b *reorderBuffer) push(request *models.Request) (ready []*models.Request, late bool) {
+	if request.ParsedTime.Before(b.watermark) {
+		return nil, true
+	}
+	heap.Push(&b.requests, bufferedRequest{request: request, arrival: b.arrivals})
+	b.arrivals++
+	if watermark := request.ParsedTime.Add(-b.lateness); watermark.After(b.watermark) {
+		b.watermark = watermark
+	}
+	for b.requests.Len() > 0 {
+		next := b.requests[0].request
+		// a full buffer moves the watermark up to its oldest request
+		if b.size > 0 && b.requests.Len() > b.size && next.ParsedTime.After(b.watermark) {
+			b.watermark = next.ParsedTime
+		}
+		if next.ParsedTime.After(b.watermark) {
+			break
+		}
+		ready = append(ready, heap.Pop(&b.requests).(bufferedRequest).request)
+	}
+	return ready, false
+}
+
+// buffered returns the buffered requests in arrival order.
+func (b *reorderBuffer) buffered() []*models.Request {
+	requests := make([]bufferedRequest, len(b.requests))
+	copy(requests, b.requests)
+	sort.Slice(requests, func(i, j int) bool { return requests[i].arrival < requests[j].arrival })
+	buffered := make([]*models.Request, len(requests))
+	for i, r := range requests {
+		buffered[i] = r.request
+	}
+	return buffered
+}
+
+// restore puts back the requests and watermark of a checkpoint.
+func (b *reorderBuffer) restore(requests []*models.Request, watermark time.Time) {
+	for _, request := range requests {
+		heap.Push(&b.requests, bufferedRequest{request: request, arrival: b.arrivals})
+		b.arrivals++
+	}
+	b.watermark = watermark
+}
+
+// flush returns every buffered request in time order.
+func (b *reorderBuffer) flush() []*models.Request {
+	ready := make([]*models.Request, 0, b.requests.Len())
+	for b.requests.Len() > 0 {
+		ready = append(ready, heap.Pop(&b.requests).(bufferedRequest).request)
+	}
+	return ready
+}
+
+type bufferedRequest struct {
+	request *models.Request
+	arrival int
+}
+
+// requestHeap orders requests by time and then by arrival.
+type requestHeap []bufferedRequest
+
+func (h requestHeap) Len() int { return len(h) }
+
+func (h requestHeap) Less(i, j int) bool {
+	if h[i].request.ParsedTime.Equal(h[j].request.ParsedTime) {
+		return h[i].arrival < h[j].arrival
+	}
+	return h[i].request.ParsedTime.Before(h[j].request.ParsedTime)
+}
+
+func (h requestHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *requestHeap) Push(x interface{}) { *h = append(*h, x.(bufferedRequest)) }
+
+func (h *requestHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"velocitylimits/config"
+	"velocitylimits/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReorderBuffer(t *testing.T) {
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	request := func(id string, minutes int) *models.Request {
+		return &models.Request{ID: id, ParsedTime: start.Add(time.Duration(minutes) * time.Minute)}
+	}
+	ids := func(requests []*models.Request) []string {
+		ids := []string{}
+		for _, request := range requests {
+			ids = append(ids, request.ID)
+		}
+		return ids
+	}
+
+	t.Run("passes requests straight through without lateness", func(t *testing.T) {
+		buffer := newReorderBuffer(config.Reorder{})
+		ready, late := buffer.push(request("1", 0))
+		assert.False(t, late)
+		assert.Equal(t, []string{"1"}, ids(ready))
+		ready, late = buffer.push(request("2", 0))
+		assert.False(t, late)
+		assert.Equal(t, []string{"2"}, ids(ready))
+		_, late = buffer.push(request("3", -1))
+		assert.True(t, late)
+		assert.Empty(t, buffer.flush())
+	})
+	t.Run("sorts requests within the lateness", func(t *testing.T) {
+		buffer := newReorderBuffer(config.Reorder{Lateness: 10 * time.Minute})
+		var released []*models.Request
+		for _, r := range []*models.Request{request("a", 5), request("b", 0), request("c", 12), request("d", 3), request("e", 20)} {
+			ready, late := buffer.push(r)
+			assert.False(t, late, r.ID)
+			released = append(released, ready...)
+		}
+		// the watermark is at minute 10
+		assert.Equal(t, []string{"b", "d", "a"}, ids(released))
+		assert.Equal(t, start.Add(10*time.Minute), buffer.watermark)
+		assert.Equal(t, []string{"c", "e"}, ids(buffer.flush()))
+	})
+	t.Run("keeps arrival order for equal times", func(t *testing.T) {
+		buffer := newReorderBuffer(config.Reorder{Lateness: time.Hour})
+		for _, r := range []*models.Request{request("1", 5), request("2", 1), request("3", 5), request("4", 1)} {
+			buffer.push(r)
+		}
+		assert.Equal(t, []string{"2", "4", "1", "3"}, ids(buffer.flush()))
+	})
+	t.Run("restores the buffered requests and watermark", func(t *testing.T) {
+		buffer := newReorderBuffer(config.Reorder{Lateness: time.Hour})
+		for _, r := range []*models.Request{request("1", 5), request("2", 1), request("3", 5)} {
+			buffer.push(r)
+		}
+		assert.Equal(t, []string{"1", "2", "3"}, ids(buffer.buffered()))
+		restored := newReorderBuffer(config.Reorder{Lateness: time.Hour})
+		restored.restore(buffer.buffered(), buffer.watermark)
+		for _, b := range []*reorderBuffer{buffer, restored} {
+			b.push(request("4", 3))
+			ready, _ := b.push(request("5", 65))
+			assert.Equal(t, []string{"2", "4", "1", "3"}, ids(ready))
+		}
+	})
+	t.Run("flags requests behind the watermark as late", func(t *testing.T) {
+		buffer := newReorderBuffer(config.Reorder{Lateness: 10 * time.Minute})
+		buffer.push(request("1", 30))
+		ready, late := buffer.push(request("2", 19))
+		assert.True(t, late)
+		assert.Empty(t, ready)
+		// on the watermark is not late, and released at once
+		ready, late = buffer.push(request("3", 20))
+		assert.False(t, late)
+		assert.Equal(t, []string{"3"}, ids(ready))
+		assert.Equal(t, []string{"1"}, ids(buffer.flush()))
+	})
+	t.Run("a full buffer releases its oldest request early", func(t *testing.T) {
+		buffer := newReorderBuffer(config.Reorder{Lateness: time.Hour, BufferSize: 2})
+		buffer.push(request("1", 10))
+		buffer.push(request("2", 5))
+		ready, _ := buffer.push(request("3", 7))
+		assert.Equal(t, []string{"2"}, ids(ready))
+		assert.Equal(t, start.Add(5*time.Minute), buffer.watermark)
+		// within the lateness, but behind the raised watermark
+		_, late := buffer.push(request("4", 4))
+		assert.True(t, late)
+		assert.Equal(t, []string{"3", "1"}, ids(buffer.flush()))
+	})
+}
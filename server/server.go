@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"velocitylimits/config"
+	"velocitylimits/engine"
+	"velocitylimits/models"
+	"velocitylimits/service"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxBodyBytes caps the size of a load request body.
+const maxBodyBytes = 1 << 20
+
+// errBodyTooLarge is returned for a load request body over maxBodyBytes.
+var errBodyTooLarge = errors.New("request body too large")
+
+// Server authorizes loads over HTTP.
+//
+//	POST /loads                   attempts a load, body is a models.Request,
+//	                              409 for a load conflicting with an earlier one
+//	GET  /customers/{id}/limits   remaining daily and weekly headroom
+//	GET  /customers/{id}/check    decision a load of the "amount" query
+//	                              parameter would get, without making it
+type Server struct {
+	config    *config.Configurations
+	engine    *engine.Engine
+	validator *models.Validator
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewServer returns a server over the engine, configured by its config.
+func NewServer(engine *engine.Engine) *Server {
+	return &Server{
+		config:    engine.Config(),
+		engine:    engine,
+		validator: models.NewValidator(engine.Config().Validation),
+	}
+}
+
+// Handler returns the routes of the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loads", s.handleLoads)
+	mux.HandleFunc("/customers/", s.handleCustomers)
+	return mux
+}
+
+// ListenAndServe serves the API on the configured address until ctx is done.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.config.Server.Address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves the API on listener until ctx is done and then shuts down
+// gracefully, letting in-flight requests finish.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	httpServer := &http.Server{
+		Handler:      s.Handler(),
+		ReadTimeout:  s.config.Server.ReadTimeout,
+		WriteTimeout: s.config.Server.WriteTimeout,
+	}
+	errC := make(chan error, 1)
+	go func() {
+		logrus.Infof("Listening on %s", listener.Addr())
+		errC <- httpServer.Serve(listener)
+	}()
+	select {
+	case err := <-errC:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.Server.ShutdownTimeout)
+	defer cancel()
+	logrus.Infoln("Shutting down server")
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errC; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// handleLoads attempts the load in the request body.
+func (s *Server) handleLoads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	// read a byte past the cap to tell a body over it from one that fails
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(body) > maxBodyBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, errBodyTooLarge)
+		return
+	}
+	request, err := s.validator.Parse(string(body))
+	if err != nil {
+		var fieldErr *models.FieldError
+		if errors.As(err, &fieldErr) {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	response, err := s.engine.Evaluate(r.Context(), request)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if response.Conflicting() {
+		writeError(w, http.StatusConflict, service.ErrConflictingRetry)
+		return
+	}
+
+	line, err := response.MarshalLine(s.config.Output.IncludeReason)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(line)
+}
+
+// handleCustomers routes the customer resources.
+func (s *Server) handleCustomers(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/customers/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	switch parts[1] {
+	case "limits":
+		s.handleLimits(w, r, parts[0])
+	case "check":
+		s.handleCheck(w, r, parts[0])
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// handleLimits reports the headroom of a customer at the time given in the
+// "at" query parameter, or now.
+func (s *Server) handleLimits(w http.ResponseWriter, r *http.Request, customerID string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	at, err := parseAt(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	headroom := s.engine.Headroom(customerID, at)
+	if headroom == nil {
+		writeError(w, http.StatusNotFound, errors.New("customer not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, headroom)
+}
+
+// handleCheck reports the decision a load of the "amount" query parameter by
+// the customer would get at the time given in "at", or now, and the headroom
+// before and after it. Nothing is loaded.
+func (s *Server) handleCheck(w http.ResponseWriter, r *http.Request, customerID string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	at, err := parseAt(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	amount, err := models.ParseMoney(r.URL.Query().Get("amount"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if amount <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("amount must be positive"))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.engine.WhatIf(customerID, amount, at))
+}
+
+// parseAt returns the time of the "at" query parameter, or the zero time
+// without one.
+func parseAt(r *http.Request) (time.Time, error) {
+	param := r.URL.Query().Get("at")
+	if param == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, param)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logrus.Errorf("Error writing response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"velocitylimits/config"
+	"velocitylimits/engine"
+	"velocitylimits/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer() *Server {
+	return NewServer(engine.New(engine.WithConfig(&config.Configurations{
+		VelocityLimit: config.VelocityLimit{
+			MaxDailyLoadLimit:    models.Dollars(5000),
+			MaxDailyTransactions: 3,
+			MaxWeeklyLoadLimit:   models.Dollars(20000),
+		},
+		Server: config.Server{ShutdownTimeout: time.Second},
+	})))
+}
+
+func do(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return recorder
+}
+
+// failingReader fails every read with err, like a client that disconnects
+// while sending the body.
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestHandleLoads(t *testing.T) {
+	t.Run("accepts a load", func(t *testing.T) {
+		recorder := do(newTestServer().Handler(), http.MethodPost, "/loads", `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-01T00:00:00Z"}`)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"id":"1","customer_id":"528","accepted":true}`, recorder.Body.String())
+	})
+	t.Run("declines a load over the daily limit", func(t *testing.T) {
+		handler := newTestServer().Handler()
+		do(handler, http.MethodPost, "/loads", `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-01T00:00:00Z"}`)
+		recorder := do(handler, http.MethodPost, "/loads", `{"id":"2","customer_id":"528","load_amount":"$3000","time":"2000-01-01T01:00:00Z"}`)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{"id":"2","customer_id":"528","accepted":false}`, recorder.Body.String())
+	})
+	t.Run("replays retries and returns 409 for conflicts", func(t *testing.T) {
+		server := newTestServer()
+		server.config.Store.Dedup.Idempotent = true
+		handler := server.Handler()
+		load := `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-01T00:00:00Z"}`
+		do(handler, http.MethodPost, "/loads", load)
+		recorder := do(handler, http.MethodPost, "/loads", load)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{"id":"1","customer_id":"528","accepted":true}`, recorder.Body.String())
+		recorder = do(handler, http.MethodPost, "/loads", `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-01T00:00:01Z"}`)
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "different amount or time")
+	})
+	t.Run("returns 400 for malformed json", func(t *testing.T) {
+		recorder := do(newTestServer().Handler(), http.MethodPost, "/loads", `{"id":`)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "error")
+	})
+	t.Run("returns 422 for an invalid amount", func(t *testing.T) {
+		recorder := do(newTestServer().Handler(), http.MethodPost, "/loads", `{"id":"1","customer_id":"528","load_amount":"$abc","time":"2000-01-01T00:00:00Z"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "load_amount")
+	})
+	t.Run("returns 422 for an invalid time", func(t *testing.T) {
+		recorder := do(newTestServer().Handler(), http.MethodPost, "/loads", `{"id":"1","customer_id":"528","load_amount":"$1","time":"yesterday"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "time")
+	})
+	t.Run("returns 422 for a missing customer", func(t *testing.T) {
+		recorder := do(newTestServer().Handler(), http.MethodPost, "/loads", `{"id":"1","load_amount":"$1","time":"2000-01-01T00:00:00Z"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	})
+	t.Run("returns 422 for a request breaking a validation rule", func(t *testing.T) {
+		server := newTestServer()
+		server.validator = models.NewValidator(models.ValidationRules{Strict: true, RequireCurrency: true, RejectUnknownFields: true})
+		handler := server.Handler()
+		recorder := do(handler, http.MethodPost, "/loads", `{"id":"1","customer_id":"528","load_amount":"3000","time":"2000-01-01T00:00:00Z"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "load_amount: missing currency symbol")
+		recorder = do(handler, http.MethodPost, "/loads", `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-01T00:00:00Z","note":"x"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "note: unknown field")
+	})
+	t.Run("returns 413 for a body over the cap", func(t *testing.T) {
+		body := `{"id":"1","customer_id":"528","load_amount":"$1","time":"2000-01-01T00:00:00Z","note":"` + strings.Repeat("x", maxBodyBytes) + `"}`
+		recorder := do(newTestServer().Handler(), http.MethodPost, "/loads", body)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	})
+	t.Run("returns 400 for a body that cannot be read", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		body := io.MultiReader(strings.NewReader(`{"id":"1",`), &failingReader{err: io.ErrUnexpectedEOF})
+		newTestServer().Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/loads", body))
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), io.ErrUnexpectedEOF.Error())
+	})
+	t.Run("returns 405 for other methods", func(t *testing.T) {
+		recorder := do(newTestServer().Handler(), http.MethodGet, "/loads", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+		assert.Equal(t, http.MethodPost, recorder.Header().Get("Allow"))
+	})
+}
+
+func TestHandleLimits(t *testing.T) {
+	t.Run("returns remaining headroom", func(t *testing.T) {
+		handler := newTestServer().Handler()
+		do(handler, http.MethodPost, "/loads", `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-04T10:00:00Z"}`)
+		recorder := do(handler, http.MethodGet, "/customers/528/limits?at=2000-01-04T12:00:00Z", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{
+			"customer_id":"528",
+			"daily":{"start":"2000-01-04T00:00:00Z","remaining_amount":"$2,000.00","limit_amount":"$5,000.00","remaining_loads":2,"limit_loads":3},
+			"weekly":{"start":"2000-01-03T00:00:00Z","remaining_amount":"$17,000.00","limit_amount":"$20,000.00"}
+		}`, recorder.Body.String())
+	})
+	t.Run("resets lapsed limits at the requested time", func(t *testing.T) {
+		handler := newTestServer().Handler()
+		do(handler, http.MethodPost, "/loads", `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-04T10:00:00Z"}`)
+		recorder := do(handler, http.MethodGet, "/customers/528/limits?at=2000-01-05T12:00:00Z", "")
+		var limits models.Headroom
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &limits))
+		assert.Equal(t, models.Dollars(5000), limits.Daily.RemainingAmount)
+		assert.Equal(t, models.Dollars(17000), limits.Weekly.RemainingAmount)
+	})
+	t.Run("returns 404 for an unknown customer", func(t *testing.T) {
+		recorder := do(newTestServer().Handler(), http.MethodGet, "/customers/1/limits", "")
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+	t.Run("returns 404 for an unknown path", func(t *testing.T) {
+		recorder := do(newTestServer().Handler(), http.MethodGet, "/customers/1/other", "")
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+	t.Run("returns 400 for an invalid time", func(t *testing.T) {
+		recorder := do(newTestServer().Handler(), http.MethodGet, "/customers/1/limits?at=today", "")
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+}
+
+func TestHandleCheck(t *testing.T) {
+	t.Run("reports the decision without making the load", func(t *testing.T) {
+		handler := newTestServer().Handler()
+		do(handler, http.MethodPost, "/loads", `{"id":"1","customer_id":"528","load_amount":"$3000","time":"2000-01-04T10:00:00Z"}`)
+		recorder := do(handler, http.MethodGet, "/customers/528/check?amount=2500&at=2000-01-04T12:00:00Z", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		var whatIf models.WhatIf
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &whatIf))
+		assert.False(t, whatIf.Accepted)
+		assert.Equal(t, models.ReasonDailyAmountExceeded, whatIf.Reason)
+		assert.Equal(t, models.Dollars(2000), whatIf.Headroom.Daily.RemainingAmount)
+
+		recorder = do(handler, http.MethodGet, "/customers/528/check?amount=$1,500.50&at=2000-01-04T12:00:00Z", "")
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &whatIf))
+		assert.True(t, whatIf.Accepted)
+		assert.Equal(t, models.Cents(49950), whatIf.After.Daily.RemainingAmount)
+		recorder = do(handler, http.MethodGet, "/customers/528/limits?at=2000-01-04T12:00:00Z", "")
+		assert.Contains(t, recorder.Body.String(), `"remaining_amount":"$2,000.00"`)
+	})
+	t.Run("returns 400 for a missing or invalid amount", func(t *testing.T) {
+		handler := newTestServer().Handler()
+		for _, query := range []string{"", "?amount=ten", "?amount=0", "?amount=-5", "?amount=1&at=today"} {
+			recorder := do(handler, http.MethodGet, "/customers/528/check"+query, "")
+			assert.Equal(t, http.StatusBadRequest, recorder.Code, query)
+		}
+	})
+	t.Run("returns 405 for other methods", func(t *testing.T) {
+		recorder := do(newTestServer().Handler(), http.MethodPost, "/customers/528/check?amount=1", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+}
+
+func TestConcurrentLoads(t *testing.T) {
+	t.Run("never accepts more than the daily count for one customer", func(t *testing.T) {
+		handler := newTestServer().Handler()
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		accepted := 0
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				body := fmt.Sprintf(`{"id":"%d","customer_id":"528","load_amount":"$1","time":"2000-01-01T00:00:00Z"}`, i)
+				var response models.Response
+				assert.NoError(t, json.Unmarshal(do(handler, http.MethodPost, "/loads", body).Body.Bytes(), &response))
+				if response.Accepted {
+					mu.Lock()
+					accepted++
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.Equal(t, 3, accepted)
+	})
+}
+
+func TestServe(t *testing.T) {
+	t.Run("shuts down when the context is cancelled", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		errC := make(chan error, 1)
+		go func() { errC <- newTestServer().Serve(ctx, listener) }()
+
+		response, err := http.Post("http://"+listener.Addr().String()+"/loads", "application/json",
+			strings.NewReader(`{"id":"1","customer_id":"528","load_amount":"$1","time":"2000-01-01T00:00:00Z"}`))
+		require.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		cancel()
+		assert.NoError(t, <-errC)
+		_, err = http.Get("http://" + listener.Addr().String() + "/customers/528/limits")
+		assert.Error(t, err)
+	})
+}
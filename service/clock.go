@@ -0,0 +1,25 @@
+package service
+
+import "time"
+
+// Clock tells the time for loads and headroom queries that do not carry one.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock, in UTC.
+type SystemClock struct{}
+
+// Now ...
+func (SystemClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// FixedClock always tells the same time, e.g. for what-if checks at a given
+// time.
+type FixedClock time.Time
+
+// Now ...
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// lockStripes is the number of mutexes customers are spread over.
+const lockStripes = 256
+
+// CustomerLocks serializes work on the same customer. Caches do not guard the
+// accounts they hand out, so concurrent callers that are not sharded by
+// customer must hold the customer's lock around AttemptLoad.
+type CustomerLocks struct {
+	stripes [lockStripes]sync.Mutex
+}
+
+// For returns the mutex guarding the customer's account.
+func (l *CustomerLocks) For(customerID string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(customerID))
+	return &l.stripes[h.Sum32()%lockStripes]
+}
@@ -0,0 +1,16 @@
+package service_test
+
+import (
+	"testing"
+
+	"velocitylimits/service"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCustomerLocks(t *testing.T) {
+	t.Run("returns the same mutex for a customer", func(t *testing.T) {
+		var locks service.CustomerLocks
+		assert.Same(t, locks.For("528"), locks.For("528"))
+	})
+}
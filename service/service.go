@@ -1,49 +1,314 @@
 package service
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"velocitylimits/audit"
 	"velocitylimits/config"
+	"velocitylimits/dedup"
 	"velocitylimits/models"
 
 	"github.com/sirupsen/logrus"
 )
 
+// ErrConflictingRetry is reported by the servers for a load declined as a
+// conflict.
+var ErrConflictingRetry = errors.New("id was used by a load with a different amount or time")
+
 // TODO : It can be argued that this belongs in test, no here
 //go:generate counterfeiter . Cache
 type Cache interface {
 	GetAccount(customerID string) *models.Account
 	AddAccount(account *models.Account) *models.Account
-	AddTransaction(id, customerID string)
-	IsDuplicateTransaction(id, customerID string) bool
+	AddTransaction(entry dedup.Entry)
+	GetTransaction(id, customerID string) (dedup.Entry, bool)
+	GetCustomerLimits(customerID string) *models.CustomerLimits
+	AddCustomerLimits(limits *models.CustomerLimits)
+}
+
+// Auditor records the decisions AttemptLoad makes, see audit.Log.
+type Auditor interface {
+	Record(record audit.Record)
 }
 
-// Load the file.
-func AttemptLoad(request *models.Request, config *config.Configurations, cache Cache) *models.Response {
+// Load the file. Every decision is recorded by auditor, which may be nil.
+func AttemptLoad(request *models.Request, config *config.Configurations, cache Cache, auditor Auditor) *models.Response {
+	var before *models.Account
+	if auditor != nil {
+		if account := cache.GetAccount(request.CustomerID); account != nil {
+			before = account.Clone()
+		}
+	}
 	// check for duplicates
-	if cache.IsDuplicateTransaction(request.ID, request.CustomerID) {
-		logrus.Infoln("Ignoring duplicate txn: ", request.ID)
-		return models.NewResponse(request.ID, request.CustomerID, false)
+	if original, ok := cache.GetTransaction(request.ID, request.CustomerID); ok {
+		response := retryResponse(request, original, config.Store.Dedup.Idempotent)
+		if auditor != nil {
+			auditor.Record(audit.Record{Request: request, Decision: *response.Decision, Before: before, After: before})
+		}
+		return response
+	}
+	account, decision, load := decide(request, cache, config)
+	transactions := []dedup.Entry{{
+		Key:      dedup.Key{ID: request.ID, CustomerID: request.CustomerID},
+		Time:     request.ParsedTime,
+		Type:     request.Type,
+		Amount:   request.ParsedAmount,
+		Decision: &decision,
+	}}
+	if load != nil {
+		transactions = append(transactions, *load)
+	}
+	// add the transactions before the account, so a persistent cache writes
+	// them together
+	for _, transaction := range transactions {
+		cache.AddTransaction(transaction)
 	}
-	// add transactions
-	cache.AddTransaction(request.ID, request.CustomerID)
-	accepted := ProcessRequest(request, cache, config)
-	response := models.NewResponse(request.ID, request.CustomerID, accepted)
+	cache.AddAccount(account)
+	if auditor != nil {
+		auditor.Record(audit.Record{Request: request, Decision: decision, Before: before, After: account.Clone(), Transactions: transactions})
+	}
+	return models.NewDecisionResponse(request.ID, request.CustomerID, decision)
+}
+
+// Decline answers the request with decision without deciding it or changing
+// the cache. The decline is recorded by auditor, which may be nil, with the
+// account left as it was.
+func Decline(request *models.Request, decision models.Decision, cache Cache, auditor Auditor) *models.Response {
+	if auditor != nil {
+		var account *models.Account
+		if current := cache.GetAccount(request.CustomerID); current != nil {
+			account = current.Clone()
+		}
+		auditor.Record(audit.Record{Request: request, Decision: decision, Before: account, After: account})
+	}
+	return models.NewDecisionResponse(request.ID, request.CustomerID, decision)
+}
 
-	return response
+// PeekLoad decides the load as AttemptLoad would, without changing the cache
+// or recording the decision.
+func PeekLoad(request *models.Request, config *config.Configurations, cache Cache) *models.Response {
+	if original, ok := cache.GetTransaction(request.ID, request.CustomerID); ok {
+		return retryResponse(request, original, config.Store.Dedup.Idempotent)
+	}
+	_, decision, _ := decide(request, peekCache{cache}, config)
+	return models.NewDecisionResponse(request.ID, request.CustomerID, decision)
+}
+
+// WhatIf decides a load of amount by the customer at the clock's time as
+// PeekLoad does, without changing the cache, and reports what the customer
+// can load before and after it.
+func WhatIf(customerID string, amount models.Money, clock Clock, cache Cache, config *config.Configurations) *models.WhatIf {
+	at := clock.Now()
+	request := &models.Request{
+		CustomerID:   customerID,
+		Amount:       amount.String(),
+		Time:         at.Format(time.RFC3339),
+		ParsedAmount: amount,
+		ParsedTime:   at,
+	}
+	cache = peekCache{cache}
+	before, _ := currentAccount(customerID, at, cache, config)
+	after, decision, _ := decide(request, cache, config)
+	return &models.WhatIf{
+		CustomerID: customerID,
+		Amount:     amount,
+		Time:       at,
+		Accepted:   decision.Accepted,
+		Reason:     decision.Reason,
+		Limit:      decision.Limit,
+		Headroom:   models.NewHeadroom(before),
+		After:      models.NewHeadroom(after),
+	}
+}
+
+// peekCache hands out copies of the cached accounts, so deciding on them
+// leaves the cache as it was.
+type peekCache struct {
+	Cache
+}
+
+func (c peekCache) GetAccount(customerID string) *models.Account {
+	account := c.Cache.GetAccount(customerID)
+	if account == nil {
+		return nil
+	}
+	return account.Clone()
+}
+
+// retryResponse answers a load whose ID was seen before. Duplicates are
+// declined unless idempotent is set. Then a retry of the same load gets the
+// original response again, and a load reusing the ID with a different amount
+// or time is declined as a conflict.
+func retryResponse(request *models.Request, original dedup.Entry, idempotent bool) *models.Response {
+	if !idempotent || original.Decision == nil {
+		logrus.Infoln("Ignoring duplicate txn: ", request.ID)
+		return models.NewDecisionResponse(request.ID, request.CustomerID, models.NewDuplicateDecision())
+	}
+	if original.Amount != request.ParsedAmount || !original.Time.Equal(request.ParsedTime) {
+		logrus.Warnf("Conflicting retry of txn %s of customer %s", request.ID, request.CustomerID)
+		return models.NewDecisionResponse(request.ID, request.CustomerID, models.NewConflictDecision())
+	}
+	return models.NewDecisionResponse(request.ID, request.CustomerID, *original.Decision)
 }
 
 // ProcessRequest ...
-func ProcessRequest(request *models.Request, cache Cache, config *config.Configurations) bool {
+func ProcessRequest(request *models.Request, cache Cache, config *config.Configurations) models.Decision {
+	account, decision, load := decide(request, cache, config)
+	if load != nil {
+		cache.AddTransaction(*load)
+	}
+	// Store the account after acting on it so persistent caches see the new limits
+	cache.AddAccount(account)
+	return decision
+}
+
+// decide acts on the request against the customer's account and returns the
+// account to store, and for reversals and refunds the load funds were given
+// back of.
+func decide(request *models.Request, cache Cache, config *config.Configurations) (*models.Account, models.Decision, *dedup.Entry) {
+	account, boundaries := currentAccount(request.CustomerID, request.ParsedTime, cache, config)
+	switch {
+	case request.Type == models.RequestWithdrawal:
+		return account, account.Withdraw(request.ParsedAmount), nil
+	case request.Type.Returns():
+		decision, load := returnFunds(request, account, cache, config.Returns, boundaries)
+		return account, decision, load
+	}
+	// Act on the request (if velocity limits agree)
+	decision := account.LoadFunds(request, config.VelocityLimit.Rules, boundaries)
+	return account, decision, nil
+}
+
+// currentAccount returns the customer's account from the cache, or a new one
+// for an unknown customer, with the limits that lapsed by the given time
+// reset, and the boundaries of its limits.
+func currentAccount(customerID string, at time.Time, cache Cache, config *config.Configurations) (*models.Account, models.Boundaries) {
 	// Fetch the account from cache
-	account := cache.GetAccount(request.CustomerID)
+	account := cache.GetAccount(customerID)
+	limits := ResolveLimits(customerID, at, cache, config)
+	boundaries := resolveBoundaries(customerID, limits)
 	// account not in cache
 	if account == nil {
-		account = models.NewAccount(request.CustomerID)
-		account.DailyLimit = models.NewDailyLimit(request.ParsedTime, config.VelocityLimit.MaxDailyLoadLimit, config.VelocityLimit.MaxDailyTransactions)
-		account.WeeklyLimit = models.NewWeeklyLimit(request.ParsedTime, config.VelocityLimit.MaxWeeklyLoadLimit)
-		cache.AddAccount(account)
+		account = models.NewAccount(customerID)
+		account.DailyLimit = models.NewDailyLimit(at, limits.MaxDailyLoadLimit, limits.MaxDailyTransactions, boundaries)
+		account.WeeklyLimit = models.NewWeeklyLimit(at, limits.MaxWeeklyLoadLimit, boundaries)
 	} else {
-		account.ResetLapsedLimits(request.ParsedTime, config.VelocityLimit.MaxDailyLoadLimit, config.VelocityLimit.MaxDailyTransactions, config.VelocityLimit.MaxWeeklyLoadLimit)
+		account.ResetLapsedLimits(at, limits.MaxDailyLoadLimit, limits.MaxDailyTransactions, limits.MaxWeeklyLoadLimit, boundaries)
+	}
+	return account, boundaries
+}
+
+// returnFunds gives back funds of the load a reversal or refund refers to,
+// which must be an accepted load of the same customer. Reversals give back
+// what is left of the load, refunds at most that. The load's entry is
+// returned with the amount given back added, if any was.
+func returnFunds(request *models.Request, account *models.Account, cache Cache, returns config.Returns, boundaries models.Boundaries) (models.Decision, *dedup.Entry) {
+	load, ok := cache.GetTransaction(request.LoadID, request.CustomerID)
+	if !ok || !load.Type.IsLoad() || load.Decision == nil || !load.Decision.Accepted {
+		logrus.Infof("Txn %s refers to unknown load %s", request.ID, request.LoadID)
+		return models.NewUnknownLoadDecision(), nil
+	}
+	left := load.Amount.Sub(load.Returned)
+	amount := request.ParsedAmount
+	reversal := request.Type == models.RequestReversal
+	if reversal {
+		amount = left
+	}
+	if amount <= 0 || left.Sub(amount).IsNegative() {
+		return models.NewExceedsLoadDecision(), nil
+	}
+	decision, err := account.Return(amount, request.LoadID, load.Time, returns.RestoreHeadroom, returns.RestoreCount && reversal, boundaries)
+	if err != nil {
+		logrus.Errorf("Txn %s cannot be returned: %v", request.ID, err)
+		return models.NewUnknownLoadDecision(), nil
+	}
+	if !decision.Accepted {
+		return decision, nil
+	}
+	load.Returned = load.Returned.Add(amount)
+	return decision, &load
+}
+
+// GetHeadroom returns what the customer can still load at the given time, or
+// nil for an unknown customer. The cached account is not changed.
+func GetHeadroom(customerID string, at time.Time, cache Cache, config *config.Configurations) *models.Headroom {
+	account := cache.GetAccount(customerID)
+	if account == nil {
+		return nil
+	}
+	account = account.Clone()
+	limits := ResolveLimits(customerID, at, cache, config)
+	account.ResetLapsedLimits(at, limits.MaxDailyLoadLimit, limits.MaxDailyTransactions, limits.MaxWeeklyLoadLimit, resolveBoundaries(customerID, limits))
+	return models.NewHeadroom(account)
+}
+
+// ResolveLimits returns the limits of the customer at the given time: those
+// of its tier, or of the default tier, with its active overrides applied.
+// Limits only change when a new day or week starts, so an override granted
+// during a day applies from the next one. The time zone and week start come
+// from the customer, then the tier, then the velocity limits.
+func ResolveLimits(customerID string, at time.Time, cache Cache, config *config.Configurations) models.Limits {
+	customer := cache.GetCustomerLimits(customerID)
+	tier := config.Tiers.Default
+	if customer != nil && customer.Tier != "" {
+		tier = customer.Tier
+	}
+	limits, ok := config.Tiers.Limits(tier)
+	if !ok {
+		if tier != "" {
+			logrus.Warnf("Unknown tier %q of customer %s, using the default limits", tier, customerID)
+		}
+		limits = config.VelocityLimit.Limits()
+	}
+	if limits.TimeZone == "" {
+		limits.TimeZone = config.VelocityLimit.TimeZone
+	}
+	if limits.WeekStart == "" {
+		limits.WeekStart = config.VelocityLimit.WeekStart
+	}
+	if customer != nil {
+		limits = customer.Apply(limits, at)
+	}
+	return limits
+}
+
+// resolveBoundaries returns the boundaries of the customer's limits. The
+// config and customers file are checked when they are loaded, so invalid
+// boundaries only fall back to the defaults here.
+func resolveBoundaries(customerID string, limits models.Limits) models.Boundaries {
+	boundaries, err := limits.Boundaries()
+	if err != nil {
+		logrus.Warnf("Using the default boundaries for customer %s: %v", customerID, err)
+		return models.DefaultBoundaries
+	}
+	return boundaries
+}
+
+// ImportCustomerLimits adds every JSON line of models.CustomerLimits read from
+// input to the cache and returns how many were added.
+func ImportCustomerLimits(input io.Reader, cache Cache) (int, error) {
+	decoder := json.NewDecoder(input)
+	count := 0
+	for {
+		var limits models.CustomerLimits
+		err := decoder.Decode(&limits)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("customer limits %d: %w", count+1, err)
+		}
+		if limits.CustomerID == "" {
+			return count, fmt.Errorf("customer limits %d: customer_id: %w", count+1, models.ErrMissingField)
+		}
+		if _, err := models.LoadBoundaries(limits.TimeZone, limits.WeekStart); err != nil {
+			return count, fmt.Errorf("customer limits %d: %w", count+1, err)
+		}
+		cache.AddCustomerLimits(&limits)
+		count++
 	}
-	// Act on the request (if velocity limits agree)
-	return account.LoadFunds(request)
 }
@@ -1,9 +1,12 @@
 package service_test
 
 import (
+	"strings"
 	"testing"
 	"time"
 
+	"velocitylimits/audit"
+	"velocitylimits/dedup"
 	"velocitylimits/service"
 	"velocitylimits/service/servicefakes"
 
@@ -21,51 +24,295 @@ import (
 func TestAttemptLoad(t *testing.T) {
 	t.Run("successful attempt to load", func(t *testing.T) {
 		config := &config.Configurations{VelocityLimit: config.VelocityLimit{
-			MaxDailyLoadLimit:    10,
+			MaxDailyLoadLimit:    models.Dollars(10),
 			MaxDailyTransactions: 1,
-			MaxWeeklyLoadLimit:   10,
+			MaxWeeklyLoadLimit:   models.Dollars(10),
 		}}
 		cache := cache.NewCache()
 		request, err := models.NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3\",\"time\":\"2000-01-01T00:00:00Z\"}")
 		require.NoError(t, err)
-		actualResponse := service.AttemptLoad(request, config, cache)
-		expectedResponse := models.NewResponse("15887", "528", true)
+		actualResponse := service.AttemptLoad(request, config, cache, nil)
+		expectedResponse := models.NewDecisionResponse("15887", "528", models.NewAcceptedDecision())
 		assert.Equal(t, expectedResponse, actualResponse)
 	})
 	t.Run("returns false for duplicate request", func(t *testing.T) {
 		config := &config.Configurations{VelocityLimit: config.VelocityLimit{
-			MaxDailyLoadLimit:    10,
+			MaxDailyLoadLimit:    models.Dollars(10),
 			MaxDailyTransactions: 1,
-			MaxWeeklyLoadLimit:   10,
+			MaxWeeklyLoadLimit:   models.Dollars(10),
 		}}
 		cache := cache.NewCache()
 		request, err := models.NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3\",\"time\":\"2000-01-01T00:00:00Z\"}")
 		require.NoError(t, err)
 		// first attempt
-		actualResponse := service.AttemptLoad(request, config, cache)
-		expectedResponse := models.NewResponse("15887", "528", true)
+		actualResponse := service.AttemptLoad(request, config, cache, nil)
+		expectedResponse := models.NewDecisionResponse("15887", "528", models.NewAcceptedDecision())
 		assert.Equal(t, expectedResponse, actualResponse)
 		// second attempt
-		actualResponse = service.AttemptLoad(request, config, cache)
-		expectedResponse = models.NewResponse("15887", "528", false)
+		actualResponse = service.AttemptLoad(request, config, cache, nil)
+		expectedResponse = models.NewDecisionResponse("15887", "528", models.NewDuplicateDecision())
 		assert.Equal(t, expectedResponse, actualResponse)
 	})
+	t.Run("replays the original response to a retry when idempotent", func(t *testing.T) {
+		config := &config.Configurations{VelocityLimit: config.VelocityLimit{
+			MaxDailyLoadLimit:    models.Dollars(10),
+			MaxDailyTransactions: 1,
+			MaxWeeklyLoadLimit:   models.Dollars(10),
+		}}
+		config.Store.Dedup.Idempotent = true
+		cache := cache.NewCache()
+		accepted, err := models.ParseRequest("1", "528", "$3", "2000-01-01T00:00:00Z")
+		require.NoError(t, err)
+		declined, err := models.ParseRequest("2", "528", "$3", "2000-01-01T01:00:00Z")
+		require.NoError(t, err)
+		first := service.AttemptLoad(accepted, config, cache, nil)
+		firstDeclined := service.AttemptLoad(declined, config, cache, nil)
+		assert.False(t, firstDeclined.Accepted)
+		// the retries are neither duplicates nor loaded again
+		assert.Equal(t, first, service.AttemptLoad(accepted, config, cache, nil))
+		assert.Equal(t, firstDeclined, service.AttemptLoad(declined, config, cache, nil))
+		assert.Equal(t, models.Dollars(3), cache.GetAccount("528").Balance)
+	})
+	t.Run("declines a retry with a different amount or time as a conflict", func(t *testing.T) {
+		config := &config.Configurations{VelocityLimit: config.VelocityLimit{
+			MaxDailyLoadLimit:    models.Dollars(10),
+			MaxDailyTransactions: 1,
+			MaxWeeklyLoadLimit:   models.Dollars(10),
+		}}
+		config.Store.Dedup.Idempotent = true
+		cache := cache.NewCache()
+		request, err := models.ParseRequest("1", "528", "$3", "2000-01-01T00:00:00Z")
+		require.NoError(t, err)
+		service.AttemptLoad(request, config, cache, nil)
+		for _, conflict := range [][2]string{{"$4", "2000-01-01T00:00:00Z"}, {"$3", "2000-01-01T00:00:01Z"}} {
+			request, err := models.ParseRequest("1", "528", conflict[0], conflict[1])
+			require.NoError(t, err)
+			response := service.AttemptLoad(request, config, cache, nil)
+			assert.Equal(t, models.NewDecisionResponse("1", "528", models.NewConflictDecision()), response)
+			assert.True(t, response.Conflicting())
+		}
+	})
+	t.Run("returns the limit that declined the request", func(t *testing.T) {
+		config := &config.Configurations{VelocityLimit: config.VelocityLimit{
+			MaxDailyLoadLimit:    models.Dollars(10),
+			MaxDailyTransactions: 1,
+			MaxWeeklyLoadLimit:   models.Dollars(10),
+		}}
+		cache := cache.NewCache()
+		request, err := models.NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$11\",\"time\":\"2000-01-01T00:00:00Z\"}")
+		require.NoError(t, err)
+		actualResponse := service.AttemptLoad(request, config, cache, nil)
+		expectedDecision := models.NewAmountDecline(models.ReasonDailyAmountExceeded, models.LimitDailyAmount, models.Dollars(10), models.Dollars(10))
+		assert.Equal(t, models.NewDecisionResponse("15887", "528", expectedDecision), actualResponse)
+	})
+}
+
+func TestAttemptReturns(t *testing.T) {
+	newConfig := func() *config.Configurations {
+		return &config.Configurations{
+			VelocityLimit: config.VelocityLimit{
+				MaxDailyLoadLimit:    models.Dollars(5000),
+				MaxDailyTransactions: 1,
+				MaxWeeklyLoadLimit:   models.Dollars(20000),
+			},
+			Returns: config.Returns{RestoreHeadroom: true, RestoreCount: true},
+		}
+	}
+	attempt := func(t *testing.T, config *config.Configurations, cache service.Cache, line string) *models.Response {
+		request, err := models.NewRequest(line)
+		require.NoError(t, err)
+		return service.AttemptLoad(request, config, cache, nil)
+	}
+
+	t.Run("a reversal restores the headroom and count of the load", func(t *testing.T) {
+		config, cache := newConfig(), cache.NewCache()
+		assert.True(t, attempt(t, config, cache, `{"id":"1","customer_id":"528","load_amount":"$5000","time":"2000-01-01T00:00:00Z"}`).Accepted)
+		assert.False(t, attempt(t, config, cache, `{"id":"2","customer_id":"528","load_amount":"$1","time":"2000-01-01T01:00:00Z"}`).Accepted)
+		assert.True(t, attempt(t, config, cache, `{"id":"3","customer_id":"528","type":"reversal","load_id":"1","time":"2000-01-01T02:00:00Z"}`).Accepted)
+		assert.Equal(t, models.Money(0), cache.GetAccount("528").Balance)
+		assert.True(t, attempt(t, config, cache, `{"id":"4","customer_id":"528","load_amount":"$5000","time":"2000-01-01T03:00:00Z"}`).Accepted)
+		// nothing is left of the load to reverse again
+		response := attempt(t, config, cache, `{"id":"5","customer_id":"528","type":"reversal","load_id":"1","time":"2000-01-01T04:00:00Z"}`)
+		assert.Equal(t, models.ReasonExceedsLoad, response.Decision.Reason)
+	})
+	t.Run("refunds give back at most what is left of the load", func(t *testing.T) {
+		config, cache := newConfig(), cache.NewCache()
+		attempt(t, config, cache, `{"id":"1","customer_id":"528","load_amount":"$100","time":"2000-01-01T00:00:00Z"}`)
+		assert.True(t, attempt(t, config, cache, `{"id":"2","customer_id":"528","type":"refund","load_id":"1","load_amount":"$60","time":"2000-01-01T01:00:00Z"}`).Accepted)
+		response := attempt(t, config, cache, `{"id":"3","customer_id":"528","type":"refund","load_id":"1","load_amount":"$41","time":"2000-01-01T02:00:00Z"}`)
+		assert.Equal(t, models.ReasonExceedsLoad, response.Decision.Reason)
+		assert.True(t, attempt(t, config, cache, `{"id":"4","customer_id":"528","type":"reversal","load_id":"1","time":"2000-01-01T03:00:00Z"}`).Accepted)
+		assert.Equal(t, models.Money(0), cache.GetAccount("528").Balance)
+		assert.Equal(t, models.Dollars(5000), cache.GetAccount("528").DailyLimit.MaxLoadLimit)
+	})
+	t.Run("declines returns of loads that are unknown, declined or of another customer", func(t *testing.T) {
+		config, cache := newConfig(), cache.NewCache()
+		attempt(t, config, cache, `{"id":"1","customer_id":"528","load_amount":"$100","time":"2000-01-01T00:00:00Z"}`)
+		attempt(t, config, cache, `{"id":"2","customer_id":"528","load_amount":"$100","time":"2000-01-01T00:00:00Z"}`)
+		attempt(t, config, cache, `{"id":"3","customer_id":"528","type":"withdrawal","load_amount":"$1","time":"2000-01-01T00:00:00Z"}`)
+		for _, line := range []string{
+			`{"id":"4","customer_id":"528","type":"reversal","load_id":"9","time":"2000-01-01T01:00:00Z"}`,
+			`{"id":"5","customer_id":"528","type":"reversal","load_id":"2","time":"2000-01-01T01:00:00Z"}`,
+			`{"id":"6","customer_id":"528","type":"reversal","load_id":"3","time":"2000-01-01T01:00:00Z"}`,
+			`{"id":"7","customer_id":"529","type":"reversal","load_id":"1","time":"2000-01-01T01:00:00Z"}`,
+		} {
+			assert.Equal(t, models.ReasonUnknownLoad, attempt(t, config, cache, line).Decision.Reason, line)
+		}
+		assert.Equal(t, models.Dollars(99), cache.GetAccount("528").Balance)
+	})
+	t.Run("declines the return of a load the account does not keep", func(t *testing.T) {
+		config, cache := newConfig(), cache.NewCache()
+		attempt(t, config, cache, `{"id":"1","customer_id":"528","load_amount":"$100","time":"2000-01-01T00:00:00Z"}`)
+		account := cache.GetAccount("528")
+		account.Loads = nil
+		cache.AddAccount(account)
+		response := attempt(t, config, cache, `{"id":"2","customer_id":"528","type":"reversal","load_id":"1","time":"2000-01-01T01:00:00Z"}`)
+		assert.Equal(t, models.ReasonUnknownLoad, response.Decision.Reason)
+		assert.Equal(t, models.Dollars(100), cache.GetAccount("528").Balance)
+	})
+	t.Run("the balance never goes negative", func(t *testing.T) {
+		config, cache := newConfig(), cache.NewCache()
+		attempt(t, config, cache, `{"id":"1","customer_id":"528","load_amount":"$100","time":"2000-01-01T00:00:00Z"}`)
+		response := attempt(t, config, cache, `{"id":"2","customer_id":"528","type":"withdrawal","load_amount":"$100.01","time":"2000-01-01T01:00:00Z"}`)
+		assert.Equal(t, models.ReasonInsufficientBalance, response.Decision.Reason)
+		assert.True(t, attempt(t, config, cache, `{"id":"3","customer_id":"528","type":"withdrawal","load_amount":"$50","time":"2000-01-01T01:00:00Z"}`).Accepted)
+		response = attempt(t, config, cache, `{"id":"4","customer_id":"528","type":"reversal","load_id":"1","time":"2000-01-01T02:00:00Z"}`)
+		assert.Equal(t, models.ReasonInsufficientBalance, response.Decision.Reason)
+		assert.Equal(t, models.Dollars(50), cache.GetAccount("528").Balance)
+	})
+}
+
+// recordingAuditor keeps the records it is given.
+type recordingAuditor struct {
+	records []audit.Record
+}
+
+func (a *recordingAuditor) Record(record audit.Record) {
+	a.records = append(a.records, record)
+}
+
+func TestAttemptLoadAudit(t *testing.T) {
+	config := &config.Configurations{VelocityLimit: config.VelocityLimit{
+		MaxDailyLoadLimit:    models.Dollars(10),
+		MaxDailyTransactions: 3,
+		MaxWeeklyLoadLimit:   models.Dollars(10),
+	}}
+	t.Run("records every decision with the account before and after", func(t *testing.T) {
+		cache := cache.NewCache()
+		auditor := &recordingAuditor{}
+		first, err := models.NewRequest(`{"id":"1","customer_id":"528","load_amount":"$3","time":"2000-01-01T00:00:00Z"}`)
+		require.NoError(t, err)
+		second, err := models.NewRequest(`{"id":"2","customer_id":"528","load_amount":"$4","time":"2000-01-01T01:00:00Z"}`)
+		require.NoError(t, err)
+		service.AttemptLoad(first, config, cache, auditor)
+		service.AttemptLoad(second, config, cache, auditor)
+		service.AttemptLoad(second, config, cache, auditor)
+		require.Len(t, auditor.records, 3)
+
+		assert.Nil(t, auditor.records[0].Before)
+		assert.Equal(t, models.Dollars(3), auditor.records[0].After.Balance)
+		require.Len(t, auditor.records[0].Transactions, 1)
+		assert.Equal(t, dedup.Key{ID: "1", CustomerID: "528"}, auditor.records[0].Transactions[0].Key)
+
+		assert.Equal(t, models.Dollars(3), auditor.records[1].Before.Balance)
+		assert.Equal(t, models.Dollars(7), auditor.records[1].After.Balance)
+
+		assert.Equal(t, models.ReasonDuplicate, auditor.records[2].Decision.Reason)
+		assert.Equal(t, auditor.records[2].Before, auditor.records[2].After)
+		assert.Empty(t, auditor.records[2].Transactions)
+	})
+	t.Run("records copies the cache does not change", func(t *testing.T) {
+		cache := cache.NewCache()
+		auditor := &recordingAuditor{}
+		request, err := models.NewRequest(`{"id":"1","customer_id":"528","load_amount":"$3","time":"2000-01-01T00:00:00Z"}`)
+		require.NoError(t, err)
+		service.AttemptLoad(request, config, cache, auditor)
+		cache.GetAccount("528").Balance = models.Dollars(9)
+		assert.Equal(t, models.Dollars(3), auditor.records[0].After.Balance)
+	})
+}
+
+func TestDecline(t *testing.T) {
+	t.Run("records the decline without changing the cache", func(t *testing.T) {
+		cache := cache.NewCache()
+		auditor := &recordingAuditor{}
+		load, err := models.NewRequest(`{"id":"1","customer_id":"528","load_amount":"$3","time":"2000-01-01T00:00:00Z"}`)
+		require.NoError(t, err)
+		service.AttemptLoad(load, &config.Configurations{VelocityLimit: config.VelocityLimit{
+			MaxDailyLoadLimit:    models.Dollars(10),
+			MaxDailyTransactions: 3,
+			MaxWeeklyLoadLimit:   models.Dollars(10),
+		}}, cache, auditor)
+		invalid, err := models.NewRequest(`{"id":"2","customer_id":"528","load_amount":"$4","time":"2000-01-01T01:00:00Z"}`)
+		require.NoError(t, err)
+		response := service.Decline(invalid, models.NewInvalidDecision(), cache, auditor)
+		assert.Equal(t, models.NewDecisionResponse("2", "528", models.NewInvalidDecision()), response)
+		assert.Equal(t, models.Dollars(3), cache.GetAccount("528").Balance)
+		assert.False(t, cache.IsDuplicateTransaction("2", "528"))
+		require.Len(t, auditor.records, 2)
+		assert.Equal(t, models.ReasonInvalid, auditor.records[1].Decision.Reason)
+		assert.Equal(t, auditor.records[0].After, auditor.records[1].Before)
+		assert.Equal(t, auditor.records[1].Before, auditor.records[1].After)
+		assert.Empty(t, auditor.records[1].Transactions)
+	})
+	t.Run("records no account for an unknown customer", func(t *testing.T) {
+		auditor := &recordingAuditor{}
+		request, err := models.NewRequest(`{"id":"1","customer_id":"528","load_amount":"$4","time":"2000-01-01T01:00:00Z"}`)
+		require.NoError(t, err)
+		service.Decline(request, models.NewInvalidDecision(), cache.NewCache(), auditor)
+		require.Len(t, auditor.records, 1)
+		assert.Nil(t, auditor.records[0].Before)
+		assert.Nil(t, auditor.records[0].After)
+	})
+}
+
+func TestPeekLoad(t *testing.T) {
+	config := &config.Configurations{VelocityLimit: config.VelocityLimit{
+		MaxDailyLoadLimit:    models.Dollars(10),
+		MaxDailyTransactions: 3,
+		MaxWeeklyLoadLimit:   models.Dollars(10),
+	}}
+	t.Run("decides like AttemptLoad without changing the cache", func(t *testing.T) {
+		cache := cache.NewCache()
+		first, err := models.ParseRequest("1", "528", "$6", "2000-01-01T00:00:00Z")
+		require.NoError(t, err)
+		assert.True(t, service.PeekLoad(first, config, cache).Accepted)
+		assert.Nil(t, cache.GetAccount("528"))
+		assert.False(t, cache.IsDuplicateTransaction("1", "528"))
+
+		service.AttemptLoad(first, config, cache, nil)
+		second, err := models.ParseRequest("2", "528", "$6", "2000-01-01T01:00:00Z")
+		require.NoError(t, err)
+		assert.Equal(t, models.ReasonDailyAmountExceeded, service.PeekLoad(second, config, cache).Decision.Reason)
+		third, err := models.ParseRequest("3", "528", "$2", "2000-01-01T02:00:00Z")
+		require.NoError(t, err)
+		assert.True(t, service.PeekLoad(third, config, cache).Accepted)
+		assert.Equal(t, models.Dollars(6), cache.GetAccount("528").Balance)
+		assert.Equal(t, 2, cache.GetAccount("528").DailyLimit.MaxTransactions)
+	})
+	t.Run("answers a seen ID as a retry", func(t *testing.T) {
+		cache := cache.NewCache()
+		request, err := models.ParseRequest("1", "528", "$6", "2000-01-01T00:00:00Z")
+		require.NoError(t, err)
+		service.AttemptLoad(request, config, cache, nil)
+		assert.Equal(t, models.ReasonDuplicate, service.PeekLoad(request, config, cache).Decision.Reason)
+	})
 }
 
 // this test is written a example of dependency injection.
 func TestAttemptLoadWithMockedCache(t *testing.T) {
 	t.Run("successful attempt to load", func(t *testing.T) {
 		config := &config.Configurations{VelocityLimit: config.VelocityLimit{
-			MaxDailyLoadLimit:    10,
+			MaxDailyLoadLimit:    models.Dollars(10),
 			MaxDailyTransactions: 1,
-			MaxWeeklyLoadLimit:   10,
+			MaxWeeklyLoadLimit:   models.Dollars(10),
 		}}
 		fakeCache := new(servicefakes.FakeCache)
-		fakeCache.IsDuplicateTransactionReturns(false)
+		fakeCache.GetTransactionReturns(dedup.Entry{}, false)
 		request, err := models.NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3\",\"time\":\"2000-01-01T00:00:00Z\"}")
 		require.NoError(t, err)
-		actualResponse := service.AttemptLoad(request, config, fakeCache)
-		expectedResponse := models.NewResponse("15887", "528", true)
+		actualResponse := service.AttemptLoad(request, config, fakeCache, nil)
+		expectedResponse := models.NewDecisionResponse("15887", "528", models.NewAcceptedDecision())
 		assert.Equal(t, expectedResponse, actualResponse)
 	})
 }
@@ -73,35 +320,245 @@ func TestAttemptLoadWithMockedCache(t *testing.T) {
 func TestProcessRequest(t *testing.T) {
 	t.Run("returns true for new account", func(t *testing.T) {
 		config := &config.Configurations{VelocityLimit: config.VelocityLimit{
-			MaxDailyLoadLimit:    10,
+			MaxDailyLoadLimit:    models.Dollars(10),
 			MaxDailyTransactions: 1,
-			MaxWeeklyLoadLimit:   10,
+			MaxWeeklyLoadLimit:   models.Dollars(10),
 		}}
 		cache := cache.NewCache()
 		request, err := models.NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3\",\"time\":\"2000-01-01T00:00:00Z\"}")
 		require.NoError(t, err)
 		actualResponse := service.ProcessRequest(request, cache, config)
-		assert.True(t, actualResponse)
+		assert.True(t, actualResponse.Accepted)
 
 	})
 	t.Run("returns true for existing account", func(t *testing.T) {
 		config := &config.Configurations{VelocityLimit: config.VelocityLimit{
-			MaxDailyLoadLimit:    10,
+			MaxDailyLoadLimit:    models.Dollars(10),
 			MaxDailyTransactions: 1,
-			MaxWeeklyLoadLimit:   10,
+			MaxWeeklyLoadLimit:   models.Dollars(10),
 		}}
 		cache := cache.NewCache()
 		account := models.NewAccount("528")
-		now := time.Now()
-		account.DailyLimit = models.NewDailyLimit(now, config.VelocityLimit.MaxDailyLoadLimit, config.VelocityLimit.MaxDailyTransactions)
-		account.WeeklyLimit = models.NewWeeklyLimit(now, config.VelocityLimit.MaxWeeklyLoadLimit)
+		now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		account.DailyLimit = models.NewDailyLimit(now, config.VelocityLimit.MaxDailyLoadLimit, config.VelocityLimit.MaxDailyTransactions, models.DefaultBoundaries)
+		account.WeeklyLimit = models.NewWeeklyLimit(now, config.VelocityLimit.MaxWeeklyLoadLimit, models.DefaultBoundaries)
 
 		cache.AddAccount(account)
 
 		request, err := models.NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3\",\"time\":\"2000-01-01T00:00:00Z\"}")
 		require.NoError(t, err)
 		actualResponse := service.ProcessRequest(request, cache, config)
-		assert.True(t, actualResponse)
+		assert.True(t, actualResponse.Accepted)
+
+	})
+	t.Run("declines when a configured rule fails", func(t *testing.T) {
+		config := &config.Configurations{VelocityLimit: config.VelocityLimit{
+			MaxDailyLoadLimit:    models.Dollars(10),
+			MaxDailyTransactions: 3,
+			MaxWeeklyLoadLimit:   models.Dollars(10),
+			Rules: []models.Rule{
+				{Name: "hourly_count", Window: models.WindowSliding, Duration: time.Hour, Metric: models.MetricCount, MaxCount: 1},
+			},
+		}}
+		cache := cache.NewCache()
+		request, err := models.ParseRequest("1", "528", "$3", "2000-01-01T00:00:00Z")
+		require.NoError(t, err)
+		assert.True(t, service.ProcessRequest(request, cache, config).Accepted)
+		request, err = models.ParseRequest("2", "528", "$3", "2000-01-01T00:30:00Z")
+		require.NoError(t, err)
+		decision := service.ProcessRequest(request, cache, config)
+		assert.Equal(t, models.Reason("hourly_count_exceeded"), decision.Reason)
+		assert.Equal(t, models.Dollars(3), cache.GetAccount("528").Balance)
+		request, err = models.ParseRequest("3", "528", "$3", "2000-01-01T01:00:00Z")
+		require.NoError(t, err)
+		assert.True(t, service.ProcessRequest(request, cache, config).Accepted)
+	})
+}
+
+func TestGetHeadroom(t *testing.T) {
+	config := &config.Configurations{VelocityLimit: config.VelocityLimit{
+		MaxDailyLoadLimit:    models.Dollars(10),
+		MaxDailyTransactions: 2,
+		MaxWeeklyLoadLimit:   models.Dollars(20),
+	}}
+	t.Run("returns nil for an unknown customer", func(t *testing.T) {
+		assert.Nil(t, service.GetHeadroom("528", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), cache.NewCache(), config))
+	})
+	t.Run("returns headroom after lapsed limits without changing the account", func(t *testing.T) {
+		cache := cache.NewCache()
+		request, err := models.NewRequest("{\"id\":\"15887\",\"customer_id\":\"528\",\"load_amount\":\"$3\",\"time\":\"2000-01-04T00:00:00Z\"}")
+		require.NoError(t, err)
+		service.AttemptLoad(request, config, cache, nil)
+
+		headroom := service.GetHeadroom("528", request.ParsedTime.AddDate(0, 0, 1), cache, config)
+		assert.Equal(t, models.Dollars(10), headroom.Daily.RemainingAmount)
+		assert.Equal(t, 2, headroom.Daily.RemainingLoads)
+		assert.Equal(t, models.Dollars(17), headroom.Weekly.RemainingAmount)
+		assert.Equal(t, models.Dollars(7), cache.GetAccount("528").DailyLimit.MaxLoadLimit)
+	})
+}
+
+func TestWhatIf(t *testing.T) {
+	config := &config.Configurations{VelocityLimit: config.VelocityLimit{
+		MaxDailyLoadLimit:    models.Dollars(10),
+		MaxDailyTransactions: 2,
+		MaxWeeklyLoadLimit:   models.Dollars(20),
+	}}
+	clock := service.FixedClock(time.Date(2000, 1, 4, 12, 0, 0, 0, time.UTC))
+	t.Run("reports the full limits of an unknown customer", func(t *testing.T) {
+		cache := cache.NewCache()
+		whatIf := service.WhatIf("528", models.Dollars(4), clock, cache, config)
+		assert.True(t, whatIf.Accepted)
+		assert.Equal(t, clock.Now(), whatIf.Time)
+		assert.Equal(t, models.Dollars(10), whatIf.Headroom.Daily.RemainingAmount)
+		assert.Equal(t, models.Dollars(6), whatIf.After.Daily.RemainingAmount)
+		assert.Equal(t, 1, whatIf.After.Daily.RemainingLoads)
+		assert.Nil(t, cache.GetAccount("528"))
+	})
+	t.Run("declines at the clock's time without changing the account", func(t *testing.T) {
+		cache := cache.NewCache()
+		request, err := models.ParseRequest("1", "528", "$8", "2000-01-04T00:00:00Z")
+		require.NoError(t, err)
+		service.AttemptLoad(request, config, cache, nil)
+
+		whatIf := service.WhatIf("528", models.Dollars(4), clock, cache, config)
+		assert.False(t, whatIf.Accepted)
+		assert.Equal(t, models.ReasonDailyAmountExceeded, whatIf.Reason)
+		assert.Equal(t, models.LimitDailyAmount, whatIf.Limit)
+		assert.Equal(t, models.Dollars(2), whatIf.Headroom.Daily.RemainingAmount)
+		assert.Equal(t, whatIf.Headroom, whatIf.After)
+		// the next day the limit lapsed
+		whatIf = service.WhatIf("528", models.Dollars(4), service.FixedClock(clock.Now().AddDate(0, 0, 1)), cache, config)
+		assert.True(t, whatIf.Accepted)
+		assert.Equal(t, models.Dollars(12), whatIf.Headroom.Weekly.RemainingAmount)
+		assert.Equal(t, models.Dollars(2), cache.GetAccount("528").DailyLimit.MaxLoadLimit)
+	})
+}
+
+func TestResolveLimits(t *testing.T) {
+	newConfig := func(defaultTier string) *config.Configurations {
+		return &config.Configurations{
+			VelocityLimit: config.VelocityLimit{
+				MaxDailyLoadLimit:    models.Dollars(10),
+				MaxDailyTransactions: 1,
+				MaxWeeklyLoadLimit:   models.Dollars(20),
+			},
+			Tiers: config.Tiers{
+				Default: defaultTier,
+				Catalogue: map[string]models.Limits{
+					"basic":   {MaxDailyLoadLimit: models.Dollars(100), MaxDailyTransactions: 2, MaxWeeklyLoadLimit: models.Dollars(200)},
+					"premium": {MaxDailyLoadLimit: models.Dollars(1000), MaxDailyTransactions: 5, MaxWeeklyLoadLimit: models.Dollars(2000)},
+				},
+			},
+		}
+	}
+	at := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	t.Run("uses the velocity limits without a default tier", func(t *testing.T) {
+		config := newConfig("")
+		assert.Equal(t, config.VelocityLimit.Limits(), service.ResolveLimits("528", at, cache.NewCache(), config))
+	})
+	t.Run("uses the default tier for customers without one", func(t *testing.T) {
+		config := newConfig("basic")
+		assert.Equal(t, config.Tiers.Catalogue["basic"], service.ResolveLimits("528", at, cache.NewCache(), config))
+	})
+	t.Run("uses the customer's tier and active overrides", func(t *testing.T) {
+		config := newConfig("basic")
+		cache := cache.NewCache()
+		daily, expires := models.Dollars(5000), at.AddDate(0, 0, 7)
+		cache.AddCustomerLimits(&models.CustomerLimits{CustomerID: "528", Tier: "premium", Overrides: []models.Override{{MaxDailyLoadLimit: &daily, Expires: &expires}}})
+		expected := config.Tiers.Catalogue["premium"]
+		expected.MaxDailyLoadLimit = daily
+		assert.Equal(t, expected, service.ResolveLimits("528", at, cache, config))
+		assert.Equal(t, config.Tiers.Catalogue["premium"], service.ResolveLimits("528", expires, cache, config))
+	})
+	t.Run("falls back to the velocity limits for an unknown tier", func(t *testing.T) {
+		config := newConfig("")
+		cache := cache.NewCache()
+		cache.AddCustomerLimits(&models.CustomerLimits{CustomerID: "528", Tier: "gold"})
+		assert.Equal(t, config.VelocityLimit.Limits(), service.ResolveLimits("528", at, cache, config))
+	})
+	t.Run("new accounts and lapsed limits get the customer's limits", func(t *testing.T) {
+		config := newConfig("basic")
+		cache := cache.NewCache()
+		cache.AddCustomerLimits(&models.CustomerLimits{CustomerID: "528", Tier: "premium"})
+		request, err := models.ParseRequest("1", "528", "$500", "2000-01-04T00:00:00Z")
+		require.NoError(t, err)
+		assert.True(t, service.ProcessRequest(request, cache, config).Accepted)
+		assert.Equal(t, models.Dollars(1000), cache.GetAccount("528").DailyLimit.LoadLimit)
 
+		cache.AddCustomerLimits(&models.CustomerLimits{CustomerID: "528", Tier: "basic"})
+		request, err = models.ParseRequest("2", "528", "$500", "2000-01-05T00:00:00Z")
+		require.NoError(t, err)
+		decision := service.ProcessRequest(request, cache, config)
+		assert.Equal(t, models.NewAmountDecline(models.ReasonDailyAmountExceeded, models.LimitDailyAmount, models.Dollars(100), models.Dollars(100)), decision)
+	})
+}
+
+func TestBoundaries(t *testing.T) {
+	config := &config.Configurations{
+		VelocityLimit: config.VelocityLimit{
+			MaxDailyLoadLimit:    models.Dollars(10),
+			MaxDailyTransactions: 1,
+			MaxWeeklyLoadLimit:   models.Dollars(100),
+			WeekStart:            "sunday",
+		},
+		Tiers: config.Tiers{Catalogue: map[string]models.Limits{
+			"us": {MaxDailyLoadLimit: models.Dollars(10), MaxDailyTransactions: 1, MaxWeeklyLoadLimit: models.Dollars(100), TimeZone: "America/New_York"},
+		}},
+	}
+	if _, err := time.LoadLocation("America/New_York"); err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	t.Run("resolves boundaries from the customer, tier and velocity limits", func(t *testing.T) {
+		cache := cache.NewCache()
+		cache.AddCustomerLimits(&models.CustomerLimits{CustomerID: "1", Tier: "us"})
+		cache.AddCustomerLimits(&models.CustomerLimits{CustomerID: "2", Tier: "us", TimeZone: "Europe/Paris", WeekStart: "monday"})
+		at := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		assert.Equal(t, "sunday", service.ResolveLimits("0", at, cache, config).WeekStart)
+		limits := service.ResolveLimits("1", at, cache, config)
+		assert.Equal(t, "America/New_York", limits.TimeZone)
+		assert.Equal(t, "sunday", limits.WeekStart)
+		limits = service.ResolveLimits("2", at, cache, config)
+		assert.Equal(t, "Europe/Paris", limits.TimeZone)
+		assert.Equal(t, "monday", limits.WeekStart)
+	})
+	t.Run("daily limits reset at the customer's local midnight", func(t *testing.T) {
+		cache := cache.NewCache()
+		cache.AddCustomerLimits(&models.CustomerLimits{CustomerID: "1", Tier: "us"})
+		load := func(id, customerID, at string) bool {
+			request, err := models.ParseRequest(id, customerID, "$1", at)
+			require.NoError(t, err)
+			return service.ProcessRequest(request, cache, config).Accepted
+		}
+		// 23:00 and 01:00 UTC are the same New York evening, spring forward day
+		assert.True(t, load("1", "1", "2021-03-14T23:00:00Z"))
+		assert.False(t, load("2", "1", "2021-03-15T01:00:00Z"))
+		assert.True(t, load("3", "1", "2021-03-15T04:00:00Z"))
+		// but different UTC days
+		assert.True(t, load("4", "0", "2021-03-14T23:00:00Z"))
+		assert.True(t, load("5", "0", "2021-03-15T01:00:00Z"))
+		assert.Equal(t, time.Date(2021, 3, 15, 4, 0, 0, 0, time.UTC), cache.GetAccount("1").DailyLimit.Date)
+		assert.Equal(t, time.Date(2021, 3, 14, 5, 0, 0, 0, time.UTC), cache.GetAccount("1").WeeklyLimit.Date)
+	})
+}
+
+func TestImportCustomerLimits(t *testing.T) {
+	t.Run("adds every line to the cache", func(t *testing.T) {
+		cache := cache.NewCache()
+		count, err := service.ImportCustomerLimits(strings.NewReader(`{"customer_id":"1","tier":"premium"}
+{"customer_id":"2","overrides":[{"max_daily_transactions":5,"expires":"2001-01-01T00:00:00Z"}]}
+`), cache)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+		assert.Equal(t, "premium", cache.GetCustomerLimits("1").Tier)
+		assert.Equal(t, 5, *cache.GetCustomerLimits("2").Overrides[0].MaxDailyTransactions)
+	})
+	t.Run("returns error for an invalid line", func(t *testing.T) {
+		_, err := service.ImportCustomerLimits(strings.NewReader(`{"tier":"premium"}`), cache.NewCache())
+		assert.Error(t, err)
+		_, err = service.ImportCustomerLimits(strings.NewReader(`{"customer_id":"1","overrides":[{"max_daily_load_limit":"lots"}]}`), cache.NewCache())
+		assert.Error(t, err)
+		_, err = service.ImportCustomerLimits(strings.NewReader(`{"customer_id":"1","week_start":"someday"}`), cache.NewCache())
+		assert.Error(t, err)
 	})
 }
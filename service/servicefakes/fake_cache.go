@@ -3,28 +3,32 @@ package servicefakes
 
 import (
 	"sync"
-
+	"velocitylimits/dedup"
 	"velocitylimits/models"
 	"velocitylimits/service"
 )
 
 type FakeCache struct {
-	AddAccountToStoreStub        func(*models.Account) *models.Account
-	addAccountToStoreMutex       sync.RWMutex
-	addAccountToStoreArgsForCall []struct {
+	AddAccountStub        func(*models.Account) *models.Account
+	addAccountMutex       sync.RWMutex
+	addAccountArgsForCall []struct {
 		arg1 *models.Account
 	}
-	addAccountToStoreReturns struct {
+	addAccountReturns struct {
 		result1 *models.Account
 	}
-	addAccountToStoreReturnsOnCall map[int]struct {
+	addAccountReturnsOnCall map[int]struct {
 		result1 *models.Account
 	}
-	AddTransactionStub        func(string, string)
+	AddCustomerLimitsStub        func(*models.CustomerLimits)
+	addCustomerLimitsMutex       sync.RWMutex
+	addCustomerLimitsArgsForCall []struct {
+		arg1 *models.CustomerLimits
+	}
+	AddTransactionStub        func(dedup.Entry)
 	addTransactionMutex       sync.RWMutex
 	addTransactionArgsForCall []struct {
-		arg1 string
-		arg2 string
+		arg1 dedup.Entry
 	}
 	GetAccountStub        func(string) *models.Account
 	getAccountMutex       sync.RWMutex
@@ -37,92 +41,138 @@ type FakeCache struct {
 	getAccountReturnsOnCall map[int]struct {
 		result1 *models.Account
 	}
-	IsDuplicateTransactionStub        func(string, string) bool
-	isDuplicateTransactionMutex       sync.RWMutex
-	isDuplicateTransactionArgsForCall []struct {
+	GetCustomerLimitsStub        func(string) *models.CustomerLimits
+	getCustomerLimitsMutex       sync.RWMutex
+	getCustomerLimitsArgsForCall []struct {
+		arg1 string
+	}
+	getCustomerLimitsReturns struct {
+		result1 *models.CustomerLimits
+	}
+	getCustomerLimitsReturnsOnCall map[int]struct {
+		result1 *models.CustomerLimits
+	}
+	GetTransactionStub        func(string, string) (dedup.Entry, bool)
+	getTransactionMutex       sync.RWMutex
+	getTransactionArgsForCall []struct {
 		arg1 string
 		arg2 string
 	}
-	isDuplicateTransactionReturns struct {
-		result1 bool
+	getTransactionReturns struct {
+		result1 dedup.Entry
+		result2 bool
 	}
-	isDuplicateTransactionReturnsOnCall map[int]struct {
-		result1 bool
+	getTransactionReturnsOnCall map[int]struct {
+		result1 dedup.Entry
+		result2 bool
 	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
 func (fake *FakeCache) AddAccount(arg1 *models.Account) *models.Account {
-	fake.addAccountToStoreMutex.Lock()
-	ret, specificReturn := fake.addAccountToStoreReturnsOnCall[len(fake.addAccountToStoreArgsForCall)]
-	fake.addAccountToStoreArgsForCall = append(fake.addAccountToStoreArgsForCall, struct {
+	fake.addAccountMutex.Lock()
+	ret, specificReturn := fake.addAccountReturnsOnCall[len(fake.addAccountArgsForCall)]
+	fake.addAccountArgsForCall = append(fake.addAccountArgsForCall, struct {
 		arg1 *models.Account
 	}{arg1})
+	stub := fake.AddAccountStub
+	fakeReturns := fake.addAccountReturns
 	fake.recordInvocation("AddAccount", []interface{}{arg1})
-	fake.addAccountToStoreMutex.Unlock()
-	if fake.AddAccountToStoreStub != nil {
-		return fake.AddAccountToStoreStub(arg1)
+	fake.addAccountMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.addAccountToStoreReturns
 	return fakeReturns.result1
 }
 
-func (fake *FakeCache) AddAccountToStoreCallCount() int {
-	fake.addAccountToStoreMutex.RLock()
-	defer fake.addAccountToStoreMutex.RUnlock()
-	return len(fake.addAccountToStoreArgsForCall)
+func (fake *FakeCache) AddAccountCallCount() int {
+	fake.addAccountMutex.RLock()
+	defer fake.addAccountMutex.RUnlock()
+	return len(fake.addAccountArgsForCall)
 }
 
-func (fake *FakeCache) AddAccountToStoreCalls(stub func(*models.Account) *models.Account) {
-	fake.addAccountToStoreMutex.Lock()
-	defer fake.addAccountToStoreMutex.Unlock()
-	fake.AddAccountToStoreStub = stub
+func (fake *FakeCache) AddAccountCalls(stub func(*models.Account) *models.Account) {
+	fake.addAccountMutex.Lock()
+	defer fake.addAccountMutex.Unlock()
+	fake.AddAccountStub = stub
 }
 
-func (fake *FakeCache) AddAccountToStoreArgsForCall(i int) *models.Account {
-	fake.addAccountToStoreMutex.RLock()
-	defer fake.addAccountToStoreMutex.RUnlock()
-	argsForCall := fake.addAccountToStoreArgsForCall[i]
+func (fake *FakeCache) AddAccountArgsForCall(i int) *models.Account {
+	fake.addAccountMutex.RLock()
+	defer fake.addAccountMutex.RUnlock()
+	argsForCall := fake.addAccountArgsForCall[i]
 	return argsForCall.arg1
 }
 
-func (fake *FakeCache) AddAccountToStoreReturns(result1 *models.Account) {
-	fake.addAccountToStoreMutex.Lock()
-	defer fake.addAccountToStoreMutex.Unlock()
-	fake.AddAccountToStoreStub = nil
-	fake.addAccountToStoreReturns = struct {
+func (fake *FakeCache) AddAccountReturns(result1 *models.Account) {
+	fake.addAccountMutex.Lock()
+	defer fake.addAccountMutex.Unlock()
+	fake.AddAccountStub = nil
+	fake.addAccountReturns = struct {
 		result1 *models.Account
 	}{result1}
 }
 
-func (fake *FakeCache) AddAccountToStoreReturnsOnCall(i int, result1 *models.Account) {
-	fake.addAccountToStoreMutex.Lock()
-	defer fake.addAccountToStoreMutex.Unlock()
-	fake.AddAccountToStoreStub = nil
-	if fake.addAccountToStoreReturnsOnCall == nil {
-		fake.addAccountToStoreReturnsOnCall = make(map[int]struct {
+func (fake *FakeCache) AddAccountReturnsOnCall(i int, result1 *models.Account) {
+	fake.addAccountMutex.Lock()
+	defer fake.addAccountMutex.Unlock()
+	fake.AddAccountStub = nil
+	if fake.addAccountReturnsOnCall == nil {
+		fake.addAccountReturnsOnCall = make(map[int]struct {
 			result1 *models.Account
 		})
 	}
-	fake.addAccountToStoreReturnsOnCall[i] = struct {
+	fake.addAccountReturnsOnCall[i] = struct {
 		result1 *models.Account
 	}{result1}
 }
 
-func (fake *FakeCache) AddTransaction(arg1 string, arg2 string) {
+func (fake *FakeCache) AddCustomerLimits(arg1 *models.CustomerLimits) {
+	fake.addCustomerLimitsMutex.Lock()
+	fake.addCustomerLimitsArgsForCall = append(fake.addCustomerLimitsArgsForCall, struct {
+		arg1 *models.CustomerLimits
+	}{arg1})
+	stub := fake.AddCustomerLimitsStub
+	fake.recordInvocation("AddCustomerLimits", []interface{}{arg1})
+	fake.addCustomerLimitsMutex.Unlock()
+	if stub != nil {
+		fake.AddCustomerLimitsStub(arg1)
+	}
+}
+
+func (fake *FakeCache) AddCustomerLimitsCallCount() int {
+	fake.addCustomerLimitsMutex.RLock()
+	defer fake.addCustomerLimitsMutex.RUnlock()
+	return len(fake.addCustomerLimitsArgsForCall)
+}
+
+func (fake *FakeCache) AddCustomerLimitsCalls(stub func(*models.CustomerLimits)) {
+	fake.addCustomerLimitsMutex.Lock()
+	defer fake.addCustomerLimitsMutex.Unlock()
+	fake.AddCustomerLimitsStub = stub
+}
+
+func (fake *FakeCache) AddCustomerLimitsArgsForCall(i int) *models.CustomerLimits {
+	fake.addCustomerLimitsMutex.RLock()
+	defer fake.addCustomerLimitsMutex.RUnlock()
+	argsForCall := fake.addCustomerLimitsArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeCache) AddTransaction(arg1 dedup.Entry) {
 	fake.addTransactionMutex.Lock()
 	fake.addTransactionArgsForCall = append(fake.addTransactionArgsForCall, struct {
-		arg1 string
-		arg2 string
-	}{arg1, arg2})
-	fake.recordInvocation("AddTransaction", []interface{}{arg1, arg2})
+		arg1 dedup.Entry
+	}{arg1})
+	stub := fake.AddTransactionStub
+	fake.recordInvocation("AddTransaction", []interface{}{arg1})
 	fake.addTransactionMutex.Unlock()
-	if fake.AddTransactionStub != nil {
-		fake.AddTransactionStub(arg1, arg2)
+	if stub != nil {
+		fake.AddTransactionStub(arg1)
 	}
 }
 
@@ -132,17 +182,17 @@ func (fake *FakeCache) AddTransactionCallCount() int {
 	return len(fake.addTransactionArgsForCall)
 }
 
-func (fake *FakeCache) AddTransactionCalls(stub func(string, string)) {
+func (fake *FakeCache) AddTransactionCalls(stub func(dedup.Entry)) {
 	fake.addTransactionMutex.Lock()
 	defer fake.addTransactionMutex.Unlock()
 	fake.AddTransactionStub = stub
 }
 
-func (fake *FakeCache) AddTransactionArgsForCall(i int) (string, string) {
+func (fake *FakeCache) AddTransactionArgsForCall(i int) dedup.Entry {
 	fake.addTransactionMutex.RLock()
 	defer fake.addTransactionMutex.RUnlock()
 	argsForCall := fake.addTransactionArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+	return argsForCall.arg1
 }
 
 func (fake *FakeCache) GetAccount(arg1 string) *models.Account {
@@ -151,15 +201,16 @@ func (fake *FakeCache) GetAccount(arg1 string) *models.Account {
 	fake.getAccountArgsForCall = append(fake.getAccountArgsForCall, struct {
 		arg1 string
 	}{arg1})
+	stub := fake.GetAccountStub
+	fakeReturns := fake.getAccountReturns
 	fake.recordInvocation("GetAccount", []interface{}{arg1})
 	fake.getAccountMutex.Unlock()
-	if fake.GetAccountStub != nil {
-		return fake.GetAccountStub(arg1)
+	if stub != nil {
+		return stub(arg1)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.getAccountReturns
 	return fakeReturns.result1
 }
 
@@ -205,78 +256,147 @@ func (fake *FakeCache) GetAccountReturnsOnCall(i int, result1 *models.Account) {
 	}{result1}
 }
 
-func (fake *FakeCache) IsDuplicateTransaction(arg1 string, arg2 string) bool {
-	fake.isDuplicateTransactionMutex.Lock()
-	ret, specificReturn := fake.isDuplicateTransactionReturnsOnCall[len(fake.isDuplicateTransactionArgsForCall)]
-	fake.isDuplicateTransactionArgsForCall = append(fake.isDuplicateTransactionArgsForCall, struct {
+func (fake *FakeCache) GetCustomerLimits(arg1 string) *models.CustomerLimits {
+	fake.getCustomerLimitsMutex.Lock()
+	ret, specificReturn := fake.getCustomerLimitsReturnsOnCall[len(fake.getCustomerLimitsArgsForCall)]
+	fake.getCustomerLimitsArgsForCall = append(fake.getCustomerLimitsArgsForCall, struct {
 		arg1 string
-		arg2 string
-	}{arg1, arg2})
-	fake.recordInvocation("IsDuplicateTransaction", []interface{}{arg1, arg2})
-	fake.isDuplicateTransactionMutex.Unlock()
-	if fake.IsDuplicateTransactionStub != nil {
-		return fake.IsDuplicateTransactionStub(arg1, arg2)
+	}{arg1})
+	stub := fake.GetCustomerLimitsStub
+	fakeReturns := fake.getCustomerLimitsReturns
+	fake.recordInvocation("GetCustomerLimits", []interface{}{arg1})
+	fake.getCustomerLimitsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.isDuplicateTransactionReturns
 	return fakeReturns.result1
 }
 
-func (fake *FakeCache) IsDuplicateTransactionCallCount() int {
-	fake.isDuplicateTransactionMutex.RLock()
-	defer fake.isDuplicateTransactionMutex.RUnlock()
-	return len(fake.isDuplicateTransactionArgsForCall)
+func (fake *FakeCache) GetCustomerLimitsCallCount() int {
+	fake.getCustomerLimitsMutex.RLock()
+	defer fake.getCustomerLimitsMutex.RUnlock()
+	return len(fake.getCustomerLimitsArgsForCall)
 }
 
-func (fake *FakeCache) IsDuplicateTransactionCalls(stub func(string, string) bool) {
-	fake.isDuplicateTransactionMutex.Lock()
-	defer fake.isDuplicateTransactionMutex.Unlock()
-	fake.IsDuplicateTransactionStub = stub
+func (fake *FakeCache) GetCustomerLimitsCalls(stub func(string) *models.CustomerLimits) {
+	fake.getCustomerLimitsMutex.Lock()
+	defer fake.getCustomerLimitsMutex.Unlock()
+	fake.GetCustomerLimitsStub = stub
 }
 
-func (fake *FakeCache) IsDuplicateTransactionArgsForCall(i int) (string, string) {
-	fake.isDuplicateTransactionMutex.RLock()
-	defer fake.isDuplicateTransactionMutex.RUnlock()
-	argsForCall := fake.isDuplicateTransactionArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+func (fake *FakeCache) GetCustomerLimitsArgsForCall(i int) string {
+	fake.getCustomerLimitsMutex.RLock()
+	defer fake.getCustomerLimitsMutex.RUnlock()
+	argsForCall := fake.getCustomerLimitsArgsForCall[i]
+	return argsForCall.arg1
 }
 
-func (fake *FakeCache) IsDuplicateTransactionReturns(result1 bool) {
-	fake.isDuplicateTransactionMutex.Lock()
-	defer fake.isDuplicateTransactionMutex.Unlock()
-	fake.IsDuplicateTransactionStub = nil
-	fake.isDuplicateTransactionReturns = struct {
-		result1 bool
+func (fake *FakeCache) GetCustomerLimitsReturns(result1 *models.CustomerLimits) {
+	fake.getCustomerLimitsMutex.Lock()
+	defer fake.getCustomerLimitsMutex.Unlock()
+	fake.GetCustomerLimitsStub = nil
+	fake.getCustomerLimitsReturns = struct {
+		result1 *models.CustomerLimits
 	}{result1}
 }
 
-func (fake *FakeCache) IsDuplicateTransactionReturnsOnCall(i int, result1 bool) {
-	fake.isDuplicateTransactionMutex.Lock()
-	defer fake.isDuplicateTransactionMutex.Unlock()
-	fake.IsDuplicateTransactionStub = nil
-	if fake.isDuplicateTransactionReturnsOnCall == nil {
-		fake.isDuplicateTransactionReturnsOnCall = make(map[int]struct {
-			result1 bool
+func (fake *FakeCache) GetCustomerLimitsReturnsOnCall(i int, result1 *models.CustomerLimits) {
+	fake.getCustomerLimitsMutex.Lock()
+	defer fake.getCustomerLimitsMutex.Unlock()
+	fake.GetCustomerLimitsStub = nil
+	if fake.getCustomerLimitsReturnsOnCall == nil {
+		fake.getCustomerLimitsReturnsOnCall = make(map[int]struct {
+			result1 *models.CustomerLimits
 		})
 	}
-	fake.isDuplicateTransactionReturnsOnCall[i] = struct {
-		result1 bool
+	fake.getCustomerLimitsReturnsOnCall[i] = struct {
+		result1 *models.CustomerLimits
 	}{result1}
 }
 
+func (fake *FakeCache) GetTransaction(arg1 string, arg2 string) (dedup.Entry, bool) {
+	fake.getTransactionMutex.Lock()
+	ret, specificReturn := fake.getTransactionReturnsOnCall[len(fake.getTransactionArgsForCall)]
+	fake.getTransactionArgsForCall = append(fake.getTransactionArgsForCall, struct {
+		arg1 string
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.GetTransactionStub
+	fakeReturns := fake.getTransactionReturns
+	fake.recordInvocation("GetTransaction", []interface{}{arg1, arg2})
+	fake.getTransactionMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeCache) GetTransactionCallCount() int {
+	fake.getTransactionMutex.RLock()
+	defer fake.getTransactionMutex.RUnlock()
+	return len(fake.getTransactionArgsForCall)
+}
+
+func (fake *FakeCache) GetTransactionCalls(stub func(string, string) (dedup.Entry, bool)) {
+	fake.getTransactionMutex.Lock()
+	defer fake.getTransactionMutex.Unlock()
+	fake.GetTransactionStub = stub
+}
+
+func (fake *FakeCache) GetTransactionArgsForCall(i int) (string, string) {
+	fake.getTransactionMutex.RLock()
+	defer fake.getTransactionMutex.RUnlock()
+	argsForCall := fake.getTransactionArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeCache) GetTransactionReturns(result1 dedup.Entry, result2 bool) {
+	fake.getTransactionMutex.Lock()
+	defer fake.getTransactionMutex.Unlock()
+	fake.GetTransactionStub = nil
+	fake.getTransactionReturns = struct {
+		result1 dedup.Entry
+		result2 bool
+	}{result1, result2}
+}
+
+func (fake *FakeCache) GetTransactionReturnsOnCall(i int, result1 dedup.Entry, result2 bool) {
+	fake.getTransactionMutex.Lock()
+	defer fake.getTransactionMutex.Unlock()
+	fake.GetTransactionStub = nil
+	if fake.getTransactionReturnsOnCall == nil {
+		fake.getTransactionReturnsOnCall = make(map[int]struct {
+			result1 dedup.Entry
+			result2 bool
+		})
+	}
+	fake.getTransactionReturnsOnCall[i] = struct {
+		result1 dedup.Entry
+		result2 bool
+	}{result1, result2}
+}
+
 func (fake *FakeCache) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
-	fake.addAccountToStoreMutex.RLock()
-	defer fake.addAccountToStoreMutex.RUnlock()
+	fake.addAccountMutex.RLock()
+	defer fake.addAccountMutex.RUnlock()
+	fake.addCustomerLimitsMutex.RLock()
+	defer fake.addCustomerLimitsMutex.RUnlock()
 	fake.addTransactionMutex.RLock()
 	defer fake.addTransactionMutex.RUnlock()
 	fake.getAccountMutex.RLock()
 	defer fake.getAccountMutex.RUnlock()
-	fake.isDuplicateTransactionMutex.RLock()
-	defer fake.isDuplicateTransactionMutex.RUnlock()
+	fake.getCustomerLimitsMutex.RLock()
+	defer fake.getCustomerLimitsMutex.RUnlock()
+	fake.getTransactionMutex.RLock()
+	defer fake.getTransactionMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
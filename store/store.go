@@ -0,0 +1,480 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+
+	"velocitylimits/cache"
+	"velocitylimits/config"
+	"velocitylimits/dedup"
+	"velocitylimits/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	journalFile  = "journal.log"
+	lockName     = "lock"
+)
+
+// ErrCorruptJournal is returned by Open when a record in the middle of the
+// journal cannot be decoded. A torn record at the end is expected after a
+// crash and is dropped instead.
+var ErrCorruptJournal = errors.New("corrupt journal")
+
+// ErrLocked is returned by Open and OpenReadOnly when another process has the
+// store open. Any number of read-only stores may be open at once, but only
+// while no store is open for writing.
+var ErrLocked = errors.New("store is in use by another process")
+
+// ErrReadOnly is the panic of a write to a store opened by OpenReadOnly.
+var ErrReadOnly = errors.New("store is read only")
+
+// FileStore is a service.Cache that keeps accounts and transactions in memory
+// and makes every change durable in an append-only journal. The journal is
+// folded into a snapshot every SnapshotEvery records and on Close.
+type FileStore struct {
+	mu            sync.Mutex
+	dir           string
+	syncWrites    bool
+	snapshotEvery int
+	dedupConfig   config.Dedup
+	accounts      map[string]*models.Account
+	transactions  *dedup.Set
+	customers     map[string]*models.CustomerLimits
+	pending       map[string][]dedup.Entry
+	journal       *os.File
+	lock          *os.File
+	records       int
+	// readOnly stores have no journal open and are left as they are on disk.
+	readOnly bool
+}
+
+// record is one journal line. A customer's transactions are written together
+// with the account they were applied to, so a decision is either fully in the
+// journal or not at all.
+type record struct {
+	Account      *models.Account        `json:"account,omitempty"`
+	Transactions []dedup.Entry          `json:"transactions,omitempty"`
+	Customer     *models.CustomerLimits `json:"customer,omitempty"`
+}
+
+type snapshot struct {
+	Accounts     []*models.Account        `json:"accounts"`
+	Transactions []dedup.Entry            `json:"transactions"`
+	Customers    []*models.CustomerLimits `json:"customers,omitempty"`
+}
+
+// Open loads the snapshot and replays the journal in the configured
+// directory, creating both if they do not exist yet. The store is locked
+// until it is closed, opening it again in the meantime fails with ErrLocked.
+func Open(cfg config.Store) (*FileStore, error) {
+	if err := os.MkdirAll(cfg.Path, 0755); err != nil {
+		return nil, err
+	}
+	s := newFileStore(cfg)
+	if err := s.open(true); err != nil {
+		return nil, err
+	}
+	journal, err := os.OpenFile(filepath.Join(s.dir, journalFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		s.lock.Close()
+		return nil, err
+	}
+	s.journal = journal
+	return s, nil
+}
+
+// OpenReadOnly loads the store in the configured directory, which must exist,
+// without changing anything on disk: a torn journal record is skipped rather
+// than truncated and Close writes no snapshot. Writing to the store panics
+// with ErrReadOnly.
+func OpenReadOnly(cfg config.Store) (*FileStore, error) {
+	if _, err := os.Stat(cfg.Path); err != nil {
+		return nil, err
+	}
+	s := newFileStore(cfg)
+	s.readOnly = true
+	if err := s.open(false); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func newFileStore(cfg config.Store) *FileStore {
+	return &FileStore{
+		dir:           cfg.Path,
+		syncWrites:    cfg.SyncWrites,
+		snapshotEvery: cfg.SnapshotEvery,
+		dedupConfig:   cfg.Dedup,
+		accounts:      make(map[string]*models.Account),
+		transactions:  dedup.NewSet(cfg.Dedup),
+		customers:     make(map[string]*models.CustomerLimits),
+		pending:       make(map[string][]dedup.Entry),
+	}
+}
+
+// open locks the store, exclusively to write to it, then reads the snapshot
+// and replays the journal. The lock is released if they cannot be read.
+func (s *FileStore) open(exclusive bool) error {
+	lock, err := os.OpenFile(filepath.Join(s.dir, lockName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(lock, exclusive); err != nil {
+		lock.Close()
+		if errors.Is(err, ErrLocked) {
+			return fmt.Errorf("%w: %s", ErrLocked, s.dir)
+		}
+		return err
+	}
+	s.lock = lock
+	if err = s.loadSnapshot(); err == nil {
+		err = s.replayJournal()
+	}
+	if err != nil {
+		lock.Close()
+		return err
+	}
+	return nil
+}
+
+// GetAccount returns a copy of the stored account. Changes to it are kept
+// once it is passed back to AddAccount.
+func (s *FileStore) GetAccount(customerID string) *models.Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if acc, ok := s.accounts[customerID]; ok {
+		return acc.Clone()
+	}
+	return nil
+}
+
+// AddAccount stores the account and journals it together with the
+// customer's pending transactions.
+func (s *FileStore) AddAccount(account *models.Account) *models.Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	account = account.Clone()
+	s.accounts[account.CustomerID] = account
+	s.write(record{Account: account, Transactions: s.pending[account.CustomerID]})
+	delete(s.pending, account.CustomerID)
+	return account
+}
+
+// AddTransaction records the transaction. It is journaled with the next
+// account write. Replaying the journal evicts the same transactions again, so
+// evictions are not journaled.
+func (s *FileStore) AddTransaction(entry dedup.Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transactions.Add(entry)
+	s.pending[entry.CustomerID] = append(s.pending[entry.CustomerID], entry)
+}
+
+// GetTransaction returns the recorded transaction.
+func (s *FileStore) GetTransaction(id, customerID string) (dedup.Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transactions.Get(dedup.Key{ID: id, CustomerID: customerID})
+}
+
+// IsDuplicateTransaction ...
+func (s *FileStore) IsDuplicateTransaction(id, customerID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transactions.Contains(dedup.Key{ID: id, CustomerID: customerID})
+}
+
+// Accounts returns copies of every stored account, sorted by customer ID.
+func (s *FileStore) Accounts() []*models.Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accounts := make([]*models.Account, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, account.Clone())
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].CustomerID < accounts[j].CustomerID })
+	return accounts
+}
+
+// Transactions returns every recorded transaction, oldest first.
+func (s *FileStore) Transactions() []dedup.Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transactions.Entries()
+}
+
+// AccountCount ...
+func (s *FileStore) AccountCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.accounts)
+}
+
+// TransactionCount ...
+func (s *FileStore) TransactionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transactions.Len()
+}
+
+// State returns a copy of the stored accounts, transactions and customer
+// limits.
+func (s *FileStore) State() cache.State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := cache.State{Transactions: s.transactions.Entries()}
+	for _, account := range s.accounts {
+		state.Accounts = append(state.Accounts, account.Clone())
+	}
+	for _, limits := range s.customers {
+		state.Customers = append(state.Customers, limits.Clone())
+	}
+	return state
+}
+
+// Restore replaces everything stored with the state and makes it durable
+// with a snapshot, dropping whatever the journal held.
+func (s *FileStore) Restore(state cache.State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts = make(map[string]*models.Account, len(state.Accounts))
+	for _, account := range state.Accounts {
+		s.accounts[account.CustomerID] = account.Clone()
+	}
+	s.transactions = dedup.NewSet(s.dedupConfig)
+	for _, entry := range state.Transactions {
+		s.transactions.Add(entry)
+	}
+	s.customers = make(map[string]*models.CustomerLimits, len(state.Customers))
+	for _, limits := range state.Customers {
+		s.customers[limits.CustomerID] = limits.Clone()
+	}
+	s.pending = make(map[string][]dedup.Entry)
+	return s.snapshot()
+}
+
+// GetCustomerLimits returns a copy of the customer's tier and overrides.
+func (s *FileStore) GetCustomerLimits(customerID string) *models.CustomerLimits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if limits, ok := s.customers[customerID]; ok {
+		return limits.Clone()
+	}
+	return nil
+}
+
+// AddCustomerLimits stores and journals the customer's tier and overrides.
+// Adding the limits already stored writes nothing, so a customers file can
+// be imported on every start.
+func (s *FileStore) AddCustomerLimits(limits *models.CustomerLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reflect.DeepEqual(s.customers[limits.CustomerID], limits) {
+		return
+	}
+	limits = limits.Clone()
+	s.customers[limits.CustomerID] = limits
+	s.write(record{Customer: limits})
+}
+
+// Close flushes pending transactions, writes a final snapshot and closes the
+// journal. A read-only store is left as it is.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// closing the lock file releases the lock
+	defer s.lock.Close()
+	if s.readOnly {
+		return nil
+	}
+	for customerID, keys := range s.pending {
+		s.write(record{Transactions: keys})
+		delete(s.pending, customerID)
+	}
+	if err := s.snapshot(); err != nil {
+		s.journal.Close()
+		return err
+	}
+	return s.journal.Close()
+}
+
+// write appends a record to the journal. A decision must not be acknowledged
+// if it could not be made durable, so write failures are fatal.
+func (s *FileStore) write(r record) {
+	if s.readOnly {
+		logrus.Panicf("Unable to write journal: %v", ErrReadOnly)
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		logrus.Panicf("Unable to encode journal record: %v", err)
+	}
+	if _, err := s.journal.Write(append(line, '\n')); err != nil {
+		logrus.Panicf("Unable to write journal: %v", err)
+	}
+	if s.syncWrites {
+		if err := s.journal.Sync(); err != nil {
+			logrus.Panicf("Unable to sync journal: %v", err)
+		}
+	}
+	s.records++
+	if s.snapshotEvery > 0 && s.records >= s.snapshotEvery {
+		if err := s.snapshot(); err != nil {
+			logrus.Panicf("Unable to write snapshot: %v", err)
+		}
+	}
+}
+
+// snapshot atomically replaces the snapshot with the current state and then
+// empties the journal. Replaying a journal that is already part of the
+// snapshot is harmless, so a crash between the two steps loses nothing.
+func (s *FileStore) snapshot() error {
+	snap := snapshot{
+		Accounts:     make([]*models.Account, 0, len(s.accounts)),
+		Transactions: s.transactions.Entries(),
+		Customers:    make([]*models.CustomerLimits, 0, len(s.customers)),
+	}
+	for _, limits := range s.customers {
+		snap.Customers = append(snap.Customers, limits)
+	}
+	for _, account := range s.accounts {
+		snap.Accounts = append(snap.Accounts, account)
+	}
+	tmp, err := ioutil.TempFile(s.dir, snapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := json.NewEncoder(tmp).Encode(snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+	if err := s.journal.Truncate(0); err != nil {
+		return err
+	}
+	s.records = 0
+	return nil
+}
+
+func (s *FileStore) loadSnapshot() error {
+	file, err := os.Open(filepath.Join(s.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var snap snapshot
+	if err := json.NewDecoder(file).Decode(&snap); err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+	for _, account := range snap.Accounts {
+		s.accounts[account.CustomerID] = account
+	}
+	for _, entry := range snap.Transactions {
+		s.transactions.Add(entry)
+	}
+	for _, limits := range snap.Customers {
+		s.customers[limits.CustomerID] = limits
+	}
+	return nil
+}
+
+// replayJournal applies every complete record in the journal. A torn record
+// at the end of the file is truncated away, or skipped in a read-only store.
+func (s *FileStore) replayJournal() error {
+	flag := os.O_RDWR
+	if s.readOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(filepath.Join(s.dir, journalFile), flag, 0644)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return s.dropTornRecord(file, offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				return s.dropTornRecord(file, offset)
+			}
+			return fmt.Errorf("%w at offset %d: %v", ErrCorruptJournal, offset, err)
+		}
+		s.apply(r)
+		offset += int64(len(line))
+	}
+}
+
+// dropTornRecord truncates the journal at the offset of a torn record.
+func (s *FileStore) dropTornRecord(file *os.File, offset int64) error {
+	if s.readOnly {
+		logrus.Warnf("Skipping torn journal record at offset %d", offset)
+		return nil
+	}
+	logrus.Warnf("Dropping torn journal record at offset %d", offset)
+	return file.Truncate(offset)
+}
+
+func (s *FileStore) apply(r record) {
+	if r.Account != nil {
+		s.accounts[r.Account.CustomerID] = r.Account
+	}
+	for _, entry := range r.Transactions {
+		s.transactions.Add(entry)
+	}
+	if r.Customer != nil {
+		s.customers[r.Customer.CustomerID] = r.Customer
+	}
+	s.records++
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
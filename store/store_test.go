@@ -0,0 +1,372 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"velocitylimits/cache"
+	"velocitylimits/config"
+	"velocitylimits/dedup"
+	"velocitylimits/models"
+	"velocitylimits/service"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestStore(t *testing.T, dir string, snapshotEvery int) *FileStore {
+	s, err := Open(config.Store{Type: config.StoreTypeFile, Path: dir, SyncWrites: true, SnapshotEvery: snapshotEvery})
+	require.NoError(t, err)
+	return s
+}
+
+// crash closes the store the way a killed process would: without flushing
+// or snapshotting, releasing its lock.
+func crash(s *FileStore) {
+	s.journal.Close()
+	s.lock.Close()
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func newTestAccount(customerID string) *models.Account {
+	account := models.NewAccount(customerID)
+	now := time.Date(2000, 1, 5, 10, 0, 0, 0, time.UTC)
+	account.DailyLimit = models.NewDailyLimit(now, models.Dollars(5000), 3, models.DefaultBoundaries)
+	account.WeeklyLimit = models.NewWeeklyLimit(now, models.Dollars(20000), models.DefaultBoundaries)
+	return account
+}
+
+func transaction(id, customerID string, t time.Time) dedup.Entry {
+	return dedup.Entry{Key: dedup.Key{ID: id, CustomerID: customerID}, Time: t}
+}
+
+func TestOpen(t *testing.T) {
+	t.Run("creates an empty store", func(t *testing.T) {
+		dir := tempDir(t)
+		s := openTestStore(t, dir, 0)
+		assert.Nil(t, s.GetAccount("1"))
+		assert.False(t, s.IsDuplicateTransaction("1", "1"))
+		assert.Equal(t, 0, s.AccountCount())
+		assert.Equal(t, 0, s.TransactionCount())
+		require.NoError(t, s.Close())
+		assert.FileExists(t, filepath.Join(dir, snapshotFile))
+	})
+}
+
+func TestOpenLock(t *testing.T) {
+	t.Run("returns error while another store is open", func(t *testing.T) {
+		dir := tempDir(t)
+		s := openTestStore(t, dir, 0)
+		_, err := Open(config.Store{Type: config.StoreTypeFile, Path: dir})
+		assert.True(t, errors.Is(err, ErrLocked))
+		_, err = OpenReadOnly(config.Store{Type: config.StoreTypeFile, Path: dir})
+		assert.True(t, errors.Is(err, ErrLocked))
+		require.NoError(t, s.Close())
+
+		reopened := openTestStore(t, dir, 0)
+		require.NoError(t, reopened.Close())
+	})
+	t.Run("lets read-only stores share the lock", func(t *testing.T) {
+		dir := tempDir(t)
+		require.NoError(t, openTestStore(t, dir, 0).Close())
+		first, err := OpenReadOnly(config.Store{Type: config.StoreTypeFile, Path: dir})
+		require.NoError(t, err)
+		second, err := OpenReadOnly(config.Store{Type: config.StoreTypeFile, Path: dir})
+		require.NoError(t, err)
+		_, err = Open(config.Store{Type: config.StoreTypeFile, Path: dir})
+		assert.True(t, errors.Is(err, ErrLocked))
+		require.NoError(t, first.Close())
+		require.NoError(t, second.Close())
+		require.NoError(t, openTestStore(t, dir, 0).Close())
+	})
+	t.Run("releases the lock when the store cannot be read", func(t *testing.T) {
+		dir := tempDir(t)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, journalFile), []byte("garbage\n{}\n"), 0644))
+		_, err := Open(config.Store{Path: dir})
+		assert.True(t, errors.Is(err, ErrCorruptJournal))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, journalFile), nil, 0644))
+		require.NoError(t, openTestStore(t, dir, 0).Close())
+	})
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	t.Run("loads the store without changing it on disk", func(t *testing.T) {
+		dir := tempDir(t)
+		s := openTestStore(t, dir, 0)
+		s.AddAccount(newTestAccount("1"))
+		require.NoError(t, s.Close())
+		s = openTestStore(t, dir, 0)
+		s.AddAccount(newTestAccount("2"))
+		crash(s)
+		journal, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0644)
+		require.NoError(t, err)
+		_, err = journal.WriteString(`{"account":{"CustomerID":"3"`)
+		require.NoError(t, err)
+		require.NoError(t, journal.Close())
+		snapshotBefore, err := ioutil.ReadFile(filepath.Join(dir, snapshotFile))
+		require.NoError(t, err)
+		journalBefore, err := ioutil.ReadFile(filepath.Join(dir, journalFile))
+		require.NoError(t, err)
+
+		readOnly, err := OpenReadOnly(config.Store{Type: config.StoreTypeFile, Path: dir})
+		require.NoError(t, err)
+		assert.NotNil(t, readOnly.GetAccount("1"))
+		assert.NotNil(t, readOnly.GetAccount("2"))
+		assert.Nil(t, readOnly.GetAccount("3"))
+		assert.Panics(t, func() { readOnly.AddAccount(newTestAccount("4")) })
+		require.NoError(t, readOnly.Close())
+
+		snapshotAfter, err := ioutil.ReadFile(filepath.Join(dir, snapshotFile))
+		require.NoError(t, err)
+		journalAfter, err := ioutil.ReadFile(filepath.Join(dir, journalFile))
+		require.NoError(t, err)
+		assert.Equal(t, snapshotBefore, snapshotAfter)
+		assert.Equal(t, journalBefore, journalAfter)
+	})
+	t.Run("returns error for a missing directory", func(t *testing.T) {
+		dir := filepath.Join(tempDir(t), "data")
+		_, err := OpenReadOnly(config.Store{Type: config.StoreTypeFile, Path: dir})
+		assert.True(t, os.IsNotExist(err))
+		assert.NoDirExists(t, dir)
+	})
+}
+
+func TestFileStoreAddAccount(t *testing.T) {
+	t.Run("account survives reopening after close", func(t *testing.T) {
+		dir := tempDir(t)
+		s := openTestStore(t, dir, 0)
+		account := newTestAccount("1")
+		account.DailyLimit.Apply(models.Dollars(100))
+		s.AddAccount(account)
+		require.NoError(t, s.Close())
+
+		reopened := openTestStore(t, dir, 0)
+		assert.Equal(t, account, reopened.GetAccount("1"))
+	})
+	t.Run("account survives a crash without close", func(t *testing.T) {
+		dir := tempDir(t)
+		s := openTestStore(t, dir, 0)
+		account := newTestAccount("1")
+		s.AddTransaction(transaction("10", "1", time.Time{}))
+		s.AddAccount(account)
+		crash(s)
+
+		reopened := openTestStore(t, dir, 0)
+		assert.Equal(t, account, reopened.GetAccount("1"))
+		assert.True(t, reopened.IsDuplicateTransaction("10", "1"))
+	})
+}
+
+func TestFileStoreAddTransaction(t *testing.T) {
+	t.Run("does not collide on concatenated keys", func(t *testing.T) {
+		s := openTestStore(t, tempDir(t), 0)
+		s.AddTransaction(transaction("1", "23", time.Time{}))
+		assert.True(t, s.IsDuplicateTransaction("1", "23"))
+		assert.False(t, s.IsDuplicateTransaction("12", "3"))
+	})
+	t.Run("evictions are restored on reopening", func(t *testing.T) {
+		dir := tempDir(t)
+		start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		cfg := config.Store{Type: config.StoreTypeFile, Path: dir, Dedup: config.Dedup{Retention: 48 * time.Hour}}
+		s, err := Open(cfg)
+		require.NoError(t, err)
+		for day := 0; day < 5; day++ {
+			s.AddTransaction(transaction(fmt.Sprint(day), "1", start.AddDate(0, 0, day)))
+			s.AddAccount(newTestAccount("1"))
+		}
+		crash(s)
+
+		reopened, err := Open(cfg)
+		require.NoError(t, err)
+		for day := 0; day < 5; day++ {
+			assert.Equal(t, day >= 2, reopened.IsDuplicateTransaction(fmt.Sprint(day), "1"), day)
+		}
+		require.NoError(t, reopened.Close())
+		reopened, err = Open(cfg)
+		require.NoError(t, err)
+		assert.False(t, reopened.IsDuplicateTransaction("1", "1"))
+		assert.True(t, reopened.IsDuplicateTransaction("2", "1"))
+	})
+	t.Run("the original decision survives reopening", func(t *testing.T) {
+		dir := tempDir(t)
+		s := openTestStore(t, dir, 0)
+		decision := models.NewAmountDecline(models.ReasonDailyAmountExceeded, models.LimitDailyAmount, models.Dollars(1), models.Dollars(5000))
+		entry := transaction("1", "2", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+		entry.Amount = models.Dollars(5000)
+		entry.Decision = &decision
+		s.AddTransaction(entry)
+		s.AddAccount(newTestAccount("2"))
+		crash(s)
+
+		reopened := openTestStore(t, dir, 0)
+		actual, ok := reopened.GetTransaction("1", "2")
+		require.True(t, ok)
+		assert.Equal(t, entry, actual)
+	})
+	t.Run("pending transactions are written on close", func(t *testing.T) {
+		dir := tempDir(t)
+		s := openTestStore(t, dir, 0)
+		s.AddTransaction(transaction("1", "2", time.Time{}))
+		require.NoError(t, s.Close())
+
+		reopened := openTestStore(t, dir, 0)
+		assert.True(t, reopened.IsDuplicateTransaction("1", "2"))
+	})
+}
+
+func TestFileStoreAddCustomerLimits(t *testing.T) {
+	expires := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	daily := models.Dollars(10000)
+	limits := &models.CustomerLimits{CustomerID: "1", Tier: "premium", Overrides: []models.Override{{MaxDailyLoadLimit: &daily, Expires: &expires}}}
+	t.Run("customer limits survive reopening", func(t *testing.T) {
+		dir := tempDir(t)
+		s := openTestStore(t, dir, 0)
+		s.AddCustomerLimits(limits)
+		crash(s)
+		reopened := openTestStore(t, dir, 0)
+		assert.Equal(t, limits, reopened.GetCustomerLimits("1"))
+		require.NoError(t, reopened.Close())
+
+		snapshotted := openTestStore(t, dir, 0)
+		assert.Equal(t, limits, snapshotted.GetCustomerLimits("1"))
+	})
+	t.Run("adding the same limits again writes nothing", func(t *testing.T) {
+		dir := tempDir(t)
+		s := openTestStore(t, dir, 0)
+		s.AddCustomerLimits(limits)
+		s.AddCustomerLimits(limits.Clone())
+		assert.Equal(t, 1, s.records)
+	})
+}
+
+func TestFileStoreSnapshot(t *testing.T) {
+	t.Run("journal is folded into the snapshot", func(t *testing.T) {
+		dir := tempDir(t)
+		s := openTestStore(t, dir, 2)
+		s.AddAccount(newTestAccount("1"))
+		s.AddAccount(newTestAccount("2"))
+		info, err := os.Stat(filepath.Join(dir, journalFile))
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), info.Size())
+
+		s.AddAccount(newTestAccount("3"))
+		crash(s)
+		reopened := openTestStore(t, dir, 2)
+		for _, id := range []string{"1", "2", "3"} {
+			assert.NotNil(t, reopened.GetAccount(id), id)
+		}
+	})
+}
+
+func TestFileStoreRestore(t *testing.T) {
+	t.Run("restored state replaces what was stored and survives a crash", func(t *testing.T) {
+		dir := tempDir(t)
+		s := openTestStore(t, dir, 0)
+		s.AddCustomerLimits(&models.CustomerLimits{CustomerID: "1", Tier: "premium"})
+		s.AddTransaction(transaction("1", "1", time.Time{}))
+		s.AddAccount(newTestAccount("1"))
+		state := s.State()
+
+		s.AddTransaction(transaction("2", "2", time.Time{}))
+		s.AddAccount(newTestAccount("2"))
+		require.NoError(t, s.Restore(state))
+		assert.Nil(t, s.GetAccount("2"))
+
+		// reopen without closing
+		crash(s)
+		reopened := openTestStore(t, dir, 0)
+		assert.NotNil(t, reopened.GetAccount("1"))
+		assert.Nil(t, reopened.GetAccount("2"))
+		assert.True(t, reopened.IsDuplicateTransaction("1", "1"))
+		assert.False(t, reopened.IsDuplicateTransaction("2", "2"))
+		assert.Equal(t, "premium", reopened.GetCustomerLimits("1").Tier)
+	})
+}
+
+func TestReplayJournal(t *testing.T) {
+	t.Run("drops a torn record at the end", func(t *testing.T) {
+		dir := tempDir(t)
+		s := openTestStore(t, dir, 0)
+		s.AddAccount(newTestAccount("1"))
+		crash(s)
+		journal, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0644)
+		require.NoError(t, err)
+		_, err = journal.WriteString(`{"account":{"CustomerID":"2"`)
+		require.NoError(t, err)
+		require.NoError(t, journal.Close())
+
+		reopened := openTestStore(t, dir, 0)
+		assert.NotNil(t, reopened.GetAccount("1"))
+		assert.Nil(t, reopened.GetAccount("2"))
+		reopened.AddAccount(newTestAccount("3"))
+		crash(reopened)
+
+		again := openTestStore(t, dir, 0)
+		assert.NotNil(t, again.GetAccount("3"))
+	})
+	t.Run("returns error for a corrupt record in the middle", func(t *testing.T) {
+		dir := tempDir(t)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, journalFile), []byte("garbage\n{}\n"), 0644))
+		_, err := Open(config.Store{Path: dir})
+		assert.True(t, errors.Is(err, ErrCorruptJournal))
+	})
+}
+
+// TestResumeStream splits input.txt in two, closing and reopening the store
+// in between, and checks every decision matches a single uninterrupted run.
+func TestResumeStream(t *testing.T) {
+	config := &config.Configurations{VelocityLimit: config.VelocityLimit{
+		MaxDailyLoadLimit:    models.Dollars(5000),
+		MaxDailyTransactions: 3,
+		MaxWeeklyLoadLimit:   models.Dollars(20000),
+	}}
+	requests := readInput(t)
+	expected := make([]*models.Response, 0, len(requests))
+	memory := cache.NewCache()
+	for _, request := range requests {
+		expected = append(expected, service.AttemptLoad(request, config, memory, nil))
+	}
+
+	dir := tempDir(t)
+	actual := make([]*models.Response, 0, len(requests))
+	s := openTestStore(t, dir, 100)
+	half := len(requests) / 2
+	for _, request := range requests[:half] {
+		actual = append(actual, service.AttemptLoad(request, config, s, nil))
+	}
+	require.NoError(t, s.Close())
+	s = openTestStore(t, dir, 100)
+	for _, request := range requests[half:] {
+		actual = append(actual, service.AttemptLoad(request, config, s, nil))
+	}
+	require.NoError(t, s.Close())
+
+	assert.Equal(t, expected, actual)
+}
+
+func readInput(t *testing.T) []*models.Request {
+	file, err := os.Open("../input.txt")
+	require.NoError(t, err)
+	defer file.Close()
+	var requests []*models.Request
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		request, err := models.NewRequest(scanner.Text())
+		require.NoError(t, err)
+		requests = append(requests, request)
+	}
+	require.NoError(t, scanner.Err())
+	return requests
+}